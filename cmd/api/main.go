@@ -3,55 +3,86 @@ package main
 import (
 	"context"
 	"log"
-	"net/http"
+	"log/slog"
 	"os"
-	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	ginadapter "github.com/awslabs/aws-lambda-go-api-proxy/gin"
-	"github.com/gin-gonic/gin"
 	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/blob"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/config"
 	"github.com/imrishuroy/go-idempotent-orderflow/internal/handlers"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/inventory"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/metrics"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/webhooks"
 )
 
-func setupRouter(cfg handlers.HandlerConfig) *gin.Engine {
-	r := gin.New()
-	r.Use(gin.Recovery())
-
-	// health check endpoint
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
-	})
+func main() {
+	cfg, err := config.Load()
+	if err == nil {
+		err = cfg.Validate(config.API)
+	}
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	// JSON logs on stdout; the standard log package is routed through the same handler
+	slog.SetDefault(logging.New(os.Stdout, cfg.LogLevel))
 
-	handlers.RegisterOrdersRoutes(r, cfg)
+	tp, err := tracing.Setup(cfg.TracingExporter, "orderflow-api", os.Stdout)
+	if err != nil {
+		log.Fatalf("tracing: %v", err)
+	}
+	defer func() { _ = tp.Shutdown(context.Background()) }()
 
-	return r
-}
+	clients, err := aws.NewAWSClientsWithOptions(context.Background(), cfg.AWS)
+	if err != nil {
+		log.Fatalf("failed to init aws clients: %v", err)
+	}
 
-func main() {
-	clients, err := aws.NewAWSClients(context.Background())
+	recorder, err := metrics.NewRecorder(cfg.MetricsSink, cfg.MetricsNamespace, clients.CloudWatch, os.Stdout)
+	if err != nil {
+		log.Fatalf("metrics: %v", err)
+	}
 
+	blobs, err := blob.Open(cfg.BlobStore, clients.S3)
 	if err != nil {
-		log.Fatalf("failed to init aws clients: %v", err)
+		log.Fatalf("blob store: %v", err)
 	}
 
-	cfg := handlers.HandlerConfig{
-		DynamoDBClient:   clients.DynamoDB,
-		SQSClient:        clients.SQS,
-		IdempotencyTable: os.Getenv("IDEMPOTENCY_TABLE"),
-		OrdersTable:      os.Getenv("ORDERS_TABLE"),
-		QueueURL:         os.Getenv("ORDERS_QUEUE_URL"),
-		TTLWindow:        48 * time.Hour,
+	var inv *inventory.Store
+	if cfg.StockTable != "" {
+		inv = inventory.NewStore(clients.DynamoDB, cfg.StockTable, cfg.ReservationsTable, cfg.ReservationHold)
+	}
+
+	var hooks *webhooks.Store
+	if cfg.Webhooks() {
+		hooks = webhooks.NewStore(clients.DynamoDB, cfg.WebhookEndpointsTable, cfg.WebhookEventsTable, cfg.WebhookDeliveriesTable, cfg.IdempotencyTTL)
 	}
 
-	r := setupRouter(cfg)
+	r := handlers.NewRouter(handlers.HandlerConfig{
+		DynamoDBClient:   clients.DynamoDB,
+		SQSClient:        clients.SQS,
+		IdempotencyTable: cfg.IdempotencyTable,
+		OrdersTable:      cfg.OrdersTable,
+		OutboxTable:      cfg.OutboxTable,
+		QueueURL:         cfg.QueueURL,
+		TTLWindow:        cfg.IdempotencyTTL,
+		BlobStore:        blobs,
+		Inventory:        inv,
+		Webhooks:         hooks,
+		Metrics:          recorder,
+	})
 
-	// if environment variable RUN_LOCAL is set to "true"
-	// run local HTTP server for development.
-	if os.Getenv("RUN_LOCAL") == "true" {
+	// if RUN_LOCAL is true, run local HTTP server for development.
+	if cfg.RunLocal {
+		go metrics.FlushEvery(context.Background(), recorder, cfg.MetricsFlushInterval, func(err error) {
+			slog.Warn("metrics flush failed", logging.Err(err))
+		})
 		addr := ":8080"
-		log.Printf("running local server on %s", addr)
+		slog.Info("running local server", "addr", addr)
 		if err := r.Run(addr); err != nil {
 			log.Fatalf("failed to run local server: %v", err)
 		}
@@ -63,6 +94,14 @@ func main() {
 
 	lambda.Start(func(ctx context.Context, req events.APIGatewayProxyRequest) (interface{}, error) {
 		// the adapter handles proxying; use adapter.ProxyWithContext for proper context propagation
-		return adapter.ProxyWithContext(ctx, req)
+		resp, err := adapter.ProxyWithContext(ctx, req)
+		// publish this invocation's metrics before Lambda freezes the environment
+		if ferr := recorder.Flush(ctx); ferr != nil {
+			slog.Warn("metrics flush failed", logging.Err(ferr))
+		}
+		if ferr := tp.Flush(ctx); ferr != nil {
+			slog.Warn("trace flush failed", logging.Err(ferr))
+		}
+		return resp, err
 	})
 }
@@ -0,0 +1,213 @@
+// Command local runs the whole order flow in one process without AWS: the Gin API, the outbox relay,
+// the reservation expirer, the webhook dispatcher and the worker, wired to in-memory DynamoDB, SQS and blob store. State is
+// lost on exit.
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/blob"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/handlers"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/inventory"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/outbox"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/payments"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/shipping"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/testing/dynamofake"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/testing/sqsfake"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/webhooks"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/worker"
+)
+
+const (
+	idempotencyTable  = "idempotency-local"
+	ordersTable       = "orders-local"
+	outboxTable       = "outbox-local"
+	stockTable        = "stock-local"
+	reservationsTable = "reservations-local"
+	endpointsTable    = "webhook-endpoints-local"
+	eventsTable       = "webhook-events-local"
+	deliveriesTable   = "webhook-deliveries-local"
+	queueName         = "orders-local"
+
+	ttlWindow         = 48 * time.Hour
+	relayInterval     = 2 * time.Second
+	visibilityTimeout = 30 * time.Second
+)
+
+// seedStock is the stock every local run starts with.
+var seedStock = map[string]int64{"sku-1": 100, "sku-2": 100, "sku-3": 100}
+
+// declinedCustomer is the customer whose payments the fake gateway declines, undeliverableCustomer the
+// one whose shipments the fake carrier rejects.
+const (
+	declinedCustomer      = "c-declined"
+	undeliverableCustomer = "c-undeliverable"
+)
+
+// app is the in-process wiring of API, relay and worker.
+type app struct {
+	router   *gin.Engine
+	dynamo   *dynamofake.Fake
+	queue    *sqsfake.Fake
+	queueURL string
+	relay    *outbox.Relay
+	expirer  *inventory.Expirer
+	hooks    *webhooks.Dispatcher
+	consumer *worker.Consumer
+	// hookClient delivers webhooks. Unlike the relay's default client it reaches receivers on this
+	// machine, which is where they run locally.
+	hookClient *http.Client
+}
+
+func newApp() *app {
+	dynamo := dynamofake.New(
+		dynamofake.TableSchema{Name: idempotencyTable, HashKey: "idempotency_key"},
+		dynamofake.TableSchema{
+			Name:    ordersTable,
+			HashKey: "order_id",
+			Indexes: []dynamofake.IndexSchema{{Name: orders.CustomerIndex, HashKey: "customer_id", RangeKey: "created_at"}},
+		},
+		dynamofake.TableSchema{
+			Name:    outboxTable,
+			HashKey: "outbox_id",
+			Indexes: []dynamofake.IndexSchema{{Name: outbox.StatusIndex, HashKey: "status", RangeKey: "created_at"}},
+		},
+		dynamofake.TableSchema{Name: stockTable, HashKey: "sku"},
+		dynamofake.TableSchema{
+			Name:    reservationsTable,
+			HashKey: "order_id",
+			Indexes: []dynamofake.IndexSchema{{Name: inventory.StatusIndex, HashKey: "status", RangeKey: "expires_at"}},
+		},
+		dynamofake.TableSchema{
+			Name:    endpointsTable,
+			HashKey: "endpoint_id",
+			Indexes: []dynamofake.IndexSchema{{Name: webhooks.CustomerIndex, HashKey: "customer_id", RangeKey: "created_at"}},
+		},
+		dynamofake.TableSchema{
+			Name:    eventsTable,
+			HashKey: "event_id",
+			Indexes: []dynamofake.IndexSchema{{Name: webhooks.StatusIndex, HashKey: "status", RangeKey: "created_at"}},
+		},
+		dynamofake.TableSchema{
+			Name:     deliveriesTable,
+			HashKey:  "endpoint_id",
+			RangeKey: "event_id",
+			Indexes:  []dynamofake.IndexSchema{{Name: webhooks.StatusIndex, HashKey: "status", RangeKey: "next_attempt_at"}},
+		},
+	)
+	queue := sqsfake.New()
+	queueURL := queue.CreateQueue(queueName, visibilityTimeout)
+	clients := &aws.AWSClients{DynamoDB: dynamo, SQS: queue}
+	blobs := blob.NewMemory()
+	inv := inventory.NewStore(dynamo, stockTable, reservationsTable, inventory.DefaultHold)
+	for sku, q := range seedStock {
+		if _, err := inv.AddStock(context.Background(), sku, q); err != nil {
+			log.Fatalf("seed stock: %v", err)
+		}
+	}
+	gw := payments.NewFake().Decline(declinedCustomer, "card_declined")
+	carrier := shipping.NewFake().Reject(undeliverableCustomer, "no delivery to this address")
+	hooks := webhooks.NewStore(dynamo, endpointsTable, eventsTable, deliveriesTable, ttlWindow)
+	hookClient := &http.Client{Timeout: webhooks.DefaultTimeout}
+	orderStore := orders.NewStore(dynamo, ordersTable)
+	processor := worker.NewProcessor(clients, idempotencyTable, ordersTable, ttlWindow).
+		WithBlobStore(blobs).
+		WithPaymentGateway(gw).
+		WithTransitionRecorder(hooks).
+		WithSteps(
+			worker.ValidateStep(),
+			worker.ReserveInventoryStep(inv),
+			worker.AuthorizePaymentStep(gw, orderStore),
+			worker.CapturePaymentStep(gw, orderStore),
+			worker.CommitInventoryStep(inv),
+			worker.CreateShipmentStep(carrier),
+		)
+
+	return &app{
+		router: handlers.NewRouter(handlers.HandlerConfig{
+			DynamoDBClient:   dynamo,
+			SQSClient:        queue,
+			IdempotencyTable: idempotencyTable,
+			OrdersTable:      ordersTable,
+			OutboxTable:      outboxTable,
+			QueueURL:         queueURL,
+			TTLWindow:        ttlWindow,
+			BlobStore:        blobs,
+			Inventory:        inv,
+			Webhooks:         hooks,
+		}),
+		dynamo:     dynamo,
+		queue:      queue,
+		queueURL:   queueURL,
+		relay:      outbox.NewRelay(outbox.NewStore(dynamo, outboxTable, ttlWindow), aws.NewPublisher(queue, queueURL)),
+		expirer:    inventory.NewExpirer(inv),
+		hooks:      webhooks.NewDispatcher(hooks, orderStore, hookClient),
+		hookClient: hookClient,
+		consumer: worker.NewConsumer(queue, processor, worker.ConsumerConfig{
+			QueueURL:          queueURL,
+			VisibilityTimeout: visibilityTimeout,
+		}),
+	}
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	level, err := logging.ParseLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		log.Fatalf("LOG_LEVEL: %v", err)
+	}
+	slog.SetDefault(logging.New(os.Stdout, level))
+
+	addr := os.Getenv("LOCAL_ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+
+	a := newApp()
+	go func() {
+		if err := a.relay.Run(ctx, relayInterval); err != nil && ctx.Err() == nil {
+			slog.Error("relay stopped", logging.Err(err))
+		}
+	}()
+	go func() {
+		if err := a.expirer.Run(ctx, relayInterval); err != nil && ctx.Err() == nil {
+			slog.Error("reservation expirer stopped", logging.Err(err))
+		}
+	}()
+	go func() {
+		if err := a.hooks.Run(ctx, relayInterval); err != nil && ctx.Err() == nil {
+			slog.Error("webhook dispatcher stopped", logging.Err(err))
+		}
+	}()
+	go func() {
+		_ = a.consumer.Run(ctx)
+	}()
+
+	srv := &http.Server{Addr: addr, Handler: a.router}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("running local order flow with in-memory DynamoDB, SQS and blob store", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("failed to run local server: %v", err)
+	}
+}
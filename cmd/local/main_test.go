@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/webhooks"
+)
+
+func TestLocalFlow_CreateEnqueueProcess(t *testing.T) {
+	a := newApp()
+	body := `{"customer_id":"cust-1","amount":{"amount":2550,"currency":"USD"},` +
+		`"items":[{"sku":"sku-1","quantity":2,"price":{"amount":1000,"currency":"USD"}},{"sku":"sku-2","quantity":1,"price":{"amount":550,"currency":"USD"}}]}`
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", "local-key-1")
+		w := httptest.NewRecorder()
+		a.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post()
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		OrderID string `json:"order_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.OrderID == "" {
+		t.Fatalf("unexpected create response %s: %v", w.Body.String(), err)
+	}
+	if n := a.queue.Len(a.queueURL); n != 1 {
+		t.Fatalf("expected the order message on the queue, got %d messages", n)
+	}
+
+	n, err := a.consumer.PollOnce(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("pollOnce = %d, %v", n, err)
+	}
+	if n := a.queue.Len(a.queueURL); n != 0 {
+		t.Fatalf("processed message must be deleted, %d left", n)
+	}
+
+	get := httptest.NewRecorder()
+	a.router.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/orders/"+created.OrderID, nil))
+	var order orders.Order
+	if err := json.Unmarshal(get.Body.Bytes(), &order); err != nil {
+		t.Fatalf("decode order: %v", err)
+	}
+	if order.Status != orders.StatusCompleted {
+		t.Fatalf("expected COMPLETED, got %s", order.Status)
+	}
+	// the worker charged the order through the fake gateway
+	if c := order.LastPayment("capture"); c == nil || c.Status != orders.PaymentSucceeded || c.Amount != order.Amount {
+		t.Fatalf("expected a captured payment, got %+v", order.Payments)
+	}
+	if !order.ActionStarted("create_shipment") || order.SagaStatus != "" {
+		t.Fatalf("expected the order shipped without compensation, got %v %q", order.SagaActions, order.SagaStatus)
+	}
+	// the worker reserved and committed the stock
+	for sku, want := range map[string]string{"sku-1": `"available":98,"reserved":0`, "sku-2": `"available":99,"reserved":0`} {
+		stock := httptest.NewRecorder()
+		a.router.ServeHTTP(stock, httptest.NewRequest(http.MethodGet, "/inventory/"+sku, nil))
+		if stock.Code != http.StatusOK || !strings.Contains(stock.Body.String(), want) {
+			t.Fatalf("%s: expected %s, got %d: %s", sku, want, stock.Code, stock.Body.String())
+		}
+	}
+	res := httptest.NewRecorder()
+	a.router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/orders/"+created.OrderID+"/reservation", nil))
+	if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), `"status":"COMMITTED"`) {
+		t.Fatalf("expected a committed reservation, got %d: %s", res.Code, res.Body.String())
+	}
+
+	// a retry with the same key replays the stored response (updated by the worker on completion)
+	// instead of creating a second order
+	replayed := post()
+	if replayed.Code >= 300 || !strings.Contains(replayed.Body.String(), created.OrderID) {
+		t.Fatalf("expected replay of %s, got %d: %s", created.OrderID, replayed.Code, replayed.Body.String())
+	}
+	if replayed.Header().Get("Idempotent-Replayed") != "true" || replayed.Header().Get("Location") != w.Header().Get("Location") {
+		t.Fatalf("expected replay headers with Location %q, got %v", w.Header().Get("Location"), replayed.Header())
+	}
+
+	// the same key with a different payload is rejected
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(strings.Replace(body, "cust-1", "cust-2", 1)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", "local-key-1")
+	reused := httptest.NewRecorder()
+	a.router.ServeHTTP(reused, req)
+	if reused.Code != http.StatusUnprocessableEntity || reused.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("expected 422 problem, got %d %q: %s", reused.Code, reused.Header().Get("Content-Type"), reused.Body.String())
+	}
+	if n := a.queue.Len(a.queueURL); n != 0 {
+		t.Fatalf("retry must not enqueue again, %d messages", n)
+	}
+}
+
+func TestLocalFlow_CancelBeforeProcessing(t *testing.T) {
+	a := newApp()
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		a.router.ServeHTTP(w, req)
+		return w
+	}
+	create := func(key string) string {
+		w := do(http.MethodPost, "/orders", key,
+			`{"customer_id":"cust-1","amount":{"amount":500,"currency":"USD"},"items":[{"sku":"sku-1","quantity":1,"price":{"amount":500,"currency":"USD"}}]}`)
+		var created struct {
+			OrderID string `json:"order_id"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("create: %d %s", w.Code, w.Body.String())
+		}
+		return created.OrderID
+	}
+
+	orderID := create("create-1")
+	cancelled := do(http.MethodPost, "/orders/"+orderID+"/cancel", "cancel-1", `{"reason":"changed my mind"}`)
+	var order orders.Order
+	if err := json.Unmarshal(cancelled.Body.Bytes(), &order); err != nil || cancelled.Code != http.StatusOK || order.Status != orders.StatusCancelled {
+		t.Fatalf("cancel: %d %s", cancelled.Code, cancelled.Body.String())
+	}
+	if last := order.StatusHistory[len(order.StatusHistory)-1]; last.From != orders.StatusPending || last.Reason != "changed my mind" {
+		t.Fatalf("unexpected history entry %+v", last)
+	}
+
+	// a retried cancel replays the first answer; the key is required
+	retried := do(http.MethodPost, "/orders/"+orderID+"/cancel", "cancel-1", `{"reason":"changed my mind"}`)
+	if retried.Code != http.StatusOK || retried.Header().Get("Idempotent-Replayed") != "true" || retried.Body.String() != cancelled.Body.String() {
+		t.Fatalf("expected a replay, got %d %v", retried.Code, retried.Header())
+	}
+	if w := do(http.MethodPost, "/orders/"+orderID+"/cancel", "", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a key, got %d", w.Code)
+	}
+
+	// the worker acknowledges the queued message without processing the order
+	if n, err := a.consumer.PollOnce(context.Background()); err != nil || n != 1 {
+		t.Fatalf("pollOnce = %d, %v", n, err)
+	}
+	if n := a.queue.Len(a.queueURL); n != 0 {
+		t.Fatalf("the cancelled order's message must be deleted, %d left", n)
+	}
+	get := httptest.NewRecorder()
+	a.router.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/orders/"+orderID, nil))
+	if !strings.Contains(get.Body.String(), `"status":"CANCELLED"`) {
+		t.Fatalf("expected the order to stay CANCELLED: %s", get.Body.String())
+	}
+
+	// completed orders cannot be cancelled
+	completedID := create("create-2")
+	if n, err := a.consumer.PollOnce(context.Background()); err != nil || n != 1 {
+		t.Fatalf("pollOnce = %d, %v", n, err)
+	}
+	if w := do(http.MethodPost, "/orders/"+completedID+"/cancel", "cancel-2", ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a completed order, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPost, "/orders/missing/cancel", "cancel-3", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestLocalFlow_RetryAfterFailureReportsTheFailedOrder(t *testing.T) {
+	a := newApp()
+	body := `{"customer_id":"c-declined","amount":{"amount":500,"currency":"USD"},"items":[{"sku":"sku-1","quantity":1,"price":{"amount":500,"currency":"USD"}}]}`
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", "declined-1")
+		w := httptest.NewRecorder()
+		a.router.ServeHTTP(w, req)
+		return w
+	}
+	created := post()
+	var order orders.Order
+	if err := json.Unmarshal(created.Body.Bytes(), &order); err != nil || created.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", created.Code, created.Body.String())
+	}
+	// the card is declined: the saga fails the order and marks the key FAILED
+	if n, err := a.consumer.PollOnce(context.Background()); err != nil || n != 1 {
+		t.Fatalf("pollOnce = %d, %v", n, err)
+	}
+
+	// every retry reports the terminal failure instead of asking for another retry
+	for i := 0; i < 2; i++ {
+		w := post()
+		var p struct {
+			Code    string `json:"code"`
+			OrderID string `json:"order_id"`
+			Status  string `json:"order_status"`
+			Reason  string `json:"reason"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("retry %d: expected 422, got %d: %s", i, w.Code, w.Body.String())
+		}
+		if p.Code != "order_failed" || p.OrderID != order.OrderID || p.Status != orders.StatusFailed || p.Reason == "" {
+			t.Fatalf("retry %d: unexpected problem %s", i, w.Body.String())
+		}
+	}
+	if n := a.queue.Len(a.queueURL); n != 0 {
+		t.Fatalf("retries must not enqueue again, %d messages", n)
+	}
+}
+
+func TestLocalFlow_RefundAfterCompletion(t *testing.T) {
+	a := newApp()
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		a.router.ServeHTTP(w, req)
+		return w
+	}
+	created := do(http.MethodPost, "/orders", "create-1", `{"customer_id":"cust-1","amount":{"amount":2550,"currency":"USD"},`+
+		`"items":[{"sku":"sku-1","quantity":2,"price":{"amount":1000,"currency":"USD"}},{"sku":"sku-2","quantity":1,"price":{"amount":550,"currency":"USD"}}]}`)
+	var order orders.Order
+	if err := json.Unmarshal(created.Body.Bytes(), &order); err != nil || created.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", created.Code, created.Body.String())
+	}
+	refunds := "/orders/" + order.OrderID + "/refunds"
+
+	// nothing to refund before the order completes
+	if w := do(http.MethodPost, refunds, "refund-0", ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a pending order, got %d: %s", w.Code, w.Body.String())
+	}
+	if n, err := a.consumer.PollOnce(context.Background()); err != nil || n != 1 {
+		t.Fatalf("pollOnce = %d, %v", n, err)
+	}
+
+	partial := do(http.MethodPost, refunds, "refund-1", `{"items":[{"sku":"sku-1","quantity":1}],"reason":"damaged"}`)
+	if partial.Code != http.StatusAccepted || !strings.Contains(partial.Body.String(), `"status":"PARTIALLY_REFUNDED"`) {
+		t.Fatalf("partial refund: %d %s", partial.Code, partial.Body.String())
+	}
+	if retried := do(http.MethodPost, refunds, "refund-1", `{"items":[{"sku":"sku-1","quantity":1}],"reason":"damaged"}`); retried.Header().Get("Idempotent-Replayed") != "true" || retried.Body.String() != partial.Body.String() {
+		t.Fatalf("expected the refund replayed, got %d %s", retried.Code, retried.Body.String())
+	}
+	if w := do(http.MethodPost, refunds, "", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a key, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, refunds, "refund-2", `{"items":[{"sku":"sku-2","quantity":2}]}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for more units than ordered, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// the rest, then nothing is left
+	if w := do(http.MethodPost, refunds, "refund-3", ""); w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), `"refunded_total":2550`) {
+		t.Fatalf("full refund: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPost, refunds, "refund-4", ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a refunded order, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// the worker pays both refunds out through the gateway
+	if n, err := a.consumer.PollOnce(context.Background()); err != nil || n != 2 {
+		t.Fatalf("pollOnce = %d, %v", n, err)
+	}
+	get := do(http.MethodGet, "/orders/"+order.OrderID, "", "")
+	if err := json.Unmarshal(get.Body.Bytes(), &order); err != nil {
+		t.Fatalf("decode order: %v", err)
+	}
+	if order.Status != orders.StatusRefunded || len(order.Refunds) != 2 || order.Refunds[0].Amount.Amount != 1000 {
+		t.Fatalf("unexpected order %s %+v", order.Status, order.Refunds)
+	}
+	for _, r := range order.Refunds {
+		if r.Status != orders.RefundSucceeded || r.ProviderID == "" {
+			t.Fatalf("expected the refund paid out, got %+v", r)
+		}
+	}
+}
+
+func TestLocalFlow_WebhooksForOrderTransitions(t *testing.T) {
+	a := newApp()
+	var (
+		mu       sync.Mutex
+		secret   string
+		received []webhooks.Payload
+		fail     = 1 // the first delivery is refused, to be retried
+	)
+	receiver := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if err := webhooks.Verify(secret, r.Header.Get(webhooks.HeaderSignature), body, time.Now(), 0); err != nil {
+			t.Errorf("signature: %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if fail > 0 {
+			fail--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var p webhooks.Payload
+		_ = json.Unmarshal(body, &p)
+		received = append(received, p)
+	}))
+	defer receiver.Close()
+	a.hookClient.Transport = receiver.Client().Transport // trusts the receiver's certificate
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		a.router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, url := range []string{"ftp://example.com", "http://169.254.169.254/latest/meta-data"} {
+		if w := do(http.MethodPost, "/customers/cust-1/webhooks", "", `{"url":"`+url+`"}`); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for a non-HTTPS URL %s, got %d", url, w.Code)
+		}
+	}
+	reg := do(http.MethodPost, "/customers/cust-1/webhooks", "", `{"url":"`+receiver.URL+`","events":["order.completed"]}`)
+	var ep webhooks.Endpoint
+	if err := json.Unmarshal(reg.Body.Bytes(), &ep); err != nil || reg.Code != http.StatusCreated || ep.Secret == "" {
+		t.Fatalf("register: %d %s", reg.Code, reg.Body.String())
+	}
+	mu.Lock()
+	secret = ep.Secret
+	mu.Unlock()
+	if w := do(http.MethodGet, "/customers/cust-1/webhooks", "", ""); w.Code != http.StatusOK || strings.Contains(w.Body.String(), ep.Secret) {
+		t.Fatalf("list must not show the secret: %d %s", w.Code, w.Body.String())
+	}
+
+	created := do(http.MethodPost, "/orders", "create-1", `{"customer_id":"cust-1","amount":{"amount":1000,"currency":"USD"},`+
+		`"items":[{"sku":"sku-1","quantity":1,"price":{"amount":1000,"currency":"USD"}}]}`)
+	var order orders.Order
+	if err := json.Unmarshal(created.Body.Bytes(), &order); err != nil || created.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", created.Code, created.Body.String())
+	}
+	if n, err := a.consumer.PollOnce(context.Background()); err != nil || n != 1 {
+		t.Fatalf("pollOnce = %d, %v", n, err)
+	}
+
+	// the first attempt is refused and waits for its backoff; a replay makes it due now
+	if _, err := a.hooks.Drain(context.Background()); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	deliveries := do(http.MethodGet, "/webhooks/"+ep.EndpointID+"/deliveries", "", "")
+	var listed struct{ Deliveries []webhooks.Delivery }
+	if err := json.Unmarshal(deliveries.Body.Bytes(), &listed); err != nil || len(listed.Deliveries) != 1 {
+		t.Fatalf("deliveries: %d %s", deliveries.Code, deliveries.Body.String())
+	}
+	d := listed.Deliveries[0]
+	if d.Status != webhooks.DeliveryPending || len(d.Log) != 1 || d.Log[0].StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected a failed attempt awaiting retry, got %+v", d)
+	}
+	if w := do(http.MethodPost, "/webhooks/"+ep.EndpointID+"/deliveries/"+d.EventID+"/replay", "", ""); w.Code != http.StatusAccepted {
+		t.Fatalf("replay: %d %s", w.Code, w.Body.String())
+	}
+	if _, err := a.hooks.Drain(context.Background()); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 || received[0].ID != d.EventID || received[0].Type != "order.completed" || received[0].Data.OrderID != order.OrderID {
+		t.Fatalf("expected the completed event once, got %+v", received)
+	}
+
+	if w := do(http.MethodDelete, "/webhooks/"+ep.EndpointID, "", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("disable: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodDelete, "/webhooks/missing", "", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 disabling a missing endpoint, got %d", w.Code)
+	}
+}
+
+func TestLocalFlow_CorrelationIDReachesWorker(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(logging.New(&buf, slog.LevelInfo))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	a := newApp()
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(
+		`{"customer_id":"cust-1","amount":{"amount":500,"currency":"USD"},"items":[{"sku":"sku-1","quantity":1,"price":{"amount":500,"currency":"USD"}}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", "local-key-corr")
+	req.Header.Set(logging.HeaderRequestID, "corr-e2e-1")
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated || w.Header().Get(logging.HeaderRequestID) != "corr-e2e-1" {
+		t.Fatalf("unexpected response %d %v: %s", w.Code, w.Header(), w.Body.String())
+	}
+	if n, err := a.consumer.PollOnce(context.Background()); err != nil || n != 1 {
+		t.Fatalf("pollOnce = %d, %v", n, err)
+	}
+
+	// the worker's "order completed" line carries the API request's correlation ID
+	var completed map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("log line is not JSON: %q", line)
+		}
+		if m["msg"] == "order completed" {
+			completed = m
+		}
+	}
+	if completed == nil {
+		t.Fatalf("no completion line in:\n%s", buf.String())
+	}
+	if completed[logging.KeyCorrelationID] != "corr-e2e-1" || completed[logging.KeyIdempotencyKey] != "local-key-corr" ||
+		completed[logging.KeyOrderID] == "" || completed[logging.KeyAttempt] != float64(1) {
+		t.Fatalf("unexpected worker log fields: %v", completed)
+	}
+}
+
+func TestLocalFlow_TraceContinuesFromAPIToWorker(t *testing.T) {
+	tp, rec := tracing.NewRecorder()
+	defer tp.Shutdown(context.Background())
+
+	a := newApp()
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(
+		`{"customer_id":"cust-1","amount":{"amount":500,"currency":"USD"},"items":[{"sku":"sku-1","quantity":1,"price":{"amount":500,"currency":"USD"}}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", "local-key-trace")
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if n, err := a.consumer.PollOnce(context.Background()); err != nil || n != 1 {
+		t.Fatalf("pollOnce = %d, %v", n, err)
+	}
+
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range rec.Ended() {
+		byName[s.Name()] = s
+	}
+	server, send, process := byName["POST /orders"], byName["sqs send"], byName["sqs process"]
+	if server == nil || send == nil || process == nil {
+		t.Fatalf("missing spans, got %v", byName)
+	}
+	if send.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Fatal("publish span is not a child of the request span")
+	}
+	if process.Parent().SpanID() != send.SpanContext().SpanID() || process.SpanContext().TraceID() != server.SpanContext().TraceID() {
+		t.Fatal("worker span does not continue the API trace")
+	}
+	if update := byName["orders.UpdateStatus"]; update == nil || update.SpanContext().TraceID() != server.SpanContext().TraceID() {
+		t.Fatal("worker store calls are not part of the trace")
+	}
+}
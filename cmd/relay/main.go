@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/config"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/inventory"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/outbox"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/webhooks"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err == nil {
+		err = cfg.Validate(config.Relay)
+	}
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	// JSON logs on stdout; the standard log package is routed through the same handler
+	slog.SetDefault(logging.New(os.Stdout, cfg.LogLevel))
+
+	tp, err := tracing.Setup(cfg.TracingExporter, "orderflow-relay", os.Stdout)
+	if err != nil {
+		log.Fatalf("tracing: %v", err)
+	}
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	clients, err := aws.NewAWSClientsWithOptions(context.Background(), cfg.AWS)
+	if err != nil {
+		log.Fatalf("failed to init aws clients: %v", err)
+	}
+
+	store := outbox.NewStore(clients.DynamoDB, cfg.OutboxTable, cfg.IdempotencyTTL)
+	relay := outbox.NewRelay(store, aws.NewPublisher(clients.SQS, cfg.QueueURL))
+
+	// with inventory enabled the relay also returns the stock of expired reservations
+	var expirer *inventory.Expirer
+	if cfg.StockTable != "" {
+		expirer = inventory.NewExpirer(inventory.NewStore(clients.DynamoDB, cfg.StockTable, cfg.ReservationsTable, cfg.ReservationHold))
+	}
+
+	// with webhooks enabled the relay also delivers the order events to the customers' endpoints
+	var dispatcher *webhooks.Dispatcher
+	if cfg.Webhooks() {
+		hooks := webhooks.NewStore(clients.DynamoDB, cfg.WebhookEndpointsTable, cfg.WebhookEventsTable, cfg.WebhookDeliveriesTable, cfg.IdempotencyTTL)
+		dispatcher = webhooks.NewDispatcher(hooks, orders.NewStore(clients.DynamoDB, cfg.OrdersTable), nil)
+	}
+
+	// if RUN_LOCAL is true, poll the outbox in a loop until interrupted.
+	if cfg.RunLocal {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		slog.Info("running local outbox relay")
+		if expirer != nil {
+			go func() {
+				if err := expirer.Run(ctx, cfg.RelayInterval); err != nil && ctx.Err() == nil {
+					slog.Error("reservation expirer stopped", logging.Err(err))
+				}
+			}()
+		}
+		if dispatcher != nil {
+			go func() {
+				if err := dispatcher.Run(ctx, cfg.RelayInterval); err != nil && ctx.Err() == nil {
+					slog.Error("webhook dispatcher stopped", logging.Err(err))
+				}
+			}()
+		}
+		if err := relay.Run(ctx, cfg.RelayInterval); err != nil && ctx.Err() == nil {
+			log.Fatalf("relay error: %v", err)
+		}
+		return
+	}
+
+	// invoked on a schedule: drain everything that is pending
+	lambda.Start(func(ctx context.Context) error {
+		n, err := relay.Drain(ctx)
+		slog.InfoContext(ctx, "outbox relayed entries", "count", n)
+		if expirer != nil {
+			released, xerr := expirer.Drain(ctx)
+			slog.InfoContext(ctx, "expired reservations released", "count", released)
+			err = errors.Join(err, xerr)
+		}
+		if dispatcher != nil {
+			dispatched, derr := dispatcher.Drain(ctx)
+			slog.InfoContext(ctx, "webhooks dispatched", "count", dispatched)
+			err = errors.Join(err, derr)
+		}
+		if ferr := tp.Flush(ctx); ferr != nil {
+			slog.Warn("trace flush failed", logging.Err(ferr))
+		}
+		return err
+	})
+}
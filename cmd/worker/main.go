@@ -1,52 +1,142 @@
-package worker
+package main
 
 import (
 	"context"
-	"encoding/json"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/blob"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/config"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/inventory"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/metrics"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/payments"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/shipping"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/webhooks"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/worker"
 )
 
+// For local development without AWS, run ./cmd/local, which feeds this Processor from an in-memory queue.
+func main() {
+	cfg, err := config.Load()
+	if err == nil {
+		err = cfg.Validate(config.Worker)
+	}
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	// JSON logs on stdout; the standard log package is routed through the same handler
+	slog.SetDefault(logging.New(os.Stdout, cfg.LogLevel))
 
+	tp, err := tracing.Setup(cfg.TracingExporter, "orderflow-worker", os.Stdout)
+	if err != nil {
+		log.Fatalf("tracing: %v", err)
+	}
+	defer func() { _ = tp.Shutdown(context.Background()) }()
 
-func handleSQSEvent(ctx context.Context, event events.SQSEvent) error {
-	log.Printf("received %d SQS messages", len(event.Records))
-	for _, r := range event.Records {
-		var msg WorkerMessage
-		if err := json.Unmarshal([]byte(r.Body), &msg); err != nil {
-			log.Printf("failed to unmarshal message body: %v, body: %s", err, r.Body)
-			// return error so SQS/Lambda runtime will handle retries
-			return err
-		}
-		// TODO: replace with actual idempotency & order processing logic
-		log.Printf("processing order_id=%s idempotency_key=%s", msg.OrderID, msg.IdempotencyKey)
+	clients, err := aws.NewAWSClientsWithOptions(context.Background(), cfg.AWS)
+	if err != nil {
+		log.Fatalf("failed to init aws clients: %v", err)
 	}
-	return nil
-}
 
-func main() {
-	// If RUN_LOCAL=true, we can optionally simulate a single SQS event for local testing.
-	if os.Getenv("RUN_LOCAL") == "true" {
-		// Local testing helper: simulate an event using environment variables
-		testBody := os.Getenv("LOCAL_SQS_BODY")
-		if testBody == "" {
-			testBody = `{"order_id":"local-order-1","idempotency_key":"local-key-1"}`
-		}
-		event := events.SQSEvent{
-			Records: []events.SQSMessage{
-				{
-					Body: testBody,
-				},
-			},
-		}
-		if err := handleSQSEvent(context.Background(), event); err != nil {
-			log.Fatalf("local handler error: %v", err)
+	recorder, err := metrics.NewRecorder(cfg.MetricsSink, cfg.MetricsNamespace, clients.CloudWatch, os.Stdout)
+	if err != nil {
+		log.Fatalf("metrics: %v", err)
+	}
+
+	blobs, err := blob.Open(cfg.BlobStore, clients.S3)
+	if err != nil {
+		log.Fatalf("blob store: %v", err)
+	}
+
+	gw, err := payments.NewGateway(cfg.PaymentGateway)
+	if err != nil {
+		log.Fatalf("payments: %v", err)
+	}
+	carrier, err := shipping.NewService(cfg.ShippingService)
+	if err != nil {
+		log.Fatalf("shipping: %v", err)
+	}
+
+	// validate, reserve, authorize, capture, commit, ship: the reservation is consumed only once the
+	// order is paid. Each step with side effects brings its own compensation.
+	steps := []worker.Step{worker.ValidateStep()}
+	var inv *inventory.Store
+	if cfg.StockTable != "" {
+		inv = inventory.NewStore(clients.DynamoDB, cfg.StockTable, cfg.ReservationsTable, cfg.ReservationHold)
+		steps = append(steps, worker.ReserveInventoryStep(inv))
+	}
+	if gw != nil {
+		orderStore := orders.NewStore(clients.DynamoDB, cfg.OrdersTable)
+		steps = append(steps, worker.AuthorizePaymentStep(gw, orderStore), worker.CapturePaymentStep(gw, orderStore))
+	}
+	if inv != nil {
+		steps = append(steps, worker.CommitInventoryStep(inv))
+	}
+	if carrier != nil {
+		steps = append(steps, worker.CreateShipmentStep(carrier))
+	}
+	p := worker.NewProcessor(clients, cfg.IdempotencyTable, cfg.OrdersTable, cfg.IdempotencyTTL).
+		WithMetrics(recorder).
+		WithSteps(steps...)
+	if gw != nil {
+		p.WithPaymentGateway(gw)
+	}
+	if blobs != nil {
+		p.WithBlobStore(blobs)
+	}
+	if cfg.Webhooks() {
+		p.WithTransitionRecorder(webhooks.NewStore(clients.DynamoDB, cfg.WebhookEndpointsTable, cfg.WebhookEventsTable, cfg.WebhookDeliveriesTable, cfg.IdempotencyTTL))
+	}
+
+	// WORKER_MODE=consumer runs the worker as a long-running process (e.g. in a container)
+	// that long-polls ORDERS_QUEUE_URL instead of being invoked by Lambda.
+	if cfg.WorkerMode == config.WorkerModeConsumer {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		// flushing stops only after the consumer has drained, so the drain's metrics are published too
+		flushCtx, stopFlush := context.WithCancel(context.Background())
+		flushed := make(chan struct{})
+		go func() {
+			defer close(flushed)
+			metrics.FlushEvery(flushCtx, recorder, cfg.MetricsFlushInterval, func(err error) {
+				slog.Warn("metrics flush failed", logging.Err(err))
+			})
+		}()
+
+		c := worker.NewConsumer(clients.SQS, p, worker.ConsumerConfig{
+			QueueURL:          cfg.QueueURL,
+			Concurrency:       cfg.WorkerConcurrency,
+			VisibilityTimeout: cfg.WorkerVisibilityTimeout,
+			DrainTimeout:      cfg.WorkerDrainTimeout,
+		})
+		slog.Info("running worker consumer")
+		err := c.Run(ctx)
+		stopFlush()
+		<-flushed
+		if err != nil && ctx.Err() == nil {
+			log.Fatalf("consumer error: %v", err)
 		}
 		return
 	}
 
-	lambda.Start(handleSQSEvent)
+	lambda.Start(func(ctx context.Context, ev events.SQSEvent) (events.SQSEventResponse, error) {
+		resp, err := p.Handle(ctx, ev)
+		// publish this invocation's metrics before Lambda freezes the environment
+		if ferr := recorder.Flush(ctx); ferr != nil {
+			slog.Warn("metrics flush failed", logging.Err(ferr))
+		}
+		if ferr := tp.Flush(ctx); ferr != nil {
+			slog.Warn("trace flush failed", logging.Err(ferr))
+		}
+		return resp, err
+	})
 }
@@ -15,11 +15,31 @@ module "sqs" {
   visibility_timeout_seconds = 60
 }
 
+# Idempotent response bodies too large for DynamoDB. Objects outlive the 48h record TTL by a day.
+resource "aws_s3_bucket" "idempotency_blobs" {
+  bucket_prefix = "${var.project_name}-idem-staging-"
+}
+
+resource "aws_s3_bucket_lifecycle_configuration" "idempotency_blobs" {
+  bucket = aws_s3_bucket.idempotency_blobs.id
+  rule {
+    id     = "expire-responses"
+    status = "Enabled"
+    filter {
+      prefix = "idempotency/"
+    }
+    expiration {
+      days = 3
+    }
+  }
+}
+
 module "iam_api" {
   source = "../../modules/iam"
   lambda_name = "${var.project_name}-api-staging"
-  dynamodb_table_arns = [module.dynamodb.orders_table_arn, module.dynamodb.idempotency_table_arn]
+  dynamodb_table_arns = [module.dynamodb.orders_table_arn, module.dynamodb.idempotency_table_arn, module.dynamodb.outbox_table_arn, module.dynamodb.stock_table_arn, module.dynamodb.reservations_table_arn, module.dynamodb.webhook_endpoints_table_arn, module.dynamodb.webhook_events_table_arn, module.dynamodb.webhook_deliveries_table_arn]
   sqs_queue_arn = module.sqs.queue_arn
+  s3_bucket_arns = [aws_s3_bucket.idempotency_blobs.arn]
 }
 
 module "lambda_api" {
@@ -31,15 +51,24 @@ module "lambda_api" {
   environment = {
     IDEMPOTENCY_TABLE = module.dynamodb.idempotency_table_name
     ORDERS_TABLE = module.dynamodb.orders_table_name
+    OUTBOX_TABLE = module.dynamodb.outbox_table_name
     ORDERS_QUEUE_URL = module.sqs.queue_url
+    STOCK_TABLE = module.dynamodb.stock_table_name
+    RESERVATIONS_TABLE = module.dynamodb.reservations_table_name
+    WEBHOOK_ENDPOINTS_TABLE = module.dynamodb.webhook_endpoints_table_name
+    WEBHOOK_EVENTS_TABLE = module.dynamodb.webhook_events_table_name
+    WEBHOOK_DELIVERIES_TABLE = module.dynamodb.webhook_deliveries_table_name
+    IDEMPOTENCY_BLOB_STORE = "s3://${aws_s3_bucket.idempotency_blobs.bucket}"
+    METRICS_SINK = "emf" # metrics go out as log lines; no PutMetricData permission needed
   }
 }
 
 module "iam_worker" {
   source = "../../modules/iam"
   lambda_name = "${var.project_name}-worker-staging"
-  dynamodb_table_arns = [module.dynamodb.orders_table_arn, module.dynamodb.idempotency_table_arn]
+  dynamodb_table_arns = [module.dynamodb.orders_table_arn, module.dynamodb.idempotency_table_arn, module.dynamodb.stock_table_arn, module.dynamodb.reservations_table_arn, module.dynamodb.webhook_endpoints_table_arn, module.dynamodb.webhook_events_table_arn, module.dynamodb.webhook_deliveries_table_arn]
   sqs_queue_arn = module.sqs.queue_arn
+  s3_bucket_arns = [aws_s3_bucket.idempotency_blobs.arn]
 }
 
 module "lambda_worker" {
@@ -51,15 +80,69 @@ module "lambda_worker" {
   environment = {
     IDEMPOTENCY_TABLE = module.dynamodb.idempotency_table_name
     ORDERS_TABLE = module.dynamodb.orders_table_name
+    STOCK_TABLE = module.dynamodb.stock_table_name
+    RESERVATIONS_TABLE = module.dynamodb.reservations_table_name
+    WEBHOOK_ENDPOINTS_TABLE = module.dynamodb.webhook_endpoints_table_name
+    WEBHOOK_EVENTS_TABLE = module.dynamodb.webhook_events_table_name
+    WEBHOOK_DELIVERIES_TABLE = module.dynamodb.webhook_deliveries_table_name
+    IDEMPOTENCY_BLOB_STORE = "s3://${aws_s3_bucket.idempotency_blobs.bucket}"
+    METRICS_SINK = "emf"
   }
 }
 
 # Event source mapping (SQS -> Lambda)
+# The worker reports failures per message, so a poison message only redelivers itself.
 resource "aws_lambda_event_source_mapping" "sqs_to_worker" {
-  event_source_arn = module.sqs.queue_arn
-  function_name    = module.lambda_worker.lambda_arn
-  batch_size       = 1
-  enabled          = true
+  event_source_arn                   = module.sqs.queue_arn
+  function_name                      = module.lambda_worker.lambda_arn
+  batch_size                         = 10
+  maximum_batching_window_in_seconds = 1
+  function_response_types            = ["ReportBatchItemFailures"]
+  enabled                            = true
+}
+
+module "iam_relay" {
+  source = "../../modules/iam"
+  lambda_name = "${var.project_name}-relay-staging"
+  dynamodb_table_arns = [module.dynamodb.orders_table_arn, module.dynamodb.outbox_table_arn, module.dynamodb.stock_table_arn, module.dynamodb.reservations_table_arn, module.dynamodb.webhook_endpoints_table_arn, module.dynamodb.webhook_events_table_arn, module.dynamodb.webhook_deliveries_table_arn]
+  sqs_queue_arn = module.sqs.queue_arn
+}
+
+module "lambda_relay" {
+  source = "../../modules/lambda"
+  function_name = "${var.project_name}-relay-staging"
+  s3_bucket = var.lambda_s3_bucket
+  s3_key = var.lambda_relay_s3_key
+  role_arn = module.iam_relay.lambda_role_arn
+  environment = {
+    ORDERS_TABLE = module.dynamodb.orders_table_name # webhook payloads carry the order's customer
+    OUTBOX_TABLE = module.dynamodb.outbox_table_name
+    ORDERS_QUEUE_URL = module.sqs.queue_url
+    STOCK_TABLE = module.dynamodb.stock_table_name
+    RESERVATIONS_TABLE = module.dynamodb.reservations_table_name
+    WEBHOOK_ENDPOINTS_TABLE = module.dynamodb.webhook_endpoints_table_name
+    WEBHOOK_EVENTS_TABLE = module.dynamodb.webhook_events_table_name
+    WEBHOOK_DELIVERIES_TABLE = module.dynamodb.webhook_deliveries_table_name
+  }
+}
+
+# The API publishes eagerly; the relay sweeps up anything it failed to publish.
+resource "aws_cloudwatch_event_rule" "relay_schedule" {
+  name                = "${var.project_name}-relay-staging"
+  schedule_expression = "rate(1 minute)"
+}
+
+resource "aws_cloudwatch_event_target" "relay" {
+  rule = aws_cloudwatch_event_rule.relay_schedule.name
+  arn  = module.lambda_relay.lambda_arn
+}
+
+resource "aws_lambda_permission" "relay_schedule" {
+  statement_id  = "AllowEventBridgeInvoke"
+  action        = "lambda:InvokeFunction"
+  function_name = module.lambda_relay.lambda_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.relay_schedule.arn
 }
 
 output "api_function_url" {
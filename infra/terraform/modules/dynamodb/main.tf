@@ -1,6 +1,12 @@
 locals {
-  orders_table_name      = length(var.orders_table_name) > 0 ? var.orders_table_name : "${var.name_prefix}-orders"
-  idempotency_table_name = length(var.idempotency_table_name) > 0 ? var.idempotency_table_name : "${var.name_prefix}-idempotency"
+  orders_table_name             = length(var.orders_table_name) > 0 ? var.orders_table_name : "${var.name_prefix}-orders"
+  idempotency_table_name        = length(var.idempotency_table_name) > 0 ? var.idempotency_table_name : "${var.name_prefix}-idempotency"
+  outbox_table_name             = length(var.outbox_table_name) > 0 ? var.outbox_table_name : "${var.name_prefix}-outbox"
+  stock_table_name              = length(var.stock_table_name) > 0 ? var.stock_table_name : "${var.name_prefix}-stock"
+  reservations_table_name       = length(var.reservations_table_name) > 0 ? var.reservations_table_name : "${var.name_prefix}-reservations"
+  webhook_endpoints_table_name  = length(var.webhook_endpoints_table_name) > 0 ? var.webhook_endpoints_table_name : "${var.name_prefix}-webhook-endpoints"
+  webhook_events_table_name     = length(var.webhook_events_table_name) > 0 ? var.webhook_events_table_name : "${var.name_prefix}-webhook-events"
+  webhook_deliveries_table_name = length(var.webhook_deliveries_table_name) > 0 ? var.webhook_deliveries_table_name : "${var.name_prefix}-webhook-deliveries"
 }
 
 resource "aws_dynamodb_table" "orders" {
@@ -54,3 +60,189 @@ resource "aws_dynamodb_table" "idempotency" {
     Name = local.idempotency_table_name
   }
 }
+
+# transactional outbox: written in the same transaction as the order, drained by the relay
+resource "aws_dynamodb_table" "outbox" {
+  name         = local.outbox_table_name
+  billing_mode = var.billing_mode
+  hash_key     = "outbox_id"
+
+  attribute {
+    name = "outbox_id"
+    type = "S"
+  }
+  attribute {
+    name = "status"
+    type = "S"
+  }
+  attribute {
+    name = "created_at"
+    type = "S"
+  }
+
+  global_secondary_index {
+    name            = "status_index"
+    hash_key        = "status"
+    range_key       = "created_at"
+    projection_type = "ALL"
+  }
+
+  ttl {
+    attribute_name = "expires_at"
+    enabled        = true
+  }
+
+  tags = {
+    Name = local.outbox_table_name
+  }
+}
+
+# inventory: available and reserved units per SKU
+resource "aws_dynamodb_table" "stock" {
+  name         = local.stock_table_name
+  billing_mode = var.billing_mode
+  hash_key     = "sku"
+
+  attribute {
+    name = "sku"
+    type = "S"
+  }
+
+  tags = {
+    Name = local.stock_table_name
+  }
+}
+
+# stock reservations per order; expires_at is not a TTL, the relay releases expired holds
+resource "aws_dynamodb_table" "reservations" {
+  name         = local.reservations_table_name
+  billing_mode = var.billing_mode
+  hash_key     = "order_id"
+
+  attribute {
+    name = "order_id"
+    type = "S"
+  }
+  attribute {
+    name = "status"
+    type = "S"
+  }
+  attribute {
+    name = "expires_at"
+    type = "N"
+  }
+
+  global_secondary_index {
+    name            = "status_index"
+    hash_key        = "status"
+    range_key       = "expires_at"
+    projection_type = "ALL"
+  }
+
+  tags = {
+    Name = local.reservations_table_name
+  }
+}
+
+# customers' webhook endpoints
+resource "aws_dynamodb_table" "webhook_endpoints" {
+  name         = local.webhook_endpoints_table_name
+  billing_mode = var.billing_mode
+  hash_key     = "endpoint_id"
+
+  attribute {
+    name = "endpoint_id"
+    type = "S"
+  }
+  attribute {
+    name = "customer_id"
+    type = "S"
+  }
+  attribute {
+    name = "created_at"
+    type = "S"
+  }
+
+  global_secondary_index {
+    name            = "customer_id_index"
+    hash_key        = "customer_id"
+    range_key       = "created_at"
+    projection_type = "ALL"
+  }
+
+  tags = {
+    Name = local.webhook_endpoints_table_name
+  }
+}
+
+# order status transitions, written in the same transaction as the order; the relay fans them out
+resource "aws_dynamodb_table" "webhook_events" {
+  name         = local.webhook_events_table_name
+  billing_mode = var.billing_mode
+  hash_key     = "event_id"
+
+  attribute {
+    name = "event_id"
+    type = "S"
+  }
+  attribute {
+    name = "status"
+    type = "S"
+  }
+  attribute {
+    name = "created_at"
+    type = "S"
+  }
+
+  global_secondary_index {
+    name            = "status_index"
+    hash_key        = "status"
+    range_key       = "created_at"
+    projection_type = "ALL"
+  }
+
+  ttl {
+    attribute_name = "expires_at"
+    enabled        = true
+  }
+
+  tags = {
+    Name = local.webhook_events_table_name
+  }
+}
+
+# one delivery per endpoint and event, with its attempt log
+resource "aws_dynamodb_table" "webhook_deliveries" {
+  name         = local.webhook_deliveries_table_name
+  billing_mode = var.billing_mode
+  hash_key     = "endpoint_id"
+  range_key    = "event_id"
+
+  attribute {
+    name = "endpoint_id"
+    type = "S"
+  }
+  attribute {
+    name = "event_id"
+    type = "S"
+  }
+  attribute {
+    name = "status"
+    type = "S"
+  }
+  attribute {
+    name = "next_attempt_at"
+    type = "N"
+  }
+
+  global_secondary_index {
+    name            = "status_index"
+    hash_key        = "status"
+    range_key       = "next_attempt_at"
+    projection_type = "ALL"
+  }
+
+  tags = {
+    Name = local.webhook_deliveries_table_name
+  }
+}
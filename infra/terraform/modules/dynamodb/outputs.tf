@@ -10,3 +10,39 @@ output "orders_table_arn" {
 output "idempotency_table_arn" {
   value = aws_dynamodb_table.idempotency.arn
 }
+output "outbox_table_name" {
+  value = aws_dynamodb_table.outbox.name
+}
+output "outbox_table_arn" {
+  value = aws_dynamodb_table.outbox.arn
+}
+output "stock_table_name" {
+  value = aws_dynamodb_table.stock.name
+}
+output "stock_table_arn" {
+  value = aws_dynamodb_table.stock.arn
+}
+output "reservations_table_name" {
+  value = aws_dynamodb_table.reservations.name
+}
+output "reservations_table_arn" {
+  value = aws_dynamodb_table.reservations.arn
+}
+output "webhook_endpoints_table_name" {
+  value = aws_dynamodb_table.webhook_endpoints.name
+}
+output "webhook_endpoints_table_arn" {
+  value = aws_dynamodb_table.webhook_endpoints.arn
+}
+output "webhook_events_table_name" {
+  value = aws_dynamodb_table.webhook_events.name
+}
+output "webhook_events_table_arn" {
+  value = aws_dynamodb_table.webhook_events.arn
+}
+output "webhook_deliveries_table_name" {
+  value = aws_dynamodb_table.webhook_deliveries.name
+}
+output "webhook_deliveries_table_arn" {
+  value = aws_dynamodb_table.webhook_deliveries.arn
+}
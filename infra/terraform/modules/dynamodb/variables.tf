@@ -19,3 +19,39 @@ variable "idempotency_table_name" {
   description = "Idempotency table name (optional override)"
   default     = ""
 }
+
+variable "outbox_table_name" {
+  type        = string
+  description = "Outbox table name (optional override)"
+  default     = ""
+}
+
+variable "stock_table_name" {
+  type        = string
+  description = "Stock table name (optional override)"
+  default     = ""
+}
+
+variable "reservations_table_name" {
+  type        = string
+  description = "Reservations table name (optional override)"
+  default     = ""
+}
+
+variable "webhook_endpoints_table_name" {
+  type        = string
+  description = "Webhook endpoints table name (optional override)"
+  default     = ""
+}
+
+variable "webhook_events_table_name" {
+  type        = string
+  description = "Webhook events table name (optional override)"
+  default     = ""
+}
+
+variable "webhook_deliveries_table_name" {
+  type        = string
+  description = "Webhook deliveries table name (optional override)"
+  default     = ""
+}
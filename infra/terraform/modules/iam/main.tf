@@ -23,9 +23,11 @@ data "aws_iam_policy_document" "lambda_policy_doc" {
       "dynamodb:GetItem",
       "dynamodb:PutItem",
       "dynamodb:UpdateItem",
-      "dynamodb:TransactWriteItems"
+      "dynamodb:TransactWriteItems",
+      "dynamodb:Query"
     ]
-    resources = var.dynamodb_table_arns
+    # tables plus their GSIs (queries target the index ARN)
+    resources = concat(var.dynamodb_table_arns, [for arn in var.dynamodb_table_arns : "${arn}/index/*"])
   }
 
   statement {
@@ -41,6 +43,19 @@ data "aws_iam_policy_document" "lambda_policy_doc" {
     resources = [var.sqs_queue_arn]
   }
 
+  dynamic "statement" {
+    for_each = length(var.s3_bucket_arns) > 0 ? [1] : []
+    content {
+      sid     = "S3BlobAccess"
+      effect  = "Allow"
+      actions = [
+        "s3:GetObject",
+        "s3:PutObject"
+      ]
+      resources = [for arn in var.s3_bucket_arns : "${arn}/*"]
+    }
+  }
+
   statement {
     sid     = "CloudWatchLogs"
     effect  = "Allow"
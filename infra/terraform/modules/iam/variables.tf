@@ -10,6 +10,12 @@ variable "sqs_queue_arn" {
 	type = string
 }
 
+# Bucket ARNs the function reads and writes objects in (the idempotency blob store)
+variable "s3_bucket_arns" {
+	type    = list(string)
+	default = []
+}
+
 variable "cloudwatch_namespace" {
 	type    = string
 	default = "orders-app"
@@ -5,6 +5,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 )
 
@@ -13,9 +14,10 @@ type AWSClients struct {
 	DynamoDB   DynamoDBAPI
 	SQS        SQSAPI
 	CloudWatch CloudWatchAPI
+	S3         S3API
 }
 
-// NewAWSClients loads AWS config and returns concrete service clients that implement our interfaces.
+// NewAWSClients loads AWS config from the environment and returns concrete service clients that implement our interfaces.
 func NewAWSClients(ctx context.Context) (*AWSClients, error) {
 	cfg, err := LoadAWSConfig(ctx)
 	if err != nil {
@@ -26,5 +28,40 @@ func NewAWSClients(ctx context.Context) (*AWSClients, error) {
 		DynamoDB:   dynamodb.NewFromConfig(cfg),
 		SQS:        sqs.NewFromConfig(cfg),
 		CloudWatch: cloudwatch.NewFromConfig(cfg),
+		S3:         s3.NewFromConfig(cfg),
+	}, nil
+}
+
+// NewAWSClientsWithOptions is NewAWSClients for an explicit region and per-service endpoint overrides.
+func NewAWSClientsWithOptions(ctx context.Context, opts ClientOptions) (*AWSClients, error) {
+	cfg, err := LoadAWSConfigWithOptions(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ep := opts.Endpoints
+	return &AWSClients{
+		DynamoDB: dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+			if u := ep.resolve(ep.DynamoDB); u != "" {
+				o.BaseEndpoint = &u
+			}
+		}),
+		SQS: sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+			if u := ep.resolve(ep.SQS); u != "" {
+				o.BaseEndpoint = &u
+			}
+		}),
+		CloudWatch: cloudwatch.NewFromConfig(cfg, func(o *cloudwatch.Options) {
+			if u := ep.resolve(ep.CloudWatch); u != "" {
+				o.BaseEndpoint = &u
+			}
+		}),
+		S3: s3.NewFromConfig(cfg, func(o *s3.Options) {
+			if u := ep.resolve(ep.S3); u != "" {
+				o.BaseEndpoint = &u
+				// LocalStack and other emulators do not serve virtual-hosted bucket names
+				o.UsePathStyle = true
+			}
+		}),
 	}, nil
 }
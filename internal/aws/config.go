@@ -9,21 +9,56 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 )
 
+// DefaultRegion is used when no region is configured.
+const DefaultRegion = "ap-south-1"
+
+// ClientOptions selects the region and endpoints the service clients talk to.
+type ClientOptions struct {
+	Region    string // DefaultRegion when empty
+	Endpoints Endpoints
+}
+
+// Endpoints overrides service endpoints, e.g. to point at LocalStack.
+// Empty fields fall back to Default, and an empty Default to the real AWS endpoints.
+type Endpoints struct {
+	Default    string
+	DynamoDB   string
+	SQS        string
+	CloudWatch string
+	S3         string
+}
+
+func (e Endpoints) resolve(service string) string {
+	if service != "" {
+		return service
+	}
+	return e.Default
+}
+
+// LoadAWSConfig loads the shared AWS config using AWS_REGION and AWS_ENDPOINT_OVERRIDE from the environment.
 func LoadAWSConfig(ctx context.Context) (sdkaws.Config, error) {
-	region := os.Getenv("AWS_REGION")
+	return LoadAWSConfigWithOptions(ctx, ClientOptions{
+		Region:    os.Getenv("AWS_REGION"),
+		Endpoints: Endpoints{Default: os.Getenv("AWS_ENDPOINT_OVERRIDE")},
+	})
+}
+
+// LoadAWSConfigWithOptions loads the shared AWS config for opts.Region, with opts.Endpoints.Default
+// as the base endpoint of every client. Per-service endpoints are applied by NewAWSClientsWithOptions.
+func LoadAWSConfigWithOptions(ctx context.Context, opts ClientOptions) (sdkaws.Config, error) {
+	region := opts.Region
 	if region == "" {
-		region = "ap-south-1" // default fallback
+		region = DefaultRegion // default fallback
 	}
 
-	var cfg sdkaws.Config
-	var err error
-
-	cfg, err = config.LoadDefaultConfig(ctx,
-		config.WithRegion(region),
-	)
+	loadOpts := []func(*config.LoadOptions) error{config.WithRegion(region)}
+	if opts.Endpoints.Default != "" {
+		loadOpts = append(loadOpts, config.WithBaseEndpoint(opts.Endpoints.Default))
+	}
 
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
-		return cfg, fmt.Errorf("failed to load AWS config: %w", err)
+		return cfg, fmt.Errorf("%w: failed to load AWS config: %w", ErrAWSConfig, err)
 	}
 
 	return cfg, nil
@@ -3,9 +3,13 @@ package aws
 import (
 	"context"
 	"fmt"
+	"maps"
 
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
 )
 
 // Publisher wraps an SQS client and a queue URL.
@@ -23,8 +27,21 @@ func NewPublisher(sqsClient SQSAPI, queueURL string) *Publisher {
 }
 
 // SendOrderMessage sends an order message to SQS. messageBody should be a JSON string.
-// attributes map[string]string -> sent as MessageAttributes.
-func (p *Publisher) SendOrderMessage(ctx context.Context, messageBody string, attributes map[string]string) error {
+// attributes map[string]string -> sent as MessageAttributes, together with the trace context of the
+// send span so the worker continues the trace. When ctx carries no span (e.g. the outbox relay), the
+// trace context stored in attributes by the request that wrote the message is continued instead.
+func (p *Publisher) SendOrderMessage(ctx context.Context, messageBody string, attributes map[string]string) (err error) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = tracing.Extract(ctx, attributes)
+	}
+	ctx, span := tracing.StartSQSSend(ctx, p.QueueURL)
+	defer tracing.End(span, &err)
+	attributes = maps.Clone(attributes)
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	tracing.Inject(ctx, attributes)
+
 	input := &sqs.SendMessageInput{
 		QueueUrl:    &p.QueueURL,
 		MessageBody: &messageBody,
@@ -41,7 +58,7 @@ func (p *Publisher) SendOrderMessage(ctx context.Context, messageBody string, at
 		input.MessageAttributes = msgAttrs
 	}
 
-	_, err := p.SQS.SendMessage(ctx, input)
+	_, err = p.SQS.SendMessage(ctx, input)
 	if err != nil {
 		return fmt.Errorf("send message: %w", err)
 	}
@@ -0,0 +1,95 @@
+// Package blob stores opaque byte payloads too large to keep in a DynamoDB item, such as big
+// idempotent responses. Payloads are written once under a key and read back by the same key;
+// the backends are S3 in production and a directory or memory when running locally.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+)
+
+// ErrNotFound is returned by Get when nothing is stored under the key.
+var ErrNotFound = errors.New("blob not found")
+
+// Store reads and writes payloads by key. Keys are slash-separated paths such as
+// "idempotency/<hash>/<id>"; implementations must be safe for concurrent use.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// Store URL schemes accepted by Open.
+const (
+	SchemeMemory = "memory"
+	SchemeFile   = "file"
+	SchemeS3     = "s3"
+)
+
+// Open returns the store named by rawURL:
+//
+//	memory                  in-process map (tests and single-process local runs only)
+//	file:///var/lib/blobs   files under a directory
+//	s3://bucket/prefix      objects in an S3 bucket, under an optional key prefix
+//
+// s3 is only used for s3:// URLs. An empty rawURL returns a nil Store.
+func Open(rawURL string, s3 aws.S3API) (Store, error) {
+	loc, err := parseURL(rawURL)
+	if err != nil || loc == nil {
+		return nil, err
+	}
+	switch loc.scheme {
+	case SchemeMemory:
+		return NewMemory(), nil
+	case SchemeFile:
+		return NewDir(loc.path), nil
+	default:
+		if s3 == nil {
+			return nil, fmt.Errorf("blob store %q: no S3 client", rawURL)
+		}
+		return NewS3(s3, loc.bucket, loc.path), nil
+	}
+}
+
+// CheckURL reports whether rawURL is a store URL Open accepts, without opening it.
+func CheckURL(rawURL string) error {
+	_, err := parseURL(rawURL)
+	return err
+}
+
+type location struct {
+	scheme string
+	bucket string // s3 only
+	path   string // directory for file, key prefix for s3
+}
+
+func parseURL(rawURL string) (*location, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	if rawURL == SchemeMemory {
+		return &location{scheme: SchemeMemory}, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("blob store %q: %w", rawURL, err)
+	}
+	switch u.Scheme {
+	case SchemeFile:
+		if u.Host != "" || u.Path == "" {
+			return nil, fmt.Errorf("blob store %q: want file:///absolute/dir", rawURL)
+		}
+		return &location{scheme: SchemeFile, path: u.Path}, nil
+	case SchemeS3:
+		if u.Host == "" {
+			return nil, fmt.Errorf("blob store %q: missing bucket", rawURL)
+		}
+		return &location{scheme: SchemeS3, bucket: u.Host, path: strings.Trim(u.Path, "/")}, nil
+	default:
+		return nil, fmt.Errorf("blob store %q: want %s, %s:// or %s://", rawURL, SchemeMemory, SchemeFile, SchemeS3)
+	}
+}
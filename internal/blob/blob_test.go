@@ -0,0 +1,94 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeS3 keeps objects by bucket/key.
+type fakeS3 struct {
+	objects map[string][]byte
+}
+
+func (f *fakeS3) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	b, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.objects[*in.Bucket+"/"+*in.Key] = b
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObject(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	b, ok := f.objects[*in.Bucket+"/"+*in.Key]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func TestStores_RoundTripAndNotFound(t *testing.T) {
+	ctx := context.Background()
+	s3c := &fakeS3{objects: map[string][]byte{}}
+	stores := map[string]Store{
+		"memory": NewMemory(),
+		"dir":    NewDir(t.TempDir()),
+		"s3":     NewS3(s3c, "bucket", "responses"),
+	}
+	payload := bytes.Repeat([]byte("order "), 1000)
+	for name, s := range stores {
+		if err := s.Put(ctx, "idempotency/abc/1", payload); err != nil {
+			t.Fatalf("%s: put: %v", name, err)
+		}
+		got, err := s.Get(ctx, "idempotency/abc/1")
+		if err != nil || !bytes.Equal(got, payload) {
+			t.Fatalf("%s: get returned %d bytes, %v", name, len(got), err)
+		}
+		if _, err := s.Get(ctx, "idempotency/abc/2"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: expected ErrNotFound, got %v", name, err)
+		}
+	}
+	if _, ok := s3c.objects["bucket/responses/idempotency/abc/1"]; !ok {
+		t.Fatalf("object not written under the prefix: %v", s3c.objects)
+	}
+}
+
+func TestDir_RejectsKeysEscapingRoot(t *testing.T) {
+	d := NewDir(t.TempDir())
+	for _, key := range []string{"", "../x", "a/../../x", "/abs", "a//b"} {
+		if err := d.Put(context.Background(), key, []byte("x")); err == nil {
+			t.Fatalf("expected key %q to be rejected", key)
+		}
+	}
+}
+
+func TestOpen_URLs(t *testing.T) {
+	s, err := Open("", nil)
+	if err != nil || s != nil {
+		t.Fatalf("empty URL: %v, %v", s, err)
+	}
+	if s, err := Open("memory", nil); err != nil || s == nil {
+		t.Fatalf("memory: %v", err)
+	}
+	if s, err := Open("file:///tmp/blobs", nil); err != nil || s.(*Dir).root != "/tmp/blobs" {
+		t.Fatalf("file: %v", err)
+	}
+	s, err = Open("s3://bucket/idem/", &fakeS3{})
+	if err != nil || s.(*S3).bucket != "bucket" || s.(*S3).prefix != "idem" {
+		t.Fatalf("s3: %+v, %v", s, err)
+	}
+	for _, bad := range []string{"s3://", "file://host/dir", "ftp://x", "bucket"} {
+		if CheckURL(bad) == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+	if _, err := Open("s3://bucket", nil); err == nil {
+		t.Fatal("expected an error without an S3 client")
+	}
+}
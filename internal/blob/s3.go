@@ -0,0 +1,75 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
+)
+
+// S3 stores payloads as objects in a bucket. Expire them with a lifecycle rule on the prefix
+// that outlives the idempotency TTL; nothing here deletes objects.
+type S3 struct {
+	client aws.S3API
+	bucket string
+	prefix string
+}
+
+// NewS3 returns a store writing to bucket, with every key under prefix (which may be empty).
+func NewS3(client aws.S3API, bucket, prefix string) *S3 {
+	return &S3{client: client, bucket: bucket, prefix: prefix}
+}
+
+// Put uploads data as the key's object.
+func (s *S3) Put(ctx context.Context, key string, data []byte) (err error) {
+	objKey := s.objectKey(key)
+	ctx, span := tracing.StartS3(ctx, "s3.PutObject", s.bucket, objKey)
+	defer tracing.End(span, &err)
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: &s.bucket,
+		Key:    &objKey,
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return fmt.Errorf("put object %s: %w", objKey, err)
+	}
+	return nil
+}
+
+// Get downloads the key's object.
+func (s *S3) Get(ctx context.Context, key string) (_ []byte, err error) {
+	objKey := s.objectKey(key)
+	ctx, span := tracing.StartS3(ctx, "s3.GetObject", s.bucket, objKey)
+	defer tracing.End(span, &err)
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &objKey,
+	})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, objKey)
+		}
+		return nil, fmt.Errorf("get object %s: %w", objKey, err)
+	}
+	defer out.Body.Close()
+	b, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read object %s: %w", objKey, err)
+	}
+	return b, nil
+}
+
+func (s *S3) objectKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
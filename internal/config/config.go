@@ -0,0 +1,387 @@
+// Package config loads the settings of the api, worker and relay binaries from the environment and
+// an optional JSON file, and validates them at startup.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/blob"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/inventory"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/metrics"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/payments"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/shipping"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
+)
+
+// ErrInvalid is wrapped by every error returned from Load and Validate.
+var ErrInvalid = errors.New("invalid configuration")
+
+// Environment variables. CONFIG_FILE names a JSON object using the same keys, e.g.
+// {"ORDERS_TABLE": "orders", "IDEMPOTENCY_TTL": "24h"}; a set environment variable wins over the file.
+const (
+	EnvConfigFile         = "CONFIG_FILE"
+	EnvRegion             = "AWS_REGION"
+	EnvEndpoint           = "AWS_ENDPOINT_OVERRIDE"
+	EnvDynamoDBEndpoint   = "DYNAMODB_ENDPOINT"
+	EnvSQSEndpoint        = "SQS_ENDPOINT"
+	EnvCloudWatchEndpoint = "CLOUDWATCH_ENDPOINT"
+	EnvS3Endpoint         = "S3_ENDPOINT"
+	EnvIdempotencyTable   = "IDEMPOTENCY_TABLE"
+	EnvOrdersTable        = "ORDERS_TABLE"
+	EnvOutboxTable        = "OUTBOX_TABLE"
+	EnvStockTable         = "STOCK_TABLE"
+	EnvReservationsTable  = "RESERVATIONS_TABLE"
+	EnvReservationHold    = "RESERVATION_HOLD"
+	EnvWebhookEndpoints   = "WEBHOOK_ENDPOINTS_TABLE"
+	EnvWebhookEvents      = "WEBHOOK_EVENTS_TABLE"
+	EnvWebhookDeliveries  = "WEBHOOK_DELIVERIES_TABLE"
+	EnvPaymentGateway     = "PAYMENT_GATEWAY"
+	EnvShippingService    = "SHIPPING_SERVICE"
+	EnvQueueURL           = "ORDERS_QUEUE_URL"
+	EnvIdempotencyTTL     = "IDEMPOTENCY_TTL"
+	EnvBlobStore          = "IDEMPOTENCY_BLOB_STORE"
+	EnvRunLocal           = "RUN_LOCAL"
+	EnvWorkerMode         = "WORKER_MODE"
+	EnvWorkerConcurrency  = "WORKER_CONCURRENCY"
+	EnvWorkerVisibility   = "WORKER_VISIBILITY_TIMEOUT"
+	EnvWorkerDrainTimeout = "WORKER_DRAIN_TIMEOUT"
+	EnvRelayInterval      = "RELAY_INTERVAL"
+	EnvMetricsSink        = "METRICS_SINK"
+	EnvMetricsNamespace   = "METRICS_NAMESPACE"
+	EnvMetricsFlush       = "METRICS_FLUSH_INTERVAL"
+	EnvLogLevel           = "LOG_LEVEL"
+	EnvTracingExporter    = "TRACING_EXPORTER"
+)
+
+// Defaults applied when a setting is not provided.
+const (
+	DefaultIdempotencyTTL = 48 * time.Hour
+	DefaultRelayInterval  = 2 * time.Second
+	DefaultMetricsFlush   = 10 * time.Second
+)
+
+// Worker modes.
+const (
+	WorkerModeLambda   = "lambda"
+	WorkerModeConsumer = "consumer"
+)
+
+// Component names a binary, selecting which settings Validate requires.
+type Component string
+
+const (
+	API    Component = "api"
+	Worker Component = "worker"
+	Relay  Component = "relay"
+)
+
+// Config holds every setting; each binary uses the subset its Component requires.
+type Config struct {
+	AWS aws.ClientOptions
+
+	IdempotencyTable string
+	OrdersTable      string
+	OutboxTable      string
+	QueueURL         string
+	IdempotencyTTL   time.Duration // how long idempotency records, outbox entries and webhook events are kept
+	BlobStore        string        // blob.Open URL for large idempotent response bodies; none when empty
+
+	// Inventory is enabled when both tables are set: the worker reserves stock for orders, the API
+	// serves availability and the relay releases expired reservations.
+	StockTable        string
+	ReservationsTable string
+	ReservationHold   time.Duration // how long a reservation is held before it expires
+
+	// Webhooks are enabled when all three tables are set: API and worker record an event per order
+	// transition, the API serves the webhook routes and the relay delivers the events.
+	WebhookEndpointsTable  string
+	WebhookEventsTable     string
+	WebhookDeliveriesTable string
+
+	PaymentGateway  string // payments.GatewayNone (default) or payments.GatewayFake; the worker charges orders unless none
+	ShippingService string // shipping.ServiceNone (default) or shipping.ServiceFake; the worker ships orders unless none
+
+	RunLocal bool // serve HTTP / poll in a loop instead of running under Lambda
+
+	WorkerMode              string        // WorkerModeLambda (default) or WorkerModeConsumer
+	WorkerConcurrency       int           // consumer mode; 0 uses the consumer default
+	WorkerVisibilityTimeout time.Duration // consumer mode; 0 uses the consumer default
+	WorkerDrainTimeout      time.Duration // consumer mode; 0 waits for in-flight messages
+
+	RelayInterval time.Duration // RUN_LOCAL relay polling interval
+
+	MetricsSink          string        // metrics.SinkNone (default), metrics.SinkEMF or metrics.SinkCloudWatch
+	MetricsNamespace     string        // CloudWatch namespace; metrics.DefaultNamespace when empty
+	MetricsFlushInterval time.Duration // flush period for long-running processes; Lambda flushes per invocation
+
+	LogLevel slog.Level // minimum level of the JSON logs; info by default
+
+	TracingExporter string // tracing.ExporterNone (default) or tracing.ExporterStdout
+}
+
+// Load reads the configuration from the environment and the optional CONFIG_FILE.
+// It reports malformed values; use Validate to check a binary's required settings.
+func Load() (*Config, error) {
+	return load(os.LookupEnv)
+}
+
+func load(lookupEnv func(string) (string, bool)) (*Config, error) {
+	file := map[string]string{}
+	if path, ok := lookupEnv(EnvConfigFile); ok && path != "" {
+		var err error
+		if file, err = readFile(path); err != nil {
+			return nil, err
+		}
+	}
+	get := func(key string) string {
+		if v, ok := lookupEnv(key); ok {
+			return strings.TrimSpace(v)
+		}
+		return strings.TrimSpace(file[key])
+	}
+
+	p := &parser{get: get}
+	cfg := &Config{
+		AWS: aws.ClientOptions{
+			Region: get(EnvRegion),
+			Endpoints: aws.Endpoints{
+				Default:    p.url(EnvEndpoint),
+				DynamoDB:   p.url(EnvDynamoDBEndpoint),
+				SQS:        p.url(EnvSQSEndpoint),
+				CloudWatch: p.url(EnvCloudWatchEndpoint),
+				S3:         p.url(EnvS3Endpoint),
+			},
+		},
+		IdempotencyTable:        get(EnvIdempotencyTable),
+		OrdersTable:             get(EnvOrdersTable),
+		OutboxTable:             get(EnvOutboxTable),
+		QueueURL:                p.url(EnvQueueURL),
+		IdempotencyTTL:          p.duration(EnvIdempotencyTTL, DefaultIdempotencyTTL),
+		BlobStore:               get(EnvBlobStore),
+		StockTable:              get(EnvStockTable),
+		ReservationsTable:       get(EnvReservationsTable),
+		ReservationHold:         p.duration(EnvReservationHold, inventory.DefaultHold),
+		WebhookEndpointsTable:   get(EnvWebhookEndpoints),
+		WebhookEventsTable:      get(EnvWebhookEvents),
+		WebhookDeliveriesTable:  get(EnvWebhookDeliveries),
+		PaymentGateway:          get(EnvPaymentGateway),
+		ShippingService:         get(EnvShippingService),
+		RunLocal:                p.bool(EnvRunLocal),
+		WorkerMode:              get(EnvWorkerMode),
+		WorkerConcurrency:       p.int(EnvWorkerConcurrency),
+		WorkerVisibilityTimeout: p.duration(EnvWorkerVisibility, 0),
+		WorkerDrainTimeout:      p.duration(EnvWorkerDrainTimeout, 0),
+		RelayInterval:           p.duration(EnvRelayInterval, DefaultRelayInterval),
+		MetricsSink:             get(EnvMetricsSink),
+		MetricsNamespace:        get(EnvMetricsNamespace),
+		MetricsFlushInterval:    p.duration(EnvMetricsFlush, DefaultMetricsFlush),
+		LogLevel:                p.level(EnvLogLevel),
+		TracingExporter:         get(EnvTracingExporter),
+	}
+	if cfg.IdempotencyTTL == 0 {
+		p.fail(EnvIdempotencyTTL, "must be greater than zero")
+	}
+	if cfg.StockTable != "" && cfg.ReservationsTable == "" {
+		p.fail(EnvReservationsTable, "must be set when %s is", EnvStockTable)
+	}
+	if cfg.ReservationsTable != "" && cfg.StockTable == "" {
+		p.fail(EnvStockTable, "must be set when %s is", EnvReservationsTable)
+	}
+	if cfg.Webhooks() {
+		for _, t := range [][2]string{
+			{EnvWebhookEndpoints, cfg.WebhookEndpointsTable},
+			{EnvWebhookEvents, cfg.WebhookEventsTable},
+			{EnvWebhookDeliveries, cfg.WebhookDeliveriesTable},
+		} {
+			if t[1] == "" {
+				p.fail(t[0], "must be set with the other webhook tables")
+			}
+		}
+	}
+	if cfg.ReservationHold == 0 {
+		p.fail(EnvReservationHold, "must be greater than zero")
+	}
+	if err := blob.CheckURL(cfg.BlobStore); err != nil {
+		p.fail(EnvBlobStore, "must be memory, file:///dir or s3://bucket/prefix, got %q", cfg.BlobStore)
+	}
+	if cfg.AWS.Region == "" {
+		cfg.AWS.Region = aws.DefaultRegion
+	}
+	if cfg.WorkerMode == "" {
+		cfg.WorkerMode = WorkerModeLambda
+	}
+	if cfg.WorkerMode != WorkerModeLambda && cfg.WorkerMode != WorkerModeConsumer {
+		p.fail(EnvWorkerMode, "must be %q or %q, got %q", WorkerModeLambda, WorkerModeConsumer, cfg.WorkerMode)
+	}
+	if cfg.PaymentGateway == "" {
+		cfg.PaymentGateway = payments.GatewayNone
+	}
+	if cfg.PaymentGateway != payments.GatewayNone && cfg.PaymentGateway != payments.GatewayFake {
+		p.fail(EnvPaymentGateway, "must be %q or %q, got %q", payments.GatewayNone, payments.GatewayFake, cfg.PaymentGateway)
+	}
+	if cfg.ShippingService == "" {
+		cfg.ShippingService = shipping.ServiceNone
+	}
+	if cfg.ShippingService != shipping.ServiceNone && cfg.ShippingService != shipping.ServiceFake {
+		p.fail(EnvShippingService, "must be %q or %q, got %q", shipping.ServiceNone, shipping.ServiceFake, cfg.ShippingService)
+	}
+	if cfg.MetricsSink == "" {
+		cfg.MetricsSink = metrics.SinkNone
+	}
+	switch cfg.MetricsSink {
+	case metrics.SinkNone, metrics.SinkEMF, metrics.SinkCloudWatch:
+	default:
+		p.fail(EnvMetricsSink, "must be one of %q, %q or %q, got %q", metrics.SinkNone, metrics.SinkEMF, metrics.SinkCloudWatch, cfg.MetricsSink)
+	}
+	if cfg.MetricsFlushInterval == 0 {
+		p.fail(EnvMetricsFlush, "must be greater than zero")
+	}
+	if cfg.TracingExporter == "" {
+		cfg.TracingExporter = tracing.ExporterNone
+	}
+	if cfg.TracingExporter != tracing.ExporterNone && cfg.TracingExporter != tracing.ExporterStdout {
+		p.fail(EnvTracingExporter, "must be %q or %q, got %q", tracing.ExporterNone, tracing.ExporterStdout, cfg.TracingExporter)
+	}
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Webhooks reports whether any webhook table is set; Load fails unless all of them are.
+func (c *Config) Webhooks() bool {
+	return c.WebhookEndpointsTable != "" || c.WebhookEventsTable != "" || c.WebhookDeliveriesTable != ""
+}
+
+// Validate checks that everything component needs is set, listing every missing setting at once.
+func (c *Config) Validate(component Component) error {
+	var required []string
+	require := func(key, value string) {
+		if value == "" {
+			required = append(required, key)
+		}
+	}
+	switch component {
+	case API:
+		require(EnvIdempotencyTable, c.IdempotencyTable)
+		require(EnvOrdersTable, c.OrdersTable)
+		require(EnvOutboxTable, c.OutboxTable)
+		require(EnvQueueURL, c.QueueURL)
+	case Worker:
+		require(EnvIdempotencyTable, c.IdempotencyTable)
+		require(EnvOrdersTable, c.OrdersTable)
+		if c.WorkerMode == WorkerModeConsumer {
+			require(EnvQueueURL, c.QueueURL)
+		}
+	case Relay:
+		require(EnvOutboxTable, c.OutboxTable)
+		require(EnvQueueURL, c.QueueURL)
+		if c.Webhooks() {
+			require(EnvOrdersTable, c.OrdersTable) // fan-out reads the order's customer
+		}
+	default:
+		return fmt.Errorf("%w: unknown component %q", ErrInvalid, component)
+	}
+	if len(required) > 0 {
+		return fmt.Errorf("%w for %s: missing %s", ErrInvalid, component, strings.Join(required, ", "))
+	}
+	return nil
+}
+
+func readFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: read %s: %w", ErrInvalid, EnvConfigFile, err)
+	}
+	out := map[string]string{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("%w: parse %s %s (expected a JSON object of string values): %w", ErrInvalid, EnvConfigFile, path, err)
+	}
+	return out, nil
+}
+
+// parser converts raw settings and collects every problem so they are reported together.
+type parser struct {
+	get      func(string) string
+	problems []string
+}
+
+func (p *parser) fail(key, format string, args ...interface{}) {
+	p.problems = append(p.problems, key+" "+fmt.Sprintf(format, args...))
+}
+
+func (p *parser) err() error {
+	if len(p.problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrInvalid, strings.Join(p.problems, "; "))
+}
+
+func (p *parser) url(key string) string {
+	v := p.get(key)
+	if v == "" {
+		return ""
+	}
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		p.fail(key, "must be an http(s) URL, got %q", v)
+	}
+	return v
+}
+
+func (p *parser) duration(key string, def time.Duration) time.Duration {
+	v := p.get(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		p.fail(key, "must be a non-negative duration such as 30s or 48h, got %q", v)
+		return def
+	}
+	return d
+}
+
+func (p *parser) int(key string) int {
+	v := p.get(key)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		p.fail(key, "must be a non-negative integer, got %q", v)
+		return 0
+	}
+	return n
+}
+
+func (p *parser) bool(key string) bool {
+	v := p.get(key)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		p.fail(key, "must be true or false, got %q", v)
+	}
+	return b
+}
+
+func (p *parser) level(key string) slog.Level {
+	v := p.get(key)
+	l, err := logging.ParseLevel(v)
+	if err != nil {
+		p.fail(key, "must be debug, info, warn or error, got %q", v)
+	}
+	return l
+}
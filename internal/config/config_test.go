@@ -0,0 +1,123 @@
+package config
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/inventory"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/payments"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/shipping"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := vars[k]
+		return v, ok
+	}
+}
+
+func TestLoad_FileWithEnvOverridesAndDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	file := `{"ORDERS_TABLE":"orders-file","IDEMPOTENCY_TABLE":"idemp-file","IDEMPOTENCY_TTL":"24h","SQS_ENDPOINT":"http://localhost:4566","IDEMPOTENCY_BLOB_STORE":"s3://responses/idem"}`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := load(env(map[string]string{
+		EnvConfigFile:  path,
+		EnvOrdersTable: "orders-env", // env wins over the file
+		EnvWorkerMode:  WorkerModeConsumer,
+		EnvLogLevel:    "DEBUG",
+	}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.OrdersTable != "orders-env" || cfg.IdempotencyTable != "idemp-file" || cfg.IdempotencyTTL != 24*time.Hour {
+		t.Fatalf("unexpected tables/ttl: %+v", cfg)
+	}
+	if cfg.BlobStore != "s3://responses/idem" {
+		t.Fatalf("unexpected blob store %q", cfg.BlobStore)
+	}
+	if cfg.StockTable != "" || cfg.ReservationHold != inventory.DefaultHold {
+		t.Fatalf("expected inventory disabled with the default hold, got %q, %v", cfg.StockTable, cfg.ReservationHold)
+	}
+	if cfg.PaymentGateway != payments.GatewayNone || cfg.ShippingService != shipping.ServiceNone {
+		t.Fatalf("expected no payment gateway or shipping service, got %q, %q", cfg.PaymentGateway, cfg.ShippingService)
+	}
+	if cfg.LogLevel != slog.LevelDebug {
+		t.Fatalf("expected debug log level, got %v", cfg.LogLevel)
+	}
+	if cfg.AWS.Region != "ap-south-1" || cfg.AWS.Endpoints.SQS != "http://localhost:4566" || cfg.RelayInterval != DefaultRelayInterval {
+		t.Fatalf("unexpected defaults/endpoints: %+v", cfg)
+	}
+
+	// consumer mode needs the queue URL
+	err = cfg.Validate(Worker)
+	if !errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), EnvQueueURL) {
+		t.Fatalf("expected missing %s, got %v", EnvQueueURL, err)
+	}
+}
+
+func TestLoadAndValidate_ReportEveryProblem(t *testing.T) {
+	_, err := load(env(map[string]string{
+		EnvIdempotencyTTL:    "2 days",
+		EnvQueueURL:          "not-a-url",
+		EnvWorkerConcurrency: "-1",
+		EnvWorkerMode:        "batch",
+		EnvLogLevel:          "verbose",
+		EnvTracingExporter:   "jaeger",
+		EnvBlobStore:         "ftp://bucket",
+		EnvStockTable:        "stock", // without RESERVATIONS_TABLE
+		EnvReservationHold:   "0s",
+		EnvPaymentGateway:    "stripe",
+		EnvShippingService:   "pigeon",
+		EnvWebhookEndpoints:  "webhook-endpoints", // without the events and deliveries tables
+	}))
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected ErrInvalid, got %v", err)
+	}
+	for _, key := range []string{EnvIdempotencyTTL, EnvQueueURL, EnvWorkerConcurrency, EnvWorkerMode, EnvLogLevel, EnvTracingExporter, EnvBlobStore, EnvReservationsTable, EnvReservationHold, EnvPaymentGateway, EnvShippingService, EnvWebhookEvents, EnvWebhookDeliveries} {
+		if !strings.Contains(err.Error(), key) {
+			t.Fatalf("expected %s in %q", key, err)
+		}
+	}
+
+	cfg, err := load(env(map[string]string{}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	err = cfg.Validate(API)
+	for _, key := range []string{EnvIdempotencyTable, EnvOrdersTable, EnvOutboxTable, EnvQueueURL} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Fatalf("expected %s to be reported missing, got %v", key, err)
+		}
+	}
+}
+
+func TestValidate_RelayWithWebhooksNeedsTheOrdersTable(t *testing.T) {
+	cfg, err := load(env(map[string]string{
+		EnvOutboxTable:       "outbox",
+		EnvQueueURL:          "http://localhost:4566/000000000000/orders",
+		EnvWebhookEndpoints:  "webhook-endpoints",
+		EnvWebhookEvents:     "webhook-events",
+		EnvWebhookDeliveries: "webhook-deliveries",
+	}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !cfg.Webhooks() {
+		t.Fatalf("expected webhooks enabled")
+	}
+	if err := cfg.Validate(Relay); err == nil || !strings.Contains(err.Error(), EnvOrdersTable) {
+		t.Fatalf("expected %s to be reported missing, got %v", EnvOrdersTable, err)
+	}
+	cfg.OrdersTable = "orders"
+	if err := cfg.Validate(Relay); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
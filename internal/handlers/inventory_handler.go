@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/inventory"
+)
+
+// RegisterInventoryRoutes registers the read-only inventory routes: stock availability per SKU and
+// the stock reserved for an order.
+func RegisterInventoryRoutes(r *gin.Engine, inv *inventory.Store) {
+	r.GET("/inventory/:sku", func(c *gin.Context) {
+		stock, err := inv.Get(c.Request.Context(), c.Param("sku"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "stock_lookup_failed", "detail": err.Error()})
+			return
+		}
+		if stock == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "sku_not_found"})
+			return
+		}
+		c.JSON(http.StatusOK, stock)
+	})
+
+	r.GET("/orders/:id/reservation", func(c *gin.Context) {
+		res, err := inv.GetReservation(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "reservation_lookup_failed", "detail": err.Error()})
+			return
+		}
+		if res == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "reservation_not_found"})
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+}
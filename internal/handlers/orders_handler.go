@@ -1,17 +1,31 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/blob"
 	"github.com/imrishuroy/go-idempotent-orderflow/internal/idempotency"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/inventory"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/metrics"
 	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/outbox"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/problem"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
 	"github.com/imrishuroy/go-idempotent-orderflow/internal/validation"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/webhooks"
 )
 
 // HandlerConfig groups dependencies for the orders handler.
@@ -20,20 +34,43 @@ type HandlerConfig struct {
 	SQSClient        aws.SQSAPI
 	IdempotencyTable string
 	OrdersTable      string
+	OutboxTable      string
 	QueueURL         string
 	TTLWindow        time.Duration
+	BlobStore        blob.Store       // optional; holds idempotent response bodies too large for DynamoDB
+	Inventory        *inventory.Store // optional; serves stock availability and order reservations
+	Webhooks         *webhooks.Store  // optional; records an event per order transition and serves the webhook API
+	Metrics          metrics.Recorder // optional; defaults to metrics.Nop
+	Logger           *slog.Logger     // base request logger; defaults to slog.Default
 }
 
 // RegisterOrdersRoutes registers routes for order API.
 func RegisterOrdersRoutes(r *gin.Engine, cfg HandlerConfig) {
 	v := validation.New()
 	idempStore := idempotency.NewStore(cfg.DynamoDBClient, cfg.IdempotencyTable, cfg.TTLWindow)
+	if cfg.BlobStore != nil {
+		idempStore.SetBlobStore(cfg.BlobStore)
+	}
 	ordersStore := orders.NewStore(cfg.DynamoDBClient, cfg.OrdersTable)
+	if cfg.Webhooks != nil {
+		ordersStore.SetTransitionRecorder(cfg.Webhooks)
+	}
+	outboxStore := outbox.NewStore(cfg.DynamoDBClient, cfg.OutboxTable, cfg.TTLWindow)
 	publisher := aws.NewPublisher(cfg.SQSClient, cfg.QueueURL)
+	relay := outbox.NewRelay(outboxStore, publisher)
+	recorder := metrics.OrNop(cfg.Metrics)
 
 	r.POST("/orders", func(c *gin.Context) {
 		ctx := c.Request.Context()
 
+		// Keep the raw body around for fingerprinting; binding consumes the reader
+		rawBody, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request_body", "msg": err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(rawBody))
+
 		// Bind + validate request
 		var req validation.CreateOrderRequest
 		if err := validation.BindAndValidate(c, &req, v); err != nil {
@@ -41,25 +78,32 @@ func RegisterOrdersRoutes(r *gin.Engine, cfg HandlerConfig) {
 			return
 		}
 
-		// Require idempotency key header
-		idempKey := c.GetHeader("Idempotency-Key")
+		// Require a well-formed idempotency key header
+		idempKey, err := idempotency.RequestKey(c)
+		if err != nil {
+			problem.Abort(c, idempotency.InvalidKeyProblem(err))
+			return
+		}
 		if idempKey == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "missing_idempotency_key"})
+			problem.Abort(c, idempotency.MissingKeyProblem())
+			return
+		}
+
+		// Fingerprint the request so a reused key with a different payload can be rejected
+		fingerprint, err := idempotency.Fingerprint(c.Request.Method, c.FullPath(), rawBody, req.CustomerID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request_body", "msg": err.Error()})
 			return
 		}
 
 		// Generate order id
 		orderID := uuid.NewString()
+		ctx, logger := logging.With(ctx, logging.KeyIdempotencyKey, idempKey)
 
-		// Build idempotency item (map) - lightweight
+		// Build idempotency record holding an IN_PROGRESS lease for this request
 		now := time.Now().UTC()
-		idempItem := map[string]interface{}{
-			"idempotency_key": idempKey,
-			"status":          idempotency.StatusInProgress,
-			"created_at":      now.Format(time.RFC3339),
-			"updated_at":      now.Format(time.RFC3339),
-			"order_id":        orderID,
-		}
+		leaseOwner := uuid.NewString()
+		idempItem, lease := idempStore.NewInProgressRecord(idempKey, orderID, leaseOwner, fingerprint)
 
 		// Build order object
 		order := orders.Order{
@@ -70,6 +114,9 @@ func RegisterOrdersRoutes(r *gin.Engine, cfg HandlerConfig) {
 			Metadata:   req.Metadata,
 			CreatedAt:  now,
 			UpdatedAt:  now,
+			StatusHistory: []orders.StatusChange{
+				{To: orders.StatusPending, Actor: "api", Reason: "order created", At: now},
+			},
 		}
 		// NOTE: convert items to generic representation
 		items := make([]map[string]interface{}, 0, len(req.Items))
@@ -82,15 +129,42 @@ func RegisterOrdersRoutes(r *gin.Engine, cfg HandlerConfig) {
 		}
 		order.Items = items
 
-		// Attempt the transact write to create idempotency + order atomically
-		err := ordersStore.CreateWithIdempotencyTransaction(ctx, cfg.DynamoDBClient, cfg.IdempotencyTable, idempItem, order, cfg.TTLWindow)
+		// Build the worker message; it is written to the outbox in the same transaction as the order
+		// so every committed order is guaranteed to be enqueued.
+		// The correlation ID travels in both the body and the attributes so the worker can log with it
+		// whichever it reads.
+		correlationID := logging.CorrelationID(ctx)
+		msgPayload := map[string]string{
+			"order_id":        orderID,
+			"idempotency_key": idempKey,
+			"correlation_id":  correlationID,
+		}
+		payloadBytes, _ := json.Marshal(msgPayload)
+
+		attrs := map[string]string{
+			"idempotency_key": idempKey,
+			"order_id":        orderID,
+			"correlation_id":  correlationID,
+		}
+		// the relay publishes outside this request, so the entry keeps its trace context
+		tracing.Inject(ctx, attrs)
+		entry := outboxStore.NewEntry(orderID, string(payloadBytes), attrs)
+		outboxPut, err := outboxStore.TransactPut(entry)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "outbox_entry_failed", "detail": err.Error()})
+			return
+		}
+
+		// Attempt the transact write to create idempotency + order + outbox entry atomically
+		takenOver := false
+		err = ordersStore.CreateWithIdempotencyTransaction(ctx, cfg.DynamoDBClient, cfg.IdempotencyTable, idempItem, order, cfg.TTLWindow, outboxPut)
 		if err != nil {
 			// If transaction failed because idempotency exists, fetch idempotency record and return stored response or 202
 			// Detect TransactionCanceledException by string or wrapper (our Create method wraps with message)
 			// Best-effort: call idempStore.Get and decide
 			rec, getErr := idempStore.Get(ctx, idempKey)
 			if getErr != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "idempotency_check_failed", "detail": getErr.Error()})
+				problem.Abort(c, idempotency.CheckFailedProblem(getErr))
 				return
 			}
 			if rec == nil {
@@ -98,62 +172,351 @@ func RegisterOrdersRoutes(r *gin.Engine, cfg HandlerConfig) {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "transaction_failed_no_idempotency_record", "detail": err.Error()})
 				return
 			}
+			// Same key, different request: never replay another request's response
+			if ferr := rec.VerifyFingerprint(fingerprint); errors.Is(ferr, idempotency.ErrFingerprintMismatch) {
+				problem.Abort(c, idempotency.KeyReusedProblem())
+				return
+			}
+			recorder.Count(metrics.IdempotentReplay, 1, metrics.Dim(metrics.DimStatus, rec.Status))
 			switch rec.Status {
 			case idempotency.StatusDone:
-				// return stored response if present
-				if rec.ResponseBody != "" {
-					var body interface{}
-					if derr := json.Unmarshal([]byte(rec.ResponseBody), &body); derr == nil {
-						c.Data(rec.ResponseStatus, "application/json", []byte(rec.ResponseBody))
-						return
+				replayOrder(c, rec)
+				return
+			case idempotency.StatusInProgress:
+				if !rec.LeaseExpired(time.Now()) {
+					problem.Abort(c, idempotency.InProgressProblem(rec.OrderID))
+					return
+				}
+				// The previous holder died after committing the order (and its outbox entry) but before
+				// finishing: take over its lease and complete the request for the same order. Only a
+				// still-IN_PROGRESS record is taken over, never one the worker settled in the meantime.
+				taken, terr := idempStore.TakeoverExpired(ctx, idempKey, leaseOwner, fingerprint)
+				if errors.Is(terr, idempotency.ErrNotAcquired) {
+					current, gerr := idempStore.Get(ctx, idempKey)
+					switch {
+					case gerr != nil:
+						problem.Abort(c, idempotency.CheckFailedProblem(gerr))
+					case current != nil && current.Status == idempotency.StatusDone:
+						replayOrder(c, current)
+					case current != nil && current.Status == idempotency.StatusFailed:
+						abortOrderFailed(c, ordersStore, current)
+					default:
+						problem.Abort(c, idempotency.InProgressProblem(rec.OrderID))
 					}
-					// if not JSON, just return as string
-					c.JSON(rec.ResponseStatus, gin.H{"response": rec.ResponseBody})
 					return
 				}
-				// if no response body stored, return 200 with order_id
-				c.JSON(http.StatusOK, gin.H{"order_id": rec.OrderID})
-				return
-			case idempotency.StatusInProgress:
-				c.JSON(http.StatusAccepted, gin.H{"message": "request already in progress", "order_id": rec.OrderID})
-				return
+				if terr != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "idempotency_takeover_failed", "detail": terr.Error()})
+					return
+				}
+				lease = *taken
+				orderID = lease.OrderID
+				takenOver = true
 			case idempotency.StatusFailed:
-				// let client retry
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "previous_attempt_failed", "order_id": rec.OrderID})
+				abortOrderFailed(c, ordersStore, rec)
 				return
 			default:
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "unknown_idempotency_status"})
+				problem.Abort(c, idempotency.CheckFailedProblem(fmt.Errorf("unknown record status %q", rec.Status)))
 				return
 			}
 		}
 
-		// Successfully created atomic records; now send SQS message. If SQS send fails we mark idempotency FAILED.
-		// Build message body
-		msgPayload := map[string]string{
-			"order_id":        orderID,
-			"idempotency_key": idempKey,
+		// orderID is final here: a takeover continues the previous request's order
+		logger = logger.With(logging.KeyOrderID, orderID)
+
+		// Committed. Publish right away for low latency; if that fails the entry stays PENDING
+		// and the outbox relay delivers it. A taken-over request's entry is already in the outbox.
+		if !takenOver {
+			recorder.Count(metrics.OrderCreated, 1)
+			if derr := relay.Deliver(ctx, entry); derr != nil {
+				recorder.Count(metrics.EnqueueFailure, 1)
+				logger.Warn("eager outbox delivery failed; relay will retry", logging.Err(derr))
+			}
 		}
-		payloadBytes, _ := json.Marshal(msgPayload)
 
+		// Success
+		logger.Info("order accepted", "taken_over", takenOver)
+		// Store the response, with its Location, so duplicates replay it exactly
+		responseBody, _ := json.Marshal(gin.H{"order_id": orderID, "status": "PENDING", "amount": req.Amount})
+		location := orderLocation(orderID)
+		// Fenced: if another request took over our lease, or the worker already settled the record
+		// (e.g. failed the order), theirs is the outcome retries see
+		derr := idempStore.MarkDoneFenced(ctx, lease, idempotency.Response{
+			Status:      http.StatusCreated,
+			ContentType: "application/json; charset=utf-8",
+			Headers:     map[string]string{"Location": location},
+			Body:        responseBody,
+		})
+		switch {
+		case errors.Is(derr, idempotency.ErrLeaseLost):
+			logger.Info("idempotency record already settled; keeping its outcome")
+		case derr != nil:
+			logger.Error("idempotency record could not be marked done", logging.Err(derr))
+		}
+
+		c.Header("Location", location)
+		c.Data(http.StatusCreated, "application/json; charset=utf-8", responseBody)
+	})
+
+	// Cancellation goes through the generic idempotency middleware: a retried cancel replays the
+	// first response, and the store's conditional update settles races with the worker.
+	r.POST("/orders/:id/cancel", idempotency.Middleware(idempotency.MiddlewareConfig{
+		Store:      idempStore,
+		RequireKey: true,
+		Owner:      orderOwner(ordersStore),
+		Metrics:    recorder,
+	}), func(c *gin.Context) {
+		var req validation.CancelOrderRequest
+		if c.Request.ContentLength != 0 {
+			if err := validation.BindAndValidate(c, &req, v); err != nil {
+				return
+			}
+		}
+		ctx, logger := logging.With(c.Request.Context(), logging.KeyOrderID, c.Param("id"))
+		order, err := ordersStore.Cancel(ctx, c.Param("id"), orders.StatusChange{Actor: "api", Reason: cancelReason(req.Reason)})
+		switch {
+		case errors.Is(err, orders.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "order_not_found"})
+		case errors.Is(err, orders.ErrNotCancellable):
+			c.JSON(http.StatusConflict, gin.H{"error": "order_not_cancellable", "status": order.Status})
+		case errors.Is(err, orders.ErrAlreadyCancelled):
+			c.JSON(http.StatusOK, order)
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order_cancel_failed", "detail": err.Error()})
+		default:
+			from := order.StatusHistory[len(order.StatusHistory)-1].From
+			recorder.Count(metrics.OrderCancelled, 1, metrics.Dim(metrics.DimFrom, from))
+			logger.Info("order cancelled", "from", from)
+			c.JSON(http.StatusOK, order)
+		}
+	})
+
+	// Refunds are recorded on the order with a conditional update that keeps their total within the
+	// captured amount, then paid out by the worker. A retried request finds its refund by idempotency
+	// key and enqueues the job again, which the worker settles only once.
+	r.POST("/orders/:id/refunds", idempotency.Middleware(idempotency.MiddlewareConfig{
+		Store:      idempStore,
+		RequireKey: true,
+		Owner:      orderOwner(ordersStore),
+		Metrics:    recorder,
+	}), func(c *gin.Context) {
+		var req validation.RefundOrderRequest
+		if c.Request.ContentLength != 0 {
+			if err := validation.BindAndValidate(c, &req, v); err != nil {
+				return
+			}
+		}
+		idempKey, _ := idempotency.RequestKey(c) // the middleware checked it
+		ctx, logger := logging.With(c.Request.Context(), logging.KeyOrderID, c.Param("id"), logging.KeyIdempotencyKey, idempKey)
+		items := make([]orders.RefundItem, 0, len(req.Items))
+		for _, it := range req.Items {
+			items = append(items, orders.RefundItem{SKU: it.SKU, Quantity: it.Quantity})
+		}
+		order, refund, err := ordersStore.Refund(ctx, c.Param("id"), orders.RefundRequest{
+			RefundID:       uuid.NewString(),
+			IdempotencyKey: idempKey,
+			Items:          items,
+			Reason:         req.Reason,
+			Actor:          "api",
+		})
+		switch {
+		case errors.Is(err, orders.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "order_not_found"})
+			return
+		case errors.Is(err, orders.ErrNotRefundable):
+			c.JSON(http.StatusConflict, gin.H{"error": "order_not_refundable", "status": order.Status})
+			return
+		case errors.Is(err, orders.ErrRefundExceedsCapture):
+			c.JSON(http.StatusConflict, gin.H{"error": "refund_exceeds_captured_amount", "msg": err.Error()})
+			return
+		case errors.Is(err, orders.ErrInvalidRefund):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid_refund", "msg": err.Error()})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order_refund_failed", "detail": err.Error()})
+			return
+		}
+		ctx, logger = logging.With(ctx, logging.KeyRefundID, refund.RefundID)
+
+		correlationID := logging.CorrelationID(ctx)
+		body, _ := json.Marshal(map[string]string{
+			"type":            "refund", // worker.MessageTypeRefund
+			"order_id":        order.OrderID,
+			"refund_id":       refund.RefundID,
+			"idempotency_key": idempKey,
+			"correlation_id":  correlationID,
+		})
 		attrs := map[string]string{
 			"idempotency_key": idempKey,
-			"order_id":        orderID,
-			"correlation_id":  c.GetHeader("X-Request-Id"),
+			"order_id":        order.OrderID,
+			"correlation_id":  correlationID,
 		}
+		tracing.Inject(ctx, attrs)
+		if err := publisher.SendOrderMessage(ctx, string(body), attrs); err != nil {
+			// the refund stays PENDING; the client retries with the same key to enqueue it again
+			recorder.Count(metrics.EnqueueFailure, 1)
+			logger.Warn("refund job enqueue failed", logging.Err(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "refund_enqueue_failed", "detail": err.Error()})
+			return
+		}
+
+		recorder.Count(metrics.RefundRequested, 1, metrics.Dim(metrics.DimTo, order.Status))
+		logger.Info("refund accepted", "amount", refund.Amount.String(), "status", order.Status)
+		c.JSON(http.StatusAccepted, gin.H{"order_id": order.OrderID, "status": order.Status, "refunded_total": order.RefundedTotal, "refund": refund})
+	})
 
-		if err := publisher.SendOrderMessage(ctx, string(payloadBytes), attrs); err != nil {
-			// mark idempotency failed so client can retry; attempt to set note
-			_ = idempStore.MarkFailed(ctx, idempKey, fmt.Sprintf("sqs_send_failed: %v", err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "enqueue_failed", "detail": err.Error()})
+	r.GET("/orders/:id", func(c *gin.Context) {
+		order, err := ordersStore.Get(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order_lookup_failed", "detail": err.Error()})
+			return
+		}
+		if order == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order_not_found"})
 			return
 		}
+		c.JSON(http.StatusOK, order)
+	})
 
-		// Success
-		// Optionally, we can store a minimal response in idempotency to return for duplicates
-		responseBody, _ := json.Marshal(gin.H{"order_id": orderID, "status": "PENDING"})
-		_ = idempStore.MarkDone(ctx, idempKey, string(responseBody), http.StatusCreated)
+	r.GET("/orders/:id/history", func(c *gin.Context) {
+		order, err := ordersStore.Get(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order_lookup_failed", "detail": err.Error()})
+			return
+		}
+		if order == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order_not_found"})
+			return
+		}
+		history := order.StatusHistory
+		if history == nil {
+			history = []orders.StatusChange{}
+		}
+		c.JSON(http.StatusOK, gin.H{"order_id": order.OrderID, "status": order.Status, "history": history})
+	})
 
-		c.Header("Location", fmt.Sprintf("/orders/%s", orderID))
-		c.JSON(http.StatusCreated, gin.H{"order_id": orderID, "status": "PENDING"})
+	r.GET("/customers/:customerId/orders", func(c *gin.Context) {
+		opts, err := parseListOptions(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_query", "msg": err.Error()})
+			return
+		}
+		res, err := ordersStore.ListByCustomer(c.Request.Context(), c.Param("customerId"), opts)
+		if errors.Is(err, orders.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_cursor"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order_list_failed", "detail": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, res)
 	})
 }
+
+func orderLocation(orderID string) string {
+	return fmt.Sprintf("/orders/%s", orderID)
+}
+
+// replayOrder answers a duplicate create with the stored response: the API's 201, or the worker's
+// 200 once the order is complete. Records stored without headers get their Location from the order ID.
+func replayOrder(c *gin.Context, rec *idempotency.IdempotencyRecord) {
+	if rec.ResponseBody == "" {
+		// nothing stored: answer with the order it created
+		body, _ := json.Marshal(gin.H{"order_id": rec.OrderID})
+		rec.ResponseBody, rec.ResponseStatus = string(body), http.StatusOK
+	}
+	if rec.ResponseHeaders["Location"] == "" && rec.OrderID != "" {
+		if rec.ResponseHeaders == nil {
+			rec.ResponseHeaders = map[string]string{}
+		}
+		rec.ResponseHeaders["Location"] = orderLocation(rec.OrderID)
+	}
+	idempotency.Replay(c, rec)
+}
+
+// orderOwner returns an idempotency Owner func naming the customer of the order in the route's :id, so
+// cancel and refund keys are fingerprinted with the customer like POST /orders. A missing order has no
+// owner; the handler answers 404.
+func orderOwner(ordersStore *orders.Store) func(c *gin.Context) (string, error) {
+	return func(c *gin.Context) (string, error) {
+		order, err := ordersStore.Get(c.Request.Context(), c.Param("id"))
+		if err != nil || order == nil {
+			return "", err
+		}
+		return order.CustomerID, nil
+	}
+}
+
+// abortOrderFailed answers a request whose idempotency record is FAILED: the worker failed the order,
+// and FAILED is terminal, so a retry can only report it.
+func abortOrderFailed(c *gin.Context, ordersStore *orders.Store, rec *idempotency.IdempotencyRecord) {
+	failed, err := ordersStore.Get(c.Request.Context(), rec.OrderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "order_lookup_failed", "detail": err.Error()})
+		return
+	}
+	if failed == nil || failed.Status != orders.StatusFailed {
+		problem.Abort(c, idempotency.CheckFailedProblem(fmt.Errorf("record FAILED but order %s is not", rec.OrderID)))
+		return
+	}
+	problem.Abort(c, problem.New(http.StatusUnprocessableEntity, "order_failed", "the order created with this key failed").
+		With("order_id", failed.OrderID).
+		With("order_status", failed.Status).
+		With("reason", failureReason(failed, rec.Note)))
+}
+
+// failureReason returns why the order failed, from its history or else the idempotency record's note.
+func failureReason(o *orders.Order, note string) string {
+	for i := len(o.StatusHistory) - 1; i >= 0; i-- {
+		if h := o.StatusHistory[i]; h.To == orders.StatusFailed && h.Reason != "" {
+			return h.Reason
+		}
+	}
+	return note
+}
+
+func cancelReason(reason string) string {
+	if reason == "" {
+		return "cancelled by client"
+	}
+	return reason
+}
+
+// maxListLimit caps the page size a client may request.
+const maxListLimit = 100
+
+// parseListOptions reads ?limit=&cursor=&status=A,B&from=&to= (RFC3339 timestamps).
+func parseListOptions(c *gin.Context) (orders.ListOptions, error) {
+	opts := orders.ListOptions{Cursor: c.Query("cursor")}
+
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		opts.Limit = int32(n)
+	}
+	if v := c.Query("status"); v != "" {
+		for _, st := range strings.Split(v, ",") {
+			st = strings.ToUpper(strings.TrimSpace(st))
+			if !orders.Lifecycle.IsValid(st) {
+				return opts, fmt.Errorf("unknown status %q", st)
+			}
+			opts.Statuses = append(opts.Statuses, st)
+		}
+	}
+	for param, dst := range map[string]*time.Time{"from": &opts.CreatedFrom, "to": &opts.CreatedTo} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return opts, fmt.Errorf("%s must be an RFC3339 timestamp", param)
+			}
+			*dst = t
+		}
+	}
+	if !opts.CreatedFrom.IsZero() && !opts.CreatedTo.IsZero() && opts.CreatedFrom.After(opts.CreatedTo) {
+		return opts, fmt.Errorf("from must not be after to")
+	}
+	return opts, nil
+}
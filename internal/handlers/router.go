@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
+)
+
+// NewRouter builds the Gin engine serving the health check, the orders API and, when configured,
+// the inventory and webhook APIs.
+func NewRouter(cfg HandlerConfig) *gin.Engine {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	r := gin.New()
+	r.Use(gin.Recovery(), tracing.Middleware(), logging.Middleware(logger))
+
+	// health check endpoint
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	RegisterOrdersRoutes(r, cfg)
+	if cfg.Inventory != nil {
+		RegisterInventoryRoutes(r, cfg.Inventory)
+	}
+	if cfg.Webhooks != nil {
+		RegisterWebhookRoutes(r, cfg.Webhooks)
+	}
+
+	return r
+}
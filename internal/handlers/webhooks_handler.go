@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/validation"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/webhooks"
+)
+
+// RegisterWebhookRoutes registers the webhook routes: registering, listing and disabling a customer's
+// endpoints, an endpoint's delivery log, and replaying a delivery.
+func RegisterWebhookRoutes(r *gin.Engine, store *webhooks.Store) {
+	v := validation.New()
+
+	r.POST("/customers/:customerId/webhooks", func(c *gin.Context) {
+		var req validation.RegisterWebhookRequest
+		if err := validation.BindAndValidate(c, &req, v); err != nil {
+			return
+		}
+		ep, err := store.RegisterEndpoint(c.Request.Context(), c.Param("customerId"), req.URL, req.Events)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "webhook_registration_failed", "detail": err.Error()})
+			return
+		}
+		// the only response that shows the secret
+		c.JSON(http.StatusCreated, ep)
+	})
+
+	r.GET("/customers/:customerId/webhooks", func(c *gin.Context) {
+		endpoints, err := store.ListEndpoints(c.Request.Context(), c.Param("customerId"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "webhook_list_failed", "detail": err.Error()})
+			return
+		}
+		for i := range endpoints {
+			endpoints[i].Secret = ""
+		}
+		c.JSON(http.StatusOK, gin.H{"webhooks": endpoints})
+	})
+
+	r.DELETE("/webhooks/:id", func(c *gin.Context) {
+		err := store.DisableEndpoint(c.Request.Context(), c.Param("id"))
+		switch {
+		case errors.Is(err, webhooks.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook_not_found"})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "webhook_disable_failed", "detail": err.Error()})
+		default:
+			c.Status(http.StatusNoContent)
+		}
+	})
+
+	r.GET("/webhooks/:id/deliveries", func(c *gin.Context) {
+		var limit int32
+		if q := c.Query("limit"); q != "" {
+			n, err := strconv.Atoi(q)
+			if err != nil || n < 1 || n > maxListLimit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_query", "detail": "limit must be between 1 and " + strconv.Itoa(maxListLimit)})
+				return
+			}
+			limit = int32(n)
+		}
+		ep, err := store.GetEndpoint(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "webhook_lookup_failed", "detail": err.Error()})
+			return
+		}
+		if ep == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook_not_found"})
+			return
+		}
+		deliveries, err := store.ListDeliveries(c.Request.Context(), ep.EndpointID, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "delivery_list_failed", "detail": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+	})
+
+	r.POST("/webhooks/:id/deliveries/:eventId/replay", func(c *gin.Context) {
+		d, err := store.Replay(c.Request.Context(), c.Param("id"), c.Param("eventId"))
+		switch {
+		case errors.Is(err, webhooks.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "delivery_not_found"})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "delivery_replay_failed", "detail": err.Error()})
+		default:
+			c.JSON(http.StatusAccepted, d)
+		}
+	})
+}
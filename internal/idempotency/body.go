@@ -0,0 +1,90 @@
+package idempotency
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/blob"
+)
+
+// Response bodies of at least CompressThreshold bytes are stored gzip-compressed. A compressed body
+// larger than InlineLimit is written to the Store's blob store, if it has one, and the record keeps
+// only its key; without a blob store it stays in the item, within DynamoDB's 400 KB item limit.
+const (
+	CompressThreshold = 1 << 10
+	InlineLimit       = 64 << 10
+)
+
+// blobPrefix namespaces the response bodies in a blob store shared with other data.
+const blobPrefix = "idempotency/"
+
+// Body attributes; markDone sets one of them and removes the others.
+var bodyAttributes = []string{"response_body", "response_body_z", "response_body_ref"}
+
+// SetBlobStore makes the Store spill response bodies larger than InlineLimit to bs.
+// Every process reading the table (API and worker) must use the same blob store.
+func (s *Store) SetBlobStore(bs blob.Store) {
+	s.blobs = bs
+}
+
+// encodeBody returns the attribute and value holding body for key.
+func (s *Store) encodeBody(ctx context.Context, key string, body []byte) (string, types.AttributeValue, error) {
+	if len(body) < CompressThreshold {
+		return "response_body", &types.AttributeValueMemberS{Value: string(body)}, nil
+	}
+	var z bytes.Buffer
+	zw := gzip.NewWriter(&z)
+	if _, err := zw.Write(body); err != nil {
+		return "", nil, fmt.Errorf("compress response body: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return "", nil, fmt.Errorf("compress response body: %w", err)
+	}
+	if z.Len() <= InlineLimit || s.blobs == nil {
+		return "response_body_z", &types.AttributeValueMemberB{Value: z.Bytes()}, nil
+	}
+	// a fresh name per write: a fenced-out writer's blob can never replace the winner's
+	sum := sha256.Sum256([]byte(key))
+	ref := blobPrefix + hex.EncodeToString(sum[:]) + "/" + uuid.NewString()
+	if err := s.blobs.Put(ctx, ref, z.Bytes()); err != nil {
+		return "", nil, fmt.Errorf("store response body: %w", err)
+	}
+	return "response_body_ref", &types.AttributeValueMemberS{Value: ref}, nil
+}
+
+// decodeBody fills rec.ResponseBody from a compressed or spilled body.
+func (s *Store) decodeBody(ctx context.Context, rec *IdempotencyRecord) error {
+	z := rec.ResponseBodyZ
+	if rec.ResponseBodyRef != "" {
+		if s.blobs == nil {
+			return fmt.Errorf("response body %s is in a blob store, but none is configured", rec.ResponseBodyRef)
+		}
+		b, err := s.blobs.Get(ctx, rec.ResponseBodyRef)
+		if err != nil {
+			return fmt.Errorf("load response body: %w", err)
+		}
+		z = b
+	}
+	if z == nil {
+		return nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(z))
+	if err != nil {
+		return fmt.Errorf("decompress response body: %w", err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		return fmt.Errorf("decompress response body: %w", err)
+	}
+	rec.ResponseBody = string(body)
+	rec.ResponseBodyZ, rec.ResponseBodyRef = nil, ""
+	return nil
+}
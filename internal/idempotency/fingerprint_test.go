@@ -0,0 +1,58 @@
+package idempotency
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFingerprint_NormalizesJSON(t *testing.T) {
+	a := []byte(`{"customer_id":"c1","amount":10.50,"items":[{"sku":"s1","quantity":1}]}`)
+	b := []byte("{\n  \"items\": [{\"quantity\": 1, \"sku\": \"s1\"}],\n  \"amount\": 10.50,\n  \"customer_id\": \"c1\"\n}")
+
+	fa, err := Fingerprint("POST", "/orders", a, "c1")
+	if err != nil {
+		t.Fatalf("fingerprint a: %v", err)
+	}
+	fb, err := Fingerprint("post", "/orders", b, "c1")
+	if err != nil {
+		t.Fatalf("fingerprint b: %v", err)
+	}
+	if fa != fb {
+		t.Fatalf("expected equal fingerprints for equivalent bodies, got %s vs %s", fa, fb)
+	}
+
+	// different amount -> different fingerprint
+	c := []byte(`{"customer_id":"c1","amount":10.51,"items":[{"sku":"s1","quantity":1}]}`)
+	fc, _ := Fingerprint("POST", "/orders", c, "c1")
+	if fc == fa {
+		t.Fatalf("expected different fingerprint for different body")
+	}
+
+	// different customer or path -> different fingerprint
+	if fd, _ := Fingerprint("POST", "/orders", a, "c2"); fd == fa {
+		t.Fatalf("expected different fingerprint for different customer")
+	}
+	if fe, _ := Fingerprint("POST", "/orders/x", a, "c1"); fe == fa {
+		t.Fatalf("expected different fingerprint for different path")
+	}
+
+	if _, err := Fingerprint("POST", "/orders", []byte(`{not json`), "c1"); err == nil {
+		t.Fatalf("expected error for invalid JSON")
+	}
+}
+
+func TestVerifyFingerprint(t *testing.T) {
+	rec := &IdempotencyRecord{IdempotencyKey: "k1", RequestHash: "abc"}
+	if err := rec.VerifyFingerprint("abc"); err != nil {
+		t.Fatalf("expected match, got %v", err)
+	}
+	if err := rec.VerifyFingerprint("def"); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Fatalf("expected ErrFingerprintMismatch, got %v", err)
+	}
+
+	// legacy records without a hash always match
+	legacy := &IdempotencyRecord{IdempotencyKey: "k2"}
+	if err := legacy.VerifyFingerprint("anything"); err != nil {
+		t.Fatalf("expected legacy record to match, got %v", err)
+	}
+}
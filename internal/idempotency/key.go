@@ -0,0 +1,39 @@
+package idempotency
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HeaderIdempotentReplayed marks a response replayed from a stored idempotency record.
+const HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+// MaxKeyLength bounds the Idempotency-Key value (after unquoting).
+const MaxKeyLength = 255
+
+// ErrInvalidKey is wrapped by ParseKey when the header value is not a usable key.
+var ErrInvalidKey = errors.New("invalid idempotency key")
+
+// ParseKey validates an Idempotency-Key header value and returns the key. The IETF httpapi draft
+// defines the value as a structured-field String ("..."), which is unquoted; bare tokens are accepted
+// too since most clients send them, so "abc" and abc name the same key.
+// The key must be 1 to MaxKeyLength printable ASCII characters without quotes or backslashes.
+func ParseKey(value string) (string, error) {
+	key := strings.TrimSpace(value)
+	if len(key) >= 2 && key[0] == '"' && key[len(key)-1] == '"' {
+		key = key[1 : len(key)-1]
+	}
+	if key == "" {
+		return "", fmt.Errorf("%w: empty", ErrInvalidKey)
+	}
+	if len(key) > MaxKeyLength {
+		return "", fmt.Errorf("%w: longer than %d characters", ErrInvalidKey, MaxKeyLength)
+	}
+	for i := 0; i < len(key); i++ {
+		if b := key[i]; b < 0x20 || b > 0x7e || b == '"' || b == '\\' {
+			return "", fmt.Errorf("%w: character %q not allowed", ErrInvalidKey, b)
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,28 @@
+package idempotency
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	valid := map[string]string{
+		"8e03978e-40d5-43e8-bc93-6894a57f9324":   "8e03978e-40d5-43e8-bc93-6894a57f9324",
+		`"8e03978e-40d5-43e8-bc93-6894a57f9324"`: "8e03978e-40d5-43e8-bc93-6894a57f9324", // structured-field string
+		"  order:42 retry ":                      "order:42 retry",
+		strings.Repeat("k", MaxKeyLength):        strings.Repeat("k", MaxKeyLength),
+	}
+	for in, want := range valid {
+		got, err := ParseKey(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseKey(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{`""`, "   ", strings.Repeat("k", MaxKeyLength+1), "tab\tkey", `back\slash`, `mid"quote`, "ключ"} {
+		if _, err := ParseKey(in); !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("ParseKey(%q): expected ErrInvalidKey, got %v", in, err)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package idempotency
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/metrics"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/problem"
+)
+
+// HeaderIdempotencyKey is the request header carrying the client's idempotency key.
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// MiddlewareConfig configures idempotency for a single route (or route group).
+type MiddlewareConfig struct {
+	Store *Store
+
+	// RequireKey rejects requests without an Idempotency-Key with 400; otherwise they pass through untracked.
+	RequireKey bool
+
+	// TTL overrides the Store's TTL window for records created by this route. Zero keeps the Store default.
+	TTL time.Duration
+
+	// Cacheable decides which response statuses are persisted and replayed. Responses that are not
+	// cacheable mark the record FAILED so the client can retry with the same key.
+	// Defaults to DefaultCacheable.
+	Cacheable func(status int) bool
+
+	// Owner returns who the request acts for, such as the authenticated customer or the owner of the
+	// route's resource. It is part of the fingerprint, as the customer is for POST /orders, so a key
+	// reused on behalf of someone else gets 422 instead of their response. An error aborts the request
+	// with 500. Optional.
+	Owner func(c *gin.Context) (string, error)
+
+	// Metrics counts replays by record status. Optional.
+	Metrics metrics.Recorder
+}
+
+// DefaultCacheable caches every response except server errors and throttling.
+func DefaultCacheable(status int) bool {
+	return status < http.StatusInternalServerError && status != http.StatusTooManyRequests
+}
+
+// skipReplayHeaders are never stored: they are computed per response, belong to the original
+// request (its X-Request-Id, cookies) or, like Content-Type, are stored separately.
+var skipReplayHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Content-Type":      true,
+	"Date":              true,
+	"Connection":        true,
+	"Transfer-Encoding": true,
+	"Set-Cookie":        true,
+	"X-Request-Id":      true,
+}
+
+// Middleware returns a gin handler that makes the downstream handler idempotent.
+// The first request for a key runs the handler under a lease; its status, headers and body are
+// captured and stored. Duplicates replay the stored response verbatim, in-flight duplicates get 409,
+// and a reused key with a different request (method, path, query, body or owner; see RequestFingerprint)
+// gets 422.
+func Middleware(cfg MiddlewareConfig) gin.HandlerFunc {
+	store := cfg.Store
+	if cfg.TTL > 0 {
+		store = store.WithTTL(cfg.TTL)
+	}
+	cacheable := cfg.Cacheable
+	if cacheable == nil {
+		cacheable = DefaultCacheable
+	}
+
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		key, err := RequestKey(c)
+		if err != nil {
+			problem.Abort(c, InvalidKeyProblem(err))
+			return
+		}
+		if key == "" {
+			if cfg.RequireKey {
+				problem.Abort(c, MissingKeyProblem())
+				return
+			}
+			c.Next()
+			return
+		}
+
+		var rawBody []byte
+		if c.Request.Body != nil {
+			b, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_request_body", "msg": err.Error()})
+				return
+			}
+			rawBody = b
+			c.Request.Body = io.NopCloser(bytes.NewReader(rawBody))
+		}
+		owner := ""
+		if cfg.Owner != nil {
+			if owner, err = cfg.Owner(c); err != nil {
+				problem.Abort(c, CheckFailedProblem(err))
+				return
+			}
+		}
+		fingerprint, err := RequestFingerprint(c.Request, rawBody, owner)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_request_body", "msg": err.Error()})
+			return
+		}
+
+		lease, err := store.AcquireOrTakeover(ctx, key, "", uuid.NewString(), fingerprint)
+		if errors.Is(err, ErrNotAcquired) {
+			replay(c, store, key, fingerprint, metrics.OrNop(cfg.Metrics))
+			return
+		}
+		if err != nil {
+			problem.Abort(c, CheckFailedProblem(err))
+			return
+		}
+
+		w := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		status := w.Status()
+		if !cacheable(status) {
+			if ferr := store.MarkFailedFenced(ctx, *lease, http.StatusText(status)); ferr != nil {
+				logging.FromContext(ctx).Error("idempotency record could not be marked failed", logging.KeyIdempotencyKey, key, logging.Err(ferr))
+			}
+			return
+		}
+		headers := map[string]string{}
+		for name, values := range w.Header() {
+			if !skipReplayHeaders[name] {
+				// repeated fields combine into one comma-separated field (RFC 9110 §5.3)
+				headers[name] = strings.Join(values, ", ")
+			}
+		}
+		resp := Response{Status: status, ContentType: w.Header().Get("Content-Type"), Headers: headers, Body: w.body.Bytes()}
+		if derr := store.MarkDoneFenced(ctx, *lease, resp); derr != nil {
+			logging.FromContext(ctx).Error("idempotency record could not be marked done", logging.KeyIdempotencyKey, key, logging.Err(derr))
+		}
+	}
+}
+
+// replay answers a request whose key is already owned by a previous request.
+func replay(c *gin.Context, store *Store, key, fingerprint string, m metrics.Recorder) {
+	rec, err := store.Get(c.Request.Context(), key)
+	if err != nil {
+		problem.Abort(c, CheckFailedProblem(err))
+		return
+	}
+	if rec == nil {
+		// record expired between the conditional write and the read; ask the client to retry
+		problem.Abort(c, InProgressProblem(""))
+		return
+	}
+	if err := rec.VerifyFingerprint(fingerprint); err != nil {
+		problem.Abort(c, KeyReusedProblem())
+		return
+	}
+	m.Count(metrics.IdempotentReplay, 1, metrics.Dim(metrics.DimStatus, rec.Status))
+	if rec.Status != StatusDone {
+		problem.Abort(c, InProgressProblem(rec.OrderID))
+		return
+	}
+	Replay(c, rec)
+}
+
+// captureWriter tees everything the downstream handler writes so it can be stored for replay.
+type captureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
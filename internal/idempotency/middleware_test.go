@@ -0,0 +1,265 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/blob"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/problem"
+)
+
+func newMiddlewareRouter(store *Store, status *int, calls *int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PATCH("/orders/:id", Middleware(MiddlewareConfig{Store: store, RequireKey: true}), func(c *gin.Context) {
+		*calls++
+		c.Header("X-Call", "handled")
+		c.JSON(*status, gin.H{"id": c.Param("id"), "call": *calls})
+	})
+	return r
+}
+
+func doPatch(r *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, "/orders/o1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware_ReplaysStoredResponse(t *testing.T) {
+	store := NewStore(newFakeDynamo(), testTable, 48*time.Hour)
+	status, calls := http.StatusOK, 0
+	r := newMiddlewareRouter(store, &status, &calls)
+
+	first := doPatch(r, "mw-1", `{"note":"a"}`)
+	if first.Code != http.StatusOK || calls != 1 {
+		t.Fatalf("expected handler to run once with 200, got code=%d calls=%d", first.Code, calls)
+	}
+
+	second := doPatch(r, "mw-1", `{ "note": "a" }`)
+	if calls != 1 {
+		t.Fatalf("expected duplicate to be replayed without calling handler, calls=%d", calls)
+	}
+	if second.Code != first.Code || second.Body.String() != first.Body.String() {
+		t.Fatalf("replay mismatch: %d %q vs %d %q", second.Code, second.Body.String(), first.Code, first.Body.String())
+	}
+	if second.Header().Get("X-Call") != "handled" || second.Header().Get("Content-Type") != first.Header().Get("Content-Type") {
+		t.Fatalf("expected headers to be replayed, got %v", second.Header())
+	}
+	if first.Header().Get(HeaderIdempotentReplayed) != "" || second.Header().Get(HeaderIdempotentReplayed) != "true" {
+		t.Fatalf("only the replay must be marked: first=%q second=%q",
+			first.Header().Get(HeaderIdempotentReplayed), second.Header().Get(HeaderIdempotentReplayed))
+	}
+
+	// same key, different payload
+	mismatch := doPatch(r, "mw-1", `{"note":"b"}`)
+	assertProblem(t, mismatch, http.StatusUnprocessableEntity, CodeKeyReused)
+
+	// key is required on this route, and must be well formed
+	assertProblem(t, doPatch(r, "", `{"note":"a"}`), http.StatusBadRequest, CodeMissingKey)
+	assertProblem(t, doPatch(r, strings.Repeat("k", MaxKeyLength+1), `{"note":"a"}`), http.StatusBadRequest, CodeInvalidKey)
+	if calls != 1 {
+		t.Fatalf("rejected requests must not reach the handler, calls=%d", calls)
+	}
+}
+
+func TestMiddleware_ReplaysLargeResponseFromBlobStore(t *testing.T) {
+	store := NewStore(newFakeDynamo(), testTable, 48*time.Hour)
+	blobs := blob.NewMemory()
+	store.SetBlobStore(blobs)
+	export := make([]byte, 2*InlineLimit)
+	_, _ = rand.Read(export)
+	calls := 0
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/exports", Middleware(MiddlewareConfig{Store: store, RequireKey: true}), func(c *gin.Context) {
+		calls++
+		c.Header("Set-Cookie", "session=first-caller")
+		c.Header("Location", "/exports/e1")
+		c.Data(http.StatusCreated, "application/octet-stream", export)
+	})
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/exports", strings.NewReader(`{}`))
+		req.Header.Set(HeaderIdempotencyKey, "export-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	post()
+	replayed := post()
+	if calls != 1 || replayed.Code != http.StatusCreated || !bytes.Equal(replayed.Body.Bytes(), export) {
+		t.Fatalf("expected the %d byte body replayed, got calls=%d code=%d len=%d", len(export), calls, replayed.Code, replayed.Body.Len())
+	}
+	if replayed.Header().Get("Content-Type") != "application/octet-stream" || replayed.Header().Get("Location") != "/exports/e1" {
+		t.Fatalf("content type and headers not replayed: %v", replayed.Header())
+	}
+	if replayed.Header().Get("Set-Cookie") != "" {
+		t.Fatal("cookies of the original request must not be replayed")
+	}
+}
+
+func TestMiddleware_InFlightDuplicateConflicts(t *testing.T) {
+	store := NewStore(newFakeDynamo(), testTable, 48*time.Hour)
+	status, calls := http.StatusOK, 0
+	r := newMiddlewareRouter(store, &status, &calls)
+
+	// another request holds the lease for the same key and payload
+	fp, _ := Fingerprint(http.MethodPatch, "/orders/o1", []byte(`{}`), "")
+	if _, err := store.AcquireOrTakeover(context.Background(), "mw-3", "o1", "other", fp); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	w := doPatch(r, "mw-3", `{}`)
+	body := assertProblem(t, w, http.StatusConflict, CodeInProgress)
+	if body["order_id"] != "o1" || calls != 0 {
+		t.Fatalf("expected order_id o1 and no handler call, got %v calls=%d", body, calls)
+	}
+}
+
+func assertProblem(t *testing.T, w *httptest.ResponseRecorder, status int, code string) map[string]any {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("expected %d, got %d: %s", status, w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != problem.ContentType {
+		t.Fatalf("expected %s, got %q", problem.ContentType, ct)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode problem: %v", err)
+	}
+	if body["code"] != code || body["status"] != float64(status) || body["type"] != "/problems/"+code || body["title"] == "" {
+		t.Fatalf("unexpected problem body: %v", body)
+	}
+	return body
+}
+
+func TestMiddleware_NonCacheableStatusAllowsRetry(t *testing.T) {
+	store := NewStore(newFakeDynamo(), testTable, 48*time.Hour)
+	status, calls := http.StatusServiceUnavailable, 0
+	r := newMiddlewareRouter(store, &status, &calls)
+
+	if w := doPatch(r, "mw-2", `{}`); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", w.Code)
+	}
+	rec, _ := store.Get(context.Background(), "mw-2")
+	if rec == nil || rec.Status != StatusFailed {
+		t.Fatalf("expected FAILED record after 503, got %+v", rec)
+	}
+
+	// retry with the same key re-runs the handler and the success is cached
+	status = http.StatusOK
+	if w := doPatch(r, "mw-2", `{}`); w.Code != http.StatusOK || calls != 2 {
+		t.Fatalf("expected retry to run handler, code=%d calls=%d", w.Code, calls)
+	}
+	if w := doPatch(r, "mw-2", `{}`); w.Code != http.StatusOK || calls != 2 {
+		t.Fatalf("expected cached replay, code=%d calls=%d", w.Code, calls)
+	}
+}
+
+func TestMiddleware_FingerprintsAnyBodyAndTheQuery(t *testing.T) {
+	store := NewStore(newFakeDynamo(), testTable, 48*time.Hour)
+	calls := 0
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/uploads", Middleware(MiddlewareConfig{Store: store, RequireKey: true}), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"call": calls})
+	})
+	post := func(key, target, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set(HeaderIdempotencyKey, key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// bodies that are not JSON reach the handler, and are compared as sent
+	for i, tc := range []struct{ contentType, body string }{
+		{"application/x-www-form-urlencoded", "name=a&size=1"},
+		{"text/plain; charset=utf-8", "not { json"},
+		{"application/octet-stream", "\x00\x01\x02"},
+	} {
+		key := fmt.Sprintf("body-%d", i)
+		if w := post(key, "/uploads", tc.contentType, tc.body); w.Code != http.StatusCreated {
+			t.Fatalf("%s: expected 201, got %d: %s", tc.contentType, w.Code, w.Body.String())
+		}
+		if w := post(key, "/uploads", tc.contentType, tc.body); w.Code != http.StatusCreated || w.Header().Get(HeaderIdempotentReplayed) != "true" {
+			t.Fatalf("%s: expected a replay, got %d %v", tc.contentType, w.Code, w.Header())
+		}
+		if w := post(key, "/uploads", tc.contentType, tc.body+"x"); w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%s: expected 422 for another body, got %d", tc.contentType, w.Code)
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("expected the handler to run once per body, ran %d times", calls)
+	}
+
+	// the query is part of the request
+	if w := post("query", "/uploads?dry_run=true", "application/json", `{}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	if w := post("query", "/uploads?dry_run=false", "application/json", `{}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for another query, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := post("query", "/uploads?dry_run=true", "application/json", `{ }`); w.Header().Get(HeaderIdempotentReplayed) != "true" {
+		t.Fatalf("expected a replay for the same query, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestMiddleware_FingerprintsTheOwner(t *testing.T) {
+	store := NewStore(newFakeDynamo(), testTable, 48*time.Hour)
+	calls := 0
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	owner := func(c *gin.Context) (string, error) {
+		if c.GetHeader("X-Customer") == "broken" {
+			return "", errors.New("lookup failed")
+		}
+		return c.GetHeader("X-Customer"), nil
+	}
+	r.POST("/orders/:id/cancel", Middleware(MiddlewareConfig{Store: store, RequireKey: true, Owner: owner}), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusOK, gin.H{"call": calls})
+	})
+	post := func(customer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orders/o1/cancel", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(HeaderIdempotencyKey, "owned")
+		req.Header.Set("X-Customer", customer)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post("c1"); w.Code != http.StatusOK || calls != 1 {
+		t.Fatalf("expected the handler to run, got %d calls=%d", w.Code, calls)
+	}
+	if w := post("c1"); w.Header().Get(HeaderIdempotentReplayed) != "true" || calls != 1 {
+		t.Fatalf("expected a replay for the same owner, got %d %v", w.Code, w.Header())
+	}
+	// same key, route and body on behalf of someone else
+	assertProblem(t, post("c2"), http.StatusUnprocessableEntity, CodeKeyReused)
+	assertProblem(t, post("broken"), http.StatusInternalServerError, CodeCheckFailed)
+	if calls != 1 {
+		t.Fatalf("rejected requests must not reach the handler, calls=%d", calls)
+	}
+}
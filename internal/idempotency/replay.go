@@ -0,0 +1,77 @@
+package idempotency
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/problem"
+)
+
+// Problem codes of idempotency errors, returned as problem+json (see package problem).
+const (
+	CodeMissingKey  = "missing_idempotency_key"  // 400: the route requires an Idempotency-Key
+	CodeInvalidKey  = "invalid_idempotency_key"  // 400: the key fails ParseKey
+	CodeKeyReused   = "idempotency_key_reused"   // 422: the key was used with a different payload
+	CodeInProgress  = "request_in_progress"      // 409: the original request is still being processed
+	CodeCheckFailed = "idempotency_check_failed" // 500: the idempotency record could not be read or written
+)
+
+// RequestKey returns the request's parsed Idempotency-Key, or "" if it has none.
+func RequestKey(c *gin.Context) (string, error) {
+	value := c.GetHeader(HeaderIdempotencyKey)
+	if value == "" {
+		return "", nil
+	}
+	return ParseKey(value)
+}
+
+// MissingKeyProblem is the response to a request without the required key.
+func MissingKeyProblem() *problem.Details {
+	return problem.New(http.StatusBadRequest, CodeMissingKey, "this endpoint requires an "+HeaderIdempotencyKey+" header")
+}
+
+// InvalidKeyProblem is the response to a malformed key.
+func InvalidKeyProblem(err error) *problem.Details {
+	return problem.New(http.StatusBadRequest, CodeInvalidKey, err.Error())
+}
+
+// KeyReusedProblem is the response to a key reused with a different request payload.
+func KeyReusedProblem() *problem.Details {
+	return problem.New(http.StatusUnprocessableEntity, CodeKeyReused, HeaderIdempotencyKey+" was already used with a different request payload")
+}
+
+// InProgressProblem is the response to a duplicate of a request that is still being processed.
+// orderID, when known, is included so the client can poll the order.
+func InProgressProblem(orderID string) *problem.Details {
+	p := problem.New(http.StatusConflict, CodeInProgress, "a request with this "+HeaderIdempotencyKey+" is still being processed; retry later")
+	if orderID != "" {
+		p.With("order_id", orderID)
+	}
+	return p
+}
+
+// CheckFailedProblem is the response when the idempotency record cannot be used.
+func CheckFailedProblem(err error) *problem.Details {
+	return problem.New(http.StatusInternalServerError, CodeCheckFailed, fmt.Sprintf("idempotency check failed: %v", err))
+}
+
+// Replay writes the response stored in rec, with its content type and headers (including the
+// original Location), and marks it with Idempotent-Replayed: true.
+func Replay(c *gin.Context, rec *IdempotencyRecord) {
+	for name, value := range rec.ResponseHeaders {
+		c.Header(name, value)
+	}
+	c.Header(HeaderIdempotentReplayed, "true")
+	contentType := rec.ResponseContentType
+	if contentType == "" {
+		// records written before the content type had its own attribute
+		contentType = rec.ResponseHeaders["Content-Type"]
+	}
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	c.Data(rec.ResponseStatus, contentType, []byte(rec.ResponseBody))
+	c.Abort()
+}
@@ -11,14 +11,21 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/smithy-go"
 	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/blob"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
 )
 
+// DefaultLeaseDuration is how long an IN_PROGRESS record is owned before another request may take it over.
+const DefaultLeaseDuration = 30 * time.Second
+
 // Store encapsulates idempotency operations against DynamoDB.
 type Store struct {
-	client    aws.DynamoDBAPI
-	tableName string
-	ttlWindow time.Duration // default TTL window when creating entries
-	nowFunc   func() time.Time
+	client        aws.DynamoDBAPI
+	tableName     string
+	ttlWindow     time.Duration // default TTL window when creating entries
+	leaseDuration time.Duration // how long an IN_PROGRESS lease is held
+	blobs         blob.Store    // optional; holds response bodies too large for the item
+	nowFunc       func() time.Time
 }
 
 // NewStore returns a configured Store.
@@ -26,30 +33,73 @@ type Store struct {
 // ttlWindow: default TTL window (e.g., 48*time.Hour)
 func NewStore(client aws.DynamoDBAPI, tableName string, ttlWindow time.Duration) *Store {
 	return &Store{
-		client:    client,
-		tableName: tableName,
-		ttlWindow: ttlWindow,
-		nowFunc:   time.Now,
+		client:        client,
+		tableName:     tableName,
+		ttlWindow:     ttlWindow,
+		leaseDuration: DefaultLeaseDuration,
+		nowFunc:       time.Now,
 	}
 }
 
+// WithTTL returns a copy of the Store that writes records with a different TTL window.
+// Useful when routes sharing a table need different retention.
+func (s *Store) WithTTL(ttlWindow time.Duration) *Store {
+	cp := *s
+	cp.ttlWindow = ttlWindow
+	return &cp
+}
+
+// SetLeaseDuration overrides DefaultLeaseDuration for records created or taken over by this Store.
+func (s *Store) SetLeaseDuration(d time.Duration) {
+	s.leaseDuration = d
+}
+
 // ErrConditionFailed indicates a conditional write failed (e.g., attribute_not_exists)
 var ErrConditionFailed = errors.New("conditional check failed")
 
-// CreateIfNotExists creates an idempotency record with status IN_PROGRESS if the key does not exist.
-// Returns (created=true, nil) if successfully created.
-// Returns (created=false, nil) if the record already exists (caller should Get to inspect).
-// Returns (created=false, err) on other errors.
-func (s *Store) CreateIfNotExists(ctx context.Context, key, orderID string) (bool, error) {
+// ErrNotAcquired indicates the record exists and its lease is still held (or it is no longer IN_PROGRESS).
+var ErrNotAcquired = errors.New("idempotency lease not acquired")
+
+// ErrLeaseLost indicates a fenced write was rejected because the lease was taken over by another owner,
+// or the record was already settled (e.g. the worker marked it DONE or FAILED first).
+var ErrLeaseLost = errors.New("idempotency lease lost")
+
+// NewInProgressRecord builds an IN_PROGRESS record holding a fresh lease for owner.
+// It is meant for callers that write the record themselves, e.g. inside a TransactWriteItems
+// alongside the order; the returned Lease must be used for the fenced MarkDone/MarkFailed.
+func (s *Store) NewInProgressRecord(key, orderID, owner, fingerprint string) (IdempotencyRecord, Lease) {
 	now := s.nowFunc()
+	leaseExpires := now.Add(s.leaseDuration)
 	rec := IdempotencyRecord{
 		IdempotencyKey: key,
 		Status:         StatusInProgress,
 		OrderID:        orderID,
+		RequestHash:    fingerprint,
 		CreatedAt:      now,
 		UpdatedAt:      now,
 		ExpiresAt:      now.Add(s.ttlWindow).Unix(),
+		LeaseOwner:     owner,
+		LeaseExpiresAt: leaseExpires.UnixMilli(),
+		FenceToken:     1,
 	}
+	lease := Lease{
+		Key:       key,
+		OrderID:   orderID,
+		Owner:     owner,
+		Token:     1,
+		ExpiresAt: leaseExpires,
+	}
+	return rec, lease
+}
+
+// CreateIfNotExists creates an idempotency record with status IN_PROGRESS if the key does not exist.
+// Returns (created=true, nil) if successfully created.
+// Returns (created=false, nil) if the record already exists (caller should Get to inspect).
+// Returns (created=false, err) on other errors.
+func (s *Store) CreateIfNotExists(ctx context.Context, key, orderID string) (_ bool, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "idempotency.CreateIfNotExists", s.tableName)
+	defer tracing.End(span, &err)
+	rec, _ := s.NewInProgressRecord(key, orderID, "", "")
 
 	item, err := attributevalue.MarshalMap(rec)
 	if err != nil {
@@ -66,8 +116,7 @@ func (s *Store) CreateIfNotExists(ctx context.Context, key, orderID string) (boo
 	_, err = s.client.PutItem(ctx, input)
 	if err != nil {
 		// detect conditional check failure
-		var sc smithy.APIError
-		if errors.As(err, &sc) && sc.ErrorCode() == "ConditionalCheckFailedException" {
+		if isConditionalCheckFailed(err) {
 			return false, nil
 		}
 		return false, fmt.Errorf("put item: %w", err)
@@ -76,6 +125,89 @@ func (s *Store) CreateIfNotExists(ctx context.Context, key, orderID string) (boo
 	return true, nil
 }
 
+// AcquireOrTakeover obtains the IN_PROGRESS lease for key on behalf of owner.
+// The record is created if it does not exist; if it exists and is FAILED, or IN_PROGRESS with an expired lease,
+// it is taken over and its fencing token incremented so the previous holder's late writes are rejected.
+// Takeover requires a matching request fingerprint; an existing order_id is preserved so the new owner
+// resumes the same order.
+// Returns ErrNotAcquired if the record exists and cannot be taken over (caller should Get to inspect).
+func (s *Store) AcquireOrTakeover(ctx context.Context, key, orderID, owner, fingerprint string) (_ *Lease, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "idempotency.AcquireOrTakeover", s.tableName)
+	defer tracing.End(span, &err, ErrNotAcquired)
+	return s.acquire(ctx, key, orderID, owner, fingerprint, true)
+}
+
+// TakeoverExpired takes over key's lease only while the record is IN_PROGRESS with an expired lease and a
+// matching request fingerprint, keeping its order_id. Unlike AcquireOrTakeover it never takes over a
+// FAILED record, so a request resuming a dead holder's order cannot overwrite the worker's outcome.
+// Returns ErrNotAcquired otherwise (caller should Get to inspect).
+func (s *Store) TakeoverExpired(ctx context.Context, key, owner, fingerprint string) (_ *Lease, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "idempotency.TakeoverExpired", s.tableName)
+	defer tracing.End(span, &err, ErrNotAcquired)
+	return s.acquire(ctx, key, "", owner, fingerprint, false)
+}
+
+// acquire sets key's IN_PROGRESS lease for owner, bumping the fencing token, if the record is IN_PROGRESS
+// with an expired lease and a matching fingerprint. With create it may also create the record or take
+// over a FAILED one.
+func (s *Store) acquire(ctx context.Context, key, orderID, owner, fingerprint string, create bool) (*Lease, error) {
+	now := s.nowFunc()
+	leaseExpires := now.Add(s.leaseDuration)
+	input := &dyn.UpdateItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"idempotency_key": &types.AttributeValueMemberS{Value: key},
+		},
+		UpdateExpression: awsString("SET #s = :inprogress, lease_owner = :owner, lease_expires_at = :lexp, " +
+			"fence_token = if_not_exists(fence_token, :zero) + :one, updated_at = :ua, " +
+			"created_at = if_not_exists(created_at, :ua), expires_at = if_not_exists(expires_at, :exp), " +
+			"order_id = if_not_exists(order_id, :oid), request_hash = if_not_exists(request_hash, :rh)"),
+		ConditionExpression: awsString("#s = :inprogress AND lease_expires_at < :now AND " +
+			"(attribute_not_exists(request_hash) OR request_hash = :rh)"),
+		ExpressionAttributeNames: map[string]string{
+			"#s": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":inprogress": &types.AttributeValueMemberS{Value: StatusInProgress},
+			":owner":      &types.AttributeValueMemberS{Value: owner},
+			":lexp":       &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", leaseExpires.UnixMilli())},
+			":now":        &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.UnixMilli())},
+			":zero":       &types.AttributeValueMemberN{Value: "0"},
+			":one":        &types.AttributeValueMemberN{Value: "1"},
+			":ua":         &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+			":exp":        &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Add(s.ttlWindow).Unix())},
+			":oid":        &types.AttributeValueMemberS{Value: orderID},
+			":rh":         &types.AttributeValueMemberS{Value: fingerprint},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	}
+	if create {
+		input.ConditionExpression = awsString("attribute_not_exists(idempotency_key) OR " +
+			"((#s = :failed OR (#s = :inprogress AND lease_expires_at < :now)) AND " +
+			"(attribute_not_exists(request_hash) OR request_hash = :rh))")
+		input.ExpressionAttributeValues[":failed"] = &types.AttributeValueMemberS{Value: StatusFailed}
+	}
+	out, err := s.client.UpdateItem(ctx, input)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return nil, ErrNotAcquired
+		}
+		return nil, fmt.Errorf("update item (acquire lease): %w", err)
+	}
+
+	var rec IdempotencyRecord
+	if err := attributevalue.UnmarshalMap(out.Attributes, &rec); err != nil {
+		return nil, fmt.Errorf("unmarshal item: %w", err)
+	}
+	return &Lease{
+		Key:       key,
+		OrderID:   rec.OrderID,
+		Owner:     owner,
+		Token:     rec.FenceToken,
+		ExpiresAt: leaseExpires,
+	}, nil
+}
+
 // CreateWithOrderTransaction atomically creates the idempotency record and an order item
 // using TransactWriteItems. orderItem should be a struct that can be marshaled by attributevalue.
 // Returns nil on success; ErrConditionFailed if condition failed (idempotency key exists).
@@ -130,7 +262,9 @@ func (s *Store) CreateWithOrderTransaction(ctx context.Context, key string, orde
 }
 
 // Get retrieves an idempotency record by key. If not found, returns (nil, nil).
-func (s *Store) Get(ctx context.Context, key string) (*IdempotencyRecord, error) {
+func (s *Store) Get(ctx context.Context, key string) (_ *IdempotencyRecord, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "idempotency.Get", s.tableName)
+	defer tracing.End(span, &err)
 	input := &dyn.GetItemInput{
 		TableName: &s.tableName,
 		Key: map[string]types.AttributeValue{
@@ -148,32 +282,77 @@ func (s *Store) Get(ctx context.Context, key string) (*IdempotencyRecord, error)
 	if err := attributevalue.UnmarshalMap(out.Item, &rec); err != nil {
 		return nil, fmt.Errorf("unmarshal item: %w", err)
 	}
+	if err := s.decodeBody(ctx, &rec); err != nil {
+		return nil, err
+	}
 	return &rec, nil
 }
 
-// MarkDone sets status to DONE and stores a small response body & status.
+// MarkDone sets status to DONE and stores the response body & status.
 // It uses UpdateItem with a conditional expression to ensure transition from IN_PROGRESS -> DONE or FAILED -> DONE depending on needs.
 func (s *Store) MarkDone(ctx context.Context, key, responseBody string, responseStatus int) error {
+	return s.markDone(ctx, key, Response{Status: responseStatus, Body: []byte(responseBody)}, nil)
+}
+
+// MarkDoneFenced is MarkDone for a lease holder, storing the full response for replay: status,
+// content type, headers and body. Large bodies are compressed or spilled to the blob store (see
+// CompressThreshold). It returns ErrLeaseLost if the lease was taken over or the record is no longer
+// IN_PROGRESS, so it never overwrites an outcome the worker recorded first.
+func (s *Store) MarkDoneFenced(ctx context.Context, lease Lease, resp Response) error {
+	return s.markDone(ctx, lease.Key, resp, &lease)
+}
+
+func (s *Store) markDone(ctx context.Context, key string, resp Response, lease *Lease) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "idempotency.MarkDone", s.tableName)
+	defer tracing.End(span, &err, ErrLeaseLost)
+	bodyAttr, body, err := s.encodeBody(ctx, key, resp.Body)
+	if err != nil {
+		return err
+	}
 	now := s.nowFunc()
 	input := &dyn.UpdateItemInput{
 		TableName: &s.tableName,
 		Key: map[string]types.AttributeValue{
 			"idempotency_key": &types.AttributeValueMemberS{Value: key},
 		},
-		UpdateExpression: awsString("SET #s = :done, response_body = :rb, response_status = :rs, updated_at = :ua"),
+		UpdateExpression: awsString("SET #s = :done, " + bodyAttr + " = :rb, response_status = :rs, updated_at = :ua"),
 		ExpressionAttributeNames: map[string]string{
 			"#s": "status",
 		},
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":done": &types.AttributeValueMemberS{Value: StatusDone},
-			":rb":   &types.AttributeValueMemberS{Value: responseBody},
-			":rs":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", responseStatus)},
+			":rb":   body,
+			":rs":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", resp.Status)},
 			":ua":   &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
 		},
 		ReturnValues: types.ReturnValueUpdatedNew,
 	}
-	_, err := s.client.UpdateItem(ctx, input)
+	if resp.ContentType != "" {
+		*input.UpdateExpression += ", response_content_type = :ct"
+		input.ExpressionAttributeValues[":ct"] = &types.AttributeValueMemberS{Value: resp.ContentType}
+	}
+	if len(resp.Headers) > 0 {
+		hdrs, err := attributevalue.Marshal(resp.Headers)
+		if err != nil {
+			return fmt.Errorf("marshal response headers: %w", err)
+		}
+		*input.UpdateExpression += ", response_headers = :hdrs"
+		input.ExpressionAttributeValues[":hdrs"] = hdrs
+	}
+	// drop the body a previous write may have stored in another form
+	sep := " REMOVE "
+	for _, attr := range bodyAttributes {
+		if attr != bodyAttr {
+			*input.UpdateExpression += sep + attr
+			sep = ", "
+		}
+	}
+	fence(input, lease)
+	_, err = s.client.UpdateItem(ctx, input)
 	if err != nil {
+		if lease != nil && isConditionalCheckFailed(err) {
+			return ErrLeaseLost
+		}
 		return fmt.Errorf("update item (mark done): %w", err)
 	}
 	return nil
@@ -181,6 +360,18 @@ func (s *Store) MarkDone(ctx context.Context, key, responseBody string, response
 
 // MarkFailed marks the idempotency record as FAILED and optionally stores a note.
 func (s *Store) MarkFailed(ctx context.Context, key, note string) error {
+	return s.markFailed(ctx, key, note, nil)
+}
+
+// MarkFailedFenced is MarkFailed for a lease holder. It returns ErrLeaseLost if the lease was taken over or
+// the record is no longer IN_PROGRESS.
+func (s *Store) MarkFailedFenced(ctx context.Context, lease Lease, note string) error {
+	return s.markFailed(ctx, lease.Key, note, &lease)
+}
+
+func (s *Store) markFailed(ctx context.Context, key, note string, lease *Lease) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "idempotency.MarkFailed", s.tableName)
+	defer tracing.End(span, &err, ErrLeaseLost)
 	now := s.nowFunc()
 	input := &dyn.UpdateItemInput{
 		TableName: &s.tableName,
@@ -198,12 +389,33 @@ func (s *Store) MarkFailed(ctx context.Context, key, note string) error {
 		},
 		ReturnValues: types.ReturnValueUpdatedNew,
 	}
-	_, err := s.client.UpdateItem(ctx, input)
+	fence(input, lease)
+	_, err = s.client.UpdateItem(ctx, input)
 	if err != nil {
+		if lease != nil && isConditionalCheckFailed(err) {
+			return ErrLeaseLost
+		}
 		return fmt.Errorf("update item (mark failed): %w", err)
 	}
 	return nil
 }
 
+// fence guards an update so it only applies while lease's fencing token is current and the record is
+// still IN_PROGRESS. The update must map #s to status.
+func fence(input *dyn.UpdateItemInput, lease *Lease) {
+	if lease == nil {
+		return
+	}
+	input.ConditionExpression = awsString("fence_token = :token AND #s = :inprogress")
+	input.ExpressionAttributeValues[":token"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", lease.Token)}
+	input.ExpressionAttributeValues[":inprogress"] = &types.AttributeValueMemberS{Value: StatusInProgress}
+}
+
+// isConditionalCheckFailed detects a failed ConditionExpression by its API error code.
+func isConditionalCheckFailed(err error) bool {
+	var sc smithy.APIError
+	return errors.As(err, &sc) && sc.ErrorCode() == "ConditionalCheckFailedException"
+}
+
 // Helper
 func awsString(s string) *string { return &s }
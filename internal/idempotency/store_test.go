@@ -1,17 +1,32 @@
 package idempotency
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"errors"
+	"strconv"
 	"testing"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/blob"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/testing/dynamofake"
 )
 
+const testTable = "idempotency-table"
+
+func newFakeDynamo() *dynamofake.Fake {
+	return dynamofake.New(dynamofake.TableSchema{Name: testTable, HashKey: "idempotency_key"})
+}
+
 func TestCreateIfNotExists_Get_MarkDone_MarkFailed(t *testing.T) {
-	mock := newSimpleMock()
-	s := NewStore(mock, "idempotency-table", 48*time.Hour)
+	db := newFakeDynamo()
+	s := NewStore(db, testTable, 48*time.Hour)
 
 	ctx := context.Background()
 	key := "test-key-1"
@@ -55,10 +70,10 @@ func TestCreateIfNotExists_Get_MarkDone_MarkFailed(t *testing.T) {
 		t.Fatalf("MarkDone error: %v", err)
 	}
 
-	// Read raw item from mock to assert updated fields
-	item := mock.table[key]
+	// Read raw item to assert updated fields
+	item := db.Item(testTable, key)
 	if item == nil {
-		t.Fatalf("mock item missing")
+		t.Fatalf("item missing")
 	}
 	// verify status
 	if st, ok := item["status"].(*types.AttributeValueMemberS); !ok || st.Value != StatusDone {
@@ -74,9 +89,9 @@ func TestCreateIfNotExists_Get_MarkDone_MarkFailed(t *testing.T) {
 	if err != nil {
 		t.Fatalf("MarkFailed error: %v", err)
 	}
-	item2 := mock.table[key]
+	item2 := db.Item(testTable, key)
 	if item2 == nil {
-		t.Fatalf("mock item missing after mark failed")
+		t.Fatalf("item missing after mark failed")
 	}
 	if st, ok := item2["status"].(*types.AttributeValueMemberS); !ok || st.Value != StatusFailed {
 		t.Fatalf("status not updated to FAILED, got %+v", item2["status"])
@@ -108,3 +123,244 @@ func TestAttributevalueMarshal_Unmarshal(t *testing.T) {
 		t.Fatalf("unmarshal mismatch")
 	}
 }
+
+func TestAcquireOrTakeover_LeaseAndFencing(t *testing.T) {
+	db := newFakeDynamo()
+	s := NewStore(db, testTable, 48*time.Hour)
+	s.SetLeaseDuration(10 * time.Second)
+
+	now := time.Now()
+	s.nowFunc = func() time.Time { return now }
+
+	ctx := context.Background()
+	key := "lease-key"
+
+	// first caller creates the record and holds the lease
+	first, err := s.AcquireOrTakeover(ctx, key, "order-1", "owner-a", "fp")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if first.Token != 1 || first.OrderID != "order-1" {
+		t.Fatalf("unexpected lease: %+v", first)
+	}
+
+	// lease still live -> second caller is rejected
+	if _, err := s.AcquireOrTakeover(ctx, key, "order-2", "owner-b", "fp"); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("expected ErrNotAcquired while lease is held, got %v", err)
+	}
+
+	// lease expired -> takeover keeps the original order and bumps the fencing token
+	now = now.Add(11 * time.Second)
+	second, err := s.AcquireOrTakeover(ctx, key, "order-2", "owner-b", "fp")
+	if err != nil {
+		t.Fatalf("takeover: %v", err)
+	}
+	if second.Token != 2 {
+		t.Fatalf("expected fence token 2, got %d", second.Token)
+	}
+	if second.OrderID != "order-1" {
+		t.Fatalf("expected takeover to preserve order-1, got %s", second.OrderID)
+	}
+
+	// the old holder's late MarkDone is fenced off
+	if err := s.MarkDoneFenced(ctx, *first, Response{Status: 201, Body: []byte(`{"stale":true}`)}); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected ErrLeaseLost for stale holder, got %v", err)
+	}
+	if err := s.MarkDoneFenced(ctx, *second, Response{Status: 201, Body: []byte(`{"ok":true}`)}); err != nil {
+		t.Fatalf("MarkDoneFenced by current holder: %v", err)
+	}
+
+	rec, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if rec.Status != StatusDone || rec.ResponseBody != `{"ok":true}` || rec.LeaseOwner != "owner-b" {
+		t.Fatalf("unexpected record after fenced MarkDone: %+v", rec)
+	}
+
+	// DONE records are never taken over, even once the lease timestamp is in the past
+	now = now.Add(time.Minute)
+	if _, err := s.AcquireOrTakeover(ctx, key, "order-3", "owner-c", "fp"); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("expected ErrNotAcquired for DONE record, got %v", err)
+	}
+}
+
+func TestTakeoverExpired_OnlyTakesOverAnExpiredInProgressLease(t *testing.T) {
+	db := newFakeDynamo()
+	s := NewStore(db, testTable, 48*time.Hour)
+	s.SetLeaseDuration(10 * time.Second)
+	now := time.Now()
+	s.nowFunc = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, err := s.TakeoverExpired(ctx, "missing", "owner-b", "fp"); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("expected ErrNotAcquired for a missing record, got %v", err)
+	}
+	if rec, _ := s.Get(ctx, "missing"); rec != nil {
+		t.Fatalf("TakeoverExpired created a record: %+v", rec)
+	}
+
+	first, err := s.AcquireOrTakeover(ctx, "k", "order-1", "owner-a", "fp")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if _, err := s.TakeoverExpired(ctx, "k", "owner-b", "fp"); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("expected ErrNotAcquired while the lease is held, got %v", err)
+	}
+	now = now.Add(11 * time.Second)
+	if _, err := s.TakeoverExpired(ctx, "k", "owner-b", "other-fp"); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("expected ErrNotAcquired for another request's fingerprint, got %v", err)
+	}
+	second, err := s.TakeoverExpired(ctx, "k", "owner-b", "fp")
+	if err != nil {
+		t.Fatalf("takeover: %v", err)
+	}
+	if second.OrderID != first.OrderID || second.Token != first.Token+1 {
+		t.Fatalf("unexpected lease after takeover: %+v", second)
+	}
+
+	// the worker fails the order before the next takeover: the record stays FAILED
+	if err := s.MarkFailed(ctx, "k", "payment declined"); err != nil {
+		t.Fatalf("MarkFailed: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := s.TakeoverExpired(ctx, "k", "owner-c", "fp"); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("expected ErrNotAcquired for a FAILED record, got %v", err)
+	}
+	if rec, _ := s.Get(ctx, "k"); rec.Status != StatusFailed || rec.LeaseOwner != "owner-b" {
+		t.Fatalf("FAILED record was taken over: %+v", rec)
+	}
+}
+
+func TestMarkDoneFenced_NeverOverwritesTheWorkersOutcome(t *testing.T) {
+	db := newFakeDynamo()
+	s := NewStore(db, testTable, 48*time.Hour)
+	ctx := context.Background()
+
+	for i, settle := range []func(key string) error{
+		func(key string) error { return s.MarkFailed(ctx, key, "validation failed") },
+		func(key string) error { return s.MarkDone(ctx, key, `{"status":"COMPLETED"}`, 200) },
+	} {
+		// the API holds the lease while the worker already settled the record
+		lease, err := s.AcquireOrTakeover(ctx, "settled-"+strconv.Itoa(i), "order-1", "api", "fp")
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if err := settle(lease.Key); err != nil {
+			t.Fatalf("settle: %v", err)
+		}
+		before, _ := s.Get(ctx, lease.Key)
+		if err := s.MarkDoneFenced(ctx, *lease, Response{Status: 201, Body: []byte(`{"status":"PENDING"}`)}); !errors.Is(err, ErrLeaseLost) {
+			t.Fatalf("expected ErrLeaseLost, got %v", err)
+		}
+		if err := s.MarkFailedFenced(ctx, *lease, "late"); !errors.Is(err, ErrLeaseLost) {
+			t.Fatalf("expected ErrLeaseLost, got %v", err)
+		}
+		after, _ := s.Get(ctx, lease.Key)
+		if after.Status != before.Status || after.ResponseBody != before.ResponseBody || after.Note != before.Note {
+			t.Fatalf("the worker's outcome was overwritten: %+v -> %+v", before, after)
+		}
+	}
+}
+
+func TestMarkDoneFenced_StoresFullResponseAndSpillsLargeBodies(t *testing.T) {
+	db := newFakeDynamo()
+	s := NewStore(db, testTable, 48*time.Hour)
+	blobs := blob.NewMemory()
+	s.SetBlobStore(blobs)
+	ctx := context.Background()
+
+	// which body attribute the item holds
+	stored := func(key string) string {
+		out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(testTable),
+			Key:       map[string]types.AttributeValue{"idempotency_key": &types.AttributeValueMemberS{Value: key}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found []string
+		for _, attr := range bodyAttributes {
+			if _, ok := out.Item[attr]; ok {
+				found = append(found, attr)
+			}
+		}
+		if len(found) != 1 {
+			t.Fatalf("%s: expected exactly one body attribute, got %v", key, found)
+		}
+		return found[0]
+	}
+
+	random := make([]byte, 3*InlineLimit)
+	_, _ = rand.Read(random)
+	cases := []struct {
+		key  string
+		body []byte
+		attr string
+	}{
+		{"small", []byte(`{"ok":true}`), "response_body"},
+		{"compressible", bytes.Repeat([]byte(`{"line":"item"},`), 4*InlineLimit/16), "response_body_z"},
+		{"large", random, "response_body_ref"},
+	}
+	for _, tc := range cases {
+		lease, err := s.AcquireOrTakeover(ctx, tc.key, "", "owner", "fp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := Response{Status: 201, ContentType: "application/octet-stream", Headers: map[string]string{"Location": "/x"}, Body: tc.body}
+		if err := s.MarkDoneFenced(ctx, *lease, resp); err != nil {
+			t.Fatalf("%s: MarkDoneFenced: %v", tc.key, err)
+		}
+		if got := stored(tc.key); got != tc.attr {
+			t.Fatalf("%s: body stored as %s, want %s", tc.key, got, tc.attr)
+		}
+		rec, err := s.Get(ctx, tc.key)
+		if err != nil {
+			t.Fatalf("%s: Get: %v", tc.key, err)
+		}
+		if rec.ResponseBody != string(tc.body) || rec.ResponseStatus != 201 ||
+			rec.ResponseContentType != "application/octet-stream" || rec.ResponseHeaders["Location"] != "/x" {
+			t.Fatalf("%s: response not restored: status %d type %q headers %v body %d bytes",
+				tc.key, rec.ResponseStatus, rec.ResponseContentType, rec.ResponseHeaders, len(rec.ResponseBody))
+		}
+	}
+
+	// the worker's later small body replaces the spilled one, keeping the API's content type
+	if err := s.MarkDone(ctx, "large", `{"status":"COMPLETED"}`, 200); err != nil {
+		t.Fatal(err)
+	}
+	if got := stored("large"); got != "response_body" {
+		t.Fatalf("stale body attribute left behind: %s", got)
+	}
+	rec, err := s.Get(ctx, "large")
+	if err != nil || rec.ResponseBody != `{"status":"COMPLETED"}` || rec.ResponseContentType != "application/octet-stream" {
+		t.Fatalf("unexpected record after MarkDone: %+v, %v", rec, err)
+	}
+
+	// without a blob store a large body stays compressed in the item; a store that spilled
+	// bodies cannot read them once its blob store is gone
+	plain := NewStore(db, testTable, 48*time.Hour)
+	lease, err := plain.AcquireOrTakeover(ctx, "inline", "", "owner", "fp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.MarkDoneFenced(ctx, *lease, Response{Status: 200, Body: random}); err != nil {
+		t.Fatal(err)
+	}
+	if got := stored("inline"); got != "response_body_z" {
+		t.Fatalf("expected inline compressed body, got %s", got)
+	}
+	if _, err := plain.Get(ctx, "compressible"); err != nil {
+		t.Fatalf("compressed bodies need no blob store: %v", err)
+	}
+	lease, err = s.AcquireOrTakeover(ctx, "large-2", "", "owner", "fp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkDoneFenced(ctx, *lease, Response{Status: 200, Body: random}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.Get(ctx, "large-2"); err == nil {
+		t.Fatal("expected an error reading a spilled body without a blob store")
+	}
+}
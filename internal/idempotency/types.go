@@ -11,13 +11,45 @@ const (
 
 // IdempotencyRecord is the shape persisted in the idempotency DynamoDB table.
 type IdempotencyRecord struct {
-	IdempotencyKey string    `dynamodbav:"idempotency_key"` // PK
-	Status         string    `dynamodbav:"status"`
-	OrderID        string    `dynamodbav:"order_id,omitempty"`
-	ResponseBody   string    `dynamodbav:"response_body,omitempty"`   // small responses only; else use S3 pointer
-	ResponseStatus int       `dynamodbav:"response_status,omitempty"` // e.g., 201
-	CreatedAt      time.Time `dynamodbav:"created_at"`
-	UpdatedAt      time.Time `dynamodbav:"updated_at"`
-	ExpiresAt      int64     `dynamodbav:"expires_at"` // TTL epoch seconds
-	Note           string    `dynamodbav:"note,omitempty"`
+	IdempotencyKey      string            `dynamodbav:"idempotency_key"` // PK
+	Status              string            `dynamodbav:"status"`
+	OrderID             string            `dynamodbav:"order_id,omitempty"`
+	RequestHash         string            `dynamodbav:"request_hash,omitempty"`      // canonical request fingerprint, see Fingerprint
+	ResponseBody        string            `dynamodbav:"response_body,omitempty"`     // small bodies only; Get fills it from the fields below
+	ResponseBodyZ       []byte            `dynamodbav:"response_body_z,omitempty"`   // gzip-compressed body
+	ResponseBodyRef     string            `dynamodbav:"response_body_ref,omitempty"` // blob store key of a gzip-compressed body too large for the item
+	ResponseStatus      int               `dynamodbav:"response_status,omitempty"`   // e.g., 201
+	ResponseContentType string            `dynamodbav:"response_content_type,omitempty"`
+	ResponseHeaders     map[string]string `dynamodbav:"response_headers,omitempty"` // replayed verbatim by Replay
+	CreatedAt           time.Time         `dynamodbav:"created_at"`
+	UpdatedAt           time.Time         `dynamodbav:"updated_at"`
+	ExpiresAt           int64             `dynamodbav:"expires_at"` // TTL epoch seconds
+	Note                string            `dynamodbav:"note,omitempty"`
+	LeaseOwner          string            `dynamodbav:"lease_owner,omitempty"`      // holder of the IN_PROGRESS lease
+	LeaseExpiresAt      int64             `dynamodbav:"lease_expires_at,omitempty"` // epoch millis; stale leases may be taken over
+	FenceToken          int64             `dynamodbav:"fence_token,omitempty"`      // incremented on every acquire/takeover
+}
+
+// Response is a response stored for replay: its status, content type, the headers worth
+// replaying (e.g. Location) and the body.
+type Response struct {
+	Status      int
+	ContentType string
+	Headers     map[string]string
+	Body        []byte
+}
+
+// Lease is held by the request currently working on an IN_PROGRESS record.
+// Token is a fencing token: writes made with an older token are rejected.
+type Lease struct {
+	Key       string
+	OrderID   string
+	Owner     string
+	Token     int64
+	ExpiresAt time.Time
+}
+
+// LeaseExpired reports whether the record's IN_PROGRESS lease is no longer held at now.
+func (r *IdempotencyRecord) LeaseExpired(now time.Time) bool {
+	return r.Status == StatusInProgress && r.LeaseExpiresAt > 0 && r.LeaseExpiresAt < now.UnixMilli()
 }
@@ -0,0 +1,53 @@
+package inventory
+
+import (
+	"context"
+	"time"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+)
+
+// DefaultBatchSize is how many expired reservations the expirer reads per query.
+const DefaultBatchSize = 25
+
+// Expirer returns the stock of reservations whose hold expired, e.g. because the worker gave up on
+// the order without releasing it.
+type Expirer struct {
+	store     *Store
+	batchSize int32
+}
+
+// NewExpirer creates an Expirer releasing reservations from store.
+func NewExpirer(store *Store) *Expirer {
+	return &Expirer{store: store, batchSize: DefaultBatchSize}
+}
+
+// Drain releases batches until a batch comes back short and returns how many reservations it released.
+func (e *Expirer) Drain(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := e.store.ReleaseExpired(ctx, e.batchSize)
+		total += n
+		if err != nil || n < int(e.batchSize) {
+			return total, err
+		}
+	}
+}
+
+// Run drains expired reservations every interval until ctx is cancelled. Used when running outside Lambda.
+func (e *Expirer) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := e.Drain(ctx); err != nil {
+			logging.FromContext(ctx).Error("reservation expiry failed", logging.Err(err))
+		} else if n > 0 {
+			logging.FromContext(ctx).Info("expired reservations released", "count", n)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
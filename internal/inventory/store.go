@@ -0,0 +1,425 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	dyn "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
+)
+
+var (
+	// ErrInsufficientStock indicates a SKU has less available stock than a reservation asks for.
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrUnknownSKU indicates a SKU without a stock item.
+	ErrUnknownSKU = errors.New("unknown sku")
+	// ErrInvalidLines indicates reservation lines without a SKU or a positive quantity, or too many SKUs.
+	ErrInvalidLines = errors.New("invalid reservation lines")
+	// ErrReservationNotFound indicates the order has no reservation.
+	ErrReservationNotFound = errors.New("reservation not found")
+	// ErrReservationReleased indicates the order's reservation was released (e.g. it expired), so its
+	// stock may have gone to other orders.
+	ErrReservationReleased = errors.New("reservation released")
+)
+
+// DefaultHold is how long a reservation stays RESERVED before ReleaseExpired returns its stock.
+const DefaultHold = 30 * time.Minute
+
+// maxLines is the most SKUs one reservation can hold: a transaction has at most 100 actions, one of
+// which writes the reservation.
+const maxLines = 99
+
+// transitionAttempts bounds how often Release and Commit re-read a reservation that changed under them.
+const transitionAttempts = 3
+
+// errStale means the reservation's status changed between reading it and updating it.
+var errStale = errors.New("reservation changed concurrently")
+
+// Store encapsulates operations on the stock and reservations tables.
+type Store struct {
+	client            aws.DynamoDBAPI
+	stockTable        string
+	reservationsTable string
+	hold              time.Duration
+	nowFunc           func() time.Time
+}
+
+// NewStore creates a new inventory Store. hold is how long reservations are kept before they expire;
+// it must outlast processing an order, retries included.
+func NewStore(client aws.DynamoDBAPI, stockTable, reservationsTable string, hold time.Duration) *Store {
+	return &Store{
+		client:            client,
+		stockTable:        stockTable,
+		reservationsTable: reservationsTable,
+		hold:              hold,
+		nowFunc:           time.Now,
+	}
+}
+
+// Get fetches the stock of sku. Returns (nil, nil) if the SKU has no stock item.
+func (s *Store) Get(ctx context.Context, sku string) (_ *Stock, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "inventory.Get", s.stockTable)
+	defer tracing.End(span, &err)
+	out, err := s.client.GetItem(ctx, &dyn.GetItemInput{
+		TableName: &s.stockTable,
+		Key:       skuKey(sku),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get item: %w", err)
+	}
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+	var st Stock
+	if err := attributevalue.UnmarshalMap(out.Item, &st); err != nil {
+		return nil, fmt.Errorf("unmarshal stock: %w", err)
+	}
+	return &st, nil
+}
+
+// AddStock adds quantity units of sku to the available stock, creating its stock item if needed.
+func (s *Store) AddStock(ctx context.Context, sku string, quantity int64) (_ *Stock, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "inventory.AddStock", s.stockTable)
+	defer tracing.End(span, &err)
+	if sku == "" || quantity <= 0 {
+		return nil, fmt.Errorf("add stock: want a sku and a positive quantity, got %q and %d", sku, quantity)
+	}
+	out, err := s.client.UpdateItem(ctx, &dyn.UpdateItemInput{
+		TableName:        &s.stockTable,
+		Key:              skuKey(sku),
+		UpdateExpression: awsString("SET available = if_not_exists(available, :zero) + :q, reserved = if_not_exists(reserved, :zero), updated_at = :ua"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":q":    number(quantity),
+			":zero": number(0),
+			":ua":   &types.AttributeValueMemberS{Value: s.nowFunc().Format(time.RFC3339)},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("add stock %s: %w", sku, err)
+	}
+	var st Stock
+	if err := attributevalue.UnmarshalMap(out.Attributes, &st); err != nil {
+		return nil, fmt.Errorf("unmarshal stock: %w", err)
+	}
+	return &st, nil
+}
+
+// GetReservation fetches the reservation of orderID. Returns (nil, nil) if there is none.
+func (s *Store) GetReservation(ctx context.Context, orderID string) (_ *Reservation, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "inventory.GetReservation", s.reservationsTable)
+	defer tracing.End(span, &err)
+	out, err := s.client.GetItem(ctx, &dyn.GetItemInput{
+		TableName:      &s.reservationsTable,
+		Key:            orderKey(orderID),
+		ConsistentRead: awsBool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get item: %w", err)
+	}
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+	var r Reservation
+	if err := attributevalue.UnmarshalMap(out.Item, &r); err != nil {
+		return nil, fmt.Errorf("unmarshal reservation: %w", err)
+	}
+	return &r, nil
+}
+
+// Reserve holds lines for orderID: in one transaction it writes the reservation and moves each SKU's
+// quantity from available to reserved, so either all of the stock is reserved or none is.
+//
+// Reserve is idempotent per order: if the order already has a reservation it is returned unchanged,
+// whatever lines were passed. If that reservation was released, it returns it with ErrReservationReleased.
+// Returns ErrInsufficientStock or ErrUnknownSKU (wrapped, naming the SKU) if the stock is not there.
+func (s *Store) Reserve(ctx context.Context, orderID string, lines []Line) (_ *Reservation, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "inventory.Reserve", s.reservationsTable, s.stockTable)
+	defer tracing.End(span, &err, ErrInsufficientStock, ErrUnknownSKU, ErrReservationReleased)
+	lines, err = mergeLines(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.nowFunc()
+	res := Reservation{
+		OrderID:   orderID,
+		Status:    StatusReserved,
+		Lines:     lines,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(s.hold).Unix(),
+	}
+	item, err := attributevalue.MarshalMap(res)
+	if err != nil {
+		return nil, fmt.Errorf("marshal reservation: %w", err)
+	}
+	actions := []types.TransactWriteItem{{
+		Put: &types.Put{
+			TableName:                           &s.reservationsTable,
+			Item:                                item,
+			ConditionExpression:                 awsString("attribute_not_exists(order_id)"),
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		},
+	}}
+	ua := &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)}
+	for _, l := range lines {
+		actions = append(actions, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName:           &s.stockTable,
+				Key:                 skuKey(l.SKU),
+				UpdateExpression:    awsString("SET available = available - :q, reserved = reserved + :q, updated_at = :ua"),
+				ConditionExpression: awsString("available >= :q"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":q":  number(l.Quantity),
+					":ua": ua,
+				},
+				ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+			},
+		})
+	}
+
+	_, err = s.client.TransactWriteItems(ctx, &dyn.TransactWriteItemsInput{TransactItems: actions})
+	if err == nil {
+		return &res, nil
+	}
+	var tce *types.TransactionCanceledException
+	if !errors.As(err, &tce) {
+		return nil, fmt.Errorf("reserve stock for order %s: %w", orderID, err)
+	}
+	reasons := tce.CancellationReasons
+	if conditionFailed(reasons, 0) {
+		// reserved before: return what was reserved then
+		var existing Reservation
+		if uerr := attributevalue.UnmarshalMap(reasons[0].Item, &existing); uerr != nil {
+			return nil, fmt.Errorf("unmarshal reservation: %w", uerr)
+		}
+		if existing.Status == StatusReleased {
+			return &existing, fmt.Errorf("order %s: %w", orderID, ErrReservationReleased)
+		}
+		return &existing, nil
+	}
+	for i, l := range lines {
+		if !conditionFailed(reasons, i+1) {
+			continue
+		}
+		if len(reasons[i+1].Item) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownSKU, l.SKU)
+		}
+		var st Stock
+		_ = attributevalue.UnmarshalMap(reasons[i+1].Item, &st)
+		return nil, fmt.Errorf("%w: %s has %d available, %d wanted", ErrInsufficientStock, l.SKU, st.Available, l.Quantity)
+	}
+	// e.g. a conflicting transaction on one of the SKUs; retrying may succeed
+	return nil, fmt.Errorf("reserve stock for order %s: %w", orderID, err)
+}
+
+// Commit marks the reservation of orderID as consumed: its stock leaves reserved for good and the
+// reservation no longer expires. Committing twice is a no-op. Returns ErrReservationNotFound if the
+// order has no reservation and ErrReservationReleased if it was released.
+func (s *Store) Commit(ctx context.Context, orderID string) (_ *Reservation, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "inventory.Commit", s.reservationsTable, s.stockTable)
+	defer tracing.End(span, &err, ErrReservationNotFound, ErrReservationReleased)
+	for attempt := 0; attempt < transitionAttempts; attempt++ {
+		res, err := s.GetReservation(ctx, orderID)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case res == nil:
+			return nil, fmt.Errorf("order %s: %w", orderID, ErrReservationNotFound)
+		case res.Status == StatusCommitted:
+			return res, nil
+		case res.Status == StatusReleased:
+			return res, fmt.Errorf("order %s: %w", orderID, ErrReservationReleased)
+		}
+		err = s.transition(ctx, res, StatusCommitted, "")
+		if errors.Is(err, errStale) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("commit reservation of order %s: %w", orderID, errStale)
+}
+
+// Release returns the stock of orderID's reservation, committed or not, to available, recording
+// reason. Releasing twice, or an order without a reservation, is a no-op; the latter returns (nil, nil).
+func (s *Store) Release(ctx context.Context, orderID, reason string) (_ *Reservation, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "inventory.Release", s.reservationsTable, s.stockTable)
+	defer tracing.End(span, &err)
+	for attempt := 0; attempt < transitionAttempts; attempt++ {
+		res, err := s.GetReservation(ctx, orderID)
+		if err != nil {
+			return nil, err
+		}
+		if res == nil || res.Status == StatusReleased {
+			return res, nil
+		}
+		err = s.transition(ctx, res, StatusReleased, reason)
+		if errors.Is(err, errStale) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("release reservation of order %s: %w", orderID, errStale)
+}
+
+// ReleaseExpired releases up to limit RESERVED reservations whose hold has expired and returns how many
+// it released. Reservations committed or released meanwhile are skipped.
+func (s *Store) ReleaseExpired(ctx context.Context, limit int32) (_ int, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "inventory.ReleaseExpired", s.reservationsTable, s.stockTable)
+	defer tracing.End(span, &err)
+	out, err := s.client.Query(ctx, &dyn.QueryInput{
+		TableName:                &s.reservationsTable,
+		IndexName:                awsString(StatusIndex),
+		KeyConditionExpression:   awsString("#s = :reserved AND expires_at <= :now"),
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":reserved": &types.AttributeValueMemberS{Value: StatusReserved},
+			":now":      number(s.nowFunc().Unix()),
+		},
+		Limit:            &limit,
+		ScanIndexForward: awsBool(true),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("query expired reservations: %w", err)
+	}
+	var expired []Reservation
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &expired); err != nil {
+		return 0, fmt.Errorf("unmarshal reservations: %w", err)
+	}
+	released := 0
+	for i := range expired {
+		err := s.transition(ctx, &expired[i], StatusReleased, "reservation expired")
+		if errors.Is(err, errStale) {
+			continue // the index lags: it was committed or released meanwhile
+		}
+		if err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// transition moves res to status to and adjusts its SKUs' stock to match, in one transaction that
+// requires res to still have the status it was read with. On success res is updated in place.
+func (s *Store) transition(ctx context.Context, res *Reservation, to, reason string) error {
+	now := s.nowFunc()
+	ua := &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)}
+	update := "SET #s = :to, updated_at = :ua"
+	values := map[string]types.AttributeValue{
+		":to":   &types.AttributeValueMemberS{Value: to},
+		":from": &types.AttributeValueMemberS{Value: res.Status},
+		":ua":   ua,
+	}
+	if reason != "" {
+		update += ", reason = :reason"
+		values[":reason"] = &types.AttributeValueMemberS{Value: reason}
+	}
+	actions := []types.TransactWriteItem{{
+		Update: &types.Update{
+			TableName:                 &s.reservationsTable,
+			Key:                       orderKey(res.OrderID),
+			UpdateExpression:          &update,
+			ConditionExpression:       awsString("#s = :from"),
+			ExpressionAttributeNames:  map[string]string{"#s": "status"},
+			ExpressionAttributeValues: values,
+		},
+	}}
+
+	var stockUpdate string
+	switch {
+	case res.Status == StatusReserved && to == StatusCommitted:
+		stockUpdate = "SET reserved = reserved - :q, updated_at = :ua"
+	case res.Status == StatusReserved && to == StatusReleased:
+		stockUpdate = "SET available = available + :q, reserved = reserved - :q, updated_at = :ua"
+	case res.Status == StatusCommitted && to == StatusReleased:
+		stockUpdate = "SET available = available + :q, updated_at = :ua"
+	default:
+		return fmt.Errorf("reservation of order %s: cannot move from %s to %s", res.OrderID, res.Status, to)
+	}
+	for _, l := range res.Lines {
+		actions = append(actions, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName:        &s.stockTable,
+				Key:              skuKey(l.SKU),
+				UpdateExpression: awsString(stockUpdate),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":q":  number(l.Quantity),
+					":ua": ua,
+				},
+			},
+		})
+	}
+
+	_, err := s.client.TransactWriteItems(ctx, &dyn.TransactWriteItemsInput{TransactItems: actions})
+	if err != nil {
+		var tce *types.TransactionCanceledException
+		if errors.As(err, &tce) && conditionFailed(tce.CancellationReasons, 0) {
+			return errStale
+		}
+		return fmt.Errorf("move reservation of order %s to %s: %w", res.OrderID, to, err)
+	}
+	res.Status, res.UpdatedAt = to, now
+	if reason != "" {
+		res.Reason = reason
+	}
+	return nil
+}
+
+// mergeLines validates lines and merges those of the same SKU: a transaction cannot update one
+// stock item twice. The result is sorted by SKU.
+func mergeLines(lines []Line) ([]Line, error) {
+	bySKU := map[string]int64{}
+	for _, l := range lines {
+		if l.SKU == "" || l.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: want a sku and a positive quantity, got %+v", ErrInvalidLines, l)
+		}
+		bySKU[l.SKU] += l.Quantity
+	}
+	if len(bySKU) > maxLines {
+		return nil, fmt.Errorf("%w: %d SKUs, at most %d", ErrInvalidLines, len(bySKU), maxLines)
+	}
+	merged := make([]Line, 0, len(bySKU))
+	for sku, q := range bySKU {
+		merged = append(merged, Line{SKU: sku, Quantity: q})
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].SKU < merged[j].SKU })
+	return merged, nil
+}
+
+// conditionFailed reports whether the transaction action at index i failed its condition.
+func conditionFailed(reasons []types.CancellationReason, i int) bool {
+	return i < len(reasons) && reasons[i].Code != nil && *reasons[i].Code == "ConditionalCheckFailed"
+}
+
+func skuKey(sku string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{"sku": &types.AttributeValueMemberS{Value: sku}}
+}
+
+func orderKey(orderID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{"order_id": &types.AttributeValueMemberS{Value: orderID}}
+}
+
+func number(n int64) *types.AttributeValueMemberN {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+func awsString(s string) *string { return &s }
+
+func awsBool(b bool) *bool { return &b }
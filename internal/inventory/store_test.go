@@ -0,0 +1,166 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/testing/dynamofake"
+)
+
+const (
+	stockTable        = "stock"
+	reservationsTable = "reservations"
+)
+
+func newTestStore(t *testing.T, stock map[string]int64) *Store {
+	t.Helper()
+	db := dynamofake.New(
+		dynamofake.TableSchema{Name: stockTable, HashKey: "sku"},
+		dynamofake.TableSchema{
+			Name:    reservationsTable,
+			HashKey: "order_id",
+			Indexes: []dynamofake.IndexSchema{{Name: StatusIndex, HashKey: "status", RangeKey: "expires_at"}},
+		},
+	)
+	s := NewStore(db, stockTable, reservationsTable, DefaultHold)
+	for sku, q := range stock {
+		if _, err := s.AddStock(context.Background(), sku, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// expectStock fails unless sku has the given available and reserved quantities.
+func expectStock(t *testing.T, s *Store, sku string, available, reserved int64) {
+	t.Helper()
+	st, err := s.Get(context.Background(), sku)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st == nil || st.Available != available || st.Reserved != reserved {
+		t.Fatalf("%s: expected %d available and %d reserved, got %+v", sku, available, reserved, st)
+	}
+}
+
+func TestReserve_IdempotentPerOrderAndAllOrNothing(t *testing.T) {
+	s := newTestStore(t, map[string]int64{"A": 5, "B": 1})
+	ctx := context.Background()
+
+	res, err := s.Reserve(ctx, "o1", []Line{{SKU: "B", Quantity: 1}, {SKU: "A", Quantity: 2}, {SKU: "A", Quantity: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusReserved || len(res.Lines) != 2 || res.Lines[0] != (Line{SKU: "A", Quantity: 3}) {
+		t.Fatalf("unexpected reservation: %+v", res)
+	}
+	expectStock(t, s, "A", 2, 3)
+	expectStock(t, s, "B", 0, 1)
+
+	// a repeated delivery gets the same reservation and takes nothing more
+	again, err := s.Reserve(ctx, "o1", []Line{{SKU: "A", Quantity: 1}})
+	if err != nil || len(again.Lines) != 2 || again.ExpiresAt != res.ExpiresAt {
+		t.Fatalf("expected the first reservation back, got %+v, %v", again, err)
+	}
+	expectStock(t, s, "A", 2, 3)
+
+	// B is short, so A is not touched either
+	if _, err := s.Reserve(ctx, "o2", []Line{{SKU: "A", Quantity: 1}, {SKU: "B", Quantity: 1}}); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("expected ErrInsufficientStock, got %v", err)
+	}
+	expectStock(t, s, "A", 2, 3)
+	if r, _ := s.GetReservation(ctx, "o2"); r != nil {
+		t.Fatalf("a failed reservation must not be stored: %+v", r)
+	}
+
+	if _, err := s.Reserve(ctx, "o3", []Line{{SKU: "Z", Quantity: 1}}); !errors.Is(err, ErrUnknownSKU) {
+		t.Fatalf("expected ErrUnknownSKU, got %v", err)
+	}
+	if _, err := s.Reserve(ctx, "o4", []Line{{SKU: "A", Quantity: 0}}); !errors.Is(err, ErrInvalidLines) {
+		t.Fatalf("expected ErrInvalidLines, got %v", err)
+	}
+}
+
+func TestCommitAndRelease(t *testing.T) {
+	s := newTestStore(t, map[string]int64{"A": 5})
+	ctx := context.Background()
+	if _, err := s.Reserve(ctx, "o1", []Line{{SKU: "A", Quantity: 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		res, err := s.Commit(ctx, "o1")
+		if err != nil || res.Status != StatusCommitted {
+			t.Fatalf("commit %d: %+v, %v", i, res, err)
+		}
+		expectStock(t, s, "A", 3, 0)
+	}
+
+	// releasing a committed reservation restocks it, once
+	for i := 0; i < 2; i++ {
+		res, err := s.Release(ctx, "o1", "order cancelled")
+		if err != nil || res.Status != StatusReleased || res.Reason != "order cancelled" {
+			t.Fatalf("release %d: %+v, %v", i, res, err)
+		}
+		expectStock(t, s, "A", 5, 0)
+	}
+
+	if _, err := s.Reserve(ctx, "o1", []Line{{SKU: "A", Quantity: 2}}); !errors.Is(err, ErrReservationReleased) {
+		t.Fatalf("expected ErrReservationReleased, got %v", err)
+	}
+	if _, err := s.Commit(ctx, "o1"); !errors.Is(err, ErrReservationReleased) {
+		t.Fatalf("expected ErrReservationReleased, got %v", err)
+	}
+	if _, err := s.Commit(ctx, "missing"); !errors.Is(err, ErrReservationNotFound) {
+		t.Fatalf("expected ErrReservationNotFound, got %v", err)
+	}
+	if res, err := s.Release(ctx, "missing", "order failed"); res != nil || err != nil {
+		t.Fatalf("releasing nothing is a no-op, got %+v, %v", res, err)
+	}
+
+	// a reservation still held is released from reserved
+	if _, err := s.Reserve(ctx, "o2", []Line{{SKU: "A", Quantity: 4}}); err != nil {
+		t.Fatal(err)
+	}
+	expectStock(t, s, "A", 1, 4)
+	if _, err := s.Release(ctx, "o2", "order failed"); err != nil {
+		t.Fatal(err)
+	}
+	expectStock(t, s, "A", 5, 0)
+}
+
+func TestReleaseExpired_ReturnsOnlyExpiredHolds(t *testing.T) {
+	s := newTestStore(t, map[string]int64{"A": 5})
+	ctx := context.Background()
+	now := time.Now()
+	s.nowFunc = func() time.Time { return now }
+
+	for _, id := range []string{"old", "committed"} {
+		if _, err := s.Reserve(ctx, id, []Line{{SKU: "A", Quantity: 1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Commit(ctx, "committed"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(DefaultHold / 2)
+	if _, err := s.Reserve(ctx, "recent", []Line{{SKU: "A", Quantity: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	expectStock(t, s, "A", 2, 2)
+
+	now = now.Add(DefaultHold/2 + time.Second)
+	n, err := NewExpirer(s).Drain(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 reservation released, got %d, %v", n, err)
+	}
+	expectStock(t, s, "A", 3, 1)
+	if res, _ := s.GetReservation(ctx, "old"); res.Status != StatusReleased || res.Reason != "reservation expired" {
+		t.Fatalf("unexpected expired reservation: %+v", res)
+	}
+	if res, _ := s.GetReservation(ctx, "committed"); res.Status != StatusCommitted {
+		t.Fatalf("a committed reservation must not expire: %+v", res)
+	}
+}
@@ -0,0 +1,44 @@
+// Package inventory keeps per-SKU stock in DynamoDB and reserves it for orders. A reservation moves
+// stock from available to reserved in one transaction with the reservation item, keyed by order_id, so
+// reserving an order twice is a no-op; it is later committed (the stock is consumed) or released.
+package inventory
+
+import "time"
+
+// Reservation statuses.
+const (
+	StatusReserved  = "RESERVED"  // stock held for the order
+	StatusCommitted = "COMMITTED" // stock consumed by the order
+	StatusReleased  = "RELEASED"  // stock returned: the order was cancelled or failed, or the hold expired
+)
+
+// StatusIndex is the GSI on the reservations table keyed by status (hash) and expires_at (range).
+// ReleaseExpired queries it for RESERVED reservations past their expiry.
+const StatusIndex = "status_index"
+
+// Stock is the inventory item of one SKU, stored in the stock table.
+type Stock struct {
+	SKU       string    `dynamodbav:"sku" json:"sku"`             // PK
+	Available int64     `dynamodbav:"available" json:"available"` // free to reserve
+	Reserved  int64     `dynamodbav:"reserved" json:"reserved"`   // held by RESERVED reservations
+	UpdatedAt time.Time `dynamodbav:"updated_at" json:"updated_at"`
+}
+
+// Line is a quantity of one SKU in a reservation.
+type Line struct {
+	SKU      string `dynamodbav:"sku" json:"sku"`
+	Quantity int64  `dynamodbav:"quantity" json:"quantity"`
+}
+
+// Reservation is the stock held for one order, stored in the reservations table.
+type Reservation struct {
+	OrderID   string    `dynamodbav:"order_id" json:"order_id"` // PK
+	Status    string    `dynamodbav:"status" json:"status"`     // RESERVED | COMMITTED | RELEASED
+	Lines     []Line    `dynamodbav:"lines" json:"lines"`       // one per SKU, sorted by SKU
+	Reason    string    `dynamodbav:"reason,omitempty" json:"reason,omitempty"`
+	CreatedAt time.Time `dynamodbav:"created_at" json:"created_at"`
+	UpdatedAt time.Time `dynamodbav:"updated_at" json:"updated_at"`
+	// ExpiresAt (epoch seconds) is when a reservation still RESERVED is released by ReleaseExpired.
+	// It is not a DynamoDB TTL attribute: deleting the item would not return the stock.
+	ExpiresAt int64 `dynamodbav:"expires_at" json:"expires_at"`
+}
@@ -0,0 +1,120 @@
+// Package logging provides JSON structured logging with log/slog, a request-scoped logger carried
+// in the context, and the correlation ID that ties an API request to the worker run it triggers.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HeaderRequestID carries the correlation ID on HTTP requests and responses.
+const HeaderRequestID = "X-Request-Id"
+
+// Field keys shared by every component so log lines can be joined on them.
+const (
+	KeyCorrelationID  = "correlation_id"
+	KeyOrderID        = "order_id"
+	KeyIdempotencyKey = "idempotency_key"
+	KeyAttempt        = "attempt"
+	KeyMessageID      = "message_id"
+	KeyOutboxID       = "outbox_id"
+	KeyRefundID       = "refund_id"
+	KeyEventID        = "event_id"
+	KeyEndpointID     = "endpoint_id"
+	KeyStep           = "step"
+	KeyError          = "error"
+	KeyTraceID        = "trace_id"
+)
+
+// maxCorrelationIDLen bounds client-supplied IDs; longer ones are replaced.
+const maxCorrelationIDLen = 128
+
+// New returns a logger writing JSON lines to w at level.
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// ParseLevel maps "debug", "info", "warn" and "error" (any case) to a level; "" is info.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q", s)
+	}
+	return l, nil
+}
+
+// Err is the attribute used for errors.
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+type loggerKey struct{}
+type correlationKey struct{}
+
+// WithLogger returns a context carrying l.
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or slog.Default.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// With returns a context whose logger has args added, and that logger.
+func With(ctx context.Context, args ...any) (context.Context, *slog.Logger) {
+	l := FromContext(ctx).With(args...)
+	return WithLogger(ctx, l), l
+}
+
+// WithTraceID returns ctx with the ID of its current trace, if any, added to its logger, so log lines
+// can be looked up from a trace and vice versa.
+func WithTraceID(ctx context.Context) context.Context {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		ctx, _ = With(ctx, KeyTraceID, sc.TraceID().String())
+	}
+	return ctx
+}
+
+// NewCorrelationID returns a fresh correlation ID.
+func NewCorrelationID() string {
+	return uuid.NewString()
+}
+
+// NormalizeCorrelationID returns id if it is usable as a correlation ID, or a fresh one.
+// Client-supplied IDs are limited to printable ASCII without spaces and maxCorrelationIDLen bytes.
+func NormalizeCorrelationID(id string) string {
+	if id == "" || len(id) > maxCorrelationIDLen {
+		return NewCorrelationID()
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' {
+			return NewCorrelationID()
+		}
+	}
+	return id
+}
+
+// WithCorrelationID returns a context carrying id, with id added to its logger.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, correlationKey{}, id)
+	ctx, _ = With(ctx, KeyCorrelationID, id)
+	return ctx
+}
+
+// CorrelationID returns the correlation ID carried by ctx, or "".
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
@@ -0,0 +1,106 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("log line is not JSON: %q: %v", line, err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestMiddleware_PropagatesOrGeneratesCorrelationID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{"client id kept", "req-123", true},
+		{"missing id generated", "", false},
+		{"unprintable id replaced", "bad id\n", false},
+		{"oversized id replaced", strings.Repeat("a", maxCorrelationIDLen+1), false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			r := gin.New()
+			r.Use(Middleware(New(&buf, slog.LevelInfo)))
+			var seen string
+			r.GET("/x", func(c *gin.Context) {
+				seen = CorrelationID(c.Request.Context())
+				FromContext(c.Request.Context()).Info("inside")
+				c.Status(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/x", nil)
+			if tc.header != "" {
+				req.Header.Set(HeaderRequestID, tc.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			got := w.Header().Get(HeaderRequestID)
+			if got == "" || got != seen {
+				t.Fatalf("response header %q does not match context id %q", got, seen)
+			}
+			if tc.keep != (got == tc.header) {
+				t.Fatalf("header %q: got id %q", tc.header, got)
+			}
+
+			lines := decodeLines(t, &buf)
+			if len(lines) != 2 {
+				t.Fatalf("expected 2 log lines, got %d", len(lines))
+			}
+			for _, l := range lines {
+				if l[KeyCorrelationID] != got {
+					t.Fatalf("line without correlation id: %v", l)
+				}
+			}
+			if done := lines[1]; done["msg"] != "request completed" || done["route"] != "/x" || done["status"] != float64(http.StatusNoContent) {
+				t.Fatalf("unexpected access line: %v", done)
+			}
+		})
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for in, want := range map[string]slog.Level{"": slog.LevelInfo, "debug": slog.LevelDebug, "WARN": slog.LevelWarn, "error": slog.LevelError} {
+		got, err := ParseLevel(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Fatal("expected error for unknown level")
+	}
+}
+
+func TestFromContext_DefaultsToSlogDefault(t *testing.T) {
+	if FromContext(context.Background()) != slog.Default() {
+		t.Fatal("expected slog.Default without a context logger")
+	}
+	if CorrelationID(context.Background()) != "" {
+		t.Fatal("expected no correlation id")
+	}
+}
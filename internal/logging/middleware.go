@@ -0,0 +1,37 @@
+package logging
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware gives every request a correlation ID, taken from X-Request-Id or generated, echoes it
+// in the response header, stores a logger carrying it (and the trace ID, when tracing.Middleware runs
+// first) in the request context, and logs one line per completed request.
+func Middleware(base *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := NormalizeCorrelationID(c.GetHeader(HeaderRequestID))
+		c.Header(HeaderRequestID, id)
+
+		ctx := WithLogger(c.Request.Context(), base)
+		ctx = WithCorrelationID(ctx, id)
+		ctx = WithTraceID(ctx)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		FromContext(c.Request.Context()).Log(ctx, level, "request completed",
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	}
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// buffer aggregates samples per series until they are taken by a flush.
+type buffer struct {
+	mu      sync.Mutex
+	byKey   map[string]*aggregate
+	order   []string // insertion order, so flushes are deterministic
+	nowFunc func() time.Time
+}
+
+func newBuffer() *buffer {
+	return &buffer{byKey: map[string]*aggregate{}, nowFunc: time.Now}
+}
+
+func (b *buffer) add(name string, v float64, latency bool, dims []Dimension) {
+	s := newSeries(name, dims)
+	k := s.key()
+	if latency {
+		k += "#latency"
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, ok := b.byKey[k]
+	if !ok {
+		a = &aggregate{series: s, latency: latency}
+		b.byKey[k] = a
+		b.order = append(b.order, k)
+	}
+	a.add(v)
+}
+
+// take empties the buffer and returns its aggregates with the flush timestamp.
+func (b *buffer) take() ([]*aggregate, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]*aggregate, 0, len(b.order))
+	for _, k := range b.order {
+		out = append(out, b.byKey[k])
+	}
+	b.byKey = map[string]*aggregate{}
+	b.order = nil
+	return out, b.nowFunc()
+}
+
+// putBack returns aggregates that failed to publish so the next flush retries them.
+func (b *buffer) putBack(aggs []*aggregate) {
+	for _, a := range aggs {
+		if a.latency {
+			for _, v := range a.values {
+				b.add(a.name, v, true, a.dims)
+			}
+			continue
+		}
+		b.add(a.name, a.sum, false, a.dims)
+	}
+}
+
+// Memory keeps every sample in memory for assertions in tests. Flush is a no-op.
+type Memory struct {
+	mu      sync.Mutex
+	samples []Sample
+}
+
+// Sample is one recorded Count or Observe call.
+type Sample struct {
+	Name     string
+	Value    float64 // counter increment, or latency in milliseconds
+	Latency  bool
+	Dims     map[string]string
+	Recorded time.Time
+}
+
+// NewMemory returns an empty in-memory recorder.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Count(name string, n float64, dims ...Dimension) {
+	m.record(name, n, false, dims)
+}
+
+func (m *Memory) Observe(name string, d time.Duration, dims ...Dimension) {
+	m.record(name, milliseconds(d), true, dims)
+}
+
+func (m *Memory) Flush(context.Context) error { return nil }
+
+func (m *Memory) record(name string, v float64, latency bool, dims []Dimension) {
+	ds := make(map[string]string, len(dims))
+	for _, d := range dims {
+		ds[d.Name] = d.Value
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = append(m.samples, Sample{Name: name, Value: v, Latency: latency, Dims: ds, Recorded: time.Now()})
+}
+
+// Samples returns a copy of everything recorded.
+func (m *Memory) Samples() []Sample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Sample(nil), m.samples...)
+}
+
+// Total sums the counter named name over samples carrying all of dims.
+func (m *Memory) Total(name string, dims ...Dimension) float64 {
+	var total float64
+	for _, s := range m.matching(name, dims) {
+		if !s.Latency {
+			total += s.Value
+		}
+	}
+	return total
+}
+
+// Observations returns how many latency samples named name carry all of dims.
+func (m *Memory) Observations(name string, dims ...Dimension) int {
+	n := 0
+	for _, s := range m.matching(name, dims) {
+		if s.Latency {
+			n++
+		}
+	}
+	return n
+}
+
+func (m *Memory) matching(name string, dims []Dimension) []Sample {
+	var out []Sample
+next:
+	for _, s := range m.Samples() {
+		if s.Name != name {
+			continue
+		}
+		for _, d := range dims {
+			if s.Dims[d.Name] != d.Value {
+				continue next
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
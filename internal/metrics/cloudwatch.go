@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+)
+
+// maxDatumsPerPut is the PutMetricData limit on metric datums per request.
+const maxDatumsPerPut = 1000
+
+// CloudWatch buffers samples and publishes them with PutMetricData. Counters are sent as one summed
+// value and latencies as a statistic set per series, so a flush costs one call per 1000 series
+// regardless of traffic.
+type CloudWatch struct {
+	client    aws.CloudWatchAPI
+	namespace string
+	buf       *buffer
+}
+
+// NewCloudWatch returns a recorder publishing to namespace (DefaultNamespace when empty).
+func NewCloudWatch(client aws.CloudWatchAPI, namespace string) *CloudWatch {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	return &CloudWatch{client: client, namespace: namespace, buf: newBuffer()}
+}
+
+func (c *CloudWatch) Count(name string, n float64, dims ...Dimension) {
+	c.buf.add(name, n, false, dims)
+}
+
+func (c *CloudWatch) Observe(name string, d time.Duration, dims ...Dimension) {
+	c.buf.add(name, milliseconds(d), true, dims)
+}
+
+// Flush publishes the buffered series. Series in a failed request are kept and retried on the next Flush.
+func (c *CloudWatch) Flush(ctx context.Context) error {
+	aggs, ts := c.buf.take()
+	for start := 0; start < len(aggs); start += maxDatumsPerPut {
+		chunk := aggs[start:min(start+maxDatumsPerPut, len(aggs))]
+		datums := make([]cwtypes.MetricDatum, 0, len(chunk))
+		for _, a := range chunk {
+			datums = append(datums, toDatum(a, ts))
+		}
+		if _, err := c.client.PutMetricData(ctx, &cloudwatch.PutMetricDataInput{
+			Namespace:  &c.namespace,
+			MetricData: datums,
+		}); err != nil {
+			c.buf.putBack(aggs[start:])
+			return fmt.Errorf("put metric data: %w", err)
+		}
+	}
+	return nil
+}
+
+func toDatum(a *aggregate, ts time.Time) cwtypes.MetricDatum {
+	name := a.name
+	d := cwtypes.MetricDatum{MetricName: &name, Timestamp: &ts}
+	for _, dim := range a.dims {
+		n, v := dim.Name, dim.Value
+		d.Dimensions = append(d.Dimensions, cwtypes.Dimension{Name: &n, Value: &v})
+	}
+	if a.latency {
+		count := float64(a.count)
+		d.Unit = cwtypes.StandardUnitMilliseconds
+		d.StatisticValues = &cwtypes.StatisticSet{Minimum: &a.min, Maximum: &a.max, Sum: &a.sum, SampleCount: &count}
+		return d
+	}
+	d.Unit = cwtypes.StandardUnitCount
+	d.Value = &a.sum
+	return d
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxEMFValues is the EMF limit on values in one metric array.
+const maxEMFValues = 100
+
+// EMF buffers samples and writes them as CloudWatch Embedded Metric Format JSON lines, one per
+// dimension set. Written to a Lambda function's stdout, CloudWatch Logs extracts them as metrics.
+type EMF struct {
+	mu        sync.Mutex // serialises writes to w
+	w         io.Writer
+	namespace string
+	buf       *buffer
+}
+
+// NewEMF returns a recorder writing EMF lines to w under namespace (DefaultNamespace when empty).
+func NewEMF(w io.Writer, namespace string) *EMF {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	return &EMF{w: w, namespace: namespace, buf: newBuffer()}
+}
+
+func (e *EMF) Count(name string, n float64, dims ...Dimension) {
+	e.buf.add(name, n, false, dims)
+}
+
+func (e *EMF) Observe(name string, d time.Duration, dims ...Dimension) {
+	e.buf.add(name, milliseconds(d), true, dims)
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+// Flush writes the buffered series, grouping series with the same dimensions into one line.
+func (e *EMF) Flush(ctx context.Context) error {
+	aggs, ts := e.buf.take()
+
+	// group by dimension set; latencies with more than maxEMFValues samples spill into extra lines
+	type line struct {
+		dims    []Dimension
+		metrics []emfMetric
+		values  map[string]interface{}
+	}
+	var lines []*line
+	byDims := map[string]*line{}
+	lineFor := func(a *aggregate, name string) *line {
+		l, ok := byDims[a.dimsKey()]
+		if !ok || l.values[name] != nil {
+			l = &line{dims: a.dims, values: map[string]interface{}{}}
+			lines = append(lines, l)
+			byDims[a.dimsKey()] = l
+		}
+		return l
+	}
+	for _, a := range aggs {
+		if !a.latency {
+			l := lineFor(a, a.name)
+			l.metrics = append(l.metrics, emfMetric{Name: a.name, Unit: "Count"})
+			l.values[a.name] = a.sum
+			continue
+		}
+		for start := 0; start < len(a.values); start += maxEMFValues {
+			l := lineFor(a, a.name)
+			l.metrics = append(l.metrics, emfMetric{Name: a.name, Unit: "Milliseconds"})
+			l.values[a.name] = a.values[start:min(start+maxEMFValues, len(a.values))]
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, l := range lines {
+		doc := map[string]interface{}{}
+		dimNames := make([]string, 0, len(l.dims))
+		for _, d := range l.dims {
+			doc[d.Name] = d.Value
+			dimNames = append(dimNames, d.Name)
+		}
+		for k, v := range l.values {
+			doc[k] = v
+		}
+		doc["_aws"] = map[string]interface{}{
+			"Timestamp": ts.UnixMilli(),
+			"CloudWatchMetrics": []emfDirective{{
+				Namespace:  e.namespace,
+				Dimensions: [][]string{dimNames},
+				Metrics:    l.metrics,
+			}},
+		}
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("encode emf: %w", err)
+		}
+		if _, err := e.w.Write(append(b, '\n')); err != nil {
+			return fmt.Errorf("write emf: %w", err)
+		}
+	}
+	return nil
+}
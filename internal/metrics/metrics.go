@@ -0,0 +1,185 @@
+// Package metrics records counters and latencies for the order flow. Recorders buffer samples in
+// memory and publish them on Flush, either through CloudWatch PutMetricData or as CloudWatch
+// Embedded Metric Format (EMF) log lines, which Lambda turns into metrics without any API calls.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+)
+
+// DefaultNamespace is the CloudWatch namespace used when none is configured.
+const DefaultNamespace = "OrderFlow"
+
+// Metric names.
+const (
+	IdempotentReplay   = "IdempotentReplay"   // duplicate request answered from the idempotency record; dimension Status
+	OrderCreated       = "OrderCreated"       // new order committed by the API
+	OrderCancelled     = "OrderCancelled"     // order cancelled through the API; dimension From
+	EnqueueFailure     = "EnqueueFailure"     // publishing an order message failed; the outbox relay retries it
+	WorkerTransition   = "WorkerTransition"   // status change made by the worker; dimensions From, To
+	ProcessingDuration = "ProcessingDuration" // time the worker spent on one message; dimension Outcome
+	WorkerRetry        = "WorkerRetry"        // message received again after an earlier attempt failed or timed out
+	Compensation       = "Compensation"       // work undone for an order cancelled or failed while processing; dimension Outcome
+	StepDuration       = "StepDuration"       // time one worker pipeline step took; dimensions Step, Outcome
+	RefundRequested    = "RefundRequested"    // refund recorded through the API; dimension To (the order's new status)
+	RefundSettled      = "RefundSettled"      // refund paid out (or given up) by the worker; dimension Outcome
+)
+
+// Dimension names and the values of Outcome.
+const (
+	DimStatus  = "Status"
+	DimFrom    = "From"
+	DimTo      = "To"
+	DimOutcome = "Outcome"
+	DimStep    = "Step"
+
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Dimension is a name/value pair qualifying a metric.
+type Dimension struct {
+	Name  string
+	Value string
+}
+
+// Dim is shorthand for a Dimension.
+func Dim(name, value string) Dimension {
+	return Dimension{Name: name, Value: value}
+}
+
+// Recorder records metrics. Implementations are safe for concurrent use; Count and Observe never
+// block on I/O, and buffered samples are published by Flush.
+type Recorder interface {
+	// Count adds n to a counter.
+	Count(name string, n float64, dims ...Dimension)
+	// Observe records one latency sample.
+	Observe(name string, d time.Duration, dims ...Dimension)
+	// Flush publishes everything buffered so far.
+	Flush(ctx context.Context) error
+}
+
+// Nop discards everything. It is the Recorder used when none is configured.
+type Nop struct{}
+
+func (Nop) Count(string, float64, ...Dimension)         {}
+func (Nop) Observe(string, time.Duration, ...Dimension) {}
+func (Nop) Flush(context.Context) error                 { return nil }
+
+// OrNop returns r, or Nop when r is nil.
+func OrNop(r Recorder) Recorder {
+	if r == nil {
+		return Nop{}
+	}
+	return r
+}
+
+// FlushEvery flushes r every interval until ctx is cancelled, then flushes once more.
+// Used by long-running processes; Lambda handlers flush at the end of each invocation instead.
+func FlushEvery(ctx context.Context, r Recorder, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := r.Flush(context.WithoutCancel(ctx)); err != nil && onError != nil {
+				onError(err)
+			}
+			return
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// series identifies one metric stream: a name plus its dimensions in a canonical order.
+type series struct {
+	name string
+	dims []Dimension // sorted by name
+}
+
+func newSeries(name string, dims []Dimension) series {
+	sorted := append([]Dimension(nil), dims...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return series{name: name, dims: sorted}
+}
+
+func (s series) key() string {
+	var b strings.Builder
+	b.WriteString(s.name)
+	for _, d := range s.dims {
+		b.WriteString("|")
+		b.WriteString(d.Name)
+		b.WriteString("=")
+		b.WriteString(d.Value)
+	}
+	return b.String()
+}
+
+func (s series) dimsKey() string {
+	names := make([]string, len(s.dims))
+	for i, d := range s.dims {
+		names[i] = d.Name + "=" + d.Value
+	}
+	return strings.Join(names, ",")
+}
+
+// aggregate is the buffered state of one series between flushes.
+type aggregate struct {
+	series
+	latency bool      // Observe samples in milliseconds; otherwise a counter
+	sum     float64   // counter total or sum of samples
+	count   int       // number of samples
+	min     float64   // latency only
+	max     float64   // latency only
+	values  []float64 // latency samples, for EMF
+}
+
+func (a *aggregate) add(v float64) {
+	if a.count == 0 || v < a.min {
+		a.min = v
+	}
+	if a.count == 0 || v > a.max {
+		a.max = v
+	}
+	a.sum += v
+	a.count++
+	if a.latency {
+		a.values = append(a.values, v)
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Sinks selectable with NewRecorder.
+const (
+	SinkNone       = "none"
+	SinkEMF        = "emf"
+	SinkCloudWatch = "cloudwatch"
+)
+
+// NewRecorder builds the recorder for sink: EMF lines written to w, PutMetricData through client,
+// or Nop for SinkNone and "".
+func NewRecorder(sink, namespace string, client aws.CloudWatchAPI, w io.Writer) (Recorder, error) {
+	switch sink {
+	case SinkNone, "":
+		return Nop{}, nil
+	case SinkEMF:
+		return NewEMF(w, namespace), nil
+	case SinkCloudWatch:
+		return NewCloudWatch(client, namespace), nil
+	default:
+		return nil, fmt.Errorf("unknown metrics sink %q", sink)
+	}
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+type mockCloudWatch struct {
+	calls    []*cloudwatch.PutMetricDataInput
+	failNext bool
+}
+
+func (m *mockCloudWatch) PutMetricData(ctx context.Context, in *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	if m.failNext {
+		m.failNext = false
+		return nil, errors.New("throttled")
+	}
+	m.calls = append(m.calls, in)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func TestCloudWatch_AggregatesPerSeriesAndRetriesFailedFlush(t *testing.T) {
+	cw := &mockCloudWatch{failNext: true}
+	r := NewCloudWatch(cw, "")
+
+	r.Count(IdempotentReplay, 1, Dim(DimStatus, "DONE"))
+	r.Count(IdempotentReplay, 1, Dim(DimStatus, "DONE"))
+	r.Count(IdempotentReplay, 1, Dim(DimStatus, "IN_PROGRESS"))
+	r.Observe(ProcessingDuration, 100*time.Millisecond, Dim(DimOutcome, OutcomeSuccess))
+	r.Observe(ProcessingDuration, 300*time.Millisecond, Dim(DimOutcome, OutcomeSuccess))
+
+	if err := r.Flush(context.Background()); err == nil {
+		t.Fatalf("expected the throttled flush to fail")
+	}
+	// the failed series are kept and sent by the next flush
+	if err := r.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if len(cw.calls) != 1 || *cw.calls[0].Namespace != DefaultNamespace {
+		t.Fatalf("expected one PutMetricData call in %s, got %+v", DefaultNamespace, cw.calls)
+	}
+
+	byKey := map[string]cwtypes.MetricDatum{}
+	for _, d := range cw.calls[0].MetricData {
+		byKey[*d.MetricName+"/"+*d.Dimensions[0].Value] = d
+	}
+	if len(byKey) != 3 {
+		t.Fatalf("expected 3 series, got %d", len(byKey))
+	}
+	if d := byKey[IdempotentReplay+"/DONE"]; *d.Value != 2 || d.Unit != cwtypes.StandardUnitCount {
+		t.Fatalf("DONE replays = %v %s, want 2 Count", *d.Value, d.Unit)
+	}
+	st := byKey[ProcessingDuration+"/"+OutcomeSuccess].StatisticValues
+	if *st.SampleCount != 2 || *st.Sum != 400 || *st.Minimum != 100 || *st.Maximum != 300 {
+		t.Fatalf("unexpected statistic set %+v", st)
+	}
+
+	// nothing buffered: no call
+	if err := r.Flush(context.Background()); err != nil || len(cw.calls) != 1 {
+		t.Fatalf("empty flush must not call PutMetricData")
+	}
+}
+
+func TestEMF_WritesOneLinePerDimensionSet(t *testing.T) {
+	var out bytes.Buffer
+	r := NewEMF(&out, "Test")
+	r.buf.nowFunc = func() time.Time { return time.UnixMilli(1700000000000) }
+
+	r.Count(WorkerTransition, 1, Dim(DimTo, "COMPLETED"), Dim(DimFrom, "PROCESSING"))
+	r.Count(WorkerTransition, 1, Dim(DimFrom, "PROCESSING"), Dim(DimTo, "COMPLETED"))
+	r.Count(OrderCreated, 1)
+	r.Observe(ProcessingDuration, 250*time.Millisecond)
+
+	if err := r.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 EMF lines (with and without dimensions), got %d:\n%s", len(lines), out.String())
+	}
+
+	var doc struct {
+		AWS struct {
+			Timestamp         int64 `json:"Timestamp"`
+			CloudWatchMetrics []struct {
+				Namespace  string     `json:"Namespace"`
+				Dimensions [][]string `json:"Dimensions"`
+				Metrics    []struct{ Name, Unit string }
+			} `json:"CloudWatchMetrics"`
+		} `json:"_aws"`
+		From             string  `json:"From"`
+		To               string  `json:"To"`
+		WorkerTransition float64 `json:"WorkerTransition"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	d := doc.AWS.CloudWatchMetrics[0]
+	if doc.AWS.Timestamp != 1700000000000 || d.Namespace != "Test" || strings.Join(d.Dimensions[0], ",") != "From,To" {
+		t.Fatalf("unexpected directive %+v", doc.AWS)
+	}
+	if doc.WorkerTransition != 2 || doc.From != "PROCESSING" || doc.To != "COMPLETED" {
+		t.Fatalf("unexpected values %+v", doc)
+	}
+	if !strings.Contains(lines[1], `"ProcessingDuration":[250]`) || !strings.Contains(lines[1], `"OrderCreated":1`) {
+		t.Fatalf("unexpected dimensionless line %s", lines[1])
+	}
+}
@@ -0,0 +1,178 @@
+// Package money provides an exact monetary value type: an integer amount in the currency's
+// minor units (cents, fils, ...) plus its ISO 4217 currency code.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUnknownCurrency indicates a currency code that is not a supported ISO 4217 code.
+	ErrUnknownCurrency = errors.New("unknown currency")
+	// ErrCurrencyMismatch indicates arithmetic between two different currencies.
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	// ErrOverflow indicates the result does not fit in int64 minor units.
+	ErrOverflow = errors.New("amount overflows int64 minor units")
+	// ErrInvalidAmount indicates a decimal amount that cannot be parsed for its currency.
+	ErrInvalidAmount = errors.New("invalid amount")
+)
+
+// exponents maps supported ISO 4217 codes to the number of digits after the decimal separator.
+var exponents = map[string]int{
+	"AED": 2, "AUD": 2, "BRL": 2, "CAD": 2, "CHF": 2, "CNY": 2, "CZK": 2, "DKK": 2,
+	"EUR": 2, "GBP": 2, "HKD": 2, "INR": 2, "MXN": 2, "NOK": 2, "NZD": 2, "PLN": 2,
+	"SAR": 2, "SEK": 2, "SGD": 2, "USD": 2, "ZAR": 2,
+	"CLP": 0, "ISK": 0, "JPY": 0, "KRW": 0, "UGX": 0, "VND": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// Money is an amount in minor units of Currency, e.g. {2550, "USD"} is 25.50 USD and {2550, "JPY"} is 2550 JPY.
+// It marshals to JSON and DynamoDB as {"amount": <minor units>, "currency": "<code>"}.
+type Money struct {
+	Amount   int64  `dynamodbav:"amount" json:"amount"`     // minor units
+	Currency string `dynamodbav:"currency" json:"currency"` // ISO 4217 code, upper case
+}
+
+// Exponent returns the number of minor-unit digits for currency and whether the currency is supported.
+func Exponent(currency string) (int, bool) {
+	e, ok := exponents[currency]
+	return e, ok
+}
+
+// New returns amount minor units of currency. The currency code is normalised to upper case.
+func New(amount int64, currency string) (Money, error) {
+	code := strings.ToUpper(strings.TrimSpace(currency))
+	if _, ok := exponents[code]; !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+	}
+	return Money{Amount: amount, Currency: code}, nil
+}
+
+// Parse converts a decimal string in major units (e.g. "25.50") into Money.
+// It rejects more fractional digits than the currency allows, so "1.5" JPY or "1.2345" KWD fail.
+func Parse(decimal, currency string) (Money, error) {
+	m, err := New(0, currency)
+	if err != nil {
+		return Money{}, err
+	}
+	exp := exponents[m.Currency]
+
+	s := strings.TrimSpace(decimal)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if whole == "" || (hasFrac && frac == "") || len(frac) > exp || !digits(whole) || !digits(frac) {
+		return Money{}, fmt.Errorf("%w: %q for %s", ErrInvalidAmount, decimal, m.Currency)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	n, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q for %s", ErrOverflow, decimal, m.Currency)
+	}
+	if neg {
+		n = -n
+	}
+	m.Amount = n
+	return m, nil
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate reports whether m carries a supported, normalised currency code.
+func (m Money) Validate() error {
+	if _, ok := exponents[m.Currency]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownCurrency, m.Currency)
+	}
+	return nil
+}
+
+// IsZero reports whether m is the zero value.
+func (m Money) IsZero() bool {
+	return m == Money{}
+}
+
+// Add returns m + o. Both must be in the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s + %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	sum := m.Amount + o.Amount
+	if (o.Amount > 0 && sum < m.Amount) || (o.Amount < 0 && sum > m.Amount) {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+// Mul returns m * n, e.g. a unit price times a quantity.
+func (m Money) Mul(n int64) (Money, error) {
+	if m.Amount == 0 || n == 0 {
+		return Money{Currency: m.Currency}, nil
+	}
+	p := m.Amount * n
+	if p/n != m.Amount || (m.Amount == -1 && n == math.MinInt64) || (n == -1 && m.Amount == math.MinInt64) {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: p, Currency: m.Currency}, nil
+}
+
+// Decimal formats the amount in major units with the currency's exponent, e.g. "25.50", "2550" or "1.250".
+func (m Money) Decimal() string {
+	exp := exponents[m.Currency]
+	if exp == 0 {
+		return strconv.FormatInt(m.Amount, 10)
+	}
+	sign := ""
+	u := uint64(m.Amount)
+	if m.Amount < 0 {
+		sign = "-"
+		u = uint64(-(m.Amount + 1)) + 1 // safe for math.MinInt64
+	}
+	s := strconv.FormatUint(u, 10)
+	if len(s) <= exp {
+		s = strings.Repeat("0", exp-len(s)+1) + s
+	}
+	return sign + s[:len(s)-exp] + "." + s[len(s)-exp:]
+}
+
+// String formats m as "<decimal> <currency>", e.g. "25.50 USD".
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// UnmarshalJSON decodes {"amount": <minor units>, "currency": "<code>"}, rejecting fractional amounts
+// and unsupported currencies. The currency code is normalised to upper case.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Amount   json.Number `json:"amount"`
+		Currency string      `json:"currency"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("money: %w", err)
+	}
+	if raw.Amount == "" {
+		return fmt.Errorf("money: %w: amount is required", ErrInvalidAmount)
+	}
+	n, err := strconv.ParseInt(string(raw.Amount), 10, 64)
+	if err != nil {
+		return fmt.Errorf("money: %w: amount must be an integer number of minor units, got %s", ErrInvalidAmount, raw.Amount)
+	}
+	v, err := New(n, raw.Currency)
+	if err != nil {
+		return fmt.Errorf("money: %w", err)
+	}
+	*m = v
+	return nil
+}
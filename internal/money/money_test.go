@@ -0,0 +1,71 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseAndDecimal_PerCurrencyExponent(t *testing.T) {
+	cases := []struct {
+		in, currency string
+		minor        int64
+		out          string
+	}{
+		{"25.50", "USD", 2550, "25.50"},
+		{"25.5", "usd", 2550, "25.50"},
+		{"0.07", "EUR", 7, "0.07"},
+		{"-1.05", "GBP", -105, "-1.05"},
+		{"2550", "JPY", 2550, "2550"},
+		{"1.250", "KWD", 1250, "1.250"},
+		{"0.005", "KWD", 5, "0.005"},
+	}
+	for _, tc := range cases {
+		m, err := Parse(tc.in, tc.currency)
+		if err != nil {
+			t.Fatalf("Parse(%q, %s): %v", tc.in, tc.currency, err)
+		}
+		if m.Amount != tc.minor || m.Decimal() != tc.out {
+			t.Fatalf("Parse(%q, %s) = %d (%s), want %d (%s)", tc.in, tc.currency, m.Amount, m.Decimal(), tc.minor, tc.out)
+		}
+	}
+
+	for _, bad := range [][2]string{{"1.5", "JPY"}, {"1.2345", "KWD"}, {"1.", "USD"}, {"abc", "USD"}, {"1", "XYZ"}} {
+		if _, err := Parse(bad[0], bad[1]); err == nil {
+			t.Fatalf("expected Parse(%q, %s) to fail", bad[0], bad[1])
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	price := Money{Amount: 1250, Currency: "KWD"}
+	line, err := price.Mul(3)
+	if err != nil || line.Amount != 3750 {
+		t.Fatalf("Mul = %v, %v", line, err)
+	}
+	sum, err := line.Add(Money{Amount: 1, Currency: "KWD"})
+	if err != nil || sum.String() != "3.751 KWD" {
+		t.Fatalf("Add = %v, %v", sum, err)
+	}
+	if _, err := sum.Add(Money{Amount: 1, Currency: "USD"}); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Fatalf("expected ErrCurrencyMismatch, got %v", err)
+	}
+	if _, err := (Money{Amount: math.MaxInt64, Currency: "USD"}).Add(Money{Amount: 1, Currency: "USD"}); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expected ErrOverflow on Add, got %v", err)
+	}
+	if _, err := (Money{Amount: math.MaxInt64 / 2, Currency: "USD"}).Mul(3); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expected ErrOverflow on Mul, got %v", err)
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	var m Money
+	if err := m.UnmarshalJSON([]byte(`{"amount":2550,"currency":"usd"}`)); err != nil || m != (Money{2550, "USD"}) {
+		t.Fatalf("got %+v, %v", m, err)
+	}
+	for _, bad := range []string{`{"amount":25.5,"currency":"USD"}`, `{"amount":1,"currency":"ABC"}`, `{"currency":"USD"}`, `12`} {
+		if err := m.UnmarshalJSON([]byte(bad)); err == nil {
+			t.Fatalf("expected error for %s", bad)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package orders
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrInvalidCursor indicates a pagination cursor that was not produced by this store for the customer
+// it is used with.
+var ErrInvalidCursor = errors.New("invalid pagination cursor")
+
+// encodeCursor turns a DynamoDB LastEvaluatedKey into an opaque, URL-safe token.
+// All key attributes of the orders table and its customer index are strings.
+func encodeCursor(lek map[string]types.AttributeValue) (string, error) {
+	if len(lek) == 0 {
+		return "", nil
+	}
+	plain := make(map[string]string, len(lek))
+	for k, v := range lek {
+		s, ok := v.(*types.AttributeValueMemberS)
+		if !ok {
+			return "", fmt.Errorf("unsupported key attribute %q", k)
+		}
+		plain[k] = s.Value
+	}
+	b, err := json.Marshal(plain)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeCursor is the inverse of encodeCursor for a cursor issued while listing customerID's orders. An
+// empty cursor yields a nil start key.
+func decodeCursor(cursor, customerID string) (map[string]types.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var plain map[string]string
+	if err := json.Unmarshal(b, &plain); err != nil || len(plain) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	for _, attr := range []string{"order_id", "customer_id", "created_at"} {
+		if _, ok := plain[attr]; !ok {
+			return nil, ErrInvalidCursor
+		}
+	}
+	// DynamoDB rejects a start key outside the queried partition
+	if plain["customer_id"] != customerID {
+		return nil, ErrInvalidCursor
+	}
+	key := make(map[string]types.AttributeValue, len(plain))
+	for k, v := range plain {
+		key[k] = &types.AttributeValueMemberS{Value: v}
+	}
+	return key, nil
+}
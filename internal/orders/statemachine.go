@@ -0,0 +1,65 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrIllegalTransition indicates the requested status change is not allowed by the state machine.
+var ErrIllegalTransition = errors.New("illegal order status transition")
+
+// StateMachine maps each order status to the statuses it may move to.
+// Statuses with no outgoing transitions are terminal.
+type StateMachine map[string][]string
+
+// Lifecycle is the order state machine enforced by Store.UpdateStatus.
+//
+//	PENDING    -> PROCESSING | ON_HOLD | CANCELLED | FAILED
+//	PROCESSING -> COMPLETED | FAILED | ON_HOLD | CANCELLED
+//	ON_HOLD    -> PENDING | PROCESSING | CANCELLED
+//	COMPLETED  -> PARTIALLY_REFUNDED | REFUNDED
+//	PARTIALLY_REFUNDED -> REFUNDED
+//	FAILED, CANCELLED, REFUNDED are terminal
+//
+// The one way back is a failed refund: Store.SettleRefund moves a REFUNDED or PARTIALLY_REFUNDED order to
+// the status matching what is still refunded.
+var Lifecycle = StateMachine{
+	StatusPending:    {StatusProcessing, StatusOnHold, StatusCancelled, StatusFailed},
+	StatusProcessing: {StatusCompleted, StatusFailed, StatusOnHold, StatusCancelled},
+	StatusOnHold:     {StatusPending, StatusProcessing, StatusCancelled},
+	StatusCompleted:  {StatusPartiallyRefunded, StatusRefunded},
+	StatusFailed:     nil,
+	StatusCancelled:  nil,
+	StatusRefunded:   nil,
+
+	StatusPartiallyRefunded: {StatusRefunded},
+}
+
+// IsValid reports whether status is a known state.
+func (m StateMachine) IsValid(status string) bool {
+	_, ok := m[status]
+	return ok
+}
+
+// IsTerminal reports whether no transition leaves status.
+func (m StateMachine) IsTerminal(status string) bool {
+	return m.IsValid(status) && len(m[status]) == 0
+}
+
+// CanTransition reports whether from -> to is a legal move.
+func (m StateMachine) CanTransition(from, to string) bool {
+	for _, next := range m[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns an error wrapping ErrIllegalTransition if from -> to is not a legal move.
+func (m StateMachine) Validate(from, to string) error {
+	if !m.CanTransition(from, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, to)
+	}
+	return nil
+}
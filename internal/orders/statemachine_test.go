@@ -0,0 +1,30 @@
+package orders
+
+import "testing"
+
+func TestLifecycle_TransitionsTargetKnownStates(t *testing.T) {
+	for from, targets := range Lifecycle {
+		for _, to := range targets {
+			if !Lifecycle.IsValid(to) {
+				t.Fatalf("%s -> %s targets an unknown state", from, to)
+			}
+			if from == to {
+				t.Fatalf("%s has a self transition", from)
+			}
+		}
+	}
+	for _, st := range []string{StatusFailed, StatusCancelled, StatusRefunded} {
+		if !Lifecycle.IsTerminal(st) {
+			t.Fatalf("expected %s to be terminal", st)
+		}
+	}
+	if Lifecycle.CanTransition(StatusCompleted, StatusCancelled) {
+		t.Fatalf("completed orders must be refunded, not cancelled")
+	}
+	if !Lifecycle.CanTransition(StatusPartiallyRefunded, StatusRefunded) || Lifecycle.CanTransition(StatusPartiallyRefunded, StatusCancelled) {
+		t.Fatalf("partially refunded orders may only be refunded further")
+	}
+	if Lifecycle.IsValid("SHIPPED") {
+		t.Fatalf("unexpected state SHIPPED")
+	}
+}
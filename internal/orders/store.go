@@ -4,19 +4,31 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	dyn "github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
 )
 
 // Store encapsulates operations on the orders table.
 type Store struct {
-	client    aws.DynamoDBAPI
-	tableName string
-	nowFunc   func() time.Time
+	client      aws.DynamoDBAPI
+	tableName   string
+	nowFunc     func() time.Time
+	transitions TransitionRecorder
+}
+
+// TransitionRecorder returns an item to write in the same transaction as an order's status change, so
+// the item exists if and only if the change committed. webhooks.Store implements it to emit an event
+// per transition.
+type TransitionRecorder interface {
+	RecordTransition(orderID string, change StatusChange) (types.TransactWriteItem, error)
 }
 
 // NewStore creates a new orders Store.
@@ -28,6 +40,12 @@ func NewStore(client aws.DynamoDBAPI, tableName string) *Store {
 	}
 }
 
+// SetTransitionRecorder makes every status change s writes, including the creation of an order, also
+// write r's item in the same transaction.
+func (s *Store) SetTransitionRecorder(r TransitionRecorder) {
+	s.transitions = r
+}
+
 // CreateWithIdempotencyTransaction atomically creates:
 //   - idempotency record in idempotencyTable (with ConditionExpression attribute_not_exists(idempotency_key))
 //   - order record in orders table
@@ -35,7 +53,10 @@ func NewStore(client aws.DynamoDBAPI, tableName string) *Store {
 // It marshals both items and issues a TransactWriteItems call.
 // idempotencyItem must be a serializable struct with attribute idempotency_key present.
 // order is the Order struct to persist; order.OrderID must be set by caller.
-func (s *Store) CreateWithIdempotencyTransaction(ctx context.Context, dynamo aws.DynamoDBAPI, idempotencyTable string, idempotencyItem interface{}, order Order, ttlWindow time.Duration) error {
+// extra items (e.g., an outbox entry) are committed in the same transaction.
+func (s *Store) CreateWithIdempotencyTransaction(ctx context.Context, dynamo aws.DynamoDBAPI, idempotencyTable string, idempotencyItem interface{}, order Order, ttlWindow time.Duration, extra ...types.TransactWriteItem) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "orders.CreateWithIdempotencyTransaction", s.tableName, idempotencyTable)
+	defer tracing.End(span, &err)
 	// marshal idempotency item
 	idempMap, err := attributevalue.MarshalMap(idempotencyItem)
 	if err != nil {
@@ -77,6 +98,18 @@ func (s *Store) CreateWithIdempotencyTransaction(ctx context.Context, dynamo aws
 			},
 		},
 	}
+	transactItems = append(transactItems, extra...)
+	if s.transitions != nil {
+		created := StatusChange{To: order.Status, At: order.CreatedAt}
+		if n := len(order.StatusHistory); n > 0 {
+			created = order.StatusHistory[n-1]
+		}
+		record, err := s.transitions.RecordTransition(order.OrderID, created)
+		if err != nil {
+			return fmt.Errorf("record transition: %w", err)
+		}
+		transactItems = append(transactItems, record)
+	}
 
 	input := &dyn.TransactWriteItemsInput{
 		TransactItems: transactItems,
@@ -95,7 +128,9 @@ func (s *Store) CreateWithIdempotencyTransaction(ctx context.Context, dynamo aws
 }
 
 // Get fetches an order by order_id. Returns (nil, nil) if not found.
-func (s *Store) Get(ctx context.Context, orderID string) (*Order, error) {
+func (s *Store) Get(ctx context.Context, orderID string) (_ *Order, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "orders.Get", s.tableName)
+	defer tracing.End(span, &err)
 	key := map[string]types.AttributeValue{
 		"order_id": &types.AttributeValueMemberS{Value: orderID},
 	}
@@ -116,29 +151,121 @@ func (s *Store) Get(ctx context.Context, orderID string) (*Order, error) {
 	return &o, nil
 }
 
-// UpdateStatus conditionally updates the order status from expected -> newStatus.
-// Returns nil on success, ErrStatusMismatch if condition failed.
+// DefaultListLimit is the page size used by ListByCustomer when none is given.
+const DefaultListLimit = 20
+
+// ListByCustomer returns a page of a customer's orders, newest first, using the customer_id_index GSI.
+// Date bounds become part of the key condition; status filters are applied server side as a
+// FilterExpression, so a page may hold fewer than Limit orders while NextCursor is still set.
+// Returns ErrInvalidCursor if opts.Cursor cannot be decoded or was issued for another customer.
+func (s *Store) ListByCustomer(ctx context.Context, customerID string, opts ListOptions) (_ *ListResult, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "orders.ListByCustomer", s.tableName)
+	defer tracing.End(span, &err, ErrInvalidCursor)
+	startKey, err := decodeCursor(opts.Cursor, customerID)
+	if err != nil {
+		return nil, err
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	keyCond := "customer_id = :cid"
+	values := map[string]types.AttributeValue{
+		":cid": &types.AttributeValueMemberS{Value: customerID},
+	}
+	// created_at is stored in the fixed-width CreatedAtLayout, so bounds in the same layout compare lexically
+	// in chronological order
+	switch {
+	case !opts.CreatedFrom.IsZero() && !opts.CreatedTo.IsZero():
+		keyCond += " AND created_at BETWEEN :from AND :to"
+	case !opts.CreatedFrom.IsZero():
+		keyCond += " AND created_at >= :from"
+	case !opts.CreatedTo.IsZero():
+		keyCond += " AND created_at <= :to"
+	}
+	if !opts.CreatedFrom.IsZero() {
+		values[":from"] = &types.AttributeValueMemberS{Value: formatCreatedAt(opts.CreatedFrom)}
+	}
+	if !opts.CreatedTo.IsZero() {
+		values[":to"] = &types.AttributeValueMemberS{Value: formatCreatedAt(opts.CreatedTo)}
+	}
+
+	input := &dyn.QueryInput{
+		TableName:                 &s.tableName,
+		IndexName:                 awsString(CustomerIndex),
+		KeyConditionExpression:    &keyCond,
+		ExpressionAttributeValues: values,
+		ExclusiveStartKey:         startKey,
+		Limit:                     &limit,
+		ScanIndexForward:          awsBool(false),
+	}
+	if len(opts.Statuses) > 0 {
+		placeholders := make([]string, 0, len(opts.Statuses))
+		for i, st := range opts.Statuses {
+			ph := fmt.Sprintf(":s%d", i)
+			placeholders = append(placeholders, ph)
+			values[ph] = &types.AttributeValueMemberS{Value: st}
+		}
+		input.FilterExpression = awsString("#s IN (" + strings.Join(placeholders, ", ") + ")")
+		input.ExpressionAttributeNames = map[string]string{"#s": "status"}
+	}
+
+	out, err := s.client.Query(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("query orders by customer: %w", err)
+	}
+
+	res := &ListResult{Orders: []Order{}}
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &res.Orders); err != nil {
+		return nil, fmt.Errorf("unmarshal orders: %w", err)
+	}
+	if res.NextCursor, err = encodeCursor(out.LastEvaluatedKey); err != nil {
+		return nil, fmt.Errorf("encode cursor: %w", err)
+	}
+	return res, nil
+}
+
+// ErrStatusMismatch is returned by UpdateStatus when the order is not in the expected status (conditional failed).
 var ErrStatusMismatch = errors.New("status mismatch/conditional failed")
 
-func (s *Store) UpdateStatus(ctx context.Context, orderID, expectedStatus, newStatus string) error {
+// UpdateStatus conditionally moves an order from change.From to change.To and appends change to its
+// status_history. The move must be allowed by Lifecycle, otherwise ErrIllegalTransition is returned
+// without touching the table. Returns ErrStatusMismatch if the order is not currently in change.From.
+// change.At defaults to now.
+func (s *Store) UpdateStatus(ctx context.Context, orderID string, change StatusChange) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "orders.UpdateStatus", s.tableName)
+	defer tracing.End(span, &err, ErrStatusMismatch)
+	if err := Lifecycle.Validate(change.From, change.To); err != nil {
+		return err
+	}
 	now := s.nowFunc()
-	// Update expression: SET #s = :new, updated_at = :ua, attempts = if_not_exists(attempts, :zero) + :inc
-	updateExpr := "SET #s = :new, updated_at = :ua"
+	if change.At.IsZero() {
+		change.At = now
+	}
+	entry, err := attributevalue.MarshalList([]StatusChange{change})
+	if err != nil {
+		return fmt.Errorf("marshal status change: %w", err)
+	}
 	// we will not change attempts here; caller can call IncrementAttempts
 	input := &dyn.UpdateItemInput{
 		TableName: &s.tableName,
 		Key: map[string]types.AttributeValue{
 			"order_id": &types.AttributeValueMemberS{Value: orderID},
 		},
-		UpdateExpression:          &updateExpr,
-		ExpressionAttributeNames:  map[string]string{"#s": "status"},
-		ExpressionAttributeValues: map[string]types.AttributeValue{":new": &types.AttributeValueMemberS{Value: newStatus}, ":ua": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)}},
-		ConditionExpression:       awsString("#s = :expected"),
+		UpdateExpression:         awsString("SET #s = :new, updated_at = :ua, status_history = list_append(if_not_exists(status_history, :empty), :change)"),
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":new":      &types.AttributeValueMemberS{Value: change.To},
+			":expected": &types.AttributeValueMemberS{Value: change.From},
+			":ua":       &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+			":empty":    &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+			":change":   &types.AttributeValueMemberL{Value: entry},
+		},
+		ConditionExpression: awsString("#s = :expected"),
 	}
-	// add expected value
-	input.ExpressionAttributeValues[":expected"] = &types.AttributeValueMemberS{Value: expectedStatus}
 
-	_, err := s.client.UpdateItem(ctx, input)
+	err = s.update(ctx, input, orderID, &change)
 	if err != nil {
 		// detect conditional check failing
 		var sc *types.ConditionalCheckFailedException
@@ -150,8 +277,538 @@ func (s *Store) UpdateStatus(ctx context.Context, orderID, expectedStatus, newSt
 	return nil
 }
 
+// ErrNotFound is returned by Cancel when the order does not exist.
+var ErrNotFound = errors.New("order not found")
+
+// ErrNotCancellable is returned by Cancel when the order's status has no transition to CANCELLED.
+var ErrNotCancellable = errors.New("order cannot be cancelled")
+
+// ErrAlreadyCancelled is returned by Cancel, with the order, when the order is already CANCELLED.
+var ErrAlreadyCancelled = errors.New("order already cancelled")
+
+// cancelAttempts bounds how often Cancel re-reads an order whose status changed under it.
+const cancelAttempts = 3
+
+// Cancel moves the order to CANCELLED from whatever status it is in, if Lifecycle allows it
+// (PENDING, PROCESSING or ON_HOLD), recording change's actor and reason. The update is conditional on
+// the status just read, so a worker moving the order at the same time either wins (and the cancel is
+// retried from the new status) or sees ErrStatusMismatch.
+// Returns the cancelled order; the unchanged order with ErrAlreadyCancelled or ErrNotCancellable; or ErrNotFound.
+func (s *Store) Cancel(ctx context.Context, orderID string, change StatusChange) (*Order, error) {
+	change.To = StatusCancelled
+	for i := 0; i < cancelAttempts; i++ {
+		order, err := s.Get(ctx, orderID)
+		if err != nil {
+			return nil, err
+		}
+		if order == nil {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, orderID)
+		}
+		if order.Status == StatusCancelled {
+			return order, ErrAlreadyCancelled
+		}
+		if !Lifecycle.CanTransition(order.Status, StatusCancelled) {
+			return order, fmt.Errorf("%w: order is %s", ErrNotCancellable, order.Status)
+		}
+		change.From = order.Status
+		err = s.UpdateStatus(ctx, orderID, change)
+		if errors.Is(err, ErrStatusMismatch) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s.Get(ctx, orderID)
+	}
+	return nil, fmt.Errorf("cancel order %s: %w", orderID, ErrStatusMismatch)
+}
+
+// ErrNotRefundable is returned by Refund when the order is neither COMPLETED nor PARTIALLY_REFUNDED.
+var ErrNotRefundable = errors.New("order cannot be refunded")
+
+// ErrRefundExceedsCapture is returned by Refund when the order's refunds would add up to more than the
+// amount captured for it.
+var ErrRefundExceedsCapture = errors.New("refund exceeds the captured amount")
+
+// ErrInvalidRefund is returned by Refund for line items the order cannot give back: an unknown SKU,
+// more units than are left unrefunded, or an item stored without a price.
+var ErrInvalidRefund = errors.New("invalid refund")
+
+// RefundRequest describes a refund for Store.Refund. Without Items, everything captured and not yet
+// refunded is given back.
+type RefundRequest struct {
+	RefundID       string
+	IdempotencyKey string // a refund already recorded under this key is returned instead of a new one
+	Items          []RefundItem
+	Reason         string
+	Actor          string // recorded in the status history when the status changes
+}
+
+// refundAttempts bounds how often Refund re-reads an order that changed under it.
+const refundAttempts = 3
+
+// Refund records a PENDING refund on a COMPLETED or PARTIALLY_REFUNDED order and moves the order to
+// PARTIALLY_REFUNDED, or to REFUNDED once its refunds add up to the captured amount. Line items are
+// refunded at their unit price. The update is conditional on the status and refunded_total just read, so
+// concurrent refunds are applied one at a time, each checked against what the others left; a loser
+// re-reads the order and checks again.
+// Returns the updated order and the refund; the unchanged order with ErrNotRefundable,
+// ErrRefundExceedsCapture or ErrInvalidRefund; or ErrNotFound.
+func (s *Store) Refund(ctx context.Context, orderID string, req RefundRequest) (*Order, *Refund, error) {
+	for i := 0; i < refundAttempts; i++ {
+		order, err := s.Get(ctx, orderID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if order == nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrNotFound, orderID)
+		}
+		for j := range order.Refunds {
+			if req.IdempotencyKey != "" && order.Refunds[j].IdempotencyKey == req.IdempotencyKey {
+				return order, &order.Refunds[j], nil
+			}
+		}
+		if order.Status != StatusCompleted && order.Status != StatusPartiallyRefunded {
+			return order, nil, fmt.Errorf("%w: order is %s", ErrNotRefundable, order.Status)
+		}
+		refund, err := newRefund(order, req, s.nowFunc())
+		if err != nil {
+			return order, nil, err
+		}
+		err = s.addRefund(ctx, order, refund, req.Actor)
+		if errors.Is(err, ErrStatusMismatch) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if order, err = s.Get(ctx, orderID); err != nil {
+			return nil, nil, err
+		}
+		return order, &order.Refunds[order.FindRefund(refund.RefundID)], nil
+	}
+	return nil, nil, fmt.Errorf("refund order %s: %w", orderID, ErrStatusMismatch)
+}
+
+// newRefund prices req against order and checks it fits in what the order has left to refund.
+func newRefund(order *Order, req RefundRequest, now time.Time) (Refund, error) {
+	captured := order.Captured()
+	left := captured.Amount - order.RefundedTotal
+	amount := money.Money{Amount: left, Currency: captured.Currency}
+	if len(req.Items) > 0 {
+		var err error
+		if amount, err = order.priceRefund(req.Items); err != nil {
+			return Refund{}, err
+		}
+		if amount.Currency != captured.Currency {
+			return Refund{}, fmt.Errorf("%w: items are priced in %s, the order was charged in %s", ErrInvalidRefund, amount.Currency, captured.Currency)
+		}
+	}
+	if left <= 0 || amount.Amount > left {
+		return Refund{}, fmt.Errorf("%w: %s requested, %s left of %s captured", ErrRefundExceedsCapture,
+			amount, money.Money{Amount: left, Currency: captured.Currency}, captured)
+	}
+	return Refund{
+		RefundID:       req.RefundID,
+		IdempotencyKey: req.IdempotencyKey,
+		Status:         RefundPending,
+		Amount:         amount,
+		Items:          req.Items,
+		Reason:         req.Reason,
+		CreatedAt:      now,
+	}, nil
+}
+
+// priceRefund returns the value of items at the order's unit prices. Earlier refunds of a line count
+// against its quantity, unless they failed.
+func (o *Order) priceRefund(items []RefundItem) (money.Money, error) {
+	lines, err := o.LineItems()
+	if err != nil {
+		return money.Money{}, fmt.Errorf("%w: %v", ErrInvalidRefund, err)
+	}
+	available := map[string]int{}
+	prices := map[string]money.Money{}
+	for _, li := range lines {
+		available[li.SKU] += li.Quantity
+		prices[li.SKU] = li.Price
+	}
+	for _, r := range o.Refunds {
+		if r.Status == RefundFailed {
+			continue
+		}
+		for _, it := range r.Items {
+			available[it.SKU] -= it.Quantity
+		}
+	}
+
+	var total money.Money
+	for i, it := range items {
+		price, ok := prices[it.SKU]
+		switch {
+		case !ok:
+			return money.Money{}, fmt.Errorf("%w: sku %s is not in the order", ErrInvalidRefund, it.SKU)
+		case price.IsZero():
+			return money.Money{}, fmt.Errorf("%w: sku %s has no price", ErrInvalidRefund, it.SKU)
+		case it.Quantity < 1 || it.Quantity > available[it.SKU]:
+			return money.Money{}, fmt.Errorf("%w: %d of sku %s requested, %d left to refund", ErrInvalidRefund, it.Quantity, it.SKU, available[it.SKU])
+		}
+		available[it.SKU] -= it.Quantity
+		value, err := price.Mul(int64(it.Quantity))
+		if err == nil && i > 0 {
+			value, err = total.Add(value)
+		}
+		if err != nil {
+			return money.Money{}, fmt.Errorf("%w: %v", ErrInvalidRefund, err)
+		}
+		total = value
+	}
+	return total, nil
+}
+
+// addRefund appends refund to the order and adds it to refunded_total, moving the order's status if the
+// total reaches the captured amount or leaves COMPLETED. Returns ErrStatusMismatch if the status or
+// refunded_total changed since order was read.
+func (s *Store) addRefund(ctx context.Context, order *Order, refund Refund, actor string) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "orders.Refund", s.tableName)
+	defer tracing.End(span, &err, ErrStatusMismatch)
+	entry, err := attributevalue.MarshalList([]Refund{refund})
+	if err != nil {
+		return fmt.Errorf("marshal refund: %w", err)
+	}
+	now := s.nowFunc()
+	total := order.RefundedTotal + refund.Amount.Amount
+	values := map[string]types.AttributeValue{
+		":refund":   &types.AttributeValueMemberL{Value: entry},
+		":amount":   &types.AttributeValueMemberN{Value: strconv.FormatInt(refund.Amount.Amount, 10)},
+		":zero":     &types.AttributeValueMemberN{Value: "0"},
+		":empty":    &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+		":ua":       &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+		":expected": &types.AttributeValueMemberS{Value: order.Status},
+	}
+	update := "SET refunds = list_append(if_not_exists(refunds, :empty), :refund), refunded_total = if_not_exists(refunded_total, :zero) + :amount, updated_at = :ua"
+	// a total of zero is either unset or left behind by refunds that all failed
+	cond := "#s = :expected AND refunded_total = :prev"
+	if order.RefundedTotal == 0 {
+		cond = "#s = :expected AND (attribute_not_exists(refunded_total) OR refunded_total = :prev)"
+	}
+	values[":prev"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(order.RefundedTotal, 10)}
+
+	next := refundStatus(total, order.Captured().Amount)
+	var change *StatusChange
+	if next != order.Status {
+		if err := Lifecycle.Validate(order.Status, next); err != nil {
+			return err
+		}
+		change = &StatusChange{From: order.Status, To: next, Actor: actor, Reason: refundReason(refund), At: now}
+		entry, err := attributevalue.MarshalList([]StatusChange{*change})
+		if err != nil {
+			return fmt.Errorf("marshal status change: %w", err)
+		}
+		update += ", #s = :new, status_history = list_append(if_not_exists(status_history, :empty), :change)"
+		values[":new"] = &types.AttributeValueMemberS{Value: next}
+		values[":change"] = &types.AttributeValueMemberL{Value: entry}
+	}
+
+	err = s.update(ctx, &dyn.UpdateItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"order_id": &types.AttributeValueMemberS{Value: order.OrderID},
+		},
+		UpdateExpression:          awsString(update),
+		ConditionExpression:       awsString(cond),
+		ExpressionAttributeNames:  map[string]string{"#s": "status"},
+		ExpressionAttributeValues: values,
+	}, order.OrderID, change)
+	if err != nil {
+		var sc *types.ConditionalCheckFailedException
+		if errors.As(err, &sc) {
+			return ErrStatusMismatch
+		}
+		return fmt.Errorf("add refund: %w", err)
+	}
+	return nil
+}
+
+// refundStatus returns the status of an order with total refunded out of captured.
+func refundStatus(total, captured int64) string {
+	switch {
+	case total <= 0:
+		return StatusCompleted
+	case total >= captured:
+		return StatusRefunded
+	default:
+		return StatusPartiallyRefunded
+	}
+}
+
+func refundReason(r Refund) string {
+	if r.Reason != "" {
+		return r.Reason
+	}
+	return fmt.Sprintf("refund %s of %s", r.RefundID, r.Amount)
+}
+
+// SettleRefund replaces the refund at index of the order's refunds with refund, which carries the
+// provider's outcome. A FAILED refund gives its amount back: the same update takes it off refunded_total
+// and moves the order's status to match the new total (PARTIALLY_REFUNDED or COMPLETED), so the money can
+// be refunded again. Settling a refund FAILED that is no longer PENDING changes nothing.
+// Returns ErrNotFound unless a refund with the same ID is at that index.
+func (s *Store) SettleRefund(ctx context.Context, orderID string, index int, refund Refund) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "orders.SettleRefund", s.tableName)
+	defer tracing.End(span, &err, ErrNotFound, ErrStatusMismatch)
+	now := s.nowFunc()
+	if refund.SettledAt == nil {
+		refund.SettledAt = &now
+	}
+	entry, err := attributevalue.MarshalMap(refund)
+	if err != nil {
+		return fmt.Errorf("marshal refund: %w", err)
+	}
+	path := fmt.Sprintf("refunds[%d]", index)
+	if refund.Status == RefundFailed {
+		return s.failRefund(ctx, orderID, index, refund, entry)
+	}
+	input := &dyn.UpdateItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"order_id": &types.AttributeValueMemberS{Value: orderID},
+		},
+		UpdateExpression: awsString("SET " + path + " = :refund, updated_at = :ua"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":refund": &types.AttributeValueMemberM{Value: entry},
+			":id":     &types.AttributeValueMemberS{Value: refund.RefundID},
+			":ua":     &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+		},
+		ConditionExpression: awsString(path + ".refund_id = :id"),
+	}
+	if _, err = s.client.UpdateItem(ctx, input); err != nil {
+		var sc *types.ConditionalCheckFailedException
+		if errors.As(err, &sc) {
+			return fmt.Errorf("%w: refund %s of order %s", ErrNotFound, refund.RefundID, orderID)
+		}
+		return fmt.Errorf("settle refund: %w", err)
+	}
+	return nil
+}
+
+// failRefund settles a PENDING refund FAILED and gives its amount back. Like Refund, the update is
+// conditional on the status and refunded_total just read, and re-reads the order if another refund changed
+// them in the meantime.
+func (s *Store) failRefund(ctx context.Context, orderID string, index int, refund Refund, entry map[string]types.AttributeValue) error {
+	path := fmt.Sprintf("refunds[%d]", index)
+	for i := 0; i < refundAttempts; i++ {
+		order, err := s.Get(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		if order == nil || index < 0 || index >= len(order.Refunds) || order.Refunds[index].RefundID != refund.RefundID {
+			return fmt.Errorf("%w: refund %s of order %s", ErrNotFound, refund.RefundID, orderID)
+		}
+		if order.Refunds[index].Status != RefundPending {
+			return nil
+		}
+
+		now := s.nowFunc()
+		amount := order.Refunds[index].Amount.Amount
+		total := order.RefundedTotal - amount
+		values := map[string]types.AttributeValue{
+			":refund":   &types.AttributeValueMemberM{Value: entry},
+			":id":       &types.AttributeValueMemberS{Value: refund.RefundID},
+			":pending":  &types.AttributeValueMemberS{Value: RefundPending},
+			":amount":   &types.AttributeValueMemberN{Value: strconv.FormatInt(amount, 10)},
+			":prev":     &types.AttributeValueMemberN{Value: strconv.FormatInt(order.RefundedTotal, 10)},
+			":expected": &types.AttributeValueMemberS{Value: order.Status},
+			":ua":       &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+		}
+		update := "SET " + path + " = :refund, refunded_total = refunded_total - :amount, updated_at = :ua"
+		var change *StatusChange
+		if next := refundStatus(total, order.Captured().Amount); next != order.Status {
+			// the reverse of a refund's transition, which the lifecycle alone does not allow
+			change = &StatusChange{From: order.Status, To: next, Reason: fmt.Sprintf("refund %s failed", refund.RefundID), At: now}
+			history, err := attributevalue.MarshalList([]StatusChange{*change})
+			if err != nil {
+				return fmt.Errorf("marshal status change: %w", err)
+			}
+			update += ", #s = :new, status_history = list_append(if_not_exists(status_history, :empty), :change)"
+			values[":new"] = &types.AttributeValueMemberS{Value: next}
+			values[":change"] = &types.AttributeValueMemberL{Value: history}
+			values[":empty"] = &types.AttributeValueMemberL{Value: []types.AttributeValue{}}
+		}
+
+		err = s.update(ctx, &dyn.UpdateItemInput{
+			TableName: &s.tableName,
+			Key: map[string]types.AttributeValue{
+				"order_id": &types.AttributeValueMemberS{Value: orderID},
+			},
+			UpdateExpression: awsString(update),
+			ConditionExpression: awsString(path + ".refund_id = :id AND " + path + ".#s = :pending AND " +
+				"#s = :expected AND refunded_total = :prev"),
+			ExpressionAttributeNames:  map[string]string{"#s": "status"},
+			ExpressionAttributeValues: values,
+		}, orderID, change)
+		var sc *types.ConditionalCheckFailedException
+		if errors.As(err, &sc) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("settle refund: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("settle refund %s of order %s: %w", refund.RefundID, orderID, ErrStatusMismatch)
+}
+
+// CompleteStep checkpoints the worker pipeline step name on an order that is still PROCESSING, so a
+// retried message resumes after it. Completed steps are a string set: recording a step twice is harmless.
+// Returns ErrStatusMismatch if the order is no longer PROCESSING (e.g. it was cancelled meanwhile).
+func (s *Store) CompleteStep(ctx context.Context, orderID, step string) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "orders.CompleteStep", s.tableName)
+	defer tracing.End(span, &err, ErrStatusMismatch)
+	now := s.nowFunc()
+	input := &dyn.UpdateItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"order_id": &types.AttributeValueMemberS{Value: orderID},
+		},
+		UpdateExpression:         awsString("ADD completed_steps :step SET updated_at = :ua"),
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":step":       &types.AttributeValueMemberSS{Value: []string{step}},
+			":ua":         &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+			":processing": &types.AttributeValueMemberS{Value: StatusProcessing},
+		},
+		ConditionExpression: awsString("#s = :processing"),
+	}
+	_, err = s.client.UpdateItem(ctx, input)
+	if err != nil {
+		var sc *types.ConditionalCheckFailedException
+		if errors.As(err, &sc) {
+			return ErrStatusMismatch
+		}
+		return fmt.Errorf("complete step %s: %w", step, err)
+	}
+	return nil
+}
+
+// StartAction records in saga_actions that the compensable step is about to run, so the step is undone
+// if the order is cancelled or fails later, even when the step itself did not finish. Returns
+// ErrStatusMismatch unless the order is PROCESSING.
+func (s *Store) StartAction(ctx context.Context, orderID, step string) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "orders.StartAction", s.tableName)
+	defer tracing.End(span, &err, ErrStatusMismatch)
+	return s.addToSet(ctx, orderID, "saga_actions", step, true)
+}
+
+// CompleteCompensation records in saga_compensated that the compensation of step has run. Returns
+// ErrNotFound if the order does not exist.
+func (s *Store) CompleteCompensation(ctx context.Context, orderID, step string) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "orders.CompleteCompensation", s.tableName)
+	defer tracing.End(span, &err, ErrNotFound)
+	return s.addToSet(ctx, orderID, "saga_compensated", step, false)
+}
+
+// FinishCompensation sets the order's saga_status to SagaCompensated once every compensation has run.
+// Returns ErrNotFound if the order does not exist.
+func (s *Store) FinishCompensation(ctx context.Context, orderID string) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "orders.FinishCompensation", s.tableName)
+	defer tracing.End(span, &err, ErrNotFound)
+	input := &dyn.UpdateItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"order_id": &types.AttributeValueMemberS{Value: orderID},
+		},
+		UpdateExpression: awsString("SET saga_status = :compensated, updated_at = :ua"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":compensated": &types.AttributeValueMemberS{Value: SagaCompensated},
+			":ua":          &types.AttributeValueMemberS{Value: s.nowFunc().Format(time.RFC3339)},
+		},
+		ConditionExpression: awsString("attribute_exists(order_id)"),
+	}
+	if _, err = s.client.UpdateItem(ctx, input); err != nil {
+		var sc *types.ConditionalCheckFailedException
+		if errors.As(err, &sc) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("finish compensation: %w", err)
+	}
+	return nil
+}
+
+// addToSet adds value to the string set attribute attr of an existing order. With processing set it
+// only does so while the order is PROCESSING and returns ErrStatusMismatch otherwise; without, a missing
+// order gets ErrNotFound.
+func (s *Store) addToSet(ctx context.Context, orderID, attr, value string, processing bool) error {
+	input := &dyn.UpdateItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"order_id": &types.AttributeValueMemberS{Value: orderID},
+		},
+		UpdateExpression: awsString("ADD " + attr + " :v SET updated_at = :ua"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":v":  &types.AttributeValueMemberSS{Value: []string{value}},
+			":ua": &types.AttributeValueMemberS{Value: s.nowFunc().Format(time.RFC3339)},
+		},
+	}
+	mismatch := ErrNotFound
+	input.ConditionExpression = awsString("attribute_exists(order_id)")
+	if processing {
+		mismatch = ErrStatusMismatch
+		input.ExpressionAttributeNames = map[string]string{"#s": "status"}
+		input.ExpressionAttributeValues[":processing"] = &types.AttributeValueMemberS{Value: StatusProcessing}
+		input.ConditionExpression = awsString("#s = :processing")
+	}
+	_, err := s.client.UpdateItem(ctx, input)
+	if err != nil {
+		var sc *types.ConditionalCheckFailedException
+		if errors.As(err, &sc) {
+			return mismatch
+		}
+		return fmt.Errorf("add %s to %s: %w", value, attr, err)
+	}
+	return nil
+}
+
+// RecordPayment appends attempt to the order's payments. attempt.At defaults to now. Returns ErrNotFound
+// if the order does not exist.
+func (s *Store) RecordPayment(ctx context.Context, orderID string, attempt PaymentAttempt) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "orders.RecordPayment", s.tableName)
+	defer tracing.End(span, &err, ErrNotFound)
+	now := s.nowFunc()
+	if attempt.At.IsZero() {
+		attempt.At = now
+	}
+	entry, err := attributevalue.MarshalList([]PaymentAttempt{attempt})
+	if err != nil {
+		return fmt.Errorf("marshal payment attempt: %w", err)
+	}
+	input := &dyn.UpdateItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"order_id": &types.AttributeValueMemberS{Value: orderID},
+		},
+		UpdateExpression: awsString("SET payments = list_append(if_not_exists(payments, :empty), :attempt), updated_at = :ua"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty":   &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+			":attempt": &types.AttributeValueMemberL{Value: entry},
+			":ua":      &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+		},
+		ConditionExpression: awsString("attribute_exists(order_id)"),
+	}
+	_, err = s.client.UpdateItem(ctx, input)
+	if err != nil {
+		var sc *types.ConditionalCheckFailedException
+		if errors.As(err, &sc) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("record payment: %w", err)
+	}
+	return nil
+}
+
 // IncrementAttempts increases the attempts counter by 1 (useful for worker retries)
-func (s *Store) IncrementAttempts(ctx context.Context, orderID string) error {
+func (s *Store) IncrementAttempts(ctx context.Context, orderID string) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "orders.IncrementAttempts", s.tableName)
+	defer tracing.End(span, &err)
 	now := s.nowFunc()
 	input := &dyn.UpdateItemInput{
 		TableName: &s.tableName,
@@ -162,11 +819,45 @@ func (s *Store) IncrementAttempts(ctx context.Context, orderID string) error {
 		ExpressionAttributeValues: map[string]types.AttributeValue{":zero": &types.AttributeValueMemberN{Value: "0"}, ":inc": &types.AttributeValueMemberN{Value: "1"}, ":ua": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)}},
 		ReturnValues:              types.ReturnValueUpdatedNew,
 	}
-	_, err := s.client.UpdateItem(ctx, input)
+	_, err = s.client.UpdateItem(ctx, input)
 	if err != nil {
 		return fmt.Errorf("increment attempts: %w", err)
 	}
 	return nil
 }
 
+// update applies input. If it changes the order's status (change is set) and a TransitionRecorder is
+// configured, the recorder's item is written in the same transaction. A failed condition on input is
+// returned as *types.ConditionalCheckFailedException either way.
+func (s *Store) update(ctx context.Context, input *dyn.UpdateItemInput, orderID string, change *StatusChange) error {
+	if s.transitions == nil || change == nil {
+		_, err := s.client.UpdateItem(ctx, input)
+		return err
+	}
+	record, err := s.transitions.RecordTransition(orderID, *change)
+	if err != nil {
+		return fmt.Errorf("record transition: %w", err)
+	}
+	_, err = s.client.TransactWriteItems(ctx, &dyn.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Update: &types.Update{
+				TableName:                 input.TableName,
+				Key:                       input.Key,
+				UpdateExpression:          input.UpdateExpression,
+				ConditionExpression:       input.ConditionExpression,
+				ExpressionAttributeNames:  input.ExpressionAttributeNames,
+				ExpressionAttributeValues: input.ExpressionAttributeValues,
+			}},
+			record,
+		},
+	})
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) && len(tce.CancellationReasons) > 0 && tce.CancellationReasons[0].Code != nil && *tce.CancellationReasons[0].Code == "ConditionalCheckFailed" {
+		return &types.ConditionalCheckFailedException{Message: tce.Message}
+	}
+	return err
+}
+
 func awsString(s string) *string { return &s }
+
+func awsBool(b bool) *bool { return &b }
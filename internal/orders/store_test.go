@@ -3,175 +3,36 @@ package orders
 import (
 	"context"
 	"errors"
-	"sync"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
-	dyn "github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/testing/dynamofake"
 )
 
-// mockDynamo is a simple mock that supports TransactWriteItems, PutItem, GetItem, UpdateItem.
-// It stores items per table in a nested map: table -> pkValue -> item map.
-type mockDynamo struct {
-	mu     sync.Mutex
-	tables map[string]map[string]map[string]types.AttributeValue
-}
-
-func newMockDynamo() *mockDynamo {
-	return &mockDynamo{
-		tables: map[string]map[string]map[string]types.AttributeValue{},
-	}
-}
-
-func (m *mockDynamo) ensureTable(tbl string) {
-	if _, ok := m.tables[tbl]; !ok {
-		m.tables[tbl] = map[string]map[string]types.AttributeValue{}
-	}
-}
-
-func (m *mockDynamo) PutItem(ctx context.Context, params *dyn.PutItemInput, optFns ...func(*dyn.Options)) (*dyn.PutItemOutput, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	table := *params.TableName
-	m.ensureTable(table)
-	// find primary key either order_id or idempotency_key
-	var pk string
-	if v, ok := params.Item["order_id"]; ok {
-		pk = v.(*types.AttributeValueMemberS).Value
-	} else if v, ok := params.Item["idempotency_key"]; ok {
-		pk = v.(*types.AttributeValueMemberS).Value
-	} else {
-		return nil, errors.New("no primary key in put item")
-	}
-	// handle conditional expression attribute_not_exists(idempotency_key)
-	if params.ConditionExpression != nil && *params.ConditionExpression == "attribute_not_exists(idempotency_key)" {
-		if _, exists := m.tables[table][pk]; exists {
-			// simulate conditional failure
-			return nil, &types.ConditionalCheckFailedException{}
-		}
-	}
-	// write
-	m.tables[table][pk] = params.Item
-	return &dyn.PutItemOutput{}, nil
-}
-
-func (m *mockDynamo) GetItem(ctx context.Context, params *dyn.GetItemInput, optFns ...func(*dyn.Options)) (*dyn.GetItemOutput, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	table := *params.TableName
-	m.ensureTable(table)
-	var pk string
-	if v, ok := params.Key["order_id"]; ok {
-		pk = v.(*types.AttributeValueMemberS).Value
-	} else if v, ok := params.Key["idempotency_key"]; ok {
-		pk = v.(*types.AttributeValueMemberS).Value
-	} else {
-		return nil, errors.New("no key attribute")
-	}
-	item, ok := m.tables[table][pk]
-	if !ok {
-		return &dyn.GetItemOutput{}, nil
-	}
-	return &dyn.GetItemOutput{Item: item}, nil
-}
-
-func (m *mockDynamo) UpdateItem(ctx context.Context, params *dyn.UpdateItemInput, optFns ...func(*dyn.Options)) (*dyn.UpdateItemOutput, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	table := *params.TableName
-	m.ensureTable(table)
-	var pk string
-	if v, ok := params.Key["order_id"]; ok {
-		pk = v.(*types.AttributeValueMemberS).Value
-	} else if v, ok := params.Key["idempotency_key"]; ok {
-		pk = v.(*types.AttributeValueMemberS).Value
-	} else {
-		return nil, errors.New("no key attribute")
-	}
-	item, exists := m.tables[table][pk]
-	if !exists {
-		return nil, errors.New("item not found")
-	}
-	// naive apply: if ExpressionAttributeValues present, copy them into item
-	for k, v := range params.ExpressionAttributeValues {
-		// avoid overwriting reserved keys, simply set
-		item[k] = v
-	}
-	// If ConditionExpression is present and of form "#s = :expected", check existing
-	if params.ConditionExpression != nil && *params.ConditionExpression == "#s = :expected" {
-		// check status
-		if curr, ok := item["status"].(*types.AttributeValueMemberS); ok {
-			expected := params.ExpressionAttributeValues[":expected"].(*types.AttributeValueMemberS).Value
-			if curr.Value != expected {
-				return nil, &types.ConditionalCheckFailedException{}
-			}
-		} else {
-			return nil, &types.ConditionalCheckFailedException{}
-		}
-	}
-	// perform update (this is simplistic)
-	// set updated_at if provided
-	if v, ok := params.ExpressionAttributeValues[":ua"]; ok {
-		item["updated_at"] = v
-	}
-	if v, ok := params.ExpressionAttributeValues[":new"]; ok {
-		item["status"] = v
-	}
-	// store back
-	m.tables[table][pk] = item
-	return &dyn.UpdateItemOutput{Attributes: item}, nil
-}
+const (
+	ordersTable = "orders"
+	idempTable  = "idempotency"
+)
 
-func (m *mockDynamo) TransactWriteItems(ctx context.Context, params *dyn.TransactWriteItemsInput, optFns ...func(*dyn.Options)) (*dyn.TransactWriteItemsOutput, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	// First pass: verify condition expressions
-	for _, it := range params.TransactItems {
-		if p := it.Put; p != nil {
-			if p.ConditionExpression != nil && *p.ConditionExpression == "attribute_not_exists(idempotency_key)" {
-				// ensure idempotency pk exists in its table?
-				table := *p.TableName
-				m.ensureTable(table)
-				kattr := p.Item["idempotency_key"]
-				if kattr == nil {
-					return nil, errors.New("missing idempotency_key in put")
-				}
-				pk := kattr.(*types.AttributeValueMemberS).Value
-				if _, exists := m.tables[table][pk]; exists {
-					// simulate transaction canceled
-					return nil, &types.TransactionCanceledException{}
-				}
-			}
-		}
-	}
-	// Second pass: apply all puts
-	for _, it := range params.TransactItems {
-		if p := it.Put; p != nil {
-			table := *p.TableName
-			m.ensureTable(table)
-			// determine pk (order_id or idempotency_key)
-			var pk string
-			if v, ok := p.Item["order_id"]; ok {
-				pk = v.(*types.AttributeValueMemberS).Value
-			} else if v, ok := p.Item["idempotency_key"]; ok {
-				pk = v.(*types.AttributeValueMemberS).Value
-			} else {
-				return nil, errors.New("no pk found in transact put")
-			}
-			m.tables[table][pk] = p.Item
-		}
-	}
-	return &dyn.TransactWriteItemsOutput{}, nil
+func newFakeDynamo() *dynamofake.Fake {
+	return dynamofake.New(
+		dynamofake.TableSchema{
+			Name:    ordersTable,
+			HashKey: "order_id",
+			Indexes: []dynamofake.IndexSchema{{Name: CustomerIndex, HashKey: "customer_id", RangeKey: "created_at"}},
+		},
+		dynamofake.TableSchema{Name: idempTable, HashKey: "idempotency_key"},
+	)
 }
 
 func TestCreateWithIdempotencyTransaction_Success(t *testing.T) {
-	mock := newMockDynamo()
-	ordersTable := "orders"
-	idempTable := "idempotency"
-
-	store := NewStore(mock, ordersTable)
+	db := newFakeDynamo()
+	store := NewStore(db, ordersTable)
 
 	// build idempotency item struct
 	now := time.Now()
@@ -186,29 +47,29 @@ func TestCreateWithIdempotencyTransaction_Success(t *testing.T) {
 		OrderID:    "order-1",
 		CustomerID: "cust-1",
 		Status:     StatusPending,
-		Amount:     123.45,
+		Amount:     money.Money{Amount: 12345, Currency: "USD"},
 		Items:      []map[string]interface{}{{"sku": "sku-1", "qty": 1}},
 		CreatedAt:  now,
 		UpdatedAt:  now,
 	}
 
-	err := store.CreateWithIdempotencyTransaction(context.Background(), mock, idempTable, idemp, order, 48*time.Hour)
+	err := store.CreateWithIdempotencyTransaction(context.Background(), db, idempTable, idemp, order, 48*time.Hour)
 	if err != nil {
 		t.Fatalf("expected success, got error: %v", err)
 	}
 
 	// verify both tables contain items
 	// idempotency
-	idempItem, ok := mock.tables[idempTable]["key-1"]
-	if !ok {
+	idempItem := db.Item(idempTable, "key-1")
+	if idempItem == nil {
 		t.Fatalf("idempotency item not stored")
 	}
 	if _, ok := idempItem["idempotency_key"]; !ok {
 		t.Fatalf("idempotency_key missing in stored item")
 	}
 	// orders
-	orderItem, ok := mock.tables[ordersTable]["order-1"]
-	if !ok {
+	orderItem := db.Item(ordersTable, "order-1")
+	if orderItem == nil {
 		t.Fatalf("order item not stored")
 	}
 	// unmarshal order item to Order
@@ -219,21 +80,21 @@ func TestCreateWithIdempotencyTransaction_Success(t *testing.T) {
 	if got.OrderID != order.OrderID {
 		t.Fatalf("order id mismatch")
 	}
+	if got.Amount != order.Amount {
+		t.Fatalf("amount did not round-trip: got %v, want %v", got.Amount, order.Amount)
+	}
 }
 
 func TestCreateWithIdempotencyTransaction_ExistingIdempotency_Fails(t *testing.T) {
-	mock := newMockDynamo()
-	ordersTable := "orders"
-	idempTable := "idempotency"
+	db := newFakeDynamo()
 
 	// pre-insert idempotency key
-	mock.ensureTable(idempTable)
-	mock.tables[idempTable]["key-2"] = map[string]types.AttributeValue{
+	db.Put(idempTable, map[string]types.AttributeValue{
 		"idempotency_key": &types.AttributeValueMemberS{Value: "key-2"},
 		"status":          &types.AttributeValueMemberS{Value: "DONE"},
-	}
+	})
 
-	store := NewStore(mock, ordersTable)
+	store := NewStore(db, ordersTable)
 
 	idemp := map[string]interface{}{
 		"idempotency_key": "key-2",
@@ -243,43 +104,49 @@ func TestCreateWithIdempotencyTransaction_ExistingIdempotency_Fails(t *testing.T
 		OrderID:    "order-2",
 		CustomerID: "cust-2",
 		Status:     StatusPending,
-		Amount:     10.0,
+		Amount:     money.Money{Amount: 1000, Currency: "USD"},
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
 	}
 
-	err := store.CreateWithIdempotencyTransaction(context.Background(), mock, idempTable, idemp, order, 48*time.Hour)
+	err := store.CreateWithIdempotencyTransaction(context.Background(), db, idempTable, idemp, order, 48*time.Hour)
 	if err == nil {
 		t.Fatalf("expected transaction canceled error, got nil")
 	}
+	var tce *types.TransactionCanceledException
+	if !errors.As(err, &tce) || *tce.CancellationReasons[0].Code != "ConditionalCheckFailed" {
+		t.Fatalf("expected idempotency put to be the cancelled action, got %v", err)
+	}
+	// nothing from the cancelled transaction is written
+	if db.Item(ordersTable, "order-2") != nil {
+		t.Fatalf("order must not be written when the transaction is cancelled")
+	}
 }
 
 func TestUpdateStatus_Condition_SuccessAndFail(t *testing.T) {
-	mock := newMockDynamo()
-	tbl := "orders"
-	mock.ensureTable(tbl)
+	db := newFakeDynamo()
 	now := time.Now()
 	// insert order
 	item, _ := attributevalue.MarshalMap(Order{
 		OrderID:    "order-10",
 		CustomerID: "c10",
 		Status:     StatusPending,
-		Amount:     1.0,
+		Amount:     money.Money{Amount: 100, Currency: "USD"},
 		CreatedAt:  now,
 		UpdatedAt:  now,
 	})
-	mock.tables[tbl]["order-10"] = item
+	db.Put(ordersTable, item)
 
-	store := NewStore(mock, tbl)
+	store := NewStore(db, ordersTable)
 
 	// success: PENDING -> PROCESSING
-	err := store.UpdateStatus(context.Background(), "order-10", StatusPending, StatusProcessing)
+	err := store.UpdateStatus(context.Background(), "order-10", StatusChange{From: StatusPending, To: StatusProcessing})
 	if err != nil {
 		t.Fatalf("expected success, got %v", err)
 	}
 
-	// failure: PENDING -> COMPLETED (but current is PROCESSING)
-	err = store.UpdateStatus(context.Background(), "order-10", StatusPending, StatusCompleted)
+	// failure: PENDING -> ON_HOLD (but current is PROCESSING)
+	err = store.UpdateStatus(context.Background(), "order-10", StatusChange{From: StatusPending, To: StatusOnHold})
 	if err == nil {
 		t.Fatalf("expected ErrStatusMismatch, got nil")
 	}
@@ -287,3 +154,563 @@ func TestUpdateStatus_Condition_SuccessAndFail(t *testing.T) {
 		t.Fatalf("expected ErrStatusMismatch, got %v", err)
 	}
 }
+
+func TestUpdateStatus_RejectsIllegalTransitionsAndRecordsHistory(t *testing.T) {
+	db := newFakeDynamo()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	item, _ := attributevalue.MarshalMap(Order{OrderID: "order-11", Status: StatusPending, CreatedAt: now, UpdatedAt: now})
+	db.Put(ordersTable, item)
+
+	store := NewStore(db, ordersTable)
+	store.nowFunc = func() time.Time { return now }
+	ctx := context.Background()
+
+	// PENDING -> COMPLETED skips PROCESSING
+	if err := store.UpdateStatus(ctx, "order-11", StatusChange{From: StatusPending, To: StatusCompleted}); !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("expected ErrIllegalTransition, got %v", err)
+	}
+
+	steps := []StatusChange{
+		{From: StatusPending, To: StatusOnHold, Actor: "ops", Reason: "fraud check"},
+		{From: StatusOnHold, To: StatusProcessing, Actor: "ops", Reason: "cleared"},
+		{From: StatusProcessing, To: StatusCompleted, Actor: "worker"},
+		{From: StatusCompleted, To: StatusRefunded, Actor: "support", Reason: "customer request"},
+	}
+	for _, st := range steps {
+		if err := store.UpdateStatus(ctx, "order-11", st); err != nil {
+			t.Fatalf("%s -> %s: %v", st.From, st.To, err)
+		}
+	}
+
+	// REFUNDED is terminal
+	if err := store.UpdateStatus(ctx, "order-11", StatusChange{From: StatusRefunded, To: StatusPending}); !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("expected ErrIllegalTransition from terminal state, got %v", err)
+	}
+
+	got, err := store.Get(ctx, "order-11")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Status != StatusRefunded || len(got.StatusHistory) != len(steps) {
+		t.Fatalf("unexpected order after transitions: status=%s history=%+v", got.Status, got.StatusHistory)
+	}
+	for i, h := range got.StatusHistory {
+		if h.From != steps[i].From || h.To != steps[i].To || h.Actor != steps[i].Actor || h.Reason != steps[i].Reason || !h.At.Equal(now) {
+			t.Fatalf("history[%d] = %+v, want %+v", i, h, steps[i])
+		}
+	}
+}
+
+// recorder writes an item per transition to the "transitions" table, failing if it already exists.
+type recorder struct{}
+
+func (recorder) RecordTransition(orderID string, change StatusChange) (types.TransactWriteItem, error) {
+	return types.TransactWriteItem{Put: &types.Put{
+		TableName: awsString("transitions"),
+		Item: map[string]types.AttributeValue{
+			"id":   &types.AttributeValueMemberS{Value: orderID + ":" + change.To},
+			"from": &types.AttributeValueMemberS{Value: change.From},
+		},
+		ConditionExpression: awsString("attribute_not_exists(id)"),
+	}}, nil
+}
+
+func TestTransitionRecorder_WritesOnlyWithTheStatusChange(t *testing.T) {
+	db := dynamofake.New(
+		dynamofake.TableSchema{Name: ordersTable, HashKey: "order_id"},
+		dynamofake.TableSchema{Name: "transitions", HashKey: "id"},
+	)
+	now := time.Now()
+	usd := money.Money{Amount: 1000, Currency: "USD"}
+	for id, status := range map[string]string{"o1": StatusPending, "o2": StatusCompleted} {
+		item, _ := attributevalue.MarshalMap(Order{OrderID: id, Status: status, Amount: usd, CreatedAt: now, UpdatedAt: now})
+		db.Put(ordersTable, item)
+	}
+	store := NewStore(db, ordersTable)
+	store.SetTransitionRecorder(recorder{})
+	ctx := context.Background()
+
+	if err := store.UpdateStatus(ctx, "o1", StatusChange{From: StatusPending, To: StatusProcessing}); err != nil {
+		t.Fatalf("UpdateStatus: %v", err)
+	}
+	if db.Item("transitions", "o1:PROCESSING") == nil {
+		t.Fatalf("expected the transition to be recorded with the update")
+	}
+	// a stale From fails the condition and records nothing
+	err := store.UpdateStatus(ctx, "o1", StatusChange{From: StatusPending, To: StatusOnHold})
+	if !errors.Is(err, ErrStatusMismatch) {
+		t.Fatalf("expected ErrStatusMismatch, got %v", err)
+	}
+	if db.Item("transitions", "o1:ON_HOLD") != nil {
+		t.Fatalf("a transition was recorded for an update that did not commit")
+	}
+	// a recorder item that cannot be written cancels the status change
+	db.Put("transitions", map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "o1:COMPLETED"}})
+	if err := store.UpdateStatus(ctx, "o1", StatusChange{From: StatusProcessing, To: StatusCompleted}); err == nil {
+		t.Fatalf("expected the update to fail with the recorder item")
+	}
+	if got, _ := store.Get(ctx, "o1"); got.Status != StatusProcessing {
+		t.Fatalf("status changed without its transition: %s", got.Status)
+	}
+
+	// a refund moving the order to REFUNDED records its transition
+	if _, _, err := store.Refund(ctx, "o2", RefundRequest{RefundID: "r1", IdempotencyKey: "k1", Actor: "api"}); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if got := db.Item("transitions", "o2:REFUNDED"); got == nil || got["from"].(*types.AttributeValueMemberS).Value != StatusCompleted {
+		t.Fatalf("expected the refund's transition to be recorded, got %v", got)
+	}
+}
+
+func TestCancel_FromCancellableStatusesOnly(t *testing.T) {
+	db := newFakeDynamo()
+	now := time.Now()
+	for id, status := range map[string]string{
+		"pending": StatusPending, "processing": StatusProcessing, "completed": StatusCompleted, "cancelled": StatusCancelled,
+	} {
+		item, _ := attributevalue.MarshalMap(Order{OrderID: id, Status: status, CreatedAt: now, UpdatedAt: now})
+		db.Put(ordersTable, item)
+	}
+	store := NewStore(db, ordersTable)
+	ctx := context.Background()
+	change := StatusChange{Actor: "api", Reason: "customer request"}
+
+	for _, id := range []string{"pending", "processing"} {
+		got, err := store.Cancel(ctx, id, change)
+		if err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		last := got.StatusHistory[len(got.StatusHistory)-1]
+		if got.Status != StatusCancelled || last.From != strings.ToUpper(id) || last.Actor != "api" || last.Reason != "customer request" {
+			t.Fatalf("%s: unexpected order after cancel: %+v", id, got)
+		}
+	}
+
+	// already cancelled: nothing written
+	got, err := store.Cancel(ctx, "cancelled", change)
+	if !errors.Is(err, ErrAlreadyCancelled) || got.Status != StatusCancelled || len(got.StatusHistory) != 0 {
+		t.Fatalf("expected a no-op for a cancelled order, got %+v, %v", got, err)
+	}
+
+	got, err = store.Cancel(ctx, "completed", change)
+	if !errors.Is(err, ErrNotCancellable) || got.Status != StatusCompleted {
+		t.Fatalf("expected ErrNotCancellable for a completed order, got %+v, %v", got, err)
+	}
+	if _, err := store.Cancel(ctx, "missing", change); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCompleteStep_CheckpointsOnlyWhileProcessing(t *testing.T) {
+	db := newFakeDynamo()
+	now := time.Now()
+	for id, status := range map[string]string{"processing": StatusProcessing, "cancelled": StatusCancelled} {
+		item, _ := attributevalue.MarshalMap(Order{OrderID: id, Status: status, CreatedAt: now, UpdatedAt: now})
+		db.Put(ordersTable, item)
+	}
+	store := NewStore(db, ordersTable)
+	ctx := context.Background()
+
+	for _, step := range []string{"validate", "charge", "validate"} {
+		if err := store.CompleteStep(ctx, "processing", step); err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+	}
+	got, err := store.Get(ctx, "processing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.CompletedSteps) != 2 || !got.StepCompleted("validate") || !got.StepCompleted("charge") || got.StepCompleted("fulfil") {
+		t.Fatalf("unexpected checkpoints: %v", got.CompletedSteps)
+	}
+
+	if err := store.CompleteStep(ctx, "cancelled", "validate"); !errors.Is(err, ErrStatusMismatch) {
+		t.Fatalf("expected ErrStatusMismatch for a cancelled order, got %v", err)
+	}
+}
+
+func TestSagaState_ActionsOnlyWhileProcessingAndCompensations(t *testing.T) {
+	db := newFakeDynamo()
+	now := time.Now()
+	item, _ := attributevalue.MarshalMap(Order{OrderID: "o1", Status: StatusProcessing, CreatedAt: now, UpdatedAt: now})
+	db.Put(ordersTable, item)
+	store := NewStore(db, ordersTable)
+	ctx := context.Background()
+
+	for _, step := range []string{"reserve", "charge", "reserve"} {
+		if err := store.StartAction(ctx, "o1", step); err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+	}
+	if err := store.UpdateStatus(ctx, "o1", StatusChange{From: StatusProcessing, To: StatusFailed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StartAction(ctx, "o1", "ship"); !errors.Is(err, ErrStatusMismatch) {
+		t.Fatalf("expected ErrStatusMismatch once FAILED, got %v", err)
+	}
+	if err := store.CompleteCompensation(ctx, "o1", "charge"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := store.Get(ctx, "o1")
+	if len(got.SagaActions) != 2 || !got.ActionStarted("reserve") || got.ActionStarted("ship") ||
+		!got.ActionCompensated("charge") || got.ActionCompensated("reserve") || got.SagaStatus != "" {
+		t.Fatalf("unexpected saga state: %v %v %q", got.SagaActions, got.SagaCompensated, got.SagaStatus)
+	}
+	if err := store.FinishCompensation(ctx, "o1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.Get(ctx, "o1"); got.SagaStatus != SagaCompensated {
+		t.Fatalf("expected %s, got %q", SagaCompensated, got.SagaStatus)
+	}
+
+	if err := store.CompleteCompensation(ctx, "missing", "charge"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := store.FinishCompensation(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRecordPayment_AppendsAttempts(t *testing.T) {
+	db := newFakeDynamo()
+	now := time.Now()
+	item, _ := attributevalue.MarshalMap(Order{OrderID: "o1", Status: StatusProcessing, CreatedAt: now, UpdatedAt: now})
+	db.Put(ordersTable, item)
+	store := NewStore(db, ordersTable)
+	ctx := context.Background()
+
+	usd := money.Money{Amount: 1000, Currency: "USD"}
+	attempts := []PaymentAttempt{
+		{Operation: "authorize", IdempotencyKey: "o1:authorize_payment", Status: PaymentPending, Amount: usd},
+		{Operation: "authorize", IdempotencyKey: "o1:authorize_payment", Status: PaymentSucceeded, Amount: usd, ProviderID: "auth_1", AuthorizationID: "auth_1"},
+		{Operation: "capture", IdempotencyKey: "o1:capture_payment", Status: PaymentPending, Amount: usd, AuthorizationID: "auth_1"},
+	}
+	for _, a := range attempts {
+		if err := store.RecordPayment(ctx, "o1", a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := store.Get(ctx, "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Payments) != 3 || got.Payments[0].At.IsZero() {
+		t.Fatalf("unexpected payments: %+v", got.Payments)
+	}
+	if last := got.LastPayment("authorize"); last == nil || last.Status != PaymentSucceeded || last.ProviderID != "auth_1" {
+		t.Fatalf("expected the succeeded authorization last, got %+v", last)
+	}
+	if got.LastPayment("refund") != nil {
+		t.Fatal("expected no refund attempt")
+	}
+
+	if err := store.RecordPayment(ctx, "missing", attempts[0]); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRefund_CumulativeRefundsStayWithinTheCapture(t *testing.T) {
+	db := newFakeDynamo()
+	now := time.Now()
+	usd := func(n int64) money.Money { return money.Money{Amount: n, Currency: "USD"} }
+	put := func(id, status string, captured int64) {
+		item, _ := attributevalue.MarshalMap(Order{
+			OrderID: id, Status: status, Amount: usd(2000), CreatedAt: now, UpdatedAt: now,
+			Items: []map[string]interface{}{
+				{"sku": "A", "quantity": 2, "price": usd(500)},
+				{"sku": "B", "quantity": 1, "price": usd(1000)},
+			},
+			Payments: []PaymentAttempt{{Operation: "capture", Status: PaymentSucceeded, Amount: usd(captured)}},
+		})
+		db.Put(ordersTable, item)
+	}
+	put("o1", StatusCompleted, 2000)
+	put("discounted", StatusCompleted, 800)
+	put("pending", StatusPending, 2000)
+	store := NewStore(db, ordersTable)
+	ctx := context.Background()
+
+	got, refund, err := store.Refund(ctx, "o1", RefundRequest{RefundID: "r1", IdempotencyKey: "k1", Items: []RefundItem{{SKU: "A", Quantity: 1}}, Actor: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := got.StatusHistory[len(got.StatusHistory)-1]
+	if refund.Amount != usd(500) || refund.Status != RefundPending || got.RefundedTotal != 500 || got.Status != StatusPartiallyRefunded || last.From != StatusCompleted || last.Actor != "api" {
+		t.Fatalf("unexpected partial refund: %+v on %+v", refund, got)
+	}
+
+	// a retried request gets its refund back
+	if got, refund, err = store.Refund(ctx, "o1", RefundRequest{RefundID: "r1-retry", IdempotencyKey: "k1", Items: []RefundItem{{SKU: "A", Quantity: 1}}}); err != nil || refund.RefundID != "r1" || len(got.Refunds) != 1 {
+		t.Fatalf("expected the recorded refund, got %+v, %v", refund, err)
+	}
+
+	for _, items := range [][]RefundItem{{{SKU: "A", Quantity: 2}}, {{SKU: "C", Quantity: 1}}, {{SKU: "A", Quantity: 1}, {SKU: "A", Quantity: 1}}} {
+		if _, _, err := store.Refund(ctx, "o1", RefundRequest{RefundID: "bad", Items: items}); !errors.Is(err, ErrInvalidRefund) {
+			t.Fatalf("%+v: expected ErrInvalidRefund, got %v", items, err)
+		}
+	}
+
+	// the rest of the capture, then nothing is left
+	if got, refund, err = store.Refund(ctx, "o1", RefundRequest{RefundID: "r2", IdempotencyKey: "k2"}); err != nil || refund.Amount != usd(1500) || got.Status != StatusRefunded || got.RefundedTotal != 2000 {
+		t.Fatalf("expected a full refund of the rest, got %+v, %+v, %v", refund, got, err)
+	}
+	if _, _, err := store.Refund(ctx, "o1", RefundRequest{RefundID: "r3", IdempotencyKey: "k3"}); !errors.Is(err, ErrNotRefundable) {
+		t.Fatalf("expected ErrNotRefundable, got %v", err)
+	}
+
+	if _, _, err := store.Refund(ctx, "discounted", RefundRequest{RefundID: "r4", Items: []RefundItem{{SKU: "B", Quantity: 1}}}); !errors.Is(err, ErrRefundExceedsCapture) {
+		t.Fatalf("expected ErrRefundExceedsCapture, got %v", err)
+	}
+	if _, _, err := store.Refund(ctx, "pending", RefundRequest{RefundID: "r5"}); !errors.Is(err, ErrNotRefundable) {
+		t.Fatalf("expected ErrNotRefundable for a pending order, got %v", err)
+	}
+	if _, _, err := store.Refund(ctx, "missing", RefundRequest{RefundID: "r6"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// a refund computed from a stale read loses the conditional update
+	stale, _ := store.Get(ctx, "discounted")
+	if _, _, err := store.Refund(ctx, "discounted", RefundRequest{RefundID: "r7", Items: []RefundItem{{SKU: "A", Quantity: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.addRefund(ctx, stale, Refund{RefundID: "r8", Amount: usd(300)}, "api"); !errors.Is(err, ErrStatusMismatch) {
+		t.Fatalf("expected ErrStatusMismatch for a stale refund, got %v", err)
+	}
+	if got, _ = store.Get(ctx, "discounted"); got.RefundedTotal != 500 || len(got.Refunds) != 1 {
+		t.Fatalf("unexpected refunds %+v", got.Refunds)
+	}
+}
+
+func TestSettleRefund_ReplacesTheRefundAtItsIndex(t *testing.T) {
+	db := newFakeDynamo()
+	now := time.Now()
+	item, _ := attributevalue.MarshalMap(Order{OrderID: "o1", Status: StatusRefunded, CreatedAt: now, UpdatedAt: now,
+		Refunds: []Refund{{RefundID: "r1", Status: RefundPending, CreatedAt: now}}})
+	db.Put(ordersTable, item)
+	store := NewStore(db, ordersTable)
+	ctx := context.Background()
+
+	if err := store.SettleRefund(ctx, "o1", 0, Refund{RefundID: "r2", Status: RefundSucceeded}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for another refund's index, got %v", err)
+	}
+	if err := store.SettleRefund(ctx, "o1", 0, Refund{RefundID: "r1", Status: RefundSucceeded, ProviderID: "re_1", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := store.Get(ctx, "o1")
+	if r := got.Refunds[0]; r.Status != RefundSucceeded || r.ProviderID != "re_1" || r.SettledAt == nil {
+		t.Fatalf("unexpected refund %+v", r)
+	}
+}
+
+func TestSettleRefund_FailedRefundGivesItsAmountBack(t *testing.T) {
+	db := newFakeDynamo()
+	now := time.Now()
+	usd := func(n int64) money.Money { return money.Money{Amount: n, Currency: "USD"} }
+	item, _ := attributevalue.MarshalMap(Order{OrderID: "o1", Status: StatusCompleted, Amount: usd(2000), CreatedAt: now, UpdatedAt: now,
+		Items:    []map[string]interface{}{{"sku": "A", "quantity": 2, "price": usd(500)}, {"sku": "B", "quantity": 1, "price": usd(1000)}},
+		Payments: []PaymentAttempt{{Operation: "capture", Status: PaymentSucceeded, Amount: usd(2000)}},
+	})
+	db.Put(ordersTable, item)
+	store := NewStore(db, ordersTable)
+	ctx := context.Background()
+
+	if _, _, err := store.Refund(ctx, "o1", RefundRequest{RefundID: "r1", IdempotencyKey: "k1", Items: []RefundItem{{SKU: "A", Quantity: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := store.Refund(ctx, "o1", RefundRequest{RefundID: "r2", IdempotencyKey: "k2"})
+	if err != nil || got.Status != StatusRefunded {
+		t.Fatalf("expected a full refund, got %+v, %v", got, err)
+	}
+
+	// the provider rejects the rest: it can be refunded again
+	failed := got.Refunds[1]
+	failed.Status, failed.Error = RefundFailed, "declined"
+	if err := store.SettleRefund(ctx, "o1", 1, failed); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = store.Get(ctx, "o1")
+	last := got.StatusHistory[len(got.StatusHistory)-1]
+	if got.RefundedTotal != 500 || got.Status != StatusPartiallyRefunded || got.Refunds[1].Status != RefundFailed || last.From != StatusRefunded || last.To != StatusPartiallyRefunded {
+		t.Fatalf("expected the failed amount given back, got %d %s %+v", got.RefundedTotal, got.Status, last)
+	}
+	// settling it again changes nothing
+	if err := store.SettleRefund(ctx, "o1", 1, failed); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = store.Get(ctx, "o1"); got.RefundedTotal != 500 || len(got.StatusHistory) != 3 {
+		t.Fatalf("a repeated settle must not give the amount back twice: %d %+v", got.RefundedTotal, got.StatusHistory)
+	}
+
+	got, refund, err := store.Refund(ctx, "o1", RefundRequest{RefundID: "r3", IdempotencyKey: "k3", Items: []RefundItem{{SKU: "B", Quantity: 1}}})
+	if err != nil || refund.Amount != usd(1000) || got.RefundedTotal != 1500 || got.Status != StatusPartiallyRefunded {
+		t.Fatalf("expected a new refund of the failed items, got %+v, %+v, %v", refund, got, err)
+	}
+
+	// failing every refund takes the order back to COMPLETED
+	for _, id := range []string{"r1", "r3"} {
+		i := got.FindRefund(id)
+		r := got.Refunds[i]
+		r.Status = RefundFailed
+		if err := store.SettleRefund(ctx, "o1", i, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ = store.Get(ctx, "o1"); got.RefundedTotal != 0 || got.Status != StatusCompleted {
+		t.Fatalf("expected COMPLETED with nothing refunded, got %d %s", got.RefundedTotal, got.Status)
+	}
+	// the total is back at zero, not unset: the order can still be refunded
+	got, refund, err = store.Refund(ctx, "o1", RefundRequest{RefundID: "r4", IdempotencyKey: "k4", Items: []RefundItem{{SKU: "A", Quantity: 1}}})
+	if err != nil || refund.Amount != usd(500) || got.RefundedTotal != 500 || got.Status != StatusPartiallyRefunded {
+		t.Fatalf("expected a refund after every earlier one failed, got %+v, %+v, %v", refund, got, err)
+	}
+}
+
+func TestLineItems_DecodesStoredItems(t *testing.T) {
+	db := newFakeDynamo()
+	item, _ := attributevalue.MarshalMap(Order{OrderID: "o1", Status: StatusPending, Items: []map[string]interface{}{
+		{"sku": "A", "quantity": 2, "price": money.Money{Amount: 250, Currency: "USD"}},
+		{"sku": "B", "quantity": 1},
+	}})
+	db.Put(ordersTable, item)
+	got, err := NewStore(db, ordersTable).Get(context.Background(), "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := got.LineItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LineItem{
+		{SKU: "A", Quantity: 2, Price: money.Money{Amount: 250, Currency: "USD"}},
+		{SKU: "B", Quantity: 1},
+	}
+	if len(items) != 2 || items[0] != want[0] || items[1] != want[1] {
+		t.Fatalf("expected %+v, got %+v", want, items)
+	}
+
+	for _, bad := range []map[string]interface{}{{"quantity": 1}, {"sku": "A", "quantity": 1.5}, {"sku": "A", "quantity": 0}} {
+		if _, err := (&Order{Items: []map[string]interface{}{bad}}).LineItems(); err == nil {
+			t.Fatalf("expected %v to be rejected", bad)
+		}
+	}
+}
+
+func TestListByCustomer_PaginationAndFilters(t *testing.T) {
+	db := newFakeDynamo()
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	statuses := []string{StatusPending, StatusCompleted, StatusPending, StatusFailed, StatusCompleted}
+	for i, st := range statuses {
+		item, _ := attributevalue.MarshalMap(Order{
+			OrderID:    fmt.Sprintf("order-%d", i),
+			CustomerID: "cust-1",
+			Status:     st,
+			CreatedAt:  base.Add(time.Duration(i) * time.Hour),
+			UpdatedAt:  base,
+		})
+		db.Put(ordersTable, item)
+	}
+	other, _ := attributevalue.MarshalMap(Order{OrderID: "other", CustomerID: "cust-2", Status: StatusPending, CreatedAt: base})
+	db.Put(ordersTable, other)
+
+	store := NewStore(db, ordersTable)
+	ctx := context.Background()
+
+	// page through all of cust-1's orders, newest first
+	var got []string
+	cursor := ""
+	for {
+		res, err := store.ListByCustomer(ctx, "cust-1", ListOptions{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("ListByCustomer: %v", err)
+		}
+		for _, o := range res.Orders {
+			got = append(got, o.OrderID)
+		}
+		if res.NextCursor == "" {
+			break
+		}
+		cursor = res.NextCursor
+	}
+	want := []string{"order-4", "order-3", "order-2", "order-1", "order-0"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	// status + date range filters
+	res, err := store.ListByCustomer(ctx, "cust-1", ListOptions{
+		Statuses:    []string{StatusCompleted},
+		CreatedFrom: base.Add(30 * time.Minute),
+		CreatedTo:   base.Add(3 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("ListByCustomer filtered: %v", err)
+	}
+	if len(res.Orders) != 1 || res.Orders[0].OrderID != "order-1" {
+		t.Fatalf("expected only order-1, got %+v", res.Orders)
+	}
+
+	if _, err := store.ListByCustomer(ctx, "cust-1", ListOptions{Cursor: "not-a-cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+
+	// a cursor only pages through the customer it was issued for
+	first, err := store.ListByCustomer(ctx, "cust-1", ListOptions{Limit: 2})
+	if err != nil || first.NextCursor == "" {
+		t.Fatalf("expected a cursor, got %+v, %v", first, err)
+	}
+	if _, err := store.ListByCustomer(ctx, "cust-2", ListOptions{Cursor: first.NextCursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor for another customer's cursor, got %v", err)
+	}
+}
+
+func TestListByCustomer_BoundsAtFractionalSeconds(t *testing.T) {
+	db := newFakeDynamo()
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, offset := range []time.Duration{0, 500 * time.Millisecond, time.Second, 1250 * time.Millisecond} {
+		item, err := attributevalue.MarshalMap(Order{
+			OrderID:    fmt.Sprintf("order-%d", i),
+			CustomerID: "cust-1",
+			Status:     StatusPending,
+			CreatedAt:  base.Add(offset),
+		})
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		db.Put(ordersTable, item)
+	}
+	store := NewStore(db, ordersTable)
+	ctx := context.Background()
+
+	// in RFC3339Nano "00Z" sorts after "00.5Z" and "01.25Z" before "01Z"; neither may leak in
+	res, err := store.ListByCustomer(ctx, "cust-1", ListOptions{
+		CreatedFrom: base.Add(500 * time.Millisecond),
+		CreatedTo:   base.Add(time.Second),
+	})
+	if err != nil {
+		t.Fatalf("ListByCustomer: %v", err)
+	}
+	var got []string
+	for _, o := range res.Orders {
+		got = append(got, o.OrderID)
+	}
+	if strings.Join(got, ",") != "order-2,order-1" {
+		t.Fatalf("expected order-2,order-1, got %v", got)
+	}
+	if !res.Orders[1].CreatedAt.Equal(base.Add(500 * time.Millisecond)) {
+		t.Fatalf("created_at did not round-trip: %s", res.Orders[1].CreatedAt)
+	}
+
+	// newest first across fractional and whole seconds
+	all, err := store.ListByCustomer(ctx, "cust-1", ListOptions{})
+	if err != nil {
+		t.Fatalf("ListByCustomer: %v", err)
+	}
+	got = got[:0]
+	for _, o := range all.Orders {
+		got = append(got, o.OrderID)
+	}
+	if strings.Join(got, ",") != "order-3,order-2,order-1,order-0" {
+		t.Fatalf("expected newest first, got %v", got)
+	}
+}
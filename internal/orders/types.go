@@ -1,6 +1,14 @@
 package orders
 
-import "time"
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+)
 
 // Order statuses
 const (
@@ -8,17 +16,230 @@ const (
 	StatusProcessing = "PROCESSING"
 	StatusCompleted  = "COMPLETED"
 	StatusFailed     = "FAILED"
+	StatusOnHold     = "ON_HOLD"
+	StatusCancelled  = "CANCELLED"
+	StatusRefunded   = "REFUNDED"
+
+	StatusPartiallyRefunded = "PARTIALLY_REFUNDED"
 )
 
+// CustomerIndex is the GSI on the orders table keyed by customer_id (hash) and created_at (range).
+const CustomerIndex = "customer_id_index"
+
+// CreatedAtLayout is how created_at is stored: fixed-width UTC with nanoseconds, so the customer index
+// sorts it, and ListByCustomer compares its bounds, chronologically. RFC3339Nano drops trailing zeros,
+// which makes "...:00Z" sort after "...:00.5Z".
+const CreatedAtLayout = "2006-01-02T15:04:05.000000000Z"
+
+// formatCreatedAt formats t as stored in created_at.
+func formatCreatedAt(t time.Time) string { return t.UTC().Format(CreatedAtLayout) }
+
 // Order represents the item stored in the Orders DynamoDB table.
 type Order struct {
-	OrderID    string                   `dynamodbav:"order_id"`              // PK
-	CustomerID string                   `dynamodbav:"customer_id,omitempty"` // customer reference
-	Status     string                   `dynamodbav:"status"`                // PENDING | PROCESSING | COMPLETED | FAILED
-	Amount     float64                  `dynamodbav:"amount"`
-	Items      []map[string]interface{} `dynamodbav:"items,omitempty"` // flexible storage; can be refined
-	Metadata   map[string]interface{}   `dynamodbav:"metadata,omitempty"`
-	CreatedAt  time.Time                `dynamodbav:"created_at"`
-	UpdatedAt  time.Time                `dynamodbav:"updated_at"`
-	Attempts   int                      `dynamodbav:"attempts,omitempty"`
+	OrderID    string                   `dynamodbav:"order_id" json:"order_id"`                           // PK
+	CustomerID string                   `dynamodbav:"customer_id,omitempty" json:"customer_id,omitempty"` // customer reference
+	Status     string                   `dynamodbav:"status" json:"status"`                               // see Lifecycle for the allowed values
+	Amount     money.Money              `dynamodbav:"amount" json:"amount"`                               // order total in minor units
+	Items      []map[string]interface{} `dynamodbav:"items,omitempty" json:"items,omitempty"`             // flexible storage; can be refined
+	Metadata   map[string]interface{}   `dynamodbav:"metadata,omitempty" json:"metadata,omitempty"`
+	CreatedAt  time.Time                `dynamodbav:"created_at" json:"created_at"`
+	UpdatedAt  time.Time                `dynamodbav:"updated_at" json:"updated_at"`
+	Attempts   int                      `dynamodbav:"attempts,omitempty" json:"attempts,omitempty"`
+
+	StatusHistory  []StatusChange   `dynamodbav:"status_history,omitempty" json:"status_history,omitempty"`             // oldest first
+	CompletedSteps []string         `dynamodbav:"completed_steps,stringset,omitempty" json:"completed_steps,omitempty"` // worker pipeline checkpoints, see Store.CompleteStep
+	Payments       []PaymentAttempt `dynamodbav:"payments,omitempty" json:"payments,omitempty"`                         // calls to the payment provider, oldest first, see Store.RecordPayment
+	Refunds        []Refund         `dynamodbav:"refunds,omitempty" json:"refunds,omitempty"`                           // refunds requested after completion, oldest first, see Store.Refund
+	RefundedTotal  int64            `dynamodbav:"refunded_total,omitempty" json:"refunded_total,omitempty"`             // sum of Refunds in minor units of Amount's currency
+
+	// Saga state of the worker pipeline: the compensable steps started for the order and the ones undone
+	// since it was cancelled or failed. SagaStatus is SagaCompensated once every compensation has run.
+	SagaStatus      string   `dynamodbav:"saga_status,omitempty" json:"saga_status,omitempty"`
+	SagaActions     []string `dynamodbav:"saga_actions,stringset,omitempty" json:"saga_actions,omitempty"`
+	SagaCompensated []string `dynamodbav:"saga_compensated,stringset,omitempty" json:"saga_compensated,omitempty"`
+}
+
+// MarshalDynamoDBAttributeValue marshals the order with created_at in CreatedAtLayout. Reading it back
+// needs nothing special: the layout is valid RFC3339.
+func (o Order) MarshalDynamoDBAttributeValue() (types.AttributeValue, error) {
+	type plain Order // without this method
+	av, err := attributevalue.Marshal(plain(o))
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := av.(*types.AttributeValueMemberM); ok {
+		m.Value["created_at"] = &types.AttributeValueMemberS{Value: formatCreatedAt(o.CreatedAt)}
+	}
+	return av, nil
+}
+
+// SagaCompensated is the SagaStatus of an order whose saga has been fully compensated.
+const SagaCompensated = "COMPENSATED"
+
+// StepCompleted reports whether the worker pipeline step name has been checkpointed for the order.
+func (o *Order) StepCompleted(name string) bool { return contains(o.CompletedSteps, name) }
+
+// ActionStarted reports whether the compensable step name has been started for the order.
+func (o *Order) ActionStarted(name string) bool { return contains(o.SagaActions, name) }
+
+// ActionCompensated reports whether the compensation of step name has run for the order.
+func (o *Order) ActionCompensated(name string) bool { return contains(o.SagaCompensated, name) }
+
+func contains(set []string, s string) bool {
+	for _, v := range set {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Payment attempt statuses
+const (
+	PaymentPending   = "PENDING" // the call was made or is about to be; its outcome is unknown
+	PaymentSucceeded = "SUCCEEDED"
+	PaymentFailed    = "FAILED"
+)
+
+// PaymentAttempt records one call to the payment provider. The worker appends a PENDING entry before the
+// call and another entry with the outcome after it, so after a crash the last entry for an operation
+// tells whether to retry the call (with the same idempotency key) or use its result.
+type PaymentAttempt struct {
+	Operation       string      `dynamodbav:"operation" json:"operation"` // authorize, capture, void or refund
+	IdempotencyKey  string      `dynamodbav:"idempotency_key" json:"idempotency_key"`
+	Status          string      `dynamodbav:"status" json:"status"`
+	Amount          money.Money `dynamodbav:"amount" json:"amount"`
+	ProviderID      string      `dynamodbav:"provider_id,omitempty" json:"provider_id,omitempty"`           // the provider's ID of the operation
+	AuthorizationID string      `dynamodbav:"authorization_id,omitempty" json:"authorization_id,omitempty"` // the authorization it applies to
+	Error           string      `dynamodbav:"error,omitempty" json:"error,omitempty"`
+	At              time.Time   `dynamodbav:"at" json:"at"`
+}
+
+// LastPayment returns the most recent attempt of operation, or nil if there is none.
+func (o *Order) LastPayment(operation string) *PaymentAttempt {
+	for i := len(o.Payments) - 1; i >= 0; i-- {
+		if o.Payments[i].Operation == operation {
+			return &o.Payments[i]
+		}
+	}
+	return nil
+}
+
+// Refund statuses
+const (
+	RefundPending   = "PENDING" // recorded by the API; the worker has not settled it with the provider yet
+	RefundSucceeded = "SUCCEEDED"
+	RefundFailed    = "FAILED"
+)
+
+// Refund is money given back for a completed order, in full or for some of its line items. The API
+// records it PENDING and enqueues a job; the worker makes the provider call and records the outcome.
+type Refund struct {
+	RefundID       string       `dynamodbav:"refund_id" json:"refund_id"`
+	IdempotencyKey string       `dynamodbav:"idempotency_key" json:"-"` // of the API request that created it
+	Status         string       `dynamodbav:"status" json:"status"`
+	Amount         money.Money  `dynamodbav:"amount" json:"amount"`
+	Items          []RefundItem `dynamodbav:"items,omitempty" json:"items,omitempty"` // empty for a full refund
+	Reason         string       `dynamodbav:"reason,omitempty" json:"reason,omitempty"`
+	ProviderID     string       `dynamodbav:"provider_id,omitempty" json:"provider_id,omitempty"` // the provider's ID of the refund
+	Error          string       `dynamodbav:"error,omitempty" json:"error,omitempty"`
+	CreatedAt      time.Time    `dynamodbav:"created_at" json:"created_at"`
+	SettledAt      *time.Time   `dynamodbav:"settled_at,omitempty" json:"settled_at,omitempty"`
+}
+
+// RefundItem is one refunded line item.
+type RefundItem struct {
+	SKU      string `dynamodbav:"sku" json:"sku"`
+	Quantity int    `dynamodbav:"quantity" json:"quantity"`
+}
+
+// FindRefund returns the index of the refund with id in o.Refunds, or -1 if there is none.
+func (o *Order) FindRefund(id string) int {
+	for i := range o.Refunds {
+		if o.Refunds[i].RefundID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Captured returns the amount the customer was charged for the order: the successful capture, or the
+// order total if the order never went through a payment gateway. It is zero while nothing was captured.
+func (o *Order) Captured() money.Money {
+	if len(o.Payments) == 0 {
+		return o.Amount
+	}
+	if c := o.LastPayment("capture"); c != nil && c.Status == PaymentSucceeded {
+		return c.Amount
+	}
+	return money.Money{Currency: o.Amount.Currency}
+}
+
+// StatusChange records one status transition of an order.
+// From is empty for the entry written when the order is created.
+type StatusChange struct {
+	From   string    `dynamodbav:"from,omitempty" json:"from,omitempty"`
+	To     string    `dynamodbav:"to" json:"to"`
+	Actor  string    `dynamodbav:"actor,omitempty" json:"actor,omitempty"`   // who made the change, e.g. "worker" or "api"
+	Reason string    `dynamodbav:"reason,omitempty" json:"reason,omitempty"` // free-form explanation
+	At     time.Time `dynamodbav:"at" json:"at"`
+}
+
+// ListOptions filters and pages a customer's orders. Zero values mean "no filter".
+type ListOptions struct {
+	Limit       int32     // page size; the store applies a default when zero
+	Cursor      string    // opaque token from a previous ListResult.NextCursor
+	Statuses    []string  // only return orders in one of these statuses
+	CreatedFrom time.Time // inclusive lower bound on created_at
+	CreatedTo   time.Time // inclusive upper bound on created_at
+}
+
+// ListResult is one page of orders, newest first.
+type ListResult struct {
+	Orders     []Order `json:"orders"`
+	NextCursor string  `json:"next_cursor,omitempty"` // empty when there are no more pages
+}
+
+// LineItem is one typed entry of Order.Items.
+type LineItem struct {
+	SKU      string
+	Quantity int
+	Price    money.Money // per unit; zero when the item was stored without a price
+}
+
+// LineItems decodes Order.Items, which are stored untyped. It fails on an item without a SKU or a
+// positive whole quantity.
+func (o *Order) LineItems() ([]LineItem, error) {
+	out := make([]LineItem, 0, len(o.Items))
+	for i, it := range o.Items {
+		sku, _ := it["sku"].(string)
+		qty, ok := wholeNumber(it["quantity"])
+		if sku == "" || !ok || qty < 1 {
+			return nil, fmt.Errorf("item %d: want a sku and a positive quantity, got %v", i, it)
+		}
+		li := LineItem{SKU: sku, Quantity: int(qty)}
+		switch p := it["price"].(type) {
+		case money.Money:
+			li.Price = p
+		case map[string]interface{}:
+			amount, _ := wholeNumber(p["amount"])
+			currency, _ := p["currency"].(string)
+			li.Price = money.Money{Amount: amount, Currency: currency}
+		}
+		out = append(out, li)
+	}
+	return out, nil
+}
+
+// wholeNumber converts the numeric types an item field may hold after a JSON or DynamoDB round trip.
+func wholeNumber(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), n == float64(int64(n))
+	}
+	return 0, false
 }
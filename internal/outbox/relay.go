@@ -0,0 +1,120 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+)
+
+// DefaultBatchSize is how many PENDING entries the relay reads per query.
+const DefaultBatchSize = 25
+
+// Relay publishes outbox entries to SQS and marks them sent.
+// Delivery is at-least-once: an entry published but not yet marked sent (e.g., the relay crashed)
+// is published again on the next run, so consumers must be idempotent.
+type Relay struct {
+	store     *Store
+	publisher *aws.Publisher
+	batchSize int32
+}
+
+// NewRelay creates a Relay reading from store and publishing via publisher.
+func NewRelay(store *Store, publisher *aws.Publisher) *Relay {
+	return &Relay{
+		store:     store,
+		publisher: publisher,
+		batchSize: DefaultBatchSize,
+	}
+}
+
+// Deliver publishes a single entry and marks it sent.
+// On publish failure the failure is recorded and the entry stays PENDING for a later run.
+func (r *Relay) Deliver(ctx context.Context, entry Entry) error {
+	if err := r.publisher.SendOrderMessage(ctx, entry.MessageBody, entry.Attributes); err != nil {
+		if rerr := r.store.RecordFailure(ctx, entry.OutboxID, err.Error()); rerr != nil {
+			entryLogger(ctx, entry).Warn("outbox failure could not be recorded", logging.Err(rerr))
+		}
+		return fmt.Errorf("publish outbox entry %s: %w", entry.OutboxID, err)
+	}
+	if err := r.store.MarkSent(ctx, entry.OutboxID); err != nil && !errors.Is(err, ErrAlreadySent) {
+		// published but not marked: it will be published again, which consumers tolerate
+		return fmt.Errorf("mark outbox entry %s sent: %w", entry.OutboxID, err)
+	}
+	return nil
+}
+
+// RunOnce delivers the oldest batch of PENDING entries and returns how many were sent.
+// Per-entry failures are logged and left for the next run; only listing errors are returned.
+func (r *Relay) RunOnce(ctx context.Context) (int, error) {
+	entries, err := r.store.ListPending(ctx, r.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	return r.deliverAll(ctx, entries), nil
+}
+
+// Drain delivers every PENDING entry once, a batch at a time, and returns how many were sent. It pages
+// past entries that fail instead of reading them again, so entries that keep failing, however many, never
+// hold back newer ones; they are retried on the next run.
+func (r *Relay) Drain(ctx context.Context) (int, error) {
+	total := 0
+	var after map[string]types.AttributeValue
+	for {
+		entries, next, err := r.store.ListPendingAfter(ctx, r.batchSize, after)
+		if err != nil {
+			return total, err
+		}
+		total += r.deliverAll(ctx, entries)
+		if next == nil {
+			return total, nil
+		}
+		after = next
+	}
+}
+
+// deliverAll delivers entries, logging failures, and returns how many were sent.
+func (r *Relay) deliverAll(ctx context.Context, entries []Entry) int {
+	sent := 0
+	for _, e := range entries {
+		if err := r.Deliver(ctx, e); err != nil {
+			entryLogger(ctx, e).Warn("outbox delivery failed", "attempts", e.Attempts+1, logging.Err(err))
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// Run drains the outbox every interval until ctx is cancelled. Used when running outside Lambda.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := r.Drain(ctx); err != nil {
+			logging.FromContext(ctx).Error("outbox drain failed", logging.Err(err))
+		} else if n > 0 {
+			logging.FromContext(ctx).Info("outbox relayed entries", "count", n)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// entryLogger returns the context logger with the entry's IDs, including the correlation ID of the
+// request that wrote it, so relay lines join the API and worker lines for the same order.
+func entryLogger(ctx context.Context, e Entry) *slog.Logger {
+	return logging.FromContext(ctx).With(
+		logging.KeyOutboxID, e.OutboxID,
+		logging.KeyOrderID, e.OrderID,
+		logging.KeyCorrelationID, e.Attributes[logging.KeyCorrelationID],
+	)
+}
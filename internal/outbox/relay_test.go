@@ -0,0 +1,161 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	dyn "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/testing/dynamofake"
+)
+
+func newFakeDynamo() *dynamofake.Fake {
+	return dynamofake.New(dynamofake.TableSchema{
+		Name:    "outbox",
+		HashKey: "outbox_id",
+		Indexes: []dynamofake.IndexSchema{{Name: StatusIndex, HashKey: "status", RangeKey: "created_at"}},
+	})
+}
+
+// mockSQS records sent bodies and fails while failNext > 0, and always for bodies in reject.
+type mockSQS struct {
+	sent     []string
+	failNext int
+	reject   map[string]bool
+}
+
+func (m *mockSQS) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	if m.failNext > 0 {
+		m.failNext--
+		return nil, errors.New("sqs unavailable")
+	}
+	if m.reject[*params.MessageBody] {
+		return nil, errors.New("message rejected")
+	}
+	m.sent = append(m.sent, *params.MessageBody)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func (m *mockSQS) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	return &sqs.ReceiveMessageOutput{}, nil
+}
+
+func (m *mockSQS) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (m *mockSQS) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func commit(t *testing.T, db *dynamofake.Fake, store *Store, entries ...Entry) {
+	t.Helper()
+	var items []types.TransactWriteItem
+	for _, e := range entries {
+		put, err := store.TransactPut(e)
+		if err != nil {
+			t.Fatalf("TransactPut: %v", err)
+		}
+		items = append(items, put)
+	}
+	if _, err := db.TransactWriteItems(context.Background(), &dyn.TransactWriteItemsInput{TransactItems: items}); err != nil {
+		t.Fatalf("transact: %v", err)
+	}
+}
+
+func TestRelay_DeliversPendingAndRetriesFailures(t *testing.T) {
+	db := newFakeDynamo()
+	q := &mockSQS{failNext: 1}
+	store := NewStore(db, "outbox", 48*time.Hour)
+	relay := NewRelay(store, aws.NewPublisher(q, "queue-url"))
+	ctx := context.Background()
+
+	e1 := store.NewEntry("o1", `{"order_id":"o1"}`, map[string]string{"order_id": "o1"})
+	e2 := store.NewEntry("o2", `{"order_id":"o2"}`, nil)
+	commit(t, db, store, e1, e2)
+
+	// first publish fails: that entry stays PENDING with the error recorded
+	sent, err := relay.RunOnce(ctx)
+	if err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if sent != 1 {
+		t.Fatalf("expected 1 sent on first run, got %d", sent)
+	}
+	pending, err := store.ListPending(ctx, 10)
+	if err != nil {
+		t.Fatalf("ListPending: %v", err)
+	}
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError == "" {
+		t.Fatalf("expected one pending entry with a recorded failure, got %+v", pending)
+	}
+
+	// next run delivers the remainder
+	if n, err := relay.Drain(ctx); err != nil || n != 1 {
+		t.Fatalf("expected Drain to send 1, got n=%d err=%v", n, err)
+	}
+	if pending, _ := store.ListPending(ctx, 10); len(pending) != 0 {
+		t.Fatalf("expected outbox to be empty, got %+v", pending)
+	}
+	if len(q.sent) != 2 {
+		t.Fatalf("expected 2 messages published, got %d", len(q.sent))
+	}
+
+	// a concurrent relay already marked the entry sent
+	if err := store.MarkSent(ctx, e1.OutboxID); !errors.Is(err, ErrAlreadySent) {
+		t.Fatalf("expected ErrAlreadySent, got %v", err)
+	}
+}
+
+func TestRelay_DrainPagesPastEntriesThatKeepFailing(t *testing.T) {
+	db := newFakeDynamo()
+	q := &mockSQS{reject: map[string]bool{}}
+	store := NewStore(db, "outbox", 48*time.Hour)
+	now := time.Now()
+	store.nowFunc = func() time.Time { now = now.Add(time.Second); return now }
+	relay := NewRelay(store, aws.NewPublisher(q, "queue-url"))
+	relay.batchSize = 2
+	ctx := context.Background()
+
+	// a full batch of the oldest entries can never be published
+	var entries []Entry
+	for _, id := range []string{"bad-1", "bad-2", "bad-3", "o1", "o2"} {
+		body := `{"order_id":"` + id + `"}`
+		if strings.HasPrefix(id, "bad") {
+			q.reject[body] = true
+		}
+		entries = append(entries, store.NewEntry(id, body, nil))
+	}
+	commit(t, db, store, entries...)
+
+	if sent, err := relay.RunOnce(ctx); err != nil || sent != 0 {
+		t.Fatalf("expected the oldest batch to fail, got sent=%d err=%v", sent, err)
+	}
+	// Drain gets past them to the newer entries, every run
+	for run := 0; run < 2; run++ {
+		if n, err := relay.Drain(ctx); err != nil || n != 2-2*run {
+			t.Fatalf("run %d: expected Drain to send %d, got n=%d err=%v", run, 2-2*run, n, err)
+		}
+	}
+	if len(q.sent) != 2 || q.sent[0] != `{"order_id":"o1"}` || q.sent[1] != `{"order_id":"o2"}` {
+		t.Fatalf("expected the newer entries published, got %v", q.sent)
+	}
+	pending, _ := store.ListPending(ctx, 10)
+	if len(pending) != 3 {
+		t.Fatalf("expected the failing entries to stay PENDING, got %+v", pending)
+	}
+	for _, e := range pending {
+		want := 3 // RunOnce and both drains
+		if e.OrderID == "bad-3" {
+			want = 2 // past RunOnce's batch
+		}
+		if e.Attempts != want {
+			t.Fatalf("expected %s tried %d times, got %+v", e.OrderID, want, e)
+		}
+	}
+}
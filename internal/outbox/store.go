@@ -0,0 +1,155 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	dyn "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/uuid"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+)
+
+// ErrAlreadySent indicates the entry was no longer PENDING when marking it sent (e.g., a concurrent relay).
+var ErrAlreadySent = errors.New("outbox entry already sent")
+
+// Store encapsulates operations on the outbox table.
+type Store struct {
+	client    aws.DynamoDBAPI
+	tableName string
+	ttlWindow time.Duration // how long entries are retained (sent or not) before TTL deletes them
+	nowFunc   func() time.Time
+}
+
+// NewStore creates a new outbox Store.
+func NewStore(client aws.DynamoDBAPI, tableName string, ttlWindow time.Duration) *Store {
+	return &Store{
+		client:    client,
+		tableName: tableName,
+		ttlWindow: ttlWindow,
+		nowFunc:   time.Now,
+	}
+}
+
+// NewEntry builds a PENDING entry for an order message. It is not persisted until
+// TransactPut is included in the caller's TransactWriteItems.
+func (s *Store) NewEntry(orderID, messageBody string, attributes map[string]string) Entry {
+	now := s.nowFunc()
+	return Entry{
+		OutboxID:    uuid.NewString(),
+		Status:      StatusPending,
+		OrderID:     orderID,
+		MessageBody: messageBody,
+		Attributes:  attributes,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		ExpiresAt:   now.Add(s.ttlWindow).Unix(),
+	}
+}
+
+// TransactPut returns the TransactWriteItem that inserts entry, so it commits atomically
+// with the state change that produced it.
+func (s *Store) TransactPut(entry Entry) (types.TransactWriteItem, error) {
+	item, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("marshal outbox entry: %w", err)
+	}
+	return types.TransactWriteItem{
+		Put: &types.Put{
+			TableName:           &s.tableName,
+			Item:                item,
+			ConditionExpression: awsString("attribute_not_exists(outbox_id)"),
+		},
+	}, nil
+}
+
+// ListPending returns up to limit PENDING entries, oldest first.
+func (s *Store) ListPending(ctx context.Context, limit int32) ([]Entry, error) {
+	entries, _, err := s.ListPendingAfter(ctx, limit, nil)
+	return entries, err
+}
+
+// ListPendingAfter returns up to limit PENDING entries, oldest first, starting after the key returned with
+// the previous page (nil for the first page). The returned key is nil after the last page.
+func (s *Store) ListPendingAfter(ctx context.Context, limit int32, after map[string]types.AttributeValue) ([]Entry, map[string]types.AttributeValue, error) {
+	out, err := s.client.Query(ctx, &dyn.QueryInput{
+		TableName:                &s.tableName,
+		IndexName:                awsString(StatusIndex),
+		KeyConditionExpression:   awsString("#s = :pending"),
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: StatusPending},
+		},
+		Limit:             &limit,
+		ScanIndexForward:  awsBool(true),
+		ExclusiveStartKey: after,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("query pending outbox entries: %w", err)
+	}
+	var entries []Entry
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &entries); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal outbox entries: %w", err)
+	}
+	if len(out.LastEvaluatedKey) == 0 {
+		return entries, nil, nil
+	}
+	return entries, out.LastEvaluatedKey, nil
+}
+
+// MarkSent transitions an entry PENDING -> SENT. Returns ErrAlreadySent if it was not PENDING.
+func (s *Store) MarkSent(ctx context.Context, outboxID string) error {
+	now := s.nowFunc()
+	_, err := s.client.UpdateItem(ctx, &dyn.UpdateItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"outbox_id": &types.AttributeValueMemberS{Value: outboxID},
+		},
+		UpdateExpression:         awsString("SET #s = :sent, updated_at = :ua"),
+		ConditionExpression:      awsString("#s = :pending"),
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sent":    &types.AttributeValueMemberS{Value: StatusSent},
+			":pending": &types.AttributeValueMemberS{Value: StatusPending},
+			":ua":      &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		var sc smithy.APIError
+		if errors.As(err, &sc) && sc.ErrorCode() == "ConditionalCheckFailedException" {
+			return ErrAlreadySent
+		}
+		return fmt.Errorf("update item (mark sent): %w", err)
+	}
+	return nil
+}
+
+// RecordFailure increments the attempts counter and stores the last publish error; the entry stays PENDING.
+func (s *Store) RecordFailure(ctx context.Context, outboxID, lastError string) error {
+	now := s.nowFunc()
+	_, err := s.client.UpdateItem(ctx, &dyn.UpdateItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"outbox_id": &types.AttributeValueMemberS{Value: outboxID},
+		},
+		UpdateExpression: awsString("SET attempts = if_not_exists(attempts, :zero) + :inc, last_error = :err, updated_at = :ua"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":zero": &types.AttributeValueMemberN{Value: "0"},
+			":inc":  &types.AttributeValueMemberN{Value: "1"},
+			":err":  &types.AttributeValueMemberS{Value: lastError},
+			":ua":   &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("update item (record failure): %w", err)
+	}
+	return nil
+}
+
+func awsString(s string) *string { return &s }
+
+func awsBool(b bool) *bool { return &b }
@@ -0,0 +1,28 @@
+package outbox
+
+import "time"
+
+// Outbox entry statuses
+const (
+	StatusPending = "PENDING"
+	StatusSent    = "SENT"
+)
+
+// StatusIndex is the GSI on the outbox table keyed by status (hash) and created_at (range).
+// The relay queries it for PENDING entries, oldest first.
+const StatusIndex = "status_index"
+
+// Entry is a message waiting to be published, persisted in the outbox DynamoDB table
+// in the same transaction as the state change that produced it.
+type Entry struct {
+	OutboxID    string            `dynamodbav:"outbox_id"` // PK
+	Status      string            `dynamodbav:"status"`    // PENDING | SENT
+	OrderID     string            `dynamodbav:"order_id,omitempty"`
+	MessageBody string            `dynamodbav:"message_body"`
+	Attributes  map[string]string `dynamodbav:"attributes,omitempty"` // sent as SQS MessageAttributes
+	Attempts    int               `dynamodbav:"attempts,omitempty"`
+	LastError   string            `dynamodbav:"last_error,omitempty"`
+	CreatedAt   time.Time         `dynamodbav:"created_at"`
+	UpdatedAt   time.Time         `dynamodbav:"updated_at"`
+	ExpiresAt   int64             `dynamodbav:"expires_at"` // TTL epoch seconds
+}
@@ -0,0 +1,247 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+)
+
+// Op names a gateway operation.
+type Op string
+
+// Gateway operations.
+const (
+	OpAuthorize Op = "authorize"
+	OpCapture   Op = "capture"
+	OpVoid      Op = "void"
+	OpRefund    Op = "refund"
+)
+
+// Fault is a failure the Fake injects into a call.
+type Fault int
+
+const (
+	// FaultTimeout fails the call with ErrTimeout before the provider does anything.
+	FaultTimeout Fault = iota + 1
+	// FaultLostResponse performs the operation but fails the call with ErrTimeout, as when the response
+	// is lost on its way back. A retry with the same idempotency key gets the original result; a retry
+	// with a new key performs the operation again, which is how duplicate charges happen.
+	FaultLostResponse
+)
+
+// Fake is an in-memory PaymentGateway for tests and local runs. It is deterministic: IDs are
+// sequential, declines are configured per customer and faults are queued per operation. Like a real
+// provider it stores the outcome of every call, declines included, under its idempotency key.
+type Fake struct {
+	mu       sync.Mutex
+	seq      int
+	calls    map[string]fakeCall     // by idempotency key
+	payments map[string]*FakePayment // by authorization ID
+	declines map[string]string       // customer ID -> decline code
+	faults   map[Op][]Fault
+	applied  map[Op]int
+}
+
+// FakePayment is the Fake's state of one authorization.
+type FakePayment struct {
+	CustomerID string
+	Status     string
+	Authorized money.Money
+	Captured   money.Money
+	Refunded   money.Money
+}
+
+type fakeCall struct {
+	op      Op
+	request interface{}
+	result  *Result
+	err     error
+}
+
+// NewFake creates a Fake that approves every payment.
+func NewFake() *Fake {
+	return &Fake{
+		calls:    make(map[string]fakeCall),
+		payments: make(map[string]*FakePayment),
+		declines: make(map[string]string),
+		faults:   make(map[Op][]Fault),
+		applied:  make(map[Op]int),
+	}
+}
+
+// Decline makes authorizations for customerID fail with a *DeclineError carrying code.
+func (f *Fake) Decline(customerID, code string) *Fake {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.declines[customerID] = code
+	return f
+}
+
+// FailNext queues faults for the next calls of op, one fault per call. Calls answered from the
+// idempotency store do not consume a fault.
+func (f *Fake) FailNext(op Op, faults ...Fault) *Fake {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults[op] = append(f.faults[op], faults...)
+	return f
+}
+
+// Applied returns how many times op was actually performed, not counting replays and failed calls.
+func (f *Fake) Applied(op Op) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.applied[op]
+}
+
+// Payment returns the state of the authorization with the given ID.
+func (f *Fake) Payment(authorizationID string) (FakePayment, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[authorizationID]
+	if !ok {
+		return FakePayment{}, false
+	}
+	return *p, true
+}
+
+// Authorize implements PaymentGateway.
+func (f *Fake) Authorize(_ context.Context, req AuthorizeRequest) (*Result, error) {
+	return f.do(OpAuthorize, req.IdempotencyKey, req, func() (*Result, error) {
+		if err := positive(req.Amount); err != nil {
+			return nil, err
+		}
+		if code, ok := f.declines[req.CustomerID]; ok {
+			return nil, &DeclineError{Code: code}
+		}
+		id := f.nextID("auth")
+		f.payments[id] = &FakePayment{
+			CustomerID: req.CustomerID,
+			Status:     StatusAuthorized,
+			Authorized: req.Amount,
+			Captured:   money.Money{Currency: req.Amount.Currency},
+			Refunded:   money.Money{Currency: req.Amount.Currency},
+		}
+		return &Result{ID: id, AuthorizationID: id, Status: StatusAuthorized, Amount: req.Amount}, nil
+	})
+}
+
+// Capture implements PaymentGateway. An authorization is captured once, in full or in part.
+func (f *Fake) Capture(_ context.Context, req CaptureRequest) (*Result, error) {
+	return f.do(OpCapture, req.IdempotencyKey, req, func() (*Result, error) {
+		p, err := f.payment(req.AuthorizationID, StatusAuthorized)
+		if err != nil {
+			return nil, err
+		}
+		if err := positive(req.Amount); err != nil {
+			return nil, err
+		}
+		if req.Amount.Currency != p.Authorized.Currency || req.Amount.Amount > p.Authorized.Amount {
+			return nil, fmt.Errorf("%w: capture of %s exceeds the authorized %s", ErrInvalidState, req.Amount, p.Authorized)
+		}
+		p.Status, p.Captured = StatusCaptured, req.Amount
+		return &Result{ID: f.nextID("cap"), AuthorizationID: req.AuthorizationID, Status: p.Status, Amount: req.Amount}, nil
+	})
+}
+
+// Void implements PaymentGateway.
+func (f *Fake) Void(_ context.Context, req VoidRequest) (*Result, error) {
+	return f.do(OpVoid, req.IdempotencyKey, req, func() (*Result, error) {
+		p, err := f.payment(req.AuthorizationID, StatusAuthorized)
+		if err != nil {
+			return nil, err
+		}
+		p.Status = StatusVoided
+		return &Result{ID: f.nextID("void"), AuthorizationID: req.AuthorizationID, Status: p.Status, Amount: p.Authorized}, nil
+	})
+}
+
+// Refund implements PaymentGateway. Refunds may be partial; together they cannot exceed the capture.
+func (f *Fake) Refund(_ context.Context, req RefundRequest) (*Result, error) {
+	return f.do(OpRefund, req.IdempotencyKey, req, func() (*Result, error) {
+		p, err := f.payment(req.AuthorizationID, StatusCaptured)
+		if err != nil {
+			return nil, err
+		}
+		if err := positive(req.Amount); err != nil {
+			return nil, err
+		}
+		if req.Amount.Currency != p.Captured.Currency || p.Refunded.Amount+req.Amount.Amount > p.Captured.Amount {
+			return nil, fmt.Errorf("%w: refund of %s exceeds the %s left of the capture", ErrInvalidState,
+				req.Amount, money.Money{Amount: p.Captured.Amount - p.Refunded.Amount, Currency: p.Captured.Currency})
+		}
+		p.Refunded.Amount += req.Amount.Amount
+		if p.Refunded.Amount == p.Captured.Amount {
+			p.Status = StatusRefunded
+		}
+		return &Result{ID: f.nextID("re"), AuthorizationID: req.AuthorizationID, Status: p.Status, Amount: req.Amount}, nil
+	})
+}
+
+// do runs apply at most once per idempotency key and replays its outcome for later calls with the key.
+func (f *Fake) do(op Op, key string, req interface{}, apply func() (*Result, error)) (*Result, error) {
+	if key == "" {
+		return nil, fmt.Errorf("%s: missing idempotency key", op)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.calls[key]; ok {
+		if c.op != op || c.request != req {
+			return nil, fmt.Errorf("%s %s: %w", op, key, ErrKeyReused)
+		}
+		return c.result.clone(), c.err
+	}
+	var fault Fault
+	if q := f.faults[op]; len(q) > 0 {
+		fault, f.faults[op] = q[0], q[1:]
+	}
+	if fault == FaultTimeout {
+		return nil, fmt.Errorf("%s: %w", op, ErrTimeout)
+	}
+	res, err := apply()
+	f.calls[key] = fakeCall{op: op, request: req, result: res, err: err}
+	if err == nil {
+		f.applied[op]++
+	}
+	if fault == FaultLostResponse {
+		return nil, fmt.Errorf("%s: %w", op, ErrTimeout)
+	}
+	return res.clone(), err
+}
+
+// payment returns the authorization id, which must be in status want. Callers hold f.mu.
+func (f *Fake) payment(id, want string) (*FakePayment, error) {
+	p, ok := f.payments[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrPaymentNotFound, id)
+	}
+	if p.Status != want {
+		return nil, fmt.Errorf("%w: %s is %s, want %s", ErrInvalidState, id, p.Status, want)
+	}
+	return p, nil
+}
+
+// nextID returns a sequential provider ID with the given prefix. Callers hold f.mu.
+func (f *Fake) nextID(prefix string) string {
+	f.seq++
+	return fmt.Sprintf("%s_%04d", prefix, f.seq)
+}
+
+func positive(m money.Money) error {
+	if err := m.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	if m.Amount <= 0 {
+		return fmt.Errorf("%w: amount %s is not positive", ErrInvalidState, m)
+	}
+	return nil
+}
+
+func (r *Result) clone() *Result {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	return &c
+}
@@ -0,0 +1,108 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+)
+
+func usd(amount int64) money.Money { return money.Money{Amount: amount, Currency: "USD"} }
+
+func TestFake_IdempotencyKeyPreventsDuplicateCharges(t *testing.T) {
+	f := NewFake().FailNext(OpAuthorize, FaultTimeout, FaultLostResponse)
+	ctx := context.Background()
+	req := AuthorizeRequest{IdempotencyKey: "o1:authorize", OrderID: "o1", CustomerID: "c1", Amount: usd(1000)}
+
+	// nothing happened: the retry performs the authorization
+	if _, err := f.Authorize(ctx, req); !errors.Is(err, ErrTimeout) || f.Applied(OpAuthorize) != 0 {
+		t.Fatalf("expected a timeout before anything happened, got %v", err)
+	}
+	// the authorization happened but the answer was lost: the retry gets it back
+	if _, err := f.Authorize(ctx, req); !errors.Is(err, ErrTimeout) || f.Applied(OpAuthorize) != 1 {
+		t.Fatalf("expected a timeout after authorizing, got %v", err)
+	}
+	res, err := f.Authorize(ctx, req)
+	if err != nil || res.Status != StatusAuthorized || res.ID != "auth_0001" {
+		t.Fatalf("expected the first authorization back, got %+v, %v", res, err)
+	}
+	if f.Applied(OpAuthorize) != 1 {
+		t.Fatalf("expected a single authorization, got %d", f.Applied(OpAuthorize))
+	}
+
+	// a retry under a new key is a second charge
+	dup := req
+	dup.IdempotencyKey = "o1:authorize:retry"
+	if again, _ := f.Authorize(ctx, dup); again.ID == res.ID || f.Applied(OpAuthorize) != 2 {
+		t.Fatalf("expected a duplicate authorization under a new key, got %+v", again)
+	}
+
+	// the key belongs to the first request
+	other := req
+	other.Amount = usd(2000)
+	if _, err := f.Authorize(ctx, other); !errors.Is(err, ErrKeyReused) {
+		t.Fatalf("expected ErrKeyReused, got %v", err)
+	}
+	if _, err := f.Void(ctx, VoidRequest{IdempotencyKey: req.IdempotencyKey, AuthorizationID: res.ID}); !errors.Is(err, ErrKeyReused) {
+		t.Fatalf("expected ErrKeyReused for another operation, got %v", err)
+	}
+}
+
+func TestFake_DeclinesAreReplayed(t *testing.T) {
+	f := NewFake().Decline("broke", "insufficient_funds")
+	ctx := context.Background()
+	req := AuthorizeRequest{IdempotencyKey: "o2:authorize", OrderID: "o2", CustomerID: "broke", Amount: usd(500)}
+	for i := 0; i < 2; i++ {
+		_, err := f.Authorize(ctx, req)
+		var de *DeclineError
+		if !errors.As(err, &de) || de.Code != "insufficient_funds" || !errors.Is(err, ErrDeclined) || !IsPermanent(err) {
+			t.Fatalf("call %d: expected a decline, got %v", i, err)
+		}
+	}
+	if f.Applied(OpAuthorize) != 0 {
+		t.Fatalf("a decline must not authorize anything")
+	}
+}
+
+func TestFake_CaptureVoidRefund(t *testing.T) {
+	f := NewFake()
+	ctx := context.Background()
+	auth, err := f.Authorize(ctx, AuthorizeRequest{IdempotencyKey: "a", OrderID: "o", Amount: usd(1000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Capture(ctx, CaptureRequest{IdempotencyKey: "c0", AuthorizationID: auth.ID, Amount: usd(1001)}); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("expected capturing more than authorized to fail, got %v", err)
+	}
+	if _, err := f.Capture(ctx, CaptureRequest{IdempotencyKey: "c1", AuthorizationID: auth.ID, Amount: usd(1000)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Void(ctx, VoidRequest{IdempotencyKey: "v", AuthorizationID: auth.ID}); !errors.Is(err, ErrInvalidState) || !IsPermanent(err) {
+		t.Fatalf("expected a captured payment not to be voidable, got %v", err)
+	}
+
+	if _, err := f.Refund(ctx, RefundRequest{IdempotencyKey: "r1", AuthorizationID: auth.ID, Amount: usd(400)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Refund(ctx, RefundRequest{IdempotencyKey: "r2", AuthorizationID: auth.ID, Amount: usd(700)}); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("expected refunds beyond the capture to fail, got %v", err)
+	}
+	res, err := f.Refund(ctx, RefundRequest{IdempotencyKey: "r3", AuthorizationID: auth.ID, Amount: usd(600)})
+	if err != nil || res.Status != StatusRefunded {
+		t.Fatalf("expected the payment fully refunded, got %+v, %v", res, err)
+	}
+	if p, _ := f.Payment(auth.ID); p.Refunded != usd(1000) || p.Status != StatusRefunded {
+		t.Fatalf("unexpected payment state %+v", p)
+	}
+
+	auth2, _ := f.Authorize(ctx, AuthorizeRequest{IdempotencyKey: "a2", OrderID: "o2", Amount: usd(100)})
+	for i := 0; i < 2; i++ {
+		if res, err := f.Void(ctx, VoidRequest{IdempotencyKey: "v2", AuthorizationID: auth2.ID}); err != nil || res.Status != StatusVoided {
+			t.Fatalf("void %d: %+v, %v", i, res, err)
+		}
+	}
+	if _, err := f.Capture(ctx, CaptureRequest{IdempotencyKey: "c2", AuthorizationID: auth2.ID, Amount: usd(100)}); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("expected a voided authorization not to be capturable, got %v", err)
+	}
+}
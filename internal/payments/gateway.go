@@ -0,0 +1,115 @@
+// Package payments defines the interface to the payment provider. Every call carries an idempotency
+// key that the provider must honour as its own idempotency key, so a call retried after a timeout or a
+// crash returns the first outcome instead of charging the customer twice.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+)
+
+// Gateway kinds for NewGateway.
+const (
+	GatewayNone = "none"
+	GatewayFake = "fake"
+)
+
+// Payment statuses as reported by the provider.
+const (
+	StatusAuthorized = "AUTHORIZED"
+	StatusCaptured   = "CAPTURED"
+	StatusVoided     = "VOIDED"
+	StatusRefunded   = "REFUNDED"
+)
+
+var (
+	// ErrDeclined indicates the provider refused the payment; retrying will not change the answer.
+	// Errors wrapping it are *DeclineError values carrying the provider's reason code.
+	ErrDeclined = errors.New("payment declined")
+	// ErrTimeout indicates the outcome of the call is unknown. Retrying with the same idempotency key is
+	// safe: the provider either performs the operation now or returns the result of the first call.
+	ErrTimeout = errors.New("payment provider timeout")
+	// ErrKeyReused indicates an idempotency key already used for a different request.
+	ErrKeyReused = errors.New("idempotency key reused with different parameters")
+	// ErrInvalidState indicates an operation the payment's state does not allow, e.g. capturing a voided
+	// authorization or refunding more than was captured.
+	ErrInvalidState = errors.New("invalid payment state")
+	// ErrPaymentNotFound indicates an unknown authorization ID.
+	ErrPaymentNotFound = errors.New("payment not found")
+)
+
+// DeclineError is returned when the provider declines a payment.
+type DeclineError struct {
+	Code string // provider reason, e.g. "insufficient_funds"
+}
+
+func (e *DeclineError) Error() string { return fmt.Sprintf("payment declined: %s", e.Code) }
+
+// Is makes errors.Is(err, ErrDeclined) match any decline.
+func (e *DeclineError) Is(target error) bool { return target == ErrDeclined }
+
+// IsPermanent reports whether err is an answer from the provider that retrying cannot change. Any
+// other error, ErrTimeout included, may succeed on a retry with the same idempotency key.
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrDeclined) || errors.Is(err, ErrKeyReused) ||
+		errors.Is(err, ErrInvalidState) || errors.Is(err, ErrPaymentNotFound)
+}
+
+// AuthorizeRequest places a hold of Amount on the customer's payment method.
+type AuthorizeRequest struct {
+	IdempotencyKey string
+	OrderID        string
+	CustomerID     string
+	Amount         money.Money
+}
+
+// CaptureRequest collects Amount, at most the authorized amount, from an authorization.
+type CaptureRequest struct {
+	IdempotencyKey  string
+	AuthorizationID string
+	Amount          money.Money
+}
+
+// VoidRequest releases an authorization that has not been captured.
+type VoidRequest struct {
+	IdempotencyKey  string
+	AuthorizationID string
+}
+
+// RefundRequest returns Amount of a captured payment to the customer.
+type RefundRequest struct {
+	IdempotencyKey  string
+	AuthorizationID string
+	Amount          money.Money
+}
+
+// Result is the provider's answer to a successful call.
+type Result struct {
+	ID              string      // provider ID of this operation; for Authorize, the authorization ID
+	AuthorizationID string      // the authorization the operation applies to
+	Status          string      // status of the payment after the operation
+	Amount          money.Money // amount authorized, captured or refunded by this operation
+}
+
+// PaymentGateway is the payment provider. Implementations must pass each request's IdempotencyKey
+// through as the provider's idempotency key.
+type PaymentGateway interface {
+	Authorize(ctx context.Context, req AuthorizeRequest) (*Result, error)
+	Capture(ctx context.Context, req CaptureRequest) (*Result, error)
+	Void(ctx context.Context, req VoidRequest) (*Result, error)
+	Refund(ctx context.Context, req RefundRequest) (*Result, error)
+}
+
+// NewGateway returns the gateway named by kind: nil for GatewayNone or "", a Fake for GatewayFake.
+func NewGateway(kind string) (PaymentGateway, error) {
+	switch kind {
+	case "", GatewayNone:
+		return nil, nil
+	case GatewayFake:
+		return NewFake(), nil
+	}
+	return nil, fmt.Errorf("unknown payment gateway %q", kind)
+}
@@ -0,0 +1,71 @@
+// Package problem writes error responses as RFC 9457 problem details (application/problem+json).
+//
+// Every problem carries the standard members type, title, status and detail, plus code, the stable
+// machine-readable identifier clients should branch on. Problem-specific members (e.g. order_id) are
+// added as extensions at the top level of the object.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of problem details responses.
+const ContentType = "application/problem+json"
+
+// typeBase prefixes each code to form the problem type URI, documented in the README.
+const typeBase = "/problems/"
+
+// Details is one problem details object.
+type Details struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Code       string
+	Extensions map[string]any
+}
+
+// New returns the problem for code at status. The title is the status text.
+func New(status int, code, detail string) *Details {
+	return &Details{
+		Type:   typeBase + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// With adds an extension member and returns p.
+func (p *Details) With(name string, value any) *Details {
+	if p.Extensions == nil {
+		p.Extensions = map[string]any{}
+	}
+	p.Extensions[name] = value
+	return p
+}
+
+// MarshalJSON flattens the extensions next to the standard members.
+func (p *Details) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	m["type"] = p.Type
+	m["title"] = p.Title
+	m["status"] = p.Status
+	m["code"] = p.Code
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	return json.Marshal(m)
+}
+
+// Abort writes p as the response and stops the handler chain.
+func Abort(c *gin.Context, p *Details) {
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
@@ -0,0 +1,33 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestDetails_MarshalFlattensExtensions(t *testing.T) {
+	p := New(http.StatusConflict, "request_in_progress", "retry later").With("order_id", "o1")
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	_ = json.Unmarshal(b, &got)
+	want := map[string]any{
+		"type":     "/problems/request_in_progress",
+		"title":    "Conflict",
+		"status":   float64(409),
+		"code":     "request_in_progress",
+		"detail":   "retry later",
+		"order_id": "o1",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("%s: got %v, want %v", k, got[k], v)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package shipping
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Fake is an in-memory Service for tests and local runs.
+type Fake struct {
+	mu        sync.Mutex
+	seq       int
+	shipments map[string]*Shipment // by order ID
+	rejects   map[string]string    // customer ID -> reason
+	created   int
+}
+
+// NewFake creates a Fake that ships every order.
+func NewFake() *Fake {
+	return &Fake{shipments: make(map[string]*Shipment), rejects: make(map[string]string)}
+}
+
+// Reject makes shipments for customerID fail with ErrRejected and reason.
+func (f *Fake) Reject(customerID, reason string) *Fake {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rejects[customerID] = reason
+	return f
+}
+
+// Created returns how many shipments were created, not counting repeated calls.
+func (f *Fake) Created() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.created
+}
+
+// Shipment returns the order's shipment.
+func (f *Fake) Shipment(orderID string) (Shipment, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.shipments[orderID]
+	if !ok {
+		return Shipment{}, false
+	}
+	return *s, true
+}
+
+// CreateShipment implements Service.
+func (f *Fake) CreateShipment(_ context.Context, req CreateRequest) (*Shipment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.shipments[req.OrderID]; ok {
+		c := *s
+		return &c, nil
+	}
+	if reason, ok := f.rejects[req.CustomerID]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrRejected, reason)
+	}
+	f.seq++
+	s := &Shipment{ID: fmt.Sprintf("shp_%04d", f.seq), OrderID: req.OrderID, Status: StatusCreated}
+	f.shipments[req.OrderID] = s
+	f.created++
+	c := *s
+	return &c, nil
+}
+
+// CancelShipment implements Service.
+func (f *Fake) CancelShipment(_ context.Context, orderID string) (*Shipment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.shipments[orderID]
+	if !ok {
+		return nil, nil
+	}
+	s.Status = StatusCancelled
+	c := *s
+	return &c, nil
+}
@@ -0,0 +1,57 @@
+// Package shipping defines the interface to the carrier that ships orders. A carrier holds at most one
+// shipment per order, referenced by the order ID, so creating or cancelling it again is harmless.
+package shipping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Shipment statuses.
+const (
+	StatusCreated   = "CREATED"
+	StatusCancelled = "CANCELLED"
+)
+
+// Service kinds for NewService.
+const (
+	ServiceNone = "none"
+	ServiceFake = "fake"
+)
+
+// ErrRejected indicates the carrier refused the shipment, e.g. an address it does not deliver to;
+// retrying will not change the answer.
+var ErrRejected = errors.New("shipment rejected")
+
+// CreateRequest asks the carrier to ship an order.
+type CreateRequest struct {
+	OrderID    string
+	CustomerID string
+}
+
+// Shipment is the carrier's view of an order's shipment.
+type Shipment struct {
+	ID      string
+	OrderID string
+	Status  string
+}
+
+// Service is the carrier.
+type Service interface {
+	// CreateShipment creates the order's shipment, or returns the existing one.
+	CreateShipment(ctx context.Context, req CreateRequest) (*Shipment, error)
+	// CancelShipment cancels the order's shipment. It returns nil, nil if the order has none.
+	CancelShipment(ctx context.Context, orderID string) (*Shipment, error)
+}
+
+// NewService returns the service named by kind: nil for ServiceNone or "", a Fake for ServiceFake.
+func NewService(kind string) (Service, error) {
+	switch kind {
+	case "", ServiceNone:
+		return nil, nil
+	case ServiceFake:
+		return NewFake(), nil
+	}
+	return nil, fmt.Errorf("unknown shipping service %q", kind)
+}
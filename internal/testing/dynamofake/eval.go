@@ -0,0 +1,800 @@
+package dynamofake
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type item = map[string]types.AttributeValue
+
+// document paths
+
+type pathElem struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+type docPath []pathElem
+
+func (p docPath) String() string {
+	var b strings.Builder
+	for i, e := range p {
+		switch {
+		case e.isIndex:
+			fmt.Fprintf(&b, "[%d]", e.index)
+		case i > 0:
+			b.WriteString("." + e.name)
+		default:
+			b.WriteString(e.name)
+		}
+	}
+	return b.String()
+}
+
+func (p docPath) resolve(it item) (types.AttributeValue, bool) {
+	var cur types.AttributeValue = &types.AttributeValueMemberM{Value: it}
+	for _, e := range p {
+		switch v := cur.(type) {
+		case *types.AttributeValueMemberM:
+			if e.isIndex {
+				return nil, false
+			}
+			next, ok := v.Value[e.name]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case *types.AttributeValueMemberL:
+			if !e.isIndex || e.index >= len(v.Value) {
+				return nil, false
+			}
+			cur = v.Value[e.index]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// parent returns the container holding the last path element; it must already exist.
+func (p docPath) parent(it item) (types.AttributeValue, error) {
+	if len(p) == 1 {
+		return &types.AttributeValueMemberM{Value: it}, nil
+	}
+	v, ok := p[:len(p)-1].resolve(it)
+	if !ok {
+		return nil, validationError("The document path provided in the update expression is invalid for update")
+	}
+	return v, nil
+}
+
+func (p docPath) set(it item, v types.AttributeValue) error {
+	parent, err := p.parent(it)
+	if err != nil {
+		return err
+	}
+	last := p[len(p)-1]
+	switch c := parent.(type) {
+	case *types.AttributeValueMemberM:
+		if !last.isIndex {
+			c.Value[last.name] = v
+			return nil
+		}
+	case *types.AttributeValueMemberL:
+		if last.isIndex {
+			if last.index < len(c.Value) {
+				c.Value[last.index] = v
+			} else {
+				c.Value = append(c.Value, v)
+			}
+			return nil
+		}
+	}
+	return validationError("The document path provided in the update expression is invalid for update")
+}
+
+func (p docPath) remove(it item) {
+	parent, err := p.parent(it)
+	if err != nil {
+		return
+	}
+	last := p[len(p)-1]
+	switch c := parent.(type) {
+	case *types.AttributeValueMemberM:
+		delete(c.Value, last.name)
+	case *types.AttributeValueMemberL:
+		if last.isIndex && last.index < len(c.Value) {
+			c.Value = append(c.Value[:last.index], c.Value[last.index+1:]...)
+		}
+	}
+}
+
+// operands
+
+type operand interface {
+	eval(it item) (types.AttributeValue, bool)
+}
+
+type pathOperand struct{ path docPath }
+
+func (o pathOperand) eval(it item) (types.AttributeValue, bool) { return o.path.resolve(it) }
+
+type valueOperand struct{ v types.AttributeValue }
+
+func (o valueOperand) eval(item) (types.AttributeValue, bool) { return o.v, true }
+
+type sizeOperand struct{ path docPath }
+
+func (o sizeOperand) eval(it item) (types.AttributeValue, bool) {
+	v, ok := o.path.resolve(it)
+	if !ok {
+		return nil, false
+	}
+	var n int
+	switch v := v.(type) {
+	case *types.AttributeValueMemberS:
+		n = len(v.Value)
+	case *types.AttributeValueMemberB:
+		n = len(v.Value)
+	case *types.AttributeValueMemberSS:
+		n = len(v.Value)
+	case *types.AttributeValueMemberNS:
+		n = len(v.Value)
+	case *types.AttributeValueMemberBS:
+		n = len(v.Value)
+	case *types.AttributeValueMemberL:
+		n = len(v.Value)
+	case *types.AttributeValueMemberM:
+		n = len(v.Value)
+	default:
+		return nil, false
+	}
+	return &types.AttributeValueMemberN{Value: strconv.Itoa(n)}, true
+}
+
+// conditions
+
+type condition interface {
+	eval(it item) bool
+}
+
+type orCond struct{ left, right condition }
+
+func (c orCond) eval(it item) bool { return c.left.eval(it) || c.right.eval(it) }
+
+type andCond struct{ left, right condition }
+
+func (c andCond) eval(it item) bool { return c.left.eval(it) && c.right.eval(it) }
+
+type notCond struct{ c condition }
+
+func (c notCond) eval(it item) bool { return !c.c.eval(it) }
+
+type compareCond struct {
+	op          string
+	left, right operand
+}
+
+func (c compareCond) eval(it item) bool {
+	l, lok := c.left.eval(it)
+	r, rok := c.right.eval(it)
+	if c.op == "<>" {
+		// a missing attribute is "not equal" to anything
+		return !lok || !rok || !equal(l, r)
+	}
+	if !lok || !rok {
+		return false
+	}
+	if c.op == "=" {
+		return equal(l, r)
+	}
+	cmp, ok := compare(l, r)
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+type betweenCond struct{ v, lo, hi operand }
+
+func (c betweenCond) eval(it item) bool {
+	v, ok1 := c.v.eval(it)
+	lo, ok2 := c.lo.eval(it)
+	hi, ok3 := c.hi.eval(it)
+	if !ok1 || !ok2 || !ok3 {
+		return false
+	}
+	a, ok := compare(v, lo)
+	if !ok || a < 0 {
+		return false
+	}
+	b, ok := compare(v, hi)
+	return ok && b <= 0
+}
+
+type inCond struct {
+	v    operand
+	list []operand
+}
+
+func (c inCond) eval(it item) bool {
+	v, ok := c.v.eval(it)
+	if !ok {
+		return false
+	}
+	for _, o := range c.list {
+		if w, ok := o.eval(it); ok && equal(v, w) {
+			return true
+		}
+	}
+	return false
+}
+
+type funcCond struct {
+	fn   string
+	path docPath
+	arg  operand
+}
+
+func (c funcCond) eval(it item) bool {
+	v, exists := c.path.resolve(it)
+	switch c.fn {
+	case "attribute_exists":
+		return exists
+	case "attribute_not_exists":
+		return !exists
+	}
+	arg, ok := c.arg.eval(it)
+	if !exists || !ok {
+		return false
+	}
+	switch c.fn {
+	case "attribute_type":
+		t, ok := arg.(*types.AttributeValueMemberS)
+		return ok && typeCode(v) == t.Value
+	case "begins_with":
+		switch v := v.(type) {
+		case *types.AttributeValueMemberS:
+			p, ok := arg.(*types.AttributeValueMemberS)
+			return ok && strings.HasPrefix(v.Value, p.Value)
+		case *types.AttributeValueMemberB:
+			p, ok := arg.(*types.AttributeValueMemberB)
+			return ok && bytes.HasPrefix(v.Value, p.Value)
+		}
+	case "contains":
+		switch v := v.(type) {
+		case *types.AttributeValueMemberS:
+			p, ok := arg.(*types.AttributeValueMemberS)
+			return ok && strings.Contains(v.Value, p.Value)
+		case *types.AttributeValueMemberB:
+			p, ok := arg.(*types.AttributeValueMemberB)
+			return ok && bytes.Contains(v.Value, p.Value)
+		case *types.AttributeValueMemberSS:
+			p, ok := arg.(*types.AttributeValueMemberS)
+			return ok && containsString(v.Value, p.Value)
+		case *types.AttributeValueMemberNS:
+			p, ok := arg.(*types.AttributeValueMemberN)
+			return ok && containsNumber(v.Value, p.Value)
+		case *types.AttributeValueMemberBS:
+			p, ok := arg.(*types.AttributeValueMemberB)
+			if ok {
+				for _, b := range v.Value {
+					if bytes.Equal(b, p.Value) {
+						return true
+					}
+				}
+			}
+		case *types.AttributeValueMemberL:
+			for _, e := range v.Value {
+				if equal(e, arg) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// updates
+
+type update struct {
+	sets, adds, deletes []setAction
+	removes             []docPath
+}
+
+type setAction struct {
+	path  docPath
+	value valueExpr
+}
+
+// checkOverlap rejects expressions that touch the same document path twice.
+func (u *update) checkOverlap() error {
+	seen := map[string]bool{}
+	var all []docPath
+	for _, group := range [][]setAction{u.sets, u.adds, u.deletes} {
+		for _, a := range group {
+			all = append(all, a.path)
+		}
+	}
+	all = append(all, u.removes...)
+	for _, p := range all {
+		s := p.String()
+		if seen[s] {
+			return validationError("Invalid UpdateExpression: Two document paths overlap with each other; path: [%s]", s)
+		}
+		seen[s] = true
+	}
+	return nil
+}
+
+// touched returns the top-level attribute names the update writes, for key checks and UPDATED_* return values.
+func (u *update) touched() []string {
+	var names []string
+	for _, group := range [][]setAction{u.sets, u.adds, u.deletes} {
+		for _, a := range group {
+			names = append(names, a.path[0].name)
+		}
+	}
+	for _, p := range u.removes {
+		names = append(names, p[0].name)
+	}
+	return names
+}
+
+// apply evaluates every action against the original item (as DynamoDB does) and returns the updated copy.
+func (u *update) apply(orig item) (item, error) {
+	out := copyItem(orig)
+	type pending struct {
+		path docPath
+		v    types.AttributeValue
+	}
+	var sets []pending
+	for _, a := range u.sets {
+		v, err := a.value.eval(orig)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, pending{a.path, v})
+	}
+	for _, s := range sets {
+		if err := s.path.set(out, copyValue(s.v)); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range u.removes {
+		p.remove(out)
+	}
+	for _, a := range u.adds {
+		arg, _ := a.value.eval(orig)
+		cur, exists := a.path.resolve(out)
+		if !exists {
+			if _, ok := arg.(*types.AttributeValueMemberN); !ok && !isSet(arg) {
+				return nil, validationError("Invalid UpdateExpression: Incorrect operand type for operator or function; operator: ADD, operand type: %s", typeCode(arg))
+			}
+			if err := a.path.set(out, copyValue(arg)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		v, err := addValues(cur, arg)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.path.set(out, v); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range u.deletes {
+		arg, _ := a.value.eval(orig)
+		cur, exists := a.path.resolve(out)
+		if !exists {
+			continue
+		}
+		v, err := deleteFromSet(cur, arg)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			a.path.remove(out)
+		} else if err := a.path.set(out, v); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+type valueExpr interface {
+	eval(it item) (types.AttributeValue, error)
+}
+
+type operandValue struct{ o operand }
+
+func (v operandValue) eval(it item) (types.AttributeValue, error) {
+	av, ok := v.o.eval(it)
+	if !ok {
+		return nil, validationError("The provided expression refers to an attribute that does not exist in the item")
+	}
+	return av, nil
+}
+
+type ifNotExists struct {
+	path docPath
+	def  valueExpr
+}
+
+func (v ifNotExists) eval(it item) (types.AttributeValue, error) {
+	if av, ok := v.path.resolve(it); ok {
+		return av, nil
+	}
+	return v.def.eval(it)
+}
+
+type listAppend struct{ a, b valueExpr }
+
+func (v listAppend) eval(it item) (types.AttributeValue, error) {
+	a, err := v.a.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	b, err := v.b.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	la, ok1 := a.(*types.AttributeValueMemberL)
+	lb, ok2 := b.(*types.AttributeValueMemberL)
+	if !ok1 || !ok2 {
+		return nil, validationError("Invalid UpdateExpression: Incorrect operand type for operator or function; operator or function: list_append")
+	}
+	out := append(append([]types.AttributeValue{}, la.Value...), lb.Value...)
+	return &types.AttributeValueMemberL{Value: out}, nil
+}
+
+type arith struct {
+	op          string
+	left, right valueExpr
+}
+
+func (v arith) eval(it item) (types.AttributeValue, error) {
+	l, err := v.left.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	r, err := v.right.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	a, ok1 := number(l)
+	b, ok2 := number(r)
+	if !ok1 || !ok2 {
+		return nil, validationError("Invalid UpdateExpression: Incorrect operand type for operator or function; operator: %s", v.op)
+	}
+	if v.op == "+" {
+		return formatNumber(new(big.Rat).Add(a, b)), nil
+	}
+	return formatNumber(new(big.Rat).Sub(a, b)), nil
+}
+
+func addValues(cur, arg types.AttributeValue) (types.AttributeValue, error) {
+	switch c := cur.(type) {
+	case *types.AttributeValueMemberN:
+		a, _ := number(c)
+		b, ok := number(arg)
+		if ok {
+			return formatNumber(new(big.Rat).Add(a, b)), nil
+		}
+	case *types.AttributeValueMemberSS:
+		if a, ok := arg.(*types.AttributeValueMemberSS); ok {
+			out := append([]string{}, c.Value...)
+			for _, s := range a.Value {
+				if !containsString(out, s) {
+					out = append(out, s)
+				}
+			}
+			return &types.AttributeValueMemberSS{Value: out}, nil
+		}
+	case *types.AttributeValueMemberNS:
+		if a, ok := arg.(*types.AttributeValueMemberNS); ok {
+			out := append([]string{}, c.Value...)
+			for _, s := range a.Value {
+				if !containsNumber(out, s) {
+					out = append(out, s)
+				}
+			}
+			return &types.AttributeValueMemberNS{Value: out}, nil
+		}
+	}
+	return nil, validationError("An operand in the update expression has an incorrect data type")
+}
+
+// deleteFromSet removes arg's elements from the set cur; nil means the set became empty.
+func deleteFromSet(cur, arg types.AttributeValue) (types.AttributeValue, error) {
+	switch c := cur.(type) {
+	case *types.AttributeValueMemberSS:
+		if a, ok := arg.(*types.AttributeValueMemberSS); ok {
+			var out []string
+			for _, s := range c.Value {
+				if !containsString(a.Value, s) {
+					out = append(out, s)
+				}
+			}
+			if len(out) == 0 {
+				return nil, nil
+			}
+			return &types.AttributeValueMemberSS{Value: out}, nil
+		}
+	case *types.AttributeValueMemberNS:
+		if a, ok := arg.(*types.AttributeValueMemberNS); ok {
+			var out []string
+			for _, s := range c.Value {
+				if !containsNumber(a.Value, s) {
+					out = append(out, s)
+				}
+			}
+			if len(out) == 0 {
+				return nil, nil
+			}
+			return &types.AttributeValueMemberNS{Value: out}, nil
+		}
+	}
+	return nil, validationError("An operand in the update expression has an incorrect data type")
+}
+
+// values
+
+func number(av types.AttributeValue) (*big.Rat, bool) {
+	n, ok := av.(*types.AttributeValueMemberN)
+	if !ok {
+		return nil, false
+	}
+	r, ok := new(big.Rat).SetString(n.Value)
+	return r, ok
+}
+
+func formatNumber(r *big.Rat) *types.AttributeValueMemberN {
+	if r.IsInt() {
+		return &types.AttributeValueMemberN{Value: r.Num().String()}
+	}
+	s := strings.TrimRight(r.FloatString(38), "0")
+	return &types.AttributeValueMemberN{Value: strings.TrimSuffix(s, ".")}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsNumber(list []string, n string) bool {
+	want, ok := new(big.Rat).SetString(n)
+	for _, v := range list {
+		if r, ok2 := new(big.Rat).SetString(v); ok && ok2 && r.Cmp(want) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func isSet(av types.AttributeValue) bool {
+	switch av.(type) {
+	case *types.AttributeValueMemberSS, *types.AttributeValueMemberNS, *types.AttributeValueMemberBS:
+		return true
+	}
+	return false
+}
+
+func typeCode(av types.AttributeValue) string {
+	switch av.(type) {
+	case *types.AttributeValueMemberS:
+		return "S"
+	case *types.AttributeValueMemberN:
+		return "N"
+	case *types.AttributeValueMemberB:
+		return "B"
+	case *types.AttributeValueMemberBOOL:
+		return "BOOL"
+	case *types.AttributeValueMemberNULL:
+		return "NULL"
+	case *types.AttributeValueMemberSS:
+		return "SS"
+	case *types.AttributeValueMemberNS:
+		return "NS"
+	case *types.AttributeValueMemberBS:
+		return "BS"
+	case *types.AttributeValueMemberL:
+		return "L"
+	case *types.AttributeValueMemberM:
+		return "M"
+	}
+	return ""
+}
+
+// compare orders two scalars of the same type (N numerically, S and B bytewise).
+func compare(a, b types.AttributeValue) (int, bool) {
+	switch a := a.(type) {
+	case *types.AttributeValueMemberN:
+		x, ok1 := number(a)
+		y, ok2 := number(b)
+		if ok1 && ok2 {
+			return x.Cmp(y), true
+		}
+	case *types.AttributeValueMemberS:
+		if b, ok := b.(*types.AttributeValueMemberS); ok {
+			return strings.Compare(a.Value, b.Value), true
+		}
+	case *types.AttributeValueMemberB:
+		if b, ok := b.(*types.AttributeValueMemberB); ok {
+			return bytes.Compare(a.Value, b.Value), true
+		}
+	}
+	return 0, false
+}
+
+func equal(a, b types.AttributeValue) bool {
+	if typeCode(a) != typeCode(b) {
+		return false
+	}
+	switch a := a.(type) {
+	case *types.AttributeValueMemberN, *types.AttributeValueMemberS, *types.AttributeValueMemberB:
+		c, ok := compare(a, b)
+		return ok && c == 0
+	case *types.AttributeValueMemberBOOL:
+		return a.Value == b.(*types.AttributeValueMemberBOOL).Value
+	case *types.AttributeValueMemberNULL:
+		return true
+	case *types.AttributeValueMemberSS:
+		return sameSet(a.Value, b.(*types.AttributeValueMemberSS).Value, containsString)
+	case *types.AttributeValueMemberNS:
+		return sameSet(a.Value, b.(*types.AttributeValueMemberNS).Value, containsNumber)
+	case *types.AttributeValueMemberBS:
+		bs := b.(*types.AttributeValueMemberBS).Value
+		if len(a.Value) != len(bs) {
+			return false
+		}
+		for _, x := range a.Value {
+			found := false
+			for _, y := range bs {
+				found = found || bytes.Equal(x, y)
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	case *types.AttributeValueMemberL:
+		bl := b.(*types.AttributeValueMemberL).Value
+		if len(a.Value) != len(bl) {
+			return false
+		}
+		for i := range a.Value {
+			if !equal(a.Value[i], bl[i]) {
+				return false
+			}
+		}
+		return true
+	case *types.AttributeValueMemberM:
+		bm := b.(*types.AttributeValueMemberM).Value
+		if len(a.Value) != len(bm) {
+			return false
+		}
+		for k, v := range a.Value {
+			w, ok := bm[k]
+			if !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func sameSet(a, b []string, contains func([]string, string) bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, v := range a {
+		if !contains(b, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// keyString encodes a scalar key attribute so equal keys map to the same string.
+func keyString(av types.AttributeValue) string {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return "S" + v.Value
+	case *types.AttributeValueMemberN:
+		r, _ := number(v)
+		return "N" + formatNumber(r).Value
+	case *types.AttributeValueMemberB:
+		return "B" + string(v.Value)
+	}
+	return ""
+}
+
+func isKeyType(av types.AttributeValue) bool {
+	switch av.(type) {
+	case *types.AttributeValueMemberS, *types.AttributeValueMemberN, *types.AttributeValueMemberB:
+		return true
+	}
+	return false
+}
+
+func copyItem(it item) item {
+	if it == nil {
+		return nil
+	}
+	out := make(item, len(it))
+	for k, v := range it {
+		out[k] = copyValue(v)
+	}
+	return out
+}
+
+func copyValue(av types.AttributeValue) types.AttributeValue {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return &types.AttributeValueMemberS{Value: v.Value}
+	case *types.AttributeValueMemberN:
+		return &types.AttributeValueMemberN{Value: v.Value}
+	case *types.AttributeValueMemberB:
+		return &types.AttributeValueMemberB{Value: append([]byte{}, v.Value...)}
+	case *types.AttributeValueMemberBOOL:
+		return &types.AttributeValueMemberBOOL{Value: v.Value}
+	case *types.AttributeValueMemberNULL:
+		return &types.AttributeValueMemberNULL{Value: v.Value}
+	case *types.AttributeValueMemberSS:
+		return &types.AttributeValueMemberSS{Value: append([]string{}, v.Value...)}
+	case *types.AttributeValueMemberNS:
+		return &types.AttributeValueMemberNS{Value: append([]string{}, v.Value...)}
+	case *types.AttributeValueMemberBS:
+		out := make([][]byte, len(v.Value))
+		for i, b := range v.Value {
+			out[i] = append([]byte{}, b...)
+		}
+		return &types.AttributeValueMemberBS{Value: out}
+	case *types.AttributeValueMemberL:
+		out := make([]types.AttributeValue, len(v.Value))
+		for i, e := range v.Value {
+			out[i] = copyValue(e)
+		}
+		return &types.AttributeValueMemberL{Value: out}
+	case *types.AttributeValueMemberM:
+		return &types.AttributeValueMemberM{Value: copyItem(v.Value)}
+	}
+	return av
+}
+
+// project keeps only the given paths (top-level attribute granularity is enough for our callers).
+func project(it item, paths []docPath) item {
+	if it == nil || paths == nil {
+		return it
+	}
+	out := item{}
+	for _, p := range paths {
+		if v, ok := it[p[0].name]; ok {
+			out[p[0].name] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,558 @@
+package dynamofake
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// tokens
+
+type tokenKind int
+
+const (
+	tokEOF    tokenKind = iota
+	tokIdent            // attribute name, keyword or function name
+	tokName             // #placeholder
+	tokValue            // :placeholder
+	tokNumber           // list index
+	tokPunct            // ( ) , . [ ] + -
+	tokCmp              // = <> < <= > >=
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	isWord := func(c byte) bool {
+		return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || c == ':':
+			j := i + 1
+			for j < len(s) && isWord(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("invalid placeholder at position %d", i)
+			}
+			kind := tokName
+			if c == ':' {
+				kind = tokValue
+			}
+			toks = append(toks, token{kind, s[i:j]})
+			i = j
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			toks = append(toks, token{tokNumber, s[i:j]})
+			i = j
+		case isWord(c):
+			j := i
+			for j < len(s) && isWord(s[j]) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, s[i:j]})
+			i = j
+		case c == '<' || c == '>':
+			j := i + 1
+			if j < len(s) && (s[j] == '=' || c == '<' && s[j] == '>') {
+				j++
+			}
+			toks = append(toks, token{tokCmp, s[i:j]})
+			i = j
+		case c == '=':
+			toks = append(toks, token{tokCmp, "="})
+			i++
+		case strings.IndexByte("(),.[]+-", c) >= 0:
+			toks = append(toks, token{tokPunct, string(c)})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+	return append(toks, token{kind: tokEOF}), nil
+}
+
+// parser resolves placeholders against one request's ExpressionAttributeNames/Values and
+// records which ones were used, since DynamoDB rejects requests that supply unused ones.
+type parser struct {
+	toks       []token
+	pos        int
+	names      map[string]string
+	values     map[string]types.AttributeValue
+	usedNames  map[string]bool
+	usedValues map[string]bool
+}
+
+func newParser(names map[string]string, values map[string]types.AttributeValue) *parser {
+	return &parser{
+		names:      names,
+		values:     values,
+		usedNames:  map[string]bool{},
+		usedValues: map[string]bool{},
+	}
+}
+
+// checkUnused fails the request if a supplied name or value placeholder was never referenced.
+func (p *parser) checkUnused() error {
+	var unused []string
+	for k := range p.names {
+		if !p.usedNames[k] {
+			unused = append(unused, k)
+		}
+	}
+	sort.Strings(unused)
+	if len(unused) > 0 {
+		return validationError("Value provided in ExpressionAttributeNames unused in expressions: keys: {%s}", strings.Join(unused, ", "))
+	}
+	for k := range p.values {
+		if !p.usedValues[k] {
+			unused = append(unused, k)
+		}
+	}
+	sort.Strings(unused)
+	if len(unused) > 0 {
+		return validationError("Value provided in ExpressionAttributeValues unused in expressions: keys: {%s}", strings.Join(unused, ", "))
+	}
+	return nil
+}
+
+func (p *parser) reset(expr string) error {
+	toks, err := tokenize(expr)
+	if err != nil {
+		return validationError("Invalid expression %q: %v", expr, err)
+	}
+	p.toks, p.pos = toks, 0
+	return nil
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(kw string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) punct(s string) bool {
+	if t := p.peek(); t.kind == tokPunct && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if !p.punct(s) {
+		return p.errorf("expected %q", s)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	near := t.text
+	if t.kind == tokEOF {
+		near = "<EOF>"
+	}
+	return validationError("Invalid expression: %s near %q", fmt.Sprintf(format, args...), near)
+}
+
+func (p *parser) done() error {
+	if p.peek().kind != tokEOF {
+		return p.errorf("unexpected token")
+	}
+	return nil
+}
+
+var reservedFuncs = map[string]bool{
+	"attribute_exists": true, "attribute_not_exists": true, "attribute_type": true,
+	"begins_with": true, "contains": true, "size": true, "if_not_exists": true, "list_append": true,
+}
+
+// path := name ( '.' name | '[' n ']' )*
+func (p *parser) parsePath() (docPath, error) {
+	first, err := p.pathName()
+	if err != nil {
+		return nil, err
+	}
+	path := docPath{{name: first}}
+	for {
+		switch {
+		case p.punct("."):
+			n, err := p.pathName()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, pathElem{name: n})
+		case p.punct("["):
+			t := p.next()
+			if t.kind != tokNumber {
+				return nil, p.errorf("expected list index")
+			}
+			idx, _ := strconv.Atoi(t.text)
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			path = append(path, pathElem{index: idx, isIndex: true})
+		default:
+			return path, nil
+		}
+	}
+}
+
+func (p *parser) pathName() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case tokName:
+		n, ok := p.names[t.text]
+		if !ok {
+			return "", validationError("An expression attribute name used in the document path is not defined; attribute name: %s", t.text)
+		}
+		p.usedNames[t.text] = true
+		return n, nil
+	case tokIdent:
+		return t.text, nil
+	}
+	p.pos--
+	return "", p.errorf("expected attribute name")
+}
+
+func (p *parser) value() (types.AttributeValue, error) {
+	t := p.next()
+	v, ok := p.values[t.text]
+	if !ok {
+		return nil, validationError("An expression attribute value used in expression is not defined; attribute value: %s", t.text)
+	}
+	p.usedValues[t.text] = true
+	return v, nil
+}
+
+// operand := path | :value | size(path)
+func (p *parser) parseOperand() (operand, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokValue:
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		return valueOperand{v}, nil
+	case t.kind == tokIdent && strings.EqualFold(t.text, "size"):
+		p.pos++
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return sizeOperand{path}, nil
+	case t.kind == tokIdent && reservedFuncs[strings.ToLower(t.text)]:
+		return nil, p.errorf("function %s is not allowed here", t.text)
+	}
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	return pathOperand{path}, nil
+}
+
+// condition expressions
+
+func (p *parser) parseCondition(expr string) (condition, error) {
+	if err := p.reset(expr); err != nil {
+		return nil, err
+	}
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	return c, p.done()
+}
+
+func (p *parser) parseOr() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orCond{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andCond{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (condition, error) {
+	if p.keyword("NOT") {
+		c, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notCond{c}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (condition, error) {
+	if p.punct("(") {
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return c, p.expect(")")
+	}
+	if t := p.peek(); t.kind == tokIdent && p.toks[p.pos+1].text == "(" {
+		switch fn := strings.ToLower(t.text); fn {
+		case "attribute_exists", "attribute_not_exists", "attribute_type", "begins_with", "contains":
+			p.pos += 2
+			path, err := p.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			c := funcCond{fn: fn, path: path}
+			if fn != "attribute_exists" && fn != "attribute_not_exists" {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+				if c.arg, err = p.parseOperand(); err != nil {
+					return nil, err
+				}
+			}
+			return c, p.expect(")")
+		}
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokCmp {
+		p.pos++
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return compareCond{op: t.text, left: left, right: right}, nil
+	}
+	if p.keyword("BETWEEN") {
+		lo, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("AND") {
+			return nil, p.errorf("expected AND in BETWEEN")
+		}
+		hi, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return betweenCond{v: left, lo: lo, hi: hi}, nil
+	}
+	if p.keyword("IN") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		c := inCond{v: left}
+		for {
+			o, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			c.list = append(c.list, o)
+			if !p.punct(",") {
+				break
+			}
+		}
+		return c, p.expect(")")
+	}
+	return nil, p.errorf("expected comparison")
+}
+
+// update expressions
+
+func (p *parser) parseUpdate(expr string) (*update, error) {
+	if err := p.reset(expr); err != nil {
+		return nil, err
+	}
+	u := &update{}
+	seen := map[string]bool{}
+	for p.peek().kind != tokEOF {
+		t := p.next()
+		clause := strings.ToUpper(t.text)
+		if t.kind != tokIdent || seen[clause] {
+			p.pos--
+			return nil, p.errorf("expected SET, REMOVE, ADD or DELETE clause")
+		}
+		seen[clause] = true
+		for {
+			path, err := p.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			switch clause {
+			case "SET":
+				if t := p.next(); t.kind != tokCmp || t.text != "=" {
+					p.pos--
+					return nil, p.errorf("expected =")
+				}
+				v, err := p.parseSetValue()
+				if err != nil {
+					return nil, err
+				}
+				u.sets = append(u.sets, setAction{path, v})
+			case "REMOVE":
+				u.removes = append(u.removes, path)
+			case "ADD", "DELETE":
+				if p.peek().kind != tokValue {
+					return nil, p.errorf("%s requires a value placeholder", clause)
+				}
+				v, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				if clause == "ADD" {
+					u.adds = append(u.adds, setAction{path, operandValue{valueOperand{v}}})
+				} else {
+					u.deletes = append(u.deletes, setAction{path, operandValue{valueOperand{v}}})
+				}
+			default:
+				p.pos--
+				return nil, p.errorf("unknown clause %s", t.text)
+			}
+			if !p.punct(",") {
+				break
+			}
+		}
+	}
+	if len(seen) == 0 {
+		return nil, validationError("Invalid UpdateExpression: The expression can not be empty")
+	}
+	return u, u.checkOverlap()
+}
+
+// setValue := setOperand ( ('+' | '-') setOperand )?
+func (p *parser) parseSetValue() (valueExpr, error) {
+	left, err := p.parseSetOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"+", "-"} {
+		if p.punct(op) {
+			right, err := p.parseSetOperand()
+			if err != nil {
+				return nil, err
+			}
+			return arith{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseSetOperand() (valueExpr, error) {
+	t := p.peek()
+	if t.kind == tokIdent && p.toks[p.pos+1].text == "(" {
+		switch fn := strings.ToLower(t.text); fn {
+		case "if_not_exists":
+			p.pos += 2
+			path, err := p.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+			def, err := p.parseSetOperand()
+			if err != nil {
+				return nil, err
+			}
+			return ifNotExists{path: path, def: def}, p.expect(")")
+		case "list_append":
+			p.pos += 2
+			a, err := p.parseSetOperand()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+			b, err := p.parseSetOperand()
+			if err != nil {
+				return nil, err
+			}
+			return listAppend{a, b}, p.expect(")")
+		}
+	}
+	o, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := o.(sizeOperand); ok {
+		return nil, p.errorf("size is not allowed in an update expression")
+	}
+	return operandValue{o}, nil
+}
+
+// parseProjection parses a comma separated list of document paths.
+func (p *parser) parseProjection(expr string) ([]docPath, error) {
+	if err := p.reset(expr); err != nil {
+		return nil, err
+	}
+	var paths []docPath
+	for {
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+		if !p.punct(",") {
+			break
+		}
+	}
+	return paths, p.done()
+}
@@ -0,0 +1,638 @@
+// Package dynamofake is an in-memory DynamoDB for tests. It implements aws.DynamoDBAPI and
+// evaluates condition, update, key condition, filter and projection expressions the way DynamoDB
+// does, so store code is exercised against production semantics rather than string matching.
+package dynamofake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	dyn "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// maxTransactItems is DynamoDB's limit on actions in one TransactWriteItems call.
+const maxTransactItems = 100
+
+// TableSchema describes a table's primary key and its global secondary indexes.
+// Index projections are always ALL.
+type TableSchema struct {
+	Name     string
+	HashKey  string
+	RangeKey string // optional
+	Indexes  []IndexSchema
+}
+
+// IndexSchema describes a global secondary index.
+type IndexSchema struct {
+	Name     string
+	HashKey  string
+	RangeKey string // optional
+}
+
+// Fake is a concurrency-safe in-memory DynamoDB holding any number of tables.
+type Fake struct {
+	mu     sync.Mutex
+	tables map[string]*table
+}
+
+type table struct {
+	schema TableSchema
+	items  map[string]item // by encoded primary key
+}
+
+// New creates a Fake with the given tables.
+func New(schemas ...TableSchema) *Fake {
+	f := &Fake{tables: map[string]*table{}}
+	for _, s := range schemas {
+		f.CreateTable(s)
+	}
+	return f
+}
+
+// CreateTable adds an empty table, replacing any table with the same name.
+func (f *Fake) CreateTable(schema TableSchema) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tables[schema.Name] = &table{schema: schema, items: map[string]item{}}
+}
+
+// Put stores an item unconditionally. It is meant for seeding test state and panics on an
+// unknown table or an item without its key attributes.
+func (f *Fake) Put(tableName string, it map[string]types.AttributeValue) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := f.mustTable(tableName)
+	k, err := t.itemKey(it)
+	if err != nil {
+		panic(err)
+	}
+	t.items[k] = copyItem(it)
+}
+
+// Item returns a copy of the item with the given string key, or nil if there is none.
+// It panics on an unknown table.
+func (f *Fake) Item(tableName, hashKey string, rangeKey ...string) map[string]types.AttributeValue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := f.mustTable(tableName)
+	key := item{t.schema.HashKey: &types.AttributeValueMemberS{Value: hashKey}}
+	if len(rangeKey) > 0 {
+		key[t.schema.RangeKey] = &types.AttributeValueMemberS{Value: rangeKey[0]}
+	}
+	k, err := t.keyOf(key)
+	if err != nil {
+		panic(err)
+	}
+	return copyItem(t.items[k])
+}
+
+// Items returns copies of every item in a table, in no particular order.
+func (f *Fake) Items(tableName string) []map[string]types.AttributeValue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := f.mustTable(tableName)
+	out := make([]map[string]types.AttributeValue, 0, len(t.items))
+	for _, it := range t.items {
+		out = append(out, copyItem(it))
+	}
+	return out
+}
+
+func (f *Fake) mustTable(name string) *table {
+	t, ok := f.tables[name]
+	if !ok {
+		panic(fmt.Sprintf("dynamofake: unknown table %q", name))
+	}
+	return t
+}
+
+func (f *Fake) table(name *string) (*table, error) {
+	if name == nil || *name == "" {
+		return nil, validationError("1 validation error detected: Value null at 'tableName' failed to satisfy constraint: Member must not be null")
+	}
+	t, ok := f.tables[*name]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: strPtr("Requested resource not found: Table: " + *name + " not found")}
+	}
+	return t, nil
+}
+
+// PutItem implements aws.DynamoDBAPI.
+func (f *Fake) PutItem(ctx context.Context, params *dyn.PutItemInput, optFns ...func(*dyn.Options)) (*dyn.PutItemOutput, error) {
+	if rv := params.ReturnValues; rv != "" && rv != types.ReturnValueNone && rv != types.ReturnValueAllOld {
+		return nil, validationError("ReturnValues can only be ALL_OLD or NONE")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w, err := f.preparePut(params.TableName, params.Item, params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	old, next, err := w.run(params.ReturnValuesOnConditionCheckFailure)
+	if err != nil {
+		return nil, err
+	}
+	w.commit(next)
+	out := &dyn.PutItemOutput{}
+	if params.ReturnValues == types.ReturnValueAllOld {
+		out.Attributes = copyItem(old)
+	}
+	return out, nil
+}
+
+// GetItem implements aws.DynamoDBAPI.
+func (f *Fake) GetItem(ctx context.Context, params *dyn.GetItemInput, optFns ...func(*dyn.Options)) (*dyn.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, err := f.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	k, err := t.keyOf(params.Key)
+	if err != nil {
+		return nil, err
+	}
+	p := newParser(params.ExpressionAttributeNames, nil)
+	var paths []docPath
+	if params.ProjectionExpression != nil {
+		if paths, err = p.parseProjection(*params.ProjectionExpression); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.checkUnused(); err != nil {
+		return nil, err
+	}
+	return &dyn.GetItemOutput{Item: project(copyItem(t.items[k]), paths)}, nil
+}
+
+// UpdateItem implements aws.DynamoDBAPI. Like DynamoDB it creates the item when it does not
+// exist and no condition prevents it.
+func (f *Fake) UpdateItem(ctx context.Context, params *dyn.UpdateItemInput, optFns ...func(*dyn.Options)) (*dyn.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w, u, err := f.prepareUpdate(params.TableName, params.Key, params.UpdateExpression, params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	old, next, err := w.run(params.ReturnValuesOnConditionCheckFailure)
+	if err != nil {
+		return nil, err
+	}
+	w.commit(next)
+
+	out := &dyn.UpdateItemOutput{}
+	switch params.ReturnValues {
+	case types.ReturnValueAllNew:
+		out.Attributes = copyItem(next)
+	case types.ReturnValueAllOld:
+		out.Attributes = copyItem(old)
+	case types.ReturnValueUpdatedNew, types.ReturnValueUpdatedOld:
+		src := next
+		if params.ReturnValues == types.ReturnValueUpdatedOld {
+			src = old
+		}
+		out.Attributes = item{}
+		for _, name := range u.touched() {
+			if v, ok := src[name]; ok {
+				out.Attributes[name] = copyValue(v)
+			}
+		}
+	}
+	return out, nil
+}
+
+// TransactWriteItems implements aws.DynamoDBAPI. Every condition is checked before anything is
+// written; if any fails, nothing is written and a TransactionCanceledException carries one
+// CancellationReason per action, in request order.
+func (f *Fake) TransactWriteItems(ctx context.Context, params *dyn.TransactWriteItemsInput, optFns ...func(*dyn.Options)) (*dyn.TransactWriteItemsOutput, error) {
+	if n := len(params.TransactItems); n == 0 || n > maxTransactItems {
+		return nil, validationError("Member must have length between 1 and %d", maxTransactItems)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	writes := make([]*write, len(params.TransactItems))
+	onFailure := make([]types.ReturnValuesOnConditionCheckFailure, len(params.TransactItems))
+	seen := map[string]bool{}
+	for i, ti := range params.TransactItems {
+		var (
+			w   *write
+			err error
+			ops int
+		)
+		if p := ti.Put; p != nil {
+			ops++
+			w, err = f.preparePut(p.TableName, p.Item, p.ConditionExpression, p.ExpressionAttributeNames, p.ExpressionAttributeValues)
+			onFailure[i] = p.ReturnValuesOnConditionCheckFailure
+		}
+		if u := ti.Update; u != nil {
+			ops++
+			w, _, err = f.prepareUpdate(u.TableName, u.Key, u.UpdateExpression, u.ConditionExpression, u.ExpressionAttributeNames, u.ExpressionAttributeValues)
+			onFailure[i] = u.ReturnValuesOnConditionCheckFailure
+		}
+		if d := ti.Delete; d != nil {
+			ops++
+			w, err = f.prepareDelete(d.TableName, d.Key, d.ConditionExpression, d.ExpressionAttributeNames, d.ExpressionAttributeValues)
+			onFailure[i] = d.ReturnValuesOnConditionCheckFailure
+		}
+		if c := ti.ConditionCheck; c != nil {
+			ops++
+			if c.ConditionExpression == nil {
+				return nil, validationError("ConditionCheck requires a ConditionExpression")
+			}
+			w, err = f.prepareCheck(c.TableName, c.Key, c.ConditionExpression, c.ExpressionAttributeNames, c.ExpressionAttributeValues)
+			onFailure[i] = c.ReturnValuesOnConditionCheckFailure
+		}
+		if ops != 1 {
+			return nil, validationError("TransactItems[%d] must specify exactly one of Put, Update, Delete or ConditionCheck", i)
+		}
+		if err != nil {
+			return nil, err
+		}
+		id := w.table.schema.Name + "\x00" + w.key
+		if seen[id] {
+			return nil, validationError("Transaction request cannot include multiple operations on one item")
+		}
+		seen[id] = true
+		writes[i] = w
+	}
+
+	reasons := make([]types.CancellationReason, len(writes))
+	results := make([]item, len(writes))
+	canceled := false
+	for i, w := range writes {
+		_, next, err := w.run(onFailure[i])
+		if err != nil {
+			canceled = true
+			reasons[i] = cancellationReason(err)
+			continue
+		}
+		reasons[i] = types.CancellationReason{Code: strPtr("None")}
+		results[i] = next
+	}
+	if canceled {
+		codes := make([]string, len(reasons))
+		for i, r := range reasons {
+			codes[i] = *r.Code
+		}
+		return nil, &types.TransactionCanceledException{
+			Message:             strPtr("Transaction cancelled, please refer cancellation reasons for specific reasons [" + strings.Join(codes, ", ") + "]"),
+			CancellationReasons: reasons,
+		}
+	}
+	for i, w := range writes {
+		if !w.checkOnly {
+			w.commit(results[i])
+		}
+	}
+	return &dyn.TransactWriteItemsOutput{}, nil
+}
+
+func cancellationReason(err error) types.CancellationReason {
+	if ccf, ok := err.(*types.ConditionalCheckFailedException); ok {
+		return types.CancellationReason{Code: strPtr("ConditionalCheckFailed"), Message: ccf.Message, Item: ccf.Item}
+	}
+	msg := err.Error()
+	if ae, ok := err.(smithy.APIError); ok {
+		msg = ae.ErrorMessage()
+	}
+	return types.CancellationReason{Code: strPtr("ValidationError"), Message: &msg}
+}
+
+// Query implements aws.DynamoDBAPI against a table or one of its indexes. Limit caps the
+// number of items evaluated before the FilterExpression is applied, as in DynamoDB, and
+// LastEvaluatedKey is returned whenever the limit is reached.
+func (f *Fake) Query(ctx context.Context, params *dyn.QueryInput, optFns ...func(*dyn.Options)) (*dyn.QueryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, err := f.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	hashKey, rangeKey := t.schema.HashKey, t.schema.RangeKey
+	if params.IndexName != nil {
+		idx, ok := t.index(*params.IndexName)
+		if !ok {
+			return nil, validationError("The table does not have the specified index: %s", *params.IndexName)
+		}
+		if params.ConsistentRead != nil && *params.ConsistentRead {
+			return nil, validationError("Consistent reads are not supported on global secondary indexes")
+		}
+		hashKey, rangeKey = idx.HashKey, idx.RangeKey
+	}
+	if params.KeyConditionExpression == nil {
+		return nil, validationError("Either the KeyConditions or KeyConditionExpression parameter must be specified in the request.")
+	}
+	if params.Limit != nil && *params.Limit <= 0 {
+		return nil, validationError("Limit must be greater than or equal to 1")
+	}
+
+	p := newParser(params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	keyCond, err := p.parseCondition(*params.KeyConditionExpression)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyCondition(keyCond, hashKey, rangeKey); err != nil {
+		return nil, err
+	}
+	var filter condition
+	if params.FilterExpression != nil {
+		if filter, err = p.parseCondition(*params.FilterExpression); err != nil {
+			return nil, err
+		}
+	}
+	var paths []docPath
+	if params.ProjectionExpression != nil {
+		if paths, err = p.parseProjection(*params.ProjectionExpression); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.checkUnused(); err != nil {
+		return nil, err
+	}
+
+	var matched []item
+	for _, it := range t.items {
+		if rangeKey != "" && it[rangeKey] == nil {
+			continue // not projected into a sparse index
+		}
+		if keyCond.eval(it) {
+			matched = append(matched, it)
+		}
+	}
+	forward := params.ScanIndexForward == nil || *params.ScanIndexForward
+	before := func(a, b item) bool {
+		c := 0
+		if rangeKey != "" {
+			c, _ = compare(a[rangeKey], b[rangeKey])
+		}
+		if c == 0 {
+			ak, _ := t.itemKey(a)
+			bk, _ := t.itemKey(b)
+			c = strings.Compare(ak, bk)
+		}
+		if forward {
+			return c < 0
+		}
+		return c > 0
+	}
+	sort.Slice(matched, func(i, j int) bool { return before(matched[i], matched[j]) })
+	if start := params.ExclusiveStartKey; start != nil {
+		i := sort.Search(len(matched), func(i int) bool { return before(start, matched[i]) })
+		matched = matched[i:]
+	}
+
+	out := &dyn.QueryOutput{Items: []map[string]types.AttributeValue{}}
+	evaluated := matched
+	if params.Limit != nil && int(*params.Limit) <= len(matched) {
+		evaluated = matched[:*params.Limit]
+		last := evaluated[len(evaluated)-1]
+		out.LastEvaluatedKey = t.keyAttrs(last)
+		out.LastEvaluatedKey[hashKey] = copyValue(last[hashKey])
+		if rangeKey != "" {
+			out.LastEvaluatedKey[rangeKey] = copyValue(last[rangeKey])
+		}
+	}
+	for _, it := range evaluated {
+		if filter != nil && !filter.eval(it) {
+			continue
+		}
+		out.Items = append(out.Items, project(copyItem(it), paths))
+	}
+	out.Count = int32(len(out.Items))
+	out.ScannedCount = int32(len(evaluated))
+	return out, nil
+}
+
+// checkKeyCondition accepts only what DynamoDB allows: equality on the hash key, optionally
+// ANDed with one comparison, BETWEEN or begins_with on the range key.
+func checkKeyCondition(c condition, hashKey, rangeKey string) error {
+	var leaves []condition
+	var walk func(condition) bool
+	walk = func(c condition) bool {
+		if a, ok := c.(andCond); ok {
+			return walk(a.left) && walk(a.right)
+		}
+		leaves = append(leaves, c)
+		return true
+	}
+	walk(c)
+	invalid := validationError("Query key condition not supported")
+	keyName := func(o operand) string {
+		if p, ok := o.(pathOperand); ok && len(p.path) == 1 {
+			return p.path[0].name
+		}
+		return ""
+	}
+	var hashOK, rangeSeen bool
+	for _, leaf := range leaves {
+		var name string
+		switch l := leaf.(type) {
+		case compareCond:
+			if l.op == "<>" {
+				return invalid
+			}
+			name = keyName(l.left)
+			if name == hashKey && l.op == "=" && !hashOK {
+				hashOK = true
+				continue
+			}
+		case betweenCond:
+			name = keyName(l.v)
+		case funcCond:
+			if l.fn == "begins_with" && len(l.path) == 1 {
+				name = l.path[0].name
+			}
+		default:
+			return invalid
+		}
+		if name == "" || name != rangeKey || rangeSeen {
+			return invalid
+		}
+		rangeSeen = true
+	}
+	if !hashOK {
+		return invalid
+	}
+	return nil
+}
+
+func (t *table) index(name string) (IndexSchema, bool) {
+	for _, idx := range t.schema.Indexes {
+		if idx.Name == name {
+			return idx, true
+		}
+	}
+	return IndexSchema{}, false
+}
+
+func (t *table) keyNames() []string {
+	if t.schema.RangeKey == "" {
+		return []string{t.schema.HashKey}
+	}
+	return []string{t.schema.HashKey, t.schema.RangeKey}
+}
+
+// keyOf validates a Key parameter (exactly the primary key attributes) and encodes it.
+func (t *table) keyOf(key item) (string, error) {
+	if len(key) != len(t.keyNames()) {
+		return "", validationError("The provided key element does not match the schema")
+	}
+	return t.itemKey(key)
+}
+
+// itemKey encodes the primary key of a full item.
+func (t *table) itemKey(it item) (string, error) {
+	parts := make([]string, 0, 2)
+	for _, name := range t.keyNames() {
+		v, ok := it[name]
+		if !ok || !isKeyType(v) {
+			return "", validationError("One or more parameter values were invalid: Missing the key %s in the item", name)
+		}
+		parts = append(parts, keyString(v))
+	}
+	return strings.Join(parts, "\x00"), nil
+}
+
+func (t *table) keyAttrs(it item) item {
+	out := item{}
+	for _, name := range t.keyNames() {
+		out[name] = copyValue(it[name])
+	}
+	return out
+}
+
+// write is one prepared single-item action: a condition plus a function producing the new item.
+type write struct {
+	table     *table
+	key       string
+	cond      condition
+	next      func(old item) (item, error) // nil result deletes the item
+	checkOnly bool
+}
+
+// run evaluates the condition against the current item (an absent item has no attributes)
+// and computes the new item without storing it.
+func (w *write) run(onFailure types.ReturnValuesOnConditionCheckFailure) (old, next item, err error) {
+	old = w.table.items[w.key]
+	if w.cond != nil && !w.cond.eval(old) {
+		ccf := &types.ConditionalCheckFailedException{Message: strPtr("The conditional request failed")}
+		if onFailure == types.ReturnValuesOnConditionCheckFailureAllOld {
+			ccf.Item = copyItem(old)
+		}
+		return old, nil, ccf
+	}
+	if w.checkOnly {
+		return old, old, nil
+	}
+	next, err = w.next(old)
+	return old, next, err
+}
+
+func (w *write) commit(next item) {
+	if next == nil {
+		delete(w.table.items, w.key)
+		return
+	}
+	w.table.items[w.key] = next
+}
+
+func (f *Fake) prepare(tableName, cond *string, names map[string]string, values map[string]types.AttributeValue, parse func(*parser) error) (*table, condition, error) {
+	t, err := f.table(tableName)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := newParser(names, values)
+	var c condition
+	if cond != nil {
+		if c, err = p.parseCondition(*cond); err != nil {
+			return nil, nil, err
+		}
+	}
+	if parse != nil {
+		if err := parse(p); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := p.checkUnused(); err != nil {
+		return nil, nil, err
+	}
+	return t, c, nil
+}
+
+func (f *Fake) preparePut(tableName *string, it item, cond *string, names map[string]string, values map[string]types.AttributeValue) (*write, error) {
+	t, c, err := f.prepare(tableName, cond, names, values, nil)
+	if err != nil {
+		return nil, err
+	}
+	k, err := t.itemKey(it)
+	if err != nil {
+		return nil, err
+	}
+	stored := copyItem(it)
+	return &write{table: t, key: k, cond: c, next: func(item) (item, error) { return copyItem(stored), nil }}, nil
+}
+
+func (f *Fake) prepareUpdate(tableName *string, key item, updateExpr, cond *string, names map[string]string, values map[string]types.AttributeValue) (*write, *update, error) {
+	if updateExpr == nil {
+		return nil, nil, validationError("UpdateExpression is required")
+	}
+	var u *update
+	t, c, err := f.prepare(tableName, cond, names, values, func(p *parser) (err error) {
+		u, err = p.parseUpdate(*updateExpr)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	k, err := t.keyOf(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range u.touched() {
+		for _, kn := range t.keyNames() {
+			if name == kn {
+				return nil, nil, validationError("One or more parameter values were invalid: Cannot update attribute %s. This attribute is part of the key", name)
+			}
+		}
+	}
+	keyAttrs := copyItem(key)
+	return &write{table: t, key: k, cond: c, next: func(old item) (item, error) {
+		if old == nil {
+			old = keyAttrs
+		}
+		return u.apply(old)
+	}}, u, nil
+}
+
+func (f *Fake) prepareDelete(tableName *string, key item, cond *string, names map[string]string, values map[string]types.AttributeValue) (*write, error) {
+	t, c, err := f.prepare(tableName, cond, names, values, nil)
+	if err != nil {
+		return nil, err
+	}
+	k, err := t.keyOf(key)
+	if err != nil {
+		return nil, err
+	}
+	return &write{table: t, key: k, cond: c, next: func(item) (item, error) { return nil, nil }}, nil
+}
+
+func (f *Fake) prepareCheck(tableName *string, key item, cond *string, names map[string]string, values map[string]types.AttributeValue) (*write, error) {
+	w, err := f.prepareDelete(tableName, key, cond, names, values)
+	if err != nil {
+		return nil, err
+	}
+	w.checkOnly = true
+	return w, nil
+}
+
+func validationError(format string, args ...interface{}) error {
+	return &smithy.GenericAPIError{Code: "ValidationException", Message: fmt.Sprintf(format, args...), Fault: smithy.FaultClient}
+}
+
+func strPtr(s string) *string { return &s }
@@ -0,0 +1,322 @@
+package dynamofake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	dyn "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+func s(v string) *types.AttributeValueMemberS { return &types.AttributeValueMemberS{Value: v} }
+func n(v string) *types.AttributeValueMemberN { return &types.AttributeValueMemberN{Value: v} }
+func str(v string) *string                    { return &v }
+
+func newTestFake() *Fake {
+	return New(
+		TableSchema{Name: "things", HashKey: "id"},
+		TableSchema{Name: "events", HashKey: "event_id", Indexes: []IndexSchema{{Name: "by_owner", HashKey: "owner", RangeKey: "at"}}},
+	)
+}
+
+func errorCode(err error) string {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		return ae.ErrorCode()
+	}
+	return ""
+}
+
+func TestConditionExpressions(t *testing.T) {
+	f := newTestFake()
+	f.Put("things", map[string]types.AttributeValue{
+		"id":     s("t1"),
+		"status": s("ACTIVE"),
+		"count":  n("5"),
+		"tags":   &types.AttributeValueMemberSS{Value: []string{"a", "b"}},
+		"meta":   &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"owner": s("bob")}},
+	})
+
+	cases := []struct {
+		cond   string
+		values map[string]types.AttributeValue
+		pass   bool
+	}{
+		{"attribute_exists(id)", nil, true},
+		{"attribute_not_exists(id)", nil, false},
+		{"#s = :v", map[string]types.AttributeValue{":v": s("ACTIVE")}, true},
+		{"#s <> :v", map[string]types.AttributeValue{":v": s("ACTIVE")}, false},
+		{"missing <> :v", map[string]types.AttributeValue{":v": s("x")}, true},
+		{"#c < :v", map[string]types.AttributeValue{":v": n("10")}, true},
+		{"#c > :v", map[string]types.AttributeValue{":v": n("10")}, false},
+		{"#c = :v", map[string]types.AttributeValue{":v": n("5.0")}, true},
+		{"#c = :v", map[string]types.AttributeValue{":v": s("5")}, false},
+		{"#c BETWEEN :a AND :b", map[string]types.AttributeValue{":a": n("1"), ":b": n("5")}, true},
+		{"#s IN (:a, :b)", map[string]types.AttributeValue{":a": s("X"), ":b": s("ACTIVE")}, true},
+		{"NOT (#s = :a) OR #c >= :b", map[string]types.AttributeValue{":a": s("ACTIVE"), ":b": n("5")}, true},
+		{"#s = :a AND (#c < :b OR attribute_not_exists(id))", map[string]types.AttributeValue{":a": s("ACTIVE"), ":b": n("1")}, false},
+		{"begins_with(#s, :p)", map[string]types.AttributeValue{":p": s("ACT")}, true},
+		{"contains(tags, :t)", map[string]types.AttributeValue{":t": s("b")}, true},
+		{"size(tags) = :n", map[string]types.AttributeValue{":n": n("2")}, true},
+		{"attribute_type(#c, :t)", map[string]types.AttributeValue{":t": s("N")}, true},
+		{"meta.#o = :v", map[string]types.AttributeValue{":v": s("bob")}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.cond, func(t *testing.T) {
+			names := map[string]string{}
+			for ph, name := range map[string]string{"#s": "status", "#c": "count", "#o": "owner"} {
+				if strings.Contains(tc.cond, ph) {
+					names[ph] = name
+				}
+			}
+			_, err := f.PutItem(context.Background(), &dyn.PutItemInput{
+				TableName:                 str("things"),
+				Item:                      f.Item("things", "t1"),
+				ConditionExpression:       &tc.cond,
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: tc.values,
+			})
+			var ccf *types.ConditionalCheckFailedException
+			if tc.pass && err != nil {
+				t.Fatalf("expected condition to pass, got %v", err)
+			}
+			if !tc.pass && !errors.As(err, &ccf) {
+				t.Fatalf("expected ConditionalCheckFailedException, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExpressionValidation(t *testing.T) {
+	f := newTestFake()
+	ctx := context.Background()
+
+	_, err := f.PutItem(ctx, &dyn.PutItemInput{
+		TableName:                 str("things"),
+		Item:                      map[string]types.AttributeValue{"id": s("t1")},
+		ConditionExpression:       str("attribute_not_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":unused": s("x")},
+	})
+	if errorCode(err) != "ValidationException" {
+		t.Fatalf("expected ValidationException for unused value, got %v", err)
+	}
+
+	_, err = f.PutItem(ctx, &dyn.PutItemInput{
+		TableName:           str("things"),
+		Item:                map[string]types.AttributeValue{"id": s("t1")},
+		ConditionExpression: str("#s = :missing"),
+	})
+	if errorCode(err) != "ValidationException" {
+		t.Fatalf("expected ValidationException for undefined placeholders, got %v", err)
+	}
+
+	_, err = f.UpdateItem(ctx, &dyn.UpdateItemInput{
+		TableName:                 str("things"),
+		Key:                       map[string]types.AttributeValue{"id": s("t1")},
+		UpdateExpression:          str("SET id = :v"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":v": s("t2")},
+	})
+	if errorCode(err) != "ValidationException" {
+		t.Fatalf("expected ValidationException for key update, got %v", err)
+	}
+
+	_, err = f.GetItem(ctx, &dyn.GetItemInput{TableName: str("nope"), Key: map[string]types.AttributeValue{"id": s("t1")}})
+	var rnf *types.ResourceNotFoundException
+	if !errors.As(err, &rnf) {
+		t.Fatalf("expected ResourceNotFoundException, got %v", err)
+	}
+}
+
+func TestUpdateExpressions(t *testing.T) {
+	f := newTestFake()
+	ctx := context.Background()
+	update := func(expr string, values map[string]types.AttributeValue) map[string]types.AttributeValue {
+		t.Helper()
+		out, err := f.UpdateItem(ctx, &dyn.UpdateItemInput{
+			TableName:                 str("things"),
+			Key:                       map[string]types.AttributeValue{"id": s("t1")},
+			UpdateExpression:          &expr,
+			ExpressionAttributeValues: values,
+			ReturnValues:              types.ReturnValueAllNew,
+		})
+		if err != nil {
+			t.Fatalf("update %q: %v", expr, err)
+		}
+		return out.Attributes
+	}
+
+	// upsert: the item does not exist yet
+	got := update("SET attempts = if_not_exists(attempts, :zero) + :one, note = :n",
+		map[string]types.AttributeValue{":zero": n("0"), ":one": n("1"), ":n": s("first")})
+	if got["attempts"].(*types.AttributeValueMemberN).Value != "1" || got["id"].(*types.AttributeValueMemberS).Value != "t1" {
+		t.Fatalf("unexpected item after upsert: %+v", got)
+	}
+
+	got = update("SET attempts = if_not_exists(attempts, :zero) + :one, price = :p - :d REMOVE note ADD tags :t",
+		map[string]types.AttributeValue{
+			":zero": n("0"), ":one": n("1"), ":p": n("10.5"), ":d": n("0.25"),
+			":t": &types.AttributeValueMemberSS{Value: []string{"x", "y"}},
+		})
+	if got["attempts"].(*types.AttributeValueMemberN).Value != "2" {
+		t.Fatalf("expected attempts 2, got %+v", got["attempts"])
+	}
+	if got["price"].(*types.AttributeValueMemberN).Value != "10.25" {
+		t.Fatalf("expected price 10.25, got %+v", got["price"])
+	}
+	if _, ok := got["note"]; ok {
+		t.Fatalf("expected note to be removed")
+	}
+
+	got = update("ADD attempts :one DELETE tags :t SET hist = list_append(if_not_exists(hist, :empty), :e)",
+		map[string]types.AttributeValue{
+			":one":   n("1"),
+			":t":     &types.AttributeValueMemberSS{Value: []string{"x"}},
+			":empty": &types.AttributeValueMemberL{},
+			":e":     &types.AttributeValueMemberL{Value: []types.AttributeValue{s("e1")}},
+		})
+	if got["attempts"].(*types.AttributeValueMemberN).Value != "3" {
+		t.Fatalf("expected attempts 3, got %+v", got["attempts"])
+	}
+	if tags := got["tags"].(*types.AttributeValueMemberSS).Value; len(tags) != 1 || tags[0] != "y" {
+		t.Fatalf("expected tags [y], got %v", tags)
+	}
+	if hist := got["hist"].(*types.AttributeValueMemberL).Value; len(hist) != 1 {
+		t.Fatalf("expected one history entry, got %v", hist)
+	}
+
+	// arithmetic on a string is rejected and nothing is written
+	_, err := f.UpdateItem(ctx, &dyn.UpdateItemInput{
+		TableName:                 str("things"),
+		Key:                       map[string]types.AttributeValue{"id": s("t1")},
+		UpdateExpression:          str("SET attempts = :s + :one"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":s": s("x"), ":one": n("1")},
+	})
+	if errorCode(err) != "ValidationException" {
+		t.Fatalf("expected ValidationException, got %v", err)
+	}
+	if f.Item("things", "t1")["attempts"].(*types.AttributeValueMemberN).Value != "3" {
+		t.Fatalf("failed update must not modify the item")
+	}
+}
+
+func TestTransactWriteItems_AtomicWithCancellationReasons(t *testing.T) {
+	f := newTestFake()
+	ctx := context.Background()
+	f.Put("things", map[string]types.AttributeValue{"id": s("exists")})
+
+	_, err := f.TransactWriteItems(ctx, &dyn.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Put: &types.Put{TableName: str("things"), Item: map[string]types.AttributeValue{"id": s("new")}}},
+		{Put: &types.Put{
+			TableName:                           str("things"),
+			Item:                                map[string]types.AttributeValue{"id": s("exists"), "v": n("2")},
+			ConditionExpression:                 str("attribute_not_exists(id)"),
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		}},
+	}})
+	var tce *types.TransactionCanceledException
+	if !errors.As(err, &tce) {
+		t.Fatalf("expected TransactionCanceledException, got %v", err)
+	}
+	if len(tce.CancellationReasons) != 2 || *tce.CancellationReasons[0].Code != "None" || *tce.CancellationReasons[1].Code != "ConditionalCheckFailed" {
+		t.Fatalf("unexpected cancellation reasons: %+v", tce.CancellationReasons)
+	}
+	if tce.CancellationReasons[1].Item["id"] == nil {
+		t.Fatalf("expected ALL_OLD item on the failed reason")
+	}
+	if f.Item("things", "new") != nil {
+		t.Fatalf("canceled transaction must not write any item")
+	}
+
+	// same item twice is a validation error
+	_, err = f.TransactWriteItems(ctx, &dyn.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Put: &types.Put{TableName: str("things"), Item: map[string]types.AttributeValue{"id": s("a")}}},
+		{Delete: &types.Delete{TableName: str("things"), Key: map[string]types.AttributeValue{"id": s("a")}}},
+	}})
+	if errorCode(err) != "ValidationException" {
+		t.Fatalf("expected ValidationException, got %v", err)
+	}
+
+	// all conditions pass: every action applies
+	_, err = f.TransactWriteItems(ctx, &dyn.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{ConditionCheck: &types.ConditionCheck{TableName: str("things"), Key: map[string]types.AttributeValue{"id": s("exists")}, ConditionExpression: str("attribute_exists(id)")}},
+		{Put: &types.Put{TableName: str("things"), Item: map[string]types.AttributeValue{"id": s("new")}, ConditionExpression: str("attribute_not_exists(id)")}},
+		{Update: &types.Update{
+			TableName:                 str("events"),
+			Key:                       map[string]types.AttributeValue{"event_id": s("e1")},
+			UpdateExpression:          str("SET #o = :o"),
+			ExpressionAttributeNames:  map[string]string{"#o": "owner"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":o": s("bob")},
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("transaction: %v", err)
+	}
+	if f.Item("things", "new") == nil || f.Item("events", "e1") == nil {
+		t.Fatalf("expected both tables to be written")
+	}
+}
+
+func TestQuery_IndexPagingAndFilter(t *testing.T) {
+	f := newTestFake()
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		kind := "a"
+		if i%2 == 1 {
+			kind = "b"
+		}
+		f.Put("events", map[string]types.AttributeValue{
+			"event_id": s(fmt.Sprintf("e%d", i)),
+			"owner":    s("bob"),
+			"at":       s(fmt.Sprintf("2025-01-0%d", i+1)),
+			"kind":     s(kind),
+		})
+	}
+	f.Put("events", map[string]types.AttributeValue{"event_id": s("other"), "owner": s("amy"), "at": s("2025-01-01")})
+	f.Put("events", map[string]types.AttributeValue{"event_id": s("sparse"), "owner": s("bob")}) // no range key: not in the index
+
+	query := func(start map[string]types.AttributeValue) *dyn.QueryOutput {
+		t.Helper()
+		out, err := f.Query(ctx, &dyn.QueryInput{
+			TableName:                 str("events"),
+			IndexName:                 str("by_owner"),
+			KeyConditionExpression:    str("#o = :o AND #at >= :from"),
+			FilterExpression:          str("kind = :k"),
+			ExpressionAttributeNames:  map[string]string{"#o": "owner", "#at": "at"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":o": s("bob"), ":from": s("2025-01-02"), ":k": s("b")},
+			Limit:                     func(v int32) *int32 { return &v }(2),
+			ScanIndexForward:          func(v bool) *bool { return &v }(false),
+			ExclusiveStartKey:         start,
+		})
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		return out
+	}
+
+	// newest first, Limit counts items before the filter
+	first := query(nil)
+	if first.ScannedCount != 2 || len(first.Items) != 1 || first.Items[0]["event_id"].(*types.AttributeValueMemberS).Value != "e3" {
+		t.Fatalf("unexpected first page: %+v", first)
+	}
+	if first.LastEvaluatedKey["event_id"] == nil || first.LastEvaluatedKey["at"] == nil {
+		t.Fatalf("expected LastEvaluatedKey with table and index keys, got %+v", first.LastEvaluatedKey)
+	}
+	second := query(first.LastEvaluatedKey)
+	if len(second.Items) != 1 || second.Items[0]["event_id"].(*types.AttributeValueMemberS).Value != "e1" {
+		t.Fatalf("unexpected second page: %+v", second.Items)
+	}
+
+	_, err := f.Query(ctx, &dyn.QueryInput{
+		TableName:                 str("events"),
+		IndexName:                 str("by_owner"),
+		KeyConditionExpression:    str("kind = :k"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":k": s("a")},
+	})
+	if errorCode(err) != "ValidationException" {
+		t.Fatalf("expected ValidationException for non-key condition, got %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+// Package sqsfake is an in-memory SQS standard queue. It implements aws.SQSAPI with visibility
+// timeouts, receipt handles and long polling, so consumers can be exercised without AWS.
+package sqsfake
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultVisibilityTimeout is used when neither the queue nor ReceiveMessage sets one, as in SQS.
+	DefaultVisibilityTimeout = 30 * time.Second
+	// maxReceive is SQS's limit on MaxNumberOfMessages.
+	maxReceive = 10
+	// maxVisibilitySeconds is SQS's limit on a visibility timeout (12 hours).
+	maxVisibilitySeconds = 12 * 60 * 60
+)
+
+// Fake is a concurrency-safe in-memory SQS holding any number of queues.
+type Fake struct {
+	mu      sync.Mutex
+	queues  map[string]*queue // by queue URL
+	nowFunc func() time.Time
+}
+
+type queue struct {
+	visibility time.Duration
+	messages   []*message    // in send order
+	notify     chan struct{} // closed and replaced whenever a message is sent
+}
+
+type message struct {
+	id            string
+	body          string
+	attributes    map[string]types.MessageAttributeValue
+	visibleAt     time.Time
+	receiptHandle string // of the latest receive; empty until received
+	receiveCount  int
+	sentAt        time.Time
+}
+
+// New creates an empty Fake.
+func New() *Fake {
+	return &Fake{queues: map[string]*queue{}, nowFunc: time.Now}
+}
+
+// CreateQueue adds an empty queue and returns its URL. A zero visibility uses DefaultVisibilityTimeout.
+func (f *Fake) CreateQueue(name string, visibility time.Duration) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if visibility <= 0 {
+		visibility = DefaultVisibilityTimeout
+	}
+	url := "https://sqs.local/000000000000/" + name
+	f.queues[url] = &queue{visibility: visibility, notify: make(chan struct{})}
+	return url
+}
+
+// Len returns the number of messages in the queue, in flight or not. It panics on an unknown queue.
+func (f *Fake) Len(queueURL string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q, ok := f.queues[queueURL]
+	if !ok {
+		panic(fmt.Sprintf("sqsfake: unknown queue %q", queueURL))
+	}
+	return len(q.messages)
+}
+
+// SendMessage implements aws.SQSAPI.
+func (f *Fake) SendMessage(ctx context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q, err := f.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if in.MessageBody == nil || *in.MessageBody == "" {
+		return nil, validationError("MessageBody is required")
+	}
+	now := f.nowFunc()
+	m := &message{
+		id:         uuid.NewString(),
+		body:       *in.MessageBody,
+		attributes: copyAttributes(in.MessageAttributes),
+		visibleAt:  now.Add(time.Duration(in.DelaySeconds) * time.Second),
+		sentAt:     now,
+	}
+	q.messages = append(q.messages, m)
+	close(q.notify)
+	q.notify = make(chan struct{})
+	return &sqs.SendMessageOutput{MessageId: &m.id}, nil
+}
+
+// ReceiveMessage implements aws.SQSAPI. With WaitTimeSeconds set it blocks until a message is
+// visible, the wait elapses or ctx is done, like SQS long polling.
+func (f *Fake) ReceiveMessage(ctx context.Context, in *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	max := int(in.MaxNumberOfMessages)
+	if max == 0 {
+		max = 1
+	}
+	if max < 1 || max > maxReceive {
+		return nil, validationError(fmt.Sprintf("MaxNumberOfMessages must be between 1 and %d", maxReceive))
+	}
+	deadline := time.Now().Add(time.Duration(in.WaitTimeSeconds) * time.Second)
+
+	for {
+		f.mu.Lock()
+		q, err := f.queue(in.QueueUrl)
+		if err != nil {
+			f.mu.Unlock()
+			return nil, err
+		}
+		visibility := q.visibility
+		if in.VisibilityTimeout > 0 {
+			visibility = time.Duration(in.VisibilityTimeout) * time.Second
+		}
+		out := f.receive(q, max, visibility, in.MessageAttributeNames)
+		notify := q.notify
+		f.mu.Unlock()
+
+		wait := time.Until(deadline)
+		if len(out) > 0 || wait <= 0 {
+			return &sqs.ReceiveMessageOutput{Messages: out}, nil
+		}
+		// in-flight messages become visible again without a send, so re-check at least every 100ms
+		timer := time.NewTimer(min(wait, 100*time.Millisecond))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-notify:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// receive hides up to max visible messages for visibility and returns them. Caller holds f.mu.
+func (f *Fake) receive(q *queue, max int, visibility time.Duration, attrNames []string) []types.Message {
+	now := f.nowFunc()
+	var out []types.Message
+	for _, m := range q.messages {
+		if len(out) == max {
+			break
+		}
+		if m.visibleAt.After(now) {
+			continue
+		}
+		m.receiveCount++
+		m.receiptHandle = uuid.NewString()
+		m.visibleAt = now.Add(visibility)
+
+		id, body, handle := m.id, m.body, m.receiptHandle
+		out = append(out, types.Message{
+			MessageId:     &id,
+			Body:          &body,
+			ReceiptHandle: &handle,
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameApproximateReceiveCount): strconv.Itoa(m.receiveCount),
+				string(types.MessageSystemAttributeNameSentTimestamp):           strconv.FormatInt(m.sentAt.UnixMilli(), 10),
+			},
+			MessageAttributes: selectAttributes(m.attributes, attrNames),
+		})
+	}
+	return out
+}
+
+// DeleteMessage implements aws.SQSAPI. Only the receipt handle of the latest receive deletes the
+// message; an older handle is accepted but has no effect once the message was received again.
+func (f *Fake) DeleteMessage(ctx context.Context, in *sqs.DeleteMessageInput, _ ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q, err := f.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if in.ReceiptHandle == nil || *in.ReceiptHandle == "" {
+		return nil, &types.ReceiptHandleIsInvalid{Message: strPtr("ReceiptHandle is required")}
+	}
+	for i, m := range q.messages {
+		if m.receiptHandle == *in.ReceiptHandle {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			break
+		}
+	}
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+// ChangeMessageVisibility implements aws.SQSAPI. It hides the in-flight message identified by the
+// receipt handle for VisibilityTimeout seconds from now; 0 makes it visible immediately.
+func (f *Fake) ChangeMessageVisibility(ctx context.Context, in *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q, err := f.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if in.VisibilityTimeout < 0 || in.VisibilityTimeout > maxVisibilitySeconds {
+		return nil, validationError(fmt.Sprintf("VisibilityTimeout must be between 0 and %d", maxVisibilitySeconds))
+	}
+	now := f.nowFunc()
+	for _, m := range q.messages {
+		if in.ReceiptHandle != nil && m.receiptHandle == *in.ReceiptHandle {
+			if !m.visibleAt.After(now) {
+				return nil, &types.MessageNotInflight{Message: strPtr("message is not in flight")}
+			}
+			m.visibleAt = now.Add(time.Duration(in.VisibilityTimeout) * time.Second)
+			if in.VisibilityTimeout == 0 {
+				close(q.notify)
+				q.notify = make(chan struct{})
+			}
+			return &sqs.ChangeMessageVisibilityOutput{}, nil
+		}
+	}
+	return nil, &types.ReceiptHandleIsInvalid{Message: strPtr("receipt handle is not current")}
+}
+
+func (f *Fake) queue(url *string) (*queue, error) {
+	if url == nil {
+		return nil, validationError("QueueUrl is required")
+	}
+	q, ok := f.queues[*url]
+	if !ok {
+		return nil, &types.QueueDoesNotExist{Message: strPtr("The specified queue does not exist: " + *url)}
+	}
+	return q, nil
+}
+
+// selectAttributes returns the attributes named in names; "All" or ".*" selects every attribute.
+func selectAttributes(attrs map[string]types.MessageAttributeValue, names []string) map[string]types.MessageAttributeValue {
+	out := map[string]types.MessageAttributeValue{}
+	for _, n := range names {
+		if n == "All" || n == ".*" {
+			return copyAttributes(attrs)
+		}
+		if v, ok := attrs[n]; ok {
+			out[n] = v
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func copyAttributes(attrs map[string]types.MessageAttributeValue) map[string]types.MessageAttributeValue {
+	if attrs == nil {
+		return nil
+	}
+	out := make(map[string]types.MessageAttributeValue, len(attrs))
+	for k, v := range attrs {
+		if v.StringValue != nil {
+			s := *v.StringValue
+			v.StringValue = &s
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func validationError(msg string) error {
+	return &smithy.GenericAPIError{Code: "InvalidParameterValue", Message: msg}
+}
+
+func strPtr(s string) *string { return &s }
@@ -0,0 +1,97 @@
+package sqsfake
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func send(t *testing.T, f *Fake, url, body string) {
+	t.Helper()
+	if _, err := f.SendMessage(context.Background(), &sqs.SendMessageInput{QueueUrl: &url, MessageBody: &body}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+}
+
+func TestVisibilityTimeoutAndDelete(t *testing.T) {
+	f := New()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.nowFunc = func() time.Time { return now }
+	url := f.CreateQueue("q", 10*time.Second)
+	ctx := context.Background()
+	send(t, f, url, "m1")
+
+	first, err := f.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: &url})
+	if err != nil || len(first.Messages) != 1 {
+		t.Fatalf("first receive = %+v, %v", first, err)
+	}
+	// in flight: hidden until the visibility timeout expires
+	if out, _ := f.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: &url}); len(out.Messages) != 0 {
+		t.Fatalf("in-flight message must be hidden")
+	}
+
+	// a heartbeat pushes the visibility timeout out again
+	now = now.Add(9 * time.Second)
+	if _, err := f.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{QueueUrl: &url, ReceiptHandle: first.Messages[0].ReceiptHandle, VisibilityTimeout: 10}); err != nil {
+		t.Fatalf("ChangeMessageVisibility: %v", err)
+	}
+	now = now.Add(9 * time.Second)
+	if out, _ := f.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: &url}); len(out.Messages) != 0 {
+		t.Fatalf("extended message must stay hidden")
+	}
+
+	now = now.Add(2 * time.Second)
+	second, _ := f.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: &url})
+	if len(second.Messages) != 1 || second.Messages[0].Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)] != "2" {
+		t.Fatalf("expected redelivery with receive count 2, got %+v", second.Messages)
+	}
+
+	// the stale handle from the first receive does not delete the redelivered message
+	if _, err := f.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &url, ReceiptHandle: first.Messages[0].ReceiptHandle}); err != nil {
+		t.Fatalf("DeleteMessage stale: %v", err)
+	}
+	if f.Len(url) != 1 {
+		t.Fatalf("stale receipt handle must not delete the message")
+	}
+	if _, err := f.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &url, ReceiptHandle: second.Messages[0].ReceiptHandle}); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if f.Len(url) != 0 {
+		t.Fatalf("expected empty queue after delete")
+	}
+
+	missing := "https://sqs.local/000000000000/missing"
+	var qdne *types.QueueDoesNotExist
+	if _, err := f.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: &missing}); !errors.As(err, &qdne) {
+		t.Fatalf("expected QueueDoesNotExist, got %v", err)
+	}
+}
+
+func TestLongPollWakesOnSend(t *testing.T) {
+	f := New()
+	url := f.CreateQueue("q", 0)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		body := "late"
+		_, _ = f.SendMessage(context.Background(), &sqs.SendMessageInput{QueueUrl: &url, MessageBody: &body})
+	}()
+	start := time.Now()
+	out, err := f.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{QueueUrl: &url, WaitTimeSeconds: 5})
+	if err != nil || len(out.Messages) != 1 || *out.Messages[0].Body != "late" {
+		t.Fatalf("long poll = %+v, %v", out, err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("long poll did not wake up on send")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: &url, WaitTimeSeconds: 5}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+// Package tracing sets up OpenTelemetry tracing and holds the helpers the HTTP API, the DynamoDB
+// stores, the SQS publisher and the worker use to create spans and carry trace context between them.
+// Spans are created through the global tracer provider, which is a no-op until Setup or
+// NewRecorder installs one.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer every span in this module is created with.
+const instrumentationName = "github.com/imrishuroy/go-idempotent-orderflow"
+
+// Exporters selectable with Setup.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+)
+
+// propagator carries W3C trace context (traceparent/tracestate) in HTTP headers and SQS attributes.
+var propagator = propagation.TraceContext{}
+
+// Provider is the tracer provider installed for the process. Flush and Shutdown are no-ops when
+// tracing is off.
+type Provider struct {
+	tp   *sdktrace.TracerProvider
+	prev trace.TracerProvider
+}
+
+// Setup installs the global tracer provider for exporter: spans written as JSON lines to w for
+// ExporterStdout, or none for ExporterNone and "". service becomes the service.name resource attribute.
+func Setup(exporter, service string, w io.Writer) (*Provider, error) {
+	switch exporter {
+	case ExporterNone, "":
+		return &Provider{}, nil
+	case ExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return nil, fmt.Errorf("stdout trace exporter: %w", err)
+		}
+		return install(sdktrace.WithBatcher(exp), service), nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+}
+
+// NewRecorder installs a provider that keeps finished spans in memory, for tests.
+// Shutdown restores the previous global provider.
+func NewRecorder() (*Provider, *tracetest.SpanRecorder) {
+	rec := tracetest.NewSpanRecorder()
+	return install(sdktrace.WithSpanProcessor(rec), "test"), rec
+}
+
+func install(opt sdktrace.TracerProviderOption, service string) *Provider {
+	tp := sdktrace.NewTracerProvider(opt, sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(service))))
+	p := &Provider{tp: tp, prev: otel.GetTracerProvider()}
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+	return p
+}
+
+// Flush exports every finished span. Lambda handlers call it at the end of each invocation.
+func (p *Provider) Flush(ctx context.Context) error {
+	if p.tp == nil {
+		return nil
+	}
+	return p.tp.ForceFlush(ctx)
+}
+
+// Shutdown flushes and stops the provider and reinstalls the previous global provider.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	if p.tp == nil {
+		return nil
+	}
+	otel.SetTracerProvider(p.prev)
+	return p.tp.Shutdown(ctx)
+}
+
+// Start starts a span named name as a child of the span in ctx.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// StartDynamoDB starts a client span around a DynamoDB call made by a store.
+func StartDynamoDB(ctx context.Context, name string, tables ...string) (context.Context, trace.Span) {
+	return Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemNameAWSDynamoDB,
+		semconv.AWSDynamoDBTableNames(tables...),
+	))
+}
+
+// StartS3 starts a client span around an S3 call on key in bucket.
+func StartS3(ctx context.Context, name, bucket, key string) (context.Context, trace.Span) {
+	return Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.AWSS3Bucket(bucket),
+		semconv.AWSS3Key(key),
+	))
+}
+
+// StartSQSSend starts a producer span around publishing a message to queueURL.
+func StartSQSSend(ctx context.Context, queueURL string) (context.Context, trace.Span) {
+	return Start(ctx, "sqs send", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(
+		semconv.MessagingSystemAWSSQS,
+		semconv.MessagingOperationTypeSend,
+		semconv.MessagingDestinationName(queueURL),
+	))
+}
+
+// StartSQSProcess starts a consumer span around processing the received message messageID.
+// ctx should carry the trace context extracted from the message so the span continues the producer's trace.
+func StartSQSProcess(ctx context.Context, messageID string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Start(ctx, "sqs process", trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(
+		semconv.MessagingSystemAWSSQS,
+		semconv.MessagingOperationTypeProcess,
+		semconv.MessagingMessageID(messageID),
+	), trace.WithAttributes(attrs...))
+}
+
+// End ends span, recording *errp as its error unless it matches one of expected, which are
+// normal outcomes (a lost race, a duplicate) rather than failures. Use it deferred with a named
+// error result: defer tracing.End(span, &err).
+func End(span trace.Span, errp *error, expected ...error) {
+	defer span.End()
+	if errp == nil || *errp == nil {
+		return
+	}
+	err := *errp
+	for _, e := range expected {
+		if errors.Is(err, e) {
+			span.SetAttributes(attribute.String("outcome", err.Error()))
+			return
+		}
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Inject writes the trace context of ctx into attrs, e.g. SQS message attributes.
+func Inject(ctx context.Context, attrs map[string]string) {
+	propagator.Inject(ctx, propagation.MapCarrier(attrs))
+}
+
+// Extract returns ctx carrying the remote trace context found in attrs, if any.
+func Extract(ctx context.Context, attrs map[string]string) context.Context {
+	return propagator.Extract(ctx, propagation.MapCarrier(attrs))
+}
@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddleware_ContinuesIncomingTrace(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tp, rec := NewRecorder()
+	defer tp.Shutdown(context.Background())
+
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/orders/:id", func(c *gin.Context) {
+		_, span := StartDynamoDB(c.Request.Context(), "orders.Get", "orders")
+		span.End()
+		c.Status(http.StatusInternalServerError)
+	})
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/orders/o1", nil)
+	req.Header.Set("traceparent", parent)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	db, server := spans[0], spans[1]
+	if server.Name() != "GET /orders/:id" || server.SpanKind() != trace.SpanKindServer {
+		t.Fatalf("unexpected server span %q kind %v", server.Name(), server.SpanKind())
+	}
+	if got := server.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("server span did not continue the incoming trace: %s", got)
+	}
+	if server.Status().Code != codes.Error {
+		t.Fatalf("expected error status for a 500, got %v", server.Status())
+	}
+	if db.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Fatal("store span is not a child of the request span")
+	}
+}
+
+func TestInjectExtract_RoundTripsThroughAttributes(t *testing.T) {
+	tp, _ := NewRecorder()
+	defer tp.Shutdown(context.Background())
+
+	ctx, span := Start(context.Background(), "send")
+	attrs := map[string]string{"order_id": "o1"}
+	Inject(ctx, attrs)
+	span.End()
+	if attrs["traceparent"] == "" {
+		t.Fatalf("traceparent not injected: %v", attrs)
+	}
+
+	remote := trace.SpanContextFromContext(Extract(context.Background(), attrs))
+	if !remote.IsRemote() || remote.TraceID() != span.SpanContext().TraceID() || remote.SpanID() != span.SpanContext().SpanID() {
+		t.Fatalf("extracted %v, want the sender's span", remote)
+	}
+}
+
+func TestEnd_ExpectedErrorsAreNotFailures(t *testing.T) {
+	tp, rec := NewRecorder()
+	defer tp.Shutdown(context.Background())
+	errLost := errors.New("lost race")
+
+	run := func(err error, expected ...error) {
+		_, span := Start(context.Background(), "op")
+		End(span, &err, expected...)
+	}
+	run(nil)
+	run(errLost, errLost)
+	run(errors.New("boom"), errLost)
+
+	spans := rec.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	for i, want := range []codes.Code{codes.Unset, codes.Unset, codes.Error} {
+		if got := spans[i].Status().Code; got != want {
+			t.Fatalf("span %d: status %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestSetup_RejectsUnknownExporter(t *testing.T) {
+	if _, err := Setup("jaeger", "test", nil); err == nil {
+		t.Fatal("expected an error")
+	}
+	tp, err := Setup(ExporterNone, "test", nil)
+	if err != nil || tp.Flush(context.Background()) != nil || tp.Shutdown(context.Background()) != nil {
+		t.Fatalf("none exporter: %v", err)
+	}
+}
@@ -1,19 +1,48 @@
 package validation
 
-import "time"
+import (
+	"time"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+)
 
 // Item represents a single order line item.
 type Item struct {
-	SKU      string  `json:"sku" validate:"required"`            // stock keeping unit
-	Quantity int     `json:"quantity" validate:"required,min=1"` // must be >= 1
-	Price    float64 `json:"price" validate:"required,gt=0"`     // price per unit
+	SKU      string      `json:"sku" validate:"required"`            // stock keeping unit
+	Quantity int         `json:"quantity" validate:"required,min=1"` // must be >= 1
+	Price    money.Money `json:"price"`                              // price per unit; checked by createOrderStructValidation
+}
+
+// CancelOrderRequest is the optional payload for POST /orders/:id/cancel
+type CancelOrderRequest struct {
+	Reason string `json:"reason,omitempty" validate:"max=256"` // recorded in the order's status history
+}
+
+// RefundItem is one line item to refund.
+type RefundItem struct {
+	SKU      string `json:"sku" validate:"required"`
+	Quantity int    `json:"quantity" validate:"required,min=1"`
+}
+
+// RefundOrderRequest is the optional payload for POST /orders/:id/refunds. Without items the whole
+// remaining captured amount is refunded.
+type RefundOrderRequest struct {
+	Items  []RefundItem `json:"items,omitempty" validate:"omitempty,dive"`
+	Reason string       `json:"reason,omitempty" validate:"max=256"` // recorded on the refund
+}
+
+// RegisterWebhookRequest is the payload for POST /customers/:customerId/webhooks. The URL must be https.
+// Events limits the event types the endpoint receives; empty means all.
+type RegisterWebhookRequest struct {
+	URL    string   `json:"url" validate:"required,url,startswith=https://,max=2048"`
+	Events []string `json:"events,omitempty" validate:"omitempty,dive,oneof=order.pending order.processing order.on_hold order.completed order.failed order.cancelled order.partially_refunded order.refunded"`
 }
 
 // CreateOrderRequest is the payload for POST /orders
 type CreateOrderRequest struct {
 	CustomerID string                 `json:"customer_id" validate:"required"`      // business id for customer
 	Items      []Item                 `json:"items" validate:"required,min=1,dive"` // at least one item
-	Amount     float64                `json:"amount" validate:"required,gt=0"`      // total amount client claims
+	Amount     money.Money            `json:"amount"`                               // total amount client claims, in minor units
 	Metadata   map[string]interface{} `json:"metadata,omitempty"`                   // optional free-form metadata
 	CreatedAt  *time.Time             `json:"created_at,omitempty"`                 // optional client timestamp
 }
@@ -1,10 +1,17 @@
 package validation
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
+
+	validatorv10 "github.com/go-playground/validator/v10"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
 )
 
+func usd(minor int64) money.Money { return money.Money{Amount: minor, Currency: "USD"} }
+
 func TestCreateOrderRequest_Valid(t *testing.T) {
 	v := New()
 
@@ -12,10 +19,10 @@ func TestCreateOrderRequest_Valid(t *testing.T) {
 	req := CreateOrderRequest{
 		CustomerID: "cust-123",
 		Items: []Item{
-			{SKU: "sku-1", Quantity: 2, Price: 10.0},
-			{SKU: "sku-2", Quantity: 1, Price: 5.5},
+			{SKU: "sku-1", Quantity: 2, Price: usd(1000)},
+			{SKU: "sku-2", Quantity: 1, Price: usd(550)},
 		},
-		Amount:    25.5, // 2*10 + 1*5.5 = 25.5
+		Amount:    usd(2550), // 2*10.00 + 1*5.50 = 25.50
 		Metadata:  map[string]interface{}{"note": "test"},
 		CreatedAt: &now,
 	}
@@ -31,9 +38,9 @@ func TestCreateOrderRequest_InvalidAmountMismatch(t *testing.T) {
 	req := CreateOrderRequest{
 		CustomerID: "cust-123",
 		Items: []Item{
-			{SKU: "sku-1", Quantity: 1, Price: 10.0},
+			{SKU: "sku-1", Quantity: 1, Price: usd(1000)},
 		},
-		Amount: 9.99, // mismatch
+		Amount: usd(999), // mismatch
 	}
 
 	if err := v.Struct(req); err == nil {
@@ -47,10 +54,72 @@ func TestCreateOrderRequest_MissingFields(t *testing.T) {
 	req := CreateOrderRequest{
 		// CustomerID missing
 		Items:  []Item{},
-		Amount: 0,
+		Amount: money.Money{},
 	}
 
 	if err := v.Struct(req); err == nil {
 		t.Fatal("expected validation errors for missing required fields, got nil")
 	}
 }
+
+func TestCreateOrderRequest_CurrencyExponents(t *testing.T) {
+	v := New()
+
+	cases := []struct {
+		name string
+		body string
+		tag  string // expected failing tag; empty means valid
+	}{
+		{"jpy has no minor unit", `{"customer_id":"c","amount":{"amount":3000,"currency":"JPY"},"items":[{"sku":"s","quantity":3,"price":{"amount":1000,"currency":"jpy"}}]}`, ""},
+		{"kwd has three decimals", `{"customer_id":"c","amount":{"amount":3750,"currency":"KWD"},"items":[{"sku":"s","quantity":3,"price":{"amount":1250,"currency":"KWD"}}]}`, ""},
+		{"kwd off by one fils", `{"customer_id":"c","amount":{"amount":3751,"currency":"KWD"},"items":[{"sku":"s","quantity":3,"price":{"amount":1250,"currency":"KWD"}}]}`, "amount_match_items"},
+		{"mixed currencies", `{"customer_id":"c","amount":{"amount":1000,"currency":"USD"},"items":[{"sku":"s","quantity":1,"price":{"amount":1000,"currency":"EUR"}}]}`, "currency_match_amount"},
+		{"non-positive price", `{"customer_id":"c","amount":{"amount":0,"currency":"USD"},"items":[{"sku":"s","quantity":1,"price":{"amount":0,"currency":"USD"}}]}`, "gt"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var req CreateOrderRequest
+			if err := json.Unmarshal([]byte(tc.body), &req); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			err := v.Struct(req)
+			if tc.tag == "" {
+				if err != nil {
+					t.Fatalf("expected valid, got %v", err)
+				}
+				return
+			}
+			ve, ok := err.(validatorv10.ValidationErrors)
+			if !ok || ve[0].Tag() != tc.tag {
+				t.Fatalf("expected %s error, got %v", tc.tag, err)
+			}
+		})
+	}
+
+	// fractional minor units and unknown currencies are rejected while decoding
+	for _, body := range []string{
+		`{"customer_id":"c","amount":{"amount":25.5,"currency":"USD"},"items":[]}`,
+		`{"customer_id":"c","amount":{"amount":100,"currency":"XXX"},"items":[]}`,
+	} {
+		var req CreateOrderRequest
+		if err := json.Unmarshal([]byte(body), &req); err == nil {
+			t.Fatalf("expected decode error for %s", body)
+		}
+	}
+}
+
+func TestRegisterWebhookRequest_RequiresHTTPS(t *testing.T) {
+	v := New()
+
+	if err := v.Struct(RegisterWebhookRequest{URL: "https://hooks.example.com/orders", Events: []string{"order.completed"}}); err != nil {
+		t.Fatalf("expected valid, got error: %v", err)
+	}
+	for _, url := range []string{"http://hooks.example.com/orders", "http://169.254.169.254/latest/meta-data", "ftp://example.com", "https//example.com", ""} {
+		if err := v.Struct(RegisterWebhookRequest{URL: url}); err == nil {
+			t.Errorf("%q: expected a validation error, got nil", url)
+		}
+	}
+	if err := v.Struct(RegisterWebhookRequest{URL: "https://example.com", Events: []string{"order.shipped"}}); err == nil {
+		t.Error("expected a validation error for an unknown event type, got nil")
+	}
+}
@@ -2,13 +2,11 @@ package validation
 
 import (
 	"fmt"
-	"math"
 
 	validatorv10 "github.com/go-playground/validator/v10"
-)
 
-// tolerance for floating point comparison (in cents)
-// const amountTolerance = 0.01
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+)
 
 // new returns a configured validator with custom struct-level validation registered.
 func New() *validatorv10.Validate {
@@ -21,23 +19,50 @@ func New() *validatorv10.Validate {
 	return v
 }
 
-// createOrderStructValidation verifies the aggregated total of items equals Amount (within cents)
+// createOrderStructValidation checks Amount and every item Price are positive amounts in one supported
+// currency, and that Amount equals the sum of price * quantity. All arithmetic is on integer minor
+// units, so it is exact for any currency exponent (JPY has none, KWD has three).
 func createOrderStructValidation(sl validatorv10.StructLevel) {
 	req := sl.Current().Interface().(CreateOrderRequest)
 
-	var sum float64
-	for _, it := range req.Items {
-		sum += float64(it.Quantity) * it.Price
+	if !checkMoney(sl, req.Amount, "amount", "Amount", "") {
+		return
 	}
 
-	sumCents := int(math.Round(sum * 100))
-	amountCents := int(math.Round(req.Amount * 100))
-	if sumCents != amountCents {
-		sl.ReportError(req.Amount, "amount", "Amount", "amount_match_items", fmt.Sprintf("items sum %.2f != amount %.2f", sum, req.Amount))
+	sum := money.Money{Currency: req.Amount.Currency}
+	ok := true
+	for i, it := range req.Items {
+		field, structField := fmt.Sprintf("items[%d].price", i), fmt.Sprintf("Items[%d].Price", i)
+		if !checkMoney(sl, it.Price, field, structField, req.Amount.Currency) {
+			ok = false
+			continue
+		}
+		line, err := it.Price.Mul(int64(it.Quantity))
+		if err == nil {
+			sum, err = sum.Add(line)
+		}
+		if err != nil {
+			sl.ReportError(it.Price, field, structField, "amount_overflow", err.Error())
+			return
+		}
+	}
+	if ok && sum.Amount != req.Amount.Amount {
+		sl.ReportError(req.Amount, "amount", "Amount", "amount_match_items", fmt.Sprintf("items sum %s != amount %s", sum, req.Amount))
 	}
+}
 
-	// // use a tolerance to avoid float rounding issues
-	// if math.Abs(sum-req.Amount) >= amountTolerance {
-	// 	sl.ReportError(req.Amount, "amount", "Amount", "amount_match_items", fmt.Sprintf("items sum %.2f != amount %.2f", sum, req.Amount))
-	// }
+// checkMoney reports an error on field unless m is a positive amount in a supported currency
+// (and in currency, when it is not empty).
+func checkMoney(sl validatorv10.StructLevel, m money.Money, field, structField, currency string) bool {
+	switch {
+	case m.Validate() != nil:
+		sl.ReportError(m, field, structField, "iso4217", m.Currency)
+	case currency != "" && m.Currency != currency:
+		sl.ReportError(m, field, structField, "currency_match_amount", currency)
+	case m.Amount <= 0:
+		sl.ReportError(m, field, structField, "gt", "0")
+	default:
+		return true
+	}
+	return false
 }
@@ -0,0 +1,55 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewClient_RefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("the request must not reach a loopback receiver")
+	}))
+	defer server.Close()
+	if _, err := NewClient(time.Second).Get(server.URL); !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("expected ErrBlockedAddress, got %v", err)
+	}
+
+	for _, addr := range []string{"127.0.0.1:443", "[::1]:443", "10.0.0.1:443", "172.16.5.4:443", "192.168.1.1:443",
+		"169.254.169.254:80", "[fe80::1]:443", "0.0.0.0:443", "[::]:443", "[::ffff:127.0.0.1]:443", "224.0.0.1:443",
+		"100.64.0.1:443", "100.127.255.254:443"} {
+		if err := blockInternal("tcp", addr, nil); !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("%s: expected ErrBlockedAddress, got %v", addr, err)
+		}
+	}
+	for _, addr := range []string{"93.184.216.34:443", "100.128.0.1:443", "[2606:2800:220:1:248:1893:25c8:1946]:443"} {
+		if err := blockInternal("tcp", addr, nil); err != nil {
+			t.Errorf("%s: expected a public address to be allowed, got %v", addr, err)
+		}
+	}
+}
+
+func TestNewClient_DoesNotFollowRedirects(t *testing.T) {
+	followed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal" {
+			followed = true
+			return
+		}
+		http.Redirect(w, r, "/internal", http.StatusFound)
+	}))
+	defer server.Close()
+
+	client := NewClient(time.Second)
+	client.Transport = http.DefaultTransport // the receiver is on loopback; only the redirect policy is under test
+	resp, err := client.Post(server.URL+"/hook", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || followed {
+		t.Fatalf("expected the 302 itself, got %d (followed: %v)", resp.StatusCode, followed)
+	}
+}
@@ -0,0 +1,298 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+)
+
+// Dispatcher defaults.
+const (
+	DefaultBatchSize   = 25
+	DefaultMaxAttempts = 8
+	DefaultBaseBackoff = 30 * time.Second
+	DefaultMaxBackoff  = time.Hour
+	DefaultTimeout     = 10 * time.Second // per POST, for the client of NewDispatcher given none
+)
+
+// errEndpointGone fails deliveries whose endpoint was deleted or disabled after they were created.
+var errEndpointGone = errors.New("endpoint disabled")
+
+// Dispatcher fans PENDING events out to deliveries and POSTs due deliveries. A delivery answered with
+// 2xx SUCCEEDS; any other answer or a transport error schedules the next attempt after an exponential
+// backoff (base, 2*base, 4*base, ... up to the max), and the delivery FAILS once maxAttempts are spent.
+// Delivery is at-least-once: a dispatcher that crashes after a POST sends it again, with the same event
+// ID.
+type Dispatcher struct {
+	store       *Store
+	orders      *orders.Store
+	client      *http.Client
+	batchSize   int32
+	maxAttempts int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	nowFunc     func() time.Time
+}
+
+// NewDispatcher creates a Dispatcher reading events and deliveries from store, looking up orders in
+// orderStore and POSTing with client (nil for NewClient(DefaultTimeout), which refuses internal addresses).
+func NewDispatcher(store *Store, orderStore *orders.Store, client *http.Client) *Dispatcher {
+	if client == nil {
+		client = NewClient(DefaultTimeout)
+	}
+	return &Dispatcher{
+		store:       store,
+		orders:      orderStore,
+		client:      client,
+		batchSize:   DefaultBatchSize,
+		maxAttempts: DefaultMaxAttempts,
+		baseBackoff: DefaultBaseBackoff,
+		maxBackoff:  DefaultMaxBackoff,
+		nowFunc:     time.Now,
+	}
+}
+
+// WithRetries sets how many attempts a delivery gets and the backoff between them.
+func (d *Dispatcher) WithRetries(maxAttempts int, baseBackoff, maxBackoff time.Duration) *Dispatcher {
+	d.maxAttempts = maxAttempts
+	d.baseBackoff = baseBackoff
+	d.maxBackoff = maxBackoff
+	return d
+}
+
+// Backoff returns the wait after the attempts-th failed attempt.
+func (d *Dispatcher) Backoff(attempts int) time.Duration {
+	wait := d.baseBackoff
+	for i := 1; i < attempts && wait < d.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.maxBackoff {
+		wait = d.maxBackoff
+	}
+	return wait
+}
+
+// FanOut creates a delivery of event for every endpoint of the order's customer that wants it, then marks
+// the event dispatched. Deliveries that already exist are kept, so fanning out again after a crash is
+// harmless.
+func (d *Dispatcher) FanOut(ctx context.Context, event Event) error {
+	order, err := d.orders.Get(ctx, event.OrderID)
+	if err != nil {
+		return err
+	}
+	if order != nil {
+		endpoints, err := d.store.ListEndpoints(ctx, order.CustomerID)
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(Payload{
+			ID:        event.EventID,
+			Type:      event.Type,
+			CreatedAt: event.CreatedAt,
+			Data: PayloadData{
+				OrderID:        event.OrderID,
+				CustomerID:     order.CustomerID,
+				Status:         event.To,
+				PreviousStatus: event.From,
+				Reason:         event.Reason,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("marshal payload: %w", err)
+		}
+		now := d.nowFunc().UTC()
+		for _, ep := range endpoints {
+			if !ep.Wants(event.Type) {
+				continue
+			}
+			_, err := d.store.CreateDelivery(ctx, Delivery{
+				EndpointID:    ep.EndpointID,
+				EventID:       event.EventID,
+				EventType:     event.Type,
+				Payload:       string(body),
+				Status:        DeliveryPending,
+				NextAttemptAt: now.UnixMilli(),
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return d.store.MarkDispatched(ctx, event.EventID)
+}
+
+// Deliver POSTs one delivery to its endpoint and records the attempt. A failed POST is not an error: it
+// is recorded and retried later. Errors mean the attempt could not be recorded.
+func (d *Dispatcher) Deliver(ctx context.Context, delivery Delivery) error {
+	ep, err := d.store.GetEndpoint(ctx, delivery.EndpointID)
+	if err != nil {
+		return err
+	}
+	start := d.nowFunc()
+	attempt := Attempt{At: start.UTC()}
+	if ep == nil || ep.Status != EndpointActive {
+		attempt.Error = errEndpointGone.Error()
+		return d.store.RecordAttempt(ctx, delivery, attempt, DeliveryFailed, start)
+	}
+	code, postErr := d.post(ctx, ep, delivery, start)
+	attempt.StatusCode = code
+	attempt.DurationMs = d.nowFunc().Sub(start).Milliseconds()
+	if postErr != nil {
+		attempt.Error = postErr.Error()
+	}
+
+	status, next := DeliveryPending, start.Add(d.Backoff(delivery.Attempts+1))
+	switch {
+	case postErr == nil:
+		status, next = DeliverySucceeded, start
+	case delivery.Attempts+1 >= d.maxAttempts:
+		status, next = DeliveryFailed, start
+	}
+	if err := d.store.RecordAttempt(ctx, delivery, attempt, status, next); err != nil {
+		return err
+	}
+	if postErr != nil {
+		deliveryLogger(ctx, delivery).Warn("webhook delivery attempt failed",
+			logging.KeyAttempt, delivery.Attempts+1, "status", status, logging.Err(postErr))
+	}
+	return nil
+}
+
+// post sends the delivery's payload signed with the endpoint's secret. It returns the response status
+// code (zero without a response) and an error unless the status is 2xx.
+func (d *Dispatcher) post(ctx context.Context, ep *Endpoint, delivery Delivery, now time.Time) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderSignature, Sign(ep.Secret, now, body))
+	req.Header.Set(HeaderEventID, delivery.EventID)
+	req.Header.Set(HeaderEventType, delivery.EventType)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // lets the connection be reused
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// RunOnce fans out the oldest batch of PENDING events, then attempts the most overdue batch of due
+// deliveries, and returns how many events and deliveries it handled. Per-item failures are logged and
+// left for the next run; only listing errors are returned.
+func (d *Dispatcher) RunOnce(ctx context.Context) (int, error) {
+	events, err := d.store.ListPendingEvents(ctx, d.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	handled := d.fanOutAll(ctx, events)
+	due, err := d.store.ListDue(ctx, d.nowFunc(), d.batchSize)
+	if err != nil {
+		return handled, err
+	}
+	return handled + d.deliverAll(ctx, due), nil
+}
+
+// Drain fans out every PENDING event once, then attempts every due delivery once, a batch at a time, and
+// returns how many it handled. Like the outbox relay it pages past items that fail instead of reading them
+// again, so failing events or deliveries, however many, never hold back newer ones; they are retried on
+// the next run.
+func (d *Dispatcher) Drain(ctx context.Context) (int, error) {
+	total := 0
+	var after map[string]types.AttributeValue
+	for {
+		events, next, err := d.store.ListPendingEventsAfter(ctx, d.batchSize, after)
+		if err != nil {
+			return total, err
+		}
+		total += d.fanOutAll(ctx, events)
+		if next == nil {
+			break
+		}
+		after = next
+	}
+	now := d.nowFunc()
+	after = nil
+	for {
+		due, next, err := d.store.ListDueAfter(ctx, now, d.batchSize, after)
+		if err != nil {
+			return total, err
+		}
+		total += d.deliverAll(ctx, due)
+		if next == nil {
+			return total, nil
+		}
+		after = next
+	}
+}
+
+// fanOutAll fans out events, logging failures, and returns how many were fanned out.
+func (d *Dispatcher) fanOutAll(ctx context.Context, events []Event) int {
+	handled := 0
+	for _, e := range events {
+		if err := d.FanOut(ctx, e); err != nil {
+			logging.FromContext(ctx).Warn("webhook fan-out failed",
+				logging.KeyEventID, e.EventID, logging.KeyOrderID, e.OrderID, logging.Err(err))
+			continue
+		}
+		handled++
+	}
+	return handled
+}
+
+// deliverAll attempts deliveries, logging the ones whose attempt could not be recorded, and returns how
+// many were attempted.
+func (d *Dispatcher) deliverAll(ctx context.Context, due []Delivery) int {
+	handled := 0
+	for _, delivery := range due {
+		if err := d.Deliver(ctx, delivery); err != nil {
+			if !errors.Is(err, ErrStale) {
+				deliveryLogger(ctx, delivery).Warn("webhook delivery could not be recorded", logging.Err(err))
+			}
+			continue
+		}
+		handled++
+	}
+	return handled
+}
+
+// Run drains events and due deliveries every interval until ctx is cancelled. Used when running outside
+// Lambda.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := d.Drain(ctx); err != nil {
+			logging.FromContext(ctx).Error("webhook dispatch failed", logging.Err(err))
+		} else if n > 0 {
+			logging.FromContext(ctx).Info("webhooks dispatched", "count", n)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func deliveryLogger(ctx context.Context, d Delivery) *slog.Logger {
+	return logging.FromContext(ctx).With(logging.KeyEndpointID, d.EndpointID, logging.KeyEventID, d.EventID)
+}
@@ -0,0 +1,360 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/testing/dynamofake"
+)
+
+const (
+	ordersTable     = "orders"
+	idempTable      = "idempotency"
+	endpointsTable  = "webhook_endpoints"
+	eventsTable     = "webhook_events"
+	deliveriesTable = "webhook_deliveries"
+)
+
+// env wires a webhooks Store into an orders Store over one fake DynamoDB, with a settable clock for the
+// dispatcher.
+type env struct {
+	db         *dynamofake.Fake
+	orders     *orders.Store
+	store      *Store
+	dispatcher *Dispatcher
+	now        time.Time
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	db := dynamofake.New(
+		dynamofake.TableSchema{
+			Name:    ordersTable,
+			HashKey: "order_id",
+			Indexes: []dynamofake.IndexSchema{{Name: orders.CustomerIndex, HashKey: "customer_id", RangeKey: "created_at"}},
+		},
+		dynamofake.TableSchema{Name: idempTable, HashKey: "idempotency_key"},
+		dynamofake.TableSchema{
+			Name:    endpointsTable,
+			HashKey: "endpoint_id",
+			Indexes: []dynamofake.IndexSchema{{Name: CustomerIndex, HashKey: "customer_id", RangeKey: "created_at"}},
+		},
+		dynamofake.TableSchema{
+			Name:    eventsTable,
+			HashKey: "event_id",
+			Indexes: []dynamofake.IndexSchema{{Name: StatusIndex, HashKey: "status", RangeKey: "created_at"}},
+		},
+		dynamofake.TableSchema{
+			Name:     deliveriesTable,
+			HashKey:  "endpoint_id",
+			RangeKey: "event_id",
+			Indexes:  []dynamofake.IndexSchema{{Name: StatusIndex, HashKey: "status", RangeKey: "next_attempt_at"}},
+		},
+	)
+	e := &env{db: db, now: time.Now()}
+	e.store = NewStore(db, endpointsTable, eventsTable, deliveriesTable, 24*time.Hour)
+	e.store.nowFunc = func() time.Time { return e.now }
+	e.orders = orders.NewStore(db, ordersTable)
+	e.orders.SetTransitionRecorder(e.store)
+	// the receivers listen on loopback, which the default client refuses
+	e.dispatcher = NewDispatcher(e.store, e.orders, &http.Client{Timeout: DefaultTimeout})
+	e.dispatcher.nowFunc = func() time.Time { return e.now }
+	return e
+}
+
+// completeOrder creates an order for customerID and moves it through PROCESSING to COMPLETED.
+func (e *env) completeOrder(t *testing.T, orderID, customerID string) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	order := orders.Order{
+		OrderID:       orderID,
+		CustomerID:    customerID,
+		Status:        orders.StatusPending,
+		Amount:        money.Money{Amount: 1000, Currency: "USD"},
+		StatusHistory: []orders.StatusChange{{To: orders.StatusPending, Actor: "api", At: now}},
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	idemp := map[string]interface{}{"idempotency_key": "k-" + orderID, "status": "COMPLETED"}
+	if err := e.orders.CreateWithIdempotencyTransaction(ctx, e.db, idempTable, idemp, order, time.Hour); err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+	for _, c := range []orders.StatusChange{
+		{From: orders.StatusPending, To: orders.StatusProcessing, Actor: "worker"},
+		{From: orders.StatusProcessing, To: orders.StatusCompleted, Actor: "worker"},
+	} {
+		if err := e.orders.UpdateStatus(ctx, orderID, c); err != nil {
+			t.Fatalf("UpdateStatus %s: %v", c.To, err)
+		}
+	}
+}
+
+// receiver is an httptest endpoint that verifies signatures and answers with the queued status codes,
+// then 200.
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	codes    []int
+	received []Payload
+	eventIDs []string
+	badSigs  int
+	server   *httptest.Server
+}
+
+func newReceiver(t *testing.T, codes ...int) *receiver {
+	r := &receiver{codes: codes}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if err := Verify(r.secret, req.Header.Get(HeaderSignature), body, time.Now(), time.Hour); err != nil {
+			r.badSigs++
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var p Payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("payload: %v", err)
+		}
+		if got := req.Header.Get(HeaderEventID); got != p.ID {
+			t.Errorf("%s header %q, payload id %q", HeaderEventID, got, p.ID)
+		}
+		r.received = append(r.received, p)
+		r.eventIDs = append(r.eventIDs, p.ID)
+		code := http.StatusOK
+		if len(r.codes) > 0 {
+			code, r.codes = r.codes[0], r.codes[1:]
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (e *env) register(t *testing.T, customerID string, r *receiver, eventTypes ...string) *Endpoint {
+	t.Helper()
+	ep, err := e.store.RegisterEndpoint(context.Background(), customerID, r.server.URL, eventTypes)
+	if err != nil {
+		t.Fatalf("RegisterEndpoint: %v", err)
+	}
+	r.secret = ep.Secret
+	return ep
+}
+
+func TestDispatcher_DeliversSignedEventsForEveryTransition(t *testing.T) {
+	e := newEnv(t)
+	ctx := context.Background()
+	all := newReceiver(t)
+	completedOnly := newReceiver(t)
+	e.register(t, "c1", all)
+	e.register(t, "c1", completedOnly, EventType(orders.StatusCompleted))
+	other := newReceiver(t)
+	e.register(t, "c2", other)
+
+	e.completeOrder(t, "o1", "c1")
+	if _, err := e.dispatcher.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+
+	want := []string{"order.pending", "order.processing", "order.completed"}
+	if len(all.received) != len(want) {
+		t.Fatalf("expected %d deliveries, got %+v", len(want), all.received)
+	}
+	for i, p := range all.received {
+		if p.Type != want[i] || p.Data.OrderID != "o1" || p.Data.CustomerID != "c1" {
+			t.Errorf("delivery %d: unexpected payload %+v", i, p)
+		}
+	}
+	if last := all.received[2]; last.Data.Status != orders.StatusCompleted || last.Data.PreviousStatus != orders.StatusProcessing {
+		t.Errorf("unexpected completed payload %+v", last)
+	}
+	if len(completedOnly.received) != 1 || completedOnly.received[0].ID != all.received[2].ID {
+		t.Errorf("filtered endpoint should get only the completed event, got %+v", completedOnly.received)
+	}
+	if len(other.received) != 0 || all.badSigs+completedOnly.badSigs != 0 {
+		t.Errorf("another customer's endpoint got %d deliveries; %d bad signatures", len(other.received), all.badSigs+completedOnly.badSigs)
+	}
+
+	// everything is dispatched and delivered: another run sends nothing
+	if n, err := e.dispatcher.Drain(ctx); err != nil || n != 0 {
+		t.Fatalf("second Drain handled %d (err %v)", n, err)
+	}
+}
+
+func TestDispatcher_RetriesWithBackoffThenFailsAndReplays(t *testing.T) {
+	e := newEnv(t)
+	ctx := context.Background()
+	e.dispatcher.WithRetries(3, time.Minute, 90*time.Second)
+	r := newReceiver(t, 500, 503, 500)
+	ep := e.register(t, "c1", r, EventType(orders.StatusCompleted))
+	e.completeOrder(t, "o1", "c1")
+
+	for i, wait := range []time.Duration{time.Minute, 90 * time.Second, 0} {
+		if _, err := e.dispatcher.RunOnce(ctx); err != nil {
+			t.Fatalf("RunOnce %d: %v", i, err)
+		}
+		deliveries, err := e.store.ListDeliveries(ctx, ep.EndpointID, 0)
+		if err != nil || len(deliveries) != 1 {
+			t.Fatalf("ListDeliveries: %v %+v", err, deliveries)
+		}
+		d := deliveries[0]
+		if d.Attempts != i+1 || len(d.Log) != i+1 || d.Log[i].StatusCode == 0 || d.Log[i].Error == "" {
+			t.Fatalf("attempt %d: unexpected delivery %+v", i+1, d)
+		}
+		if wait == 0 {
+			if d.Status != DeliveryFailed {
+				t.Fatalf("expected FAILED after the last attempt, got %s", d.Status)
+			}
+			break
+		}
+		if d.Status != DeliveryPending || d.NextAttemptAt != e.now.Add(wait).UnixMilli() {
+			t.Fatalf("attempt %d: expected PENDING due in %s, got %s at %d", i+1, wait, d.Status, d.NextAttemptAt-e.now.UnixMilli())
+		}
+		// not due yet: nothing is sent
+		if n, _ := e.dispatcher.RunOnce(ctx); n != 0 || len(r.received) != i+1 {
+			t.Fatalf("attempt %d: delivery sent before its backoff elapsed", i+1)
+		}
+		e.now = e.now.Add(wait)
+	}
+	if n, _ := e.dispatcher.Drain(ctx); n != 0 {
+		t.Fatalf("a FAILED delivery was attempted again")
+	}
+
+	eventID := r.eventIDs[0]
+	replayed, err := e.store.Replay(ctx, ep.EndpointID, eventID)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if replayed.Status != DeliveryPending || replayed.Attempts != 0 || replayed.Replays != 1 {
+		t.Fatalf("unexpected replayed delivery %+v", replayed)
+	}
+	if _, err := e.dispatcher.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	d, err := e.store.GetDelivery(ctx, ep.EndpointID, eventID)
+	if err != nil || d == nil {
+		t.Fatalf("GetDelivery: %v", err)
+	}
+	if d.Status != DeliverySucceeded || len(d.Log) != 4 || d.Log[3].StatusCode != http.StatusOK {
+		t.Fatalf("expected the replay to succeed with the whole log kept, got %+v", d)
+	}
+	for _, id := range r.eventIDs {
+		if id != eventID {
+			t.Fatalf("event ID changed between attempts: %v", r.eventIDs)
+		}
+	}
+
+	if _, err := e.store.Replay(ctx, ep.EndpointID, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound replaying a missing delivery, got %v", err)
+	}
+}
+
+func TestDispatcher_FailsPendingDeliveriesOfDisabledEndpoints(t *testing.T) {
+	e := newEnv(t)
+	ctx := context.Background()
+	r := newReceiver(t)
+	ep := e.register(t, "c1", r)
+	e.completeOrder(t, "o1", "c1")
+
+	events, err := e.store.ListPendingEvents(ctx, 10)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("ListPendingEvents: %v %+v", err, events)
+	}
+	for _, ev := range events {
+		if err := e.dispatcher.FanOut(ctx, ev); err != nil {
+			t.Fatalf("FanOut: %v", err)
+		}
+	}
+	// a second fan-out, e.g. after a crash before MarkDispatched, creates no duplicates
+	if err := e.dispatcher.FanOut(ctx, events[0]); err != nil {
+		t.Fatalf("FanOut again: %v", err)
+	}
+	if err := e.store.DisableEndpoint(ctx, ep.EndpointID); err != nil {
+		t.Fatalf("DisableEndpoint: %v", err)
+	}
+	if _, err := e.dispatcher.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+
+	deliveries, err := e.store.ListDeliveries(ctx, ep.EndpointID, 0)
+	if err != nil || len(deliveries) != 3 {
+		t.Fatalf("ListDeliveries: %v %+v", err, deliveries)
+	}
+	for _, d := range deliveries {
+		if d.Status != DeliveryFailed || d.Log[0].Error != errEndpointGone.Error() {
+			t.Errorf("expected FAILED for a disabled endpoint, got %+v", d)
+		}
+	}
+	if len(r.received) != 0 {
+		t.Errorf("a disabled endpoint received %d deliveries", len(r.received))
+	}
+	if err := e.store.DisableEndpoint(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound disabling a missing endpoint, got %v", err)
+	}
+}
+
+func TestDispatcher_DrainPagesPastEventsThatKeepFailing(t *testing.T) {
+	e := newEnv(t)
+	ctx := context.Background()
+	e.dispatcher.batchSize = 2
+	r := newReceiver(t)
+	e.register(t, "c1", r, EventType(orders.StatusCompleted))
+
+	// an order that cannot be read fails the fan-out of its events, which are older than any other and
+	// fill the first page
+	e.db.Put(ordersTable, map[string]types.AttributeValue{
+		"order_id":       &types.AttributeValueMemberS{Value: "bad"},
+		"customer_id":    &types.AttributeValueMemberS{Value: "c1"},
+		"status_history": &types.AttributeValueMemberS{Value: "not a list"},
+	})
+	for _, id := range []string{"bad-1", "bad-2", "bad-3"} {
+		e.db.Put(eventsTable, map[string]types.AttributeValue{
+			"event_id":   &types.AttributeValueMemberS{Value: id},
+			"type":       &types.AttributeValueMemberS{Value: EventType(orders.StatusCompleted)},
+			"order_id":   &types.AttributeValueMemberS{Value: "bad"},
+			"to":         &types.AttributeValueMemberS{Value: orders.StatusCompleted},
+			"status":     &types.AttributeValueMemberS{Value: EventPending},
+			"created_at": &types.AttributeValueMemberS{Value: "2000-01-01T00:00:00Z"},
+		})
+	}
+	e.completeOrder(t, "o1", "c1")
+
+	if _, err := e.dispatcher.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if len(r.received) != 1 || r.received[0].Data.OrderID != "o1" {
+		t.Fatalf("expected o1's completed event despite the failing ones, got %+v", r.received)
+	}
+	events, err := e.store.ListPendingEvents(ctx, 10)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("expected only the failing events left PENDING: %v %+v", err, events)
+	}
+	for _, ev := range events {
+		if ev.OrderID != "bad" {
+			t.Errorf("unexpected PENDING event %+v", ev)
+		}
+	}
+}
+
+func TestBackoff_DoublesUpToTheCap(t *testing.T) {
+	d := NewDispatcher(nil, nil, nil)
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute,
+		16 * time.Minute, 32 * time.Minute, time.Hour, time.Hour}
+	for i, w := range want {
+		if got := d.Backoff(i + 1); got != w {
+			t.Errorf("Backoff(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+}
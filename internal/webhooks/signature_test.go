@@ -0,0 +1,38 @@
+package webhooks
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify_AcceptsOnlyFreshSignaturesOfTheBody(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret: %v", err)
+	}
+	if !strings.HasPrefix(secret, "whsec_") {
+		t.Fatalf("secret %q lacks the whsec_ prefix", secret)
+	}
+	sent := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"id":"e1","type":"order.completed"}`)
+	header := Sign(secret, sent, body)
+
+	if err := Verify(secret, header, body, sent.Add(time.Minute), 0); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	cases := map[string]error{
+		"tampered body": Verify(secret, header, []byte(`{"id":"e2","type":"order.completed"}`), sent, 0),
+		"wrong secret":  Verify("whsec_other", header, body, sent, 0),
+		"expired":       Verify(secret, header, body, sent.Add(DefaultTolerance+time.Second), 0),
+		"no signature":  Verify(secret, "t=1700000000", body, sent, 0),
+		"malformed":     Verify(secret, "garbage", body, sent, 0),
+		"reused header": Verify(secret, strings.Replace(header, "t=1700000000", "t=1700000100", 1), body, sent.Add(100*time.Second), 0),
+	}
+	for name, err := range cases {
+		if !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected ErrInvalidSignature, got %v", name, err)
+		}
+	}
+}
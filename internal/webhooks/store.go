@@ -0,0 +1,449 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	dyn "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
+)
+
+var (
+	// ErrNotFound indicates a missing endpoint or delivery.
+	ErrNotFound = errors.New("webhook not found")
+	// ErrStale indicates a delivery changed between reading it and recording an attempt, e.g. because
+	// another dispatcher sent it.
+	ErrStale = errors.New("webhook delivery changed concurrently")
+)
+
+// DefaultListLimit is the page size of ListDeliveries when none is given.
+const DefaultListLimit = 50
+
+// Store encapsulates operations on the webhook endpoints, events and deliveries tables.
+type Store struct {
+	client          aws.DynamoDBAPI
+	endpointsTable  string
+	eventsTable     string
+	deliveriesTable string
+	eventTTL        time.Duration // how long events are retained before TTL deletes them
+	nowFunc         func() time.Time
+}
+
+// NewStore creates a new webhooks Store.
+func NewStore(client aws.DynamoDBAPI, endpointsTable, eventsTable, deliveriesTable string, eventTTL time.Duration) *Store {
+	return &Store{
+		client:          client,
+		endpointsTable:  endpointsTable,
+		eventsTable:     eventsTable,
+		deliveriesTable: deliveriesTable,
+		eventTTL:        eventTTL,
+		nowFunc:         time.Now,
+	}
+}
+
+// RecordTransition implements orders.TransitionRecorder: it returns the put of a PENDING event for the
+// transition, to be committed with it.
+func (s *Store) RecordTransition(orderID string, change orders.StatusChange) (types.TransactWriteItem, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("event id: %w", err)
+	}
+	now := s.nowFunc()
+	at := change.At
+	if at.IsZero() {
+		at = now
+	}
+	item, err := attributevalue.MarshalMap(Event{
+		EventID:   id.String(),
+		Type:      EventType(change.To),
+		OrderID:   orderID,
+		From:      change.From,
+		To:        change.To,
+		Actor:     change.Actor,
+		Reason:    change.Reason,
+		Status:    EventPending,
+		CreatedAt: at.UTC(),
+		ExpiresAt: now.Add(s.eventTTL).Unix(),
+	})
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("marshal event: %w", err)
+	}
+	return types.TransactWriteItem{
+		Put: &types.Put{
+			TableName:           &s.eventsTable,
+			Item:                item,
+			ConditionExpression: awsString("attribute_not_exists(event_id)"),
+		},
+	}, nil
+}
+
+// RegisterEndpoint stores a new ACTIVE endpoint for customerID with a fresh secret. eventTypes limits the
+// events it receives; empty means all.
+func (s *Store) RegisterEndpoint(ctx context.Context, customerID, url string, eventTypes []string) (_ *Endpoint, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "webhooks.RegisterEndpoint", s.endpointsTable)
+	defer tracing.End(span, &err)
+	secret, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	now := s.nowFunc().UTC()
+	ep := Endpoint{
+		EndpointID: uuid.NewString(),
+		CustomerID: customerID,
+		URL:        url,
+		Secret:     secret,
+		EventTypes: eventTypes,
+		Status:     EndpointActive,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	item, err := attributevalue.MarshalMap(ep)
+	if err != nil {
+		return nil, fmt.Errorf("marshal endpoint: %w", err)
+	}
+	_, err = s.client.PutItem(ctx, &dyn.PutItemInput{
+		TableName:           &s.endpointsTable,
+		Item:                item,
+		ConditionExpression: awsString("attribute_not_exists(endpoint_id)"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("put endpoint: %w", err)
+	}
+	return &ep, nil
+}
+
+// GetEndpoint fetches an endpoint. Returns (nil, nil) if not found.
+func (s *Store) GetEndpoint(ctx context.Context, endpointID string) (_ *Endpoint, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "webhooks.GetEndpoint", s.endpointsTable)
+	defer tracing.End(span, &err)
+	out, err := s.client.GetItem(ctx, &dyn.GetItemInput{
+		TableName: &s.endpointsTable,
+		Key:       map[string]types.AttributeValue{"endpoint_id": &types.AttributeValueMemberS{Value: endpointID}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get endpoint: %w", err)
+	}
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+	var ep Endpoint
+	if err := attributevalue.UnmarshalMap(out.Item, &ep); err != nil {
+		return nil, fmt.Errorf("unmarshal endpoint: %w", err)
+	}
+	return &ep, nil
+}
+
+// ListEndpoints returns every endpoint of customerID, oldest first, disabled ones included.
+func (s *Store) ListEndpoints(ctx context.Context, customerID string) (_ []Endpoint, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "webhooks.ListEndpoints", s.endpointsTable)
+	defer tracing.End(span, &err)
+	input := &dyn.QueryInput{
+		TableName:              &s.endpointsTable,
+		IndexName:              awsString(CustomerIndex),
+		KeyConditionExpression: awsString("customer_id = :cid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":cid": &types.AttributeValueMemberS{Value: customerID},
+		},
+	}
+	endpoints := []Endpoint{}
+	for {
+		out, err := s.client.Query(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("query endpoints: %w", err)
+		}
+		var page []Endpoint
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, fmt.Errorf("unmarshal endpoints: %w", err)
+		}
+		endpoints = append(endpoints, page...)
+		if len(out.LastEvaluatedKey) == 0 {
+			return endpoints, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// DisableEndpoint stops deliveries to an endpoint: no new ones are created and pending ones fail at their
+// next attempt. Returns ErrNotFound if the endpoint does not exist.
+func (s *Store) DisableEndpoint(ctx context.Context, endpointID string) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "webhooks.DisableEndpoint", s.endpointsTable)
+	defer tracing.End(span, &err, ErrNotFound)
+	_, err = s.client.UpdateItem(ctx, &dyn.UpdateItemInput{
+		TableName:                &s.endpointsTable,
+		Key:                      map[string]types.AttributeValue{"endpoint_id": &types.AttributeValueMemberS{Value: endpointID}},
+		UpdateExpression:         awsString("SET #s = :disabled, updated_at = :ua"),
+		ConditionExpression:      awsString("attribute_exists(endpoint_id)"),
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":disabled": &types.AttributeValueMemberS{Value: EndpointDisabled},
+			":ua":       &types.AttributeValueMemberS{Value: s.nowFunc().UTC().Format(time.RFC3339Nano)},
+		},
+	})
+	if err != nil {
+		var cf *types.ConditionalCheckFailedException
+		if errors.As(err, &cf) {
+			return fmt.Errorf("%w: endpoint %s", ErrNotFound, endpointID)
+		}
+		return fmt.Errorf("disable endpoint: %w", err)
+	}
+	return nil
+}
+
+// ListPendingEvents returns up to limit events not fanned out yet, oldest first.
+func (s *Store) ListPendingEvents(ctx context.Context, limit int32) ([]Event, error) {
+	events, _, err := s.ListPendingEventsAfter(ctx, limit, nil)
+	return events, err
+}
+
+// ListPendingEventsAfter returns up to limit events not fanned out yet, oldest first, starting after the
+// key returned with the previous page (nil for the first page). The returned key is nil after the last
+// page.
+func (s *Store) ListPendingEventsAfter(ctx context.Context, limit int32, after map[string]types.AttributeValue) (_ []Event, _ map[string]types.AttributeValue, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "webhooks.ListPendingEvents", s.eventsTable)
+	defer tracing.End(span, &err)
+	out, err := s.client.Query(ctx, &dyn.QueryInput{
+		TableName:                &s.eventsTable,
+		IndexName:                awsString(StatusIndex),
+		KeyConditionExpression:   awsString("#s = :pending"),
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: EventPending},
+		},
+		Limit:             &limit,
+		ScanIndexForward:  awsBool(true),
+		ExclusiveStartKey: after,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("query pending events: %w", err)
+	}
+	var events []Event
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &events); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal events: %w", err)
+	}
+	if len(out.LastEvaluatedKey) == 0 {
+		return events, nil, nil
+	}
+	return events, out.LastEvaluatedKey, nil
+}
+
+// MarkDispatched records that an event has a delivery for every endpoint that wants it.
+func (s *Store) MarkDispatched(ctx context.Context, eventID string) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "webhooks.MarkDispatched", s.eventsTable)
+	defer tracing.End(span, &err)
+	_, err = s.client.UpdateItem(ctx, &dyn.UpdateItemInput{
+		TableName:                &s.eventsTable,
+		Key:                      map[string]types.AttributeValue{"event_id": &types.AttributeValueMemberS{Value: eventID}},
+		UpdateExpression:         awsString("SET #s = :dispatched"),
+		ConditionExpression:      awsString("attribute_exists(event_id)"),
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":dispatched": &types.AttributeValueMemberS{Value: EventDispatched},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("mark event %s dispatched: %w", eventID, err)
+	}
+	return nil
+}
+
+// CreateDelivery stores d unless the endpoint already has a delivery of the event, so fanning an event
+// out twice is harmless. It reports whether d was created.
+func (s *Store) CreateDelivery(ctx context.Context, d Delivery) (_ bool, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "webhooks.CreateDelivery", s.deliveriesTable)
+	defer tracing.End(span, &err)
+	item, err := attributevalue.MarshalMap(d)
+	if err != nil {
+		return false, fmt.Errorf("marshal delivery: %w", err)
+	}
+	_, err = s.client.PutItem(ctx, &dyn.PutItemInput{
+		TableName:           &s.deliveriesTable,
+		Item:                item,
+		ConditionExpression: awsString("attribute_not_exists(endpoint_id)"),
+	})
+	if err != nil {
+		var cf *types.ConditionalCheckFailedException
+		if errors.As(err, &cf) {
+			return false, nil
+		}
+		return false, fmt.Errorf("put delivery: %w", err)
+	}
+	return true, nil
+}
+
+// ListDue returns up to limit PENDING deliveries whose next attempt is due at now, most overdue first.
+func (s *Store) ListDue(ctx context.Context, now time.Time, limit int32) ([]Delivery, error) {
+	deliveries, _, err := s.ListDueAfter(ctx, now, limit, nil)
+	return deliveries, err
+}
+
+// ListDueAfter is ListDue starting after the key returned with the previous page (nil for the first
+// page). The returned key is nil after the last page.
+func (s *Store) ListDueAfter(ctx context.Context, now time.Time, limit int32, after map[string]types.AttributeValue) (_ []Delivery, _ map[string]types.AttributeValue, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "webhooks.ListDue", s.deliveriesTable)
+	defer tracing.End(span, &err)
+	out, err := s.client.Query(ctx, &dyn.QueryInput{
+		TableName:                &s.deliveriesTable,
+		IndexName:                awsString(StatusIndex),
+		KeyConditionExpression:   awsString("#s = :pending AND next_attempt_at <= :now"),
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: DeliveryPending},
+			":now":     &types.AttributeValueMemberN{Value: strconv.FormatInt(now.UnixMilli(), 10)},
+		},
+		Limit:             &limit,
+		ScanIndexForward:  awsBool(true),
+		ExclusiveStartKey: after,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("query due deliveries: %w", err)
+	}
+	var deliveries []Delivery
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &deliveries); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal deliveries: %w", err)
+	}
+	if len(out.LastEvaluatedKey) == 0 {
+		return deliveries, nil, nil
+	}
+	return deliveries, out.LastEvaluatedKey, nil
+}
+
+// GetDelivery fetches the delivery of an event to an endpoint. Returns (nil, nil) if not found.
+func (s *Store) GetDelivery(ctx context.Context, endpointID, eventID string) (_ *Delivery, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "webhooks.GetDelivery", s.deliveriesTable)
+	defer tracing.End(span, &err)
+	out, err := s.client.GetItem(ctx, &dyn.GetItemInput{
+		TableName: &s.deliveriesTable,
+		Key:       deliveryKey(endpointID, eventID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get delivery: %w", err)
+	}
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+	var d Delivery
+	if err := attributevalue.UnmarshalMap(out.Item, &d); err != nil {
+		return nil, fmt.Errorf("unmarshal delivery: %w", err)
+	}
+	return &d, nil
+}
+
+// ListDeliveries returns an endpoint's delivery log: up to limit deliveries (DefaultListLimit when zero),
+// newest event first, each with its attempts.
+func (s *Store) ListDeliveries(ctx context.Context, endpointID string, limit int32) (_ []Delivery, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "webhooks.ListDeliveries", s.deliveriesTable)
+	defer tracing.End(span, &err)
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	out, err := s.client.Query(ctx, &dyn.QueryInput{
+		TableName:              &s.deliveriesTable,
+		KeyConditionExpression: awsString("endpoint_id = :eid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eid": &types.AttributeValueMemberS{Value: endpointID},
+		},
+		Limit:            &limit,
+		ScanIndexForward: awsBool(false), // event IDs are time-ordered
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query deliveries: %w", err)
+	}
+	deliveries := []Delivery{}
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &deliveries); err != nil {
+		return nil, fmt.Errorf("unmarshal deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// RecordAttempt appends attempt to d's log and moves d to status, with its next attempt at next for a
+// delivery staying PENDING. The update is conditional on d being PENDING with the attempts read, so of
+// two dispatchers sending the same delivery only one records it; the other gets ErrStale.
+func (s *Store) RecordAttempt(ctx context.Context, d Delivery, attempt Attempt, status string, next time.Time) (err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "webhooks.RecordAttempt", s.deliveriesTable)
+	defer tracing.End(span, &err, ErrStale)
+	entry, err := attributevalue.MarshalList([]Attempt{attempt})
+	if err != nil {
+		return fmt.Errorf("marshal attempt: %w", err)
+	}
+	_, err = s.client.UpdateItem(ctx, &dyn.UpdateItemInput{
+		TableName:                &s.deliveriesTable,
+		Key:                      deliveryKey(d.EndpointID, d.EventID),
+		UpdateExpression:         awsString("SET #s = :status, attempts = :attempts, next_attempt_at = :next, attempt_log = list_append(if_not_exists(attempt_log, :empty), :attempt), updated_at = :ua"),
+		ConditionExpression:      awsString("#s = :pending AND attempts = :prev"),
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":   &types.AttributeValueMemberS{Value: status},
+			":pending":  &types.AttributeValueMemberS{Value: DeliveryPending},
+			":attempts": &types.AttributeValueMemberN{Value: strconv.Itoa(d.Attempts + 1)},
+			":prev":     &types.AttributeValueMemberN{Value: strconv.Itoa(d.Attempts)},
+			":next":     &types.AttributeValueMemberN{Value: strconv.FormatInt(next.UnixMilli(), 10)},
+			":empty":    &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+			":attempt":  &types.AttributeValueMemberL{Value: entry},
+			":ua":       &types.AttributeValueMemberS{Value: s.nowFunc().UTC().Format(time.RFC3339Nano)},
+		},
+	})
+	if err != nil {
+		var cf *types.ConditionalCheckFailedException
+		if errors.As(err, &cf) {
+			return fmt.Errorf("%w: %s to endpoint %s", ErrStale, d.EventID, d.EndpointID)
+		}
+		return fmt.Errorf("record delivery attempt: %w", err)
+	}
+	return nil
+}
+
+// Replay makes a delivery PENDING and due now with a fresh retry budget, whatever its status. The
+// payload, and so the event ID receivers deduplicate on, is unchanged. Returns ErrNotFound if the
+// delivery does not exist.
+func (s *Store) Replay(ctx context.Context, endpointID, eventID string) (_ *Delivery, err error) {
+	ctx, span := tracing.StartDynamoDB(ctx, "webhooks.Replay", s.deliveriesTable)
+	defer tracing.End(span, &err, ErrNotFound)
+	now := s.nowFunc()
+	out, err := s.client.UpdateItem(ctx, &dyn.UpdateItemInput{
+		TableName:                &s.deliveriesTable,
+		Key:                      deliveryKey(endpointID, eventID),
+		UpdateExpression:         awsString("SET #s = :pending, attempts = :zero, next_attempt_at = :now, replays = if_not_exists(replays, :zero) + :one, updated_at = :ua"),
+		ConditionExpression:      awsString("attribute_exists(endpoint_id)"),
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: DeliveryPending},
+			":zero":    &types.AttributeValueMemberN{Value: "0"},
+			":one":     &types.AttributeValueMemberN{Value: "1"},
+			":now":     &types.AttributeValueMemberN{Value: strconv.FormatInt(now.UnixMilli(), 10)},
+			":ua":      &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339Nano)},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		var cf *types.ConditionalCheckFailedException
+		if errors.As(err, &cf) {
+			return nil, fmt.Errorf("%w: delivery of %s to endpoint %s", ErrNotFound, eventID, endpointID)
+		}
+		return nil, fmt.Errorf("replay delivery: %w", err)
+	}
+	var d Delivery
+	if err := attributevalue.UnmarshalMap(out.Attributes, &d); err != nil {
+		return nil, fmt.Errorf("unmarshal delivery: %w", err)
+	}
+	return &d, nil
+}
+
+func deliveryKey(endpointID, eventID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"endpoint_id": &types.AttributeValueMemberS{Value: endpointID},
+		"event_id":    &types.AttributeValueMemberS{Value: eventID},
+	}
+}
+
+func awsString(s string) *string { return &s }
+
+func awsBool(b bool) *bool { return &b }
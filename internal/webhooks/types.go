@@ -0,0 +1,126 @@
+// Package webhooks notifies customers of order status changes. Every transition written by
+// orders.Store also writes an Event (see Store.RecordTransition); the Dispatcher fans each event out to
+// the customer's registered endpoints as one Delivery per endpoint and POSTs it, signed with the
+// endpoint's secret, retrying with exponential backoff. Each delivery keeps a log of its attempts and
+// can be replayed.
+package webhooks
+
+import (
+	"strings"
+	"time"
+)
+
+// Endpoint statuses.
+const (
+	EndpointActive   = "ACTIVE"
+	EndpointDisabled = "DISABLED" // receives no new deliveries
+)
+
+// Event statuses.
+const (
+	EventPending    = "PENDING"    // written with the transition, not yet fanned out
+	EventDispatched = "DISPATCHED" // a delivery exists for every matching endpoint
+)
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "PENDING"   // waiting for its next attempt
+	DeliverySucceeded = "SUCCEEDED" // the endpoint answered 2xx
+	DeliveryFailed    = "FAILED"    // every attempt failed; a replay starts over
+)
+
+// CustomerIndex is the GSI on the endpoints table keyed by customer_id (hash) and created_at (range).
+const CustomerIndex = "customer_id_index"
+
+// StatusIndex is the GSI on the events table keyed by status (hash) and created_at (range), and on the
+// deliveries table keyed by status (hash) and next_attempt_at (range).
+const StatusIndex = "status_index"
+
+// EventType names the event of an order moving to status, e.g. "order.completed".
+func EventType(status string) string {
+	return "order." + strings.ToLower(status)
+}
+
+// Endpoint is a customer's URL receiving webhooks, stored in the endpoints table.
+type Endpoint struct {
+	EndpointID string    `dynamodbav:"endpoint_id" json:"endpoint_id"` // PK
+	CustomerID string    `dynamodbav:"customer_id" json:"customer_id"`
+	URL        string    `dynamodbav:"url" json:"url"`
+	Secret     string    `dynamodbav:"secret" json:"secret,omitempty"`                          // HMAC key; only shown when the endpoint is registered
+	EventTypes []string  `dynamodbav:"event_types,stringset,omitempty" json:"events,omitempty"` // empty for every event
+	Status     string    `dynamodbav:"status" json:"status"`
+	CreatedAt  time.Time `dynamodbav:"created_at" json:"created_at"`
+	UpdatedAt  time.Time `dynamodbav:"updated_at" json:"updated_at"`
+}
+
+// Wants reports whether the endpoint takes events of type eventType.
+func (e *Endpoint) Wants(eventType string) bool {
+	if e.Status != EndpointActive {
+		return false
+	}
+	if len(e.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range e.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is one order status transition, stored in the events table.
+type Event struct {
+	EventID   string    `dynamodbav:"event_id" json:"id"` // PK; time-ordered (UUIDv7)
+	Type      string    `dynamodbav:"type" json:"type"`
+	OrderID   string    `dynamodbav:"order_id" json:"order_id"`
+	From      string    `dynamodbav:"from,omitempty" json:"from,omitempty"`
+	To        string    `dynamodbav:"to" json:"to"`
+	Actor     string    `dynamodbav:"actor,omitempty" json:"actor,omitempty"`
+	Reason    string    `dynamodbav:"reason,omitempty" json:"reason,omitempty"`
+	Status    string    `dynamodbav:"status" json:"-"`
+	CreatedAt time.Time `dynamodbav:"created_at" json:"created_at"`
+	ExpiresAt int64     `dynamodbav:"expires_at" json:"-"` // DynamoDB TTL (epoch seconds)
+}
+
+// Payload is the JSON body POSTed to endpoints. ID is the event ID, the same for every attempt and
+// replay, so receivers can drop duplicates.
+type Payload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      PayloadData `json:"data"`
+}
+
+// PayloadData describes the transition.
+type PayloadData struct {
+	OrderID        string `json:"order_id"`
+	CustomerID     string `json:"customer_id,omitempty"`
+	Status         string `json:"status"`
+	PreviousStatus string `json:"previous_status,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+// Delivery is one event sent to one endpoint, stored in the deliveries table. Listing an endpoint's
+// deliveries is its delivery log.
+type Delivery struct {
+	EndpointID    string    `dynamodbav:"endpoint_id" json:"endpoint_id"` // PK
+	EventID       string    `dynamodbav:"event_id" json:"event_id"`       // SK
+	EventType     string    `dynamodbav:"event_type" json:"event_type"`
+	Payload       string    `dynamodbav:"payload" json:"payload"` // fixed at fan-out: every attempt sends the same bytes
+	Status        string    `dynamodbav:"status" json:"status"`
+	Attempts      int       `dynamodbav:"attempts" json:"attempts"` // since the delivery was created or last replayed
+	Replays       int       `dynamodbav:"replays,omitempty" json:"replays,omitempty"`
+	NextAttemptAt int64     `dynamodbav:"next_attempt_at" json:"next_attempt_at"` // epoch milliseconds
+	Log           []Attempt `dynamodbav:"attempt_log,omitempty" json:"log,omitempty"`
+	CreatedAt     time.Time `dynamodbav:"created_at" json:"created_at"`
+	UpdatedAt     time.Time `dynamodbav:"updated_at" json:"updated_at"`
+}
+
+// Attempt records one POST of a delivery.
+type Attempt struct {
+	At         time.Time `dynamodbav:"at" json:"at"`
+	StatusCode int       `dynamodbav:"status_code,omitempty" json:"status_code,omitempty"` // zero if no response arrived
+	Error      string    `dynamodbav:"error,omitempty" json:"error,omitempty"`
+	DurationMs int64     `dynamodbav:"duration_ms" json:"duration_ms"`
+}
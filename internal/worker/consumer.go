@@ -0,0 +1,255 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+)
+
+// Consumer defaults; SQS caps a receive at 10 messages and a long poll at 20 seconds.
+const (
+	DefaultConcurrency       = 4
+	DefaultWaitTime          = 20 * time.Second
+	DefaultVisibilityTimeout = 30 * time.Second
+	maxBatchSize             = 10
+	receiveErrorBackoff      = time.Second
+)
+
+// ConsumerConfig configures a Consumer. Zero values take the defaults above.
+type ConsumerConfig struct {
+	QueueURL          string
+	Concurrency       int           // messages processed in parallel
+	WaitTime          time.Duration // long-poll wait per ReceiveMessage, at most 20s
+	VisibilityTimeout time.Duration // how long a received message stays hidden; renewed by the heartbeat
+	HeartbeatInterval time.Duration // how often visibility is extended while processing; defaults to VisibilityTimeout/3
+	DrainTimeout      time.Duration // how long Run waits for in-flight messages after ctx is cancelled; 0 waits until they finish
+}
+
+// Consumer long-polls an SQS queue and feeds messages to a Processor, for running the worker
+// outside Lambda. A message is deleted only after it was processed successfully; failed messages
+// become visible again when their visibility timeout expires and are retried, then dead-lettered
+// by the queue's redrive policy, exactly as with the Lambda event source mapping.
+type Consumer struct {
+	sqs       aws.SQSAPI
+	processor *Processor
+	cfg       ConsumerConfig
+}
+
+// NewConsumer creates a Consumer reading cfg.QueueURL and processing with p.
+func NewConsumer(sqsClient aws.SQSAPI, p *Processor, cfg ConsumerConfig) *Consumer {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = DefaultConcurrency
+	}
+	if cfg.WaitTime <= 0 || cfg.WaitTime > DefaultWaitTime {
+		cfg.WaitTime = DefaultWaitTime
+	}
+	if cfg.VisibilityTimeout < time.Second {
+		cfg.VisibilityTimeout = DefaultVisibilityTimeout
+	}
+	if cfg.HeartbeatInterval <= 0 || cfg.HeartbeatInterval >= cfg.VisibilityTimeout {
+		cfg.HeartbeatInterval = cfg.VisibilityTimeout / 3
+	}
+	return &Consumer{sqs: sqsClient, processor: p, cfg: cfg}
+}
+
+// Run receives and processes messages until ctx is cancelled, then stops receiving and drains:
+// messages already received are finished (up to DrainTimeout) so a SIGTERM does not waste work.
+// Messages still unfinished when the drain times out are not deleted and will be redelivered.
+func (c *Consumer) Run(ctx context.Context) error {
+	// processing outlives ctx so in-flight messages can finish during the drain
+	procCtx, cancelProc := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelProc()
+
+	slots := make(chan struct{}, c.cfg.Concurrency)
+	var wg sync.WaitGroup
+
+	for ctx.Err() == nil {
+		// wait for a free slot, then take every other free slot up to a full batch
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			continue
+		}
+		n := 1
+	fill:
+		for n < maxBatchSize {
+			select {
+			case slots <- struct{}{}:
+				n++
+			default:
+				break fill
+			}
+		}
+
+		msgs, err := c.receive(ctx, n)
+		for i := len(msgs); i < n; i++ {
+			<-slots
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("consumer receive failed", logging.Err(err))
+				sleep(ctx, receiveErrorBackoff)
+			}
+			continue
+		}
+
+		for _, m := range msgs {
+			wg.Add(1)
+			go func(m sqstypes.Message) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				c.handle(procCtx, m)
+			}(m)
+		}
+	}
+
+	slog.Info("consumer stopping, draining in-flight messages")
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	if c.cfg.DrainTimeout > 0 {
+		select {
+		case <-done:
+		case <-time.After(c.cfg.DrainTimeout):
+			slog.Warn("consumer drain timed out; unfinished messages will be redelivered", "drain_timeout", c.cfg.DrainTimeout.String())
+			cancelProc()
+			<-done
+		}
+	} else {
+		<-done
+	}
+	return ctx.Err()
+}
+
+// PollOnce receives one batch (long-polling up to WaitTime), processes it with the configured
+// concurrency and returns how many messages were received.
+func (c *Consumer) PollOnce(ctx context.Context) (int, error) {
+	msgs, err := c.receive(ctx, min(c.cfg.Concurrency, maxBatchSize))
+	if err != nil {
+		return 0, err
+	}
+	var wg sync.WaitGroup
+	for _, m := range msgs {
+		wg.Add(1)
+		go func(m sqstypes.Message) {
+			defer wg.Done()
+			c.handle(ctx, m)
+		}(m)
+	}
+	wg.Wait()
+	return len(msgs), nil
+}
+
+func (c *Consumer) receive(ctx context.Context, max int) ([]sqstypes.Message, error) {
+	out, err := c.sqs.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:              &c.cfg.QueueURL,
+		MaxNumberOfMessages:   int32(max),
+		WaitTimeSeconds:       int32(c.cfg.WaitTime / time.Second),
+		VisibilityTimeout:     int32(c.cfg.VisibilityTimeout / time.Second),
+		MessageAttributeNames: []string{"All"},
+		MessageSystemAttributeNames: []sqstypes.MessageSystemAttributeName{
+			sqstypes.MessageSystemAttributeNameApproximateReceiveCount,
+			sqstypes.MessageSystemAttributeNameSentTimestamp,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Messages, nil
+}
+
+// handle processes one message while a heartbeat keeps it hidden, and deletes it on success.
+func (c *Consumer) handle(ctx context.Context, m sqstypes.Message) {
+	rec := toEventMessage(m)
+
+	hbCtx, stopHeartbeat := context.WithCancel(ctx)
+	var hb sync.WaitGroup
+	hb.Add(1)
+	go func() {
+		defer hb.Done()
+		c.heartbeat(hbCtx, rec)
+	}()
+	err := c.processor.processMessage(ctx, rec)
+	stopHeartbeat()
+	hb.Wait()
+
+	if err != nil {
+		// left in flight: it reappears after the visibility timeout and is retried;
+		// processMessage has already logged the error
+		return
+	}
+	if _, err := c.sqs.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &c.cfg.QueueURL, ReceiptHandle: &rec.ReceiptHandle}); err != nil {
+		// processed but not deleted: the redelivery is absorbed by the processor's idempotency
+		slog.Warn("consumer delete failed", logging.KeyMessageID, rec.MessageId, logging.Err(err))
+	}
+}
+
+// heartbeat extends the message's visibility every HeartbeatInterval until ctx is done,
+// so slow orders are not redelivered to another consumer while still being processed.
+func (c *Consumer) heartbeat(ctx context.Context, rec events.SQSMessage) {
+	ticker := time.NewTicker(c.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := c.sqs.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          &c.cfg.QueueURL,
+				ReceiptHandle:     &rec.ReceiptHandle,
+				VisibilityTimeout: int32(c.cfg.VisibilityTimeout / time.Second),
+			})
+			if err != nil && ctx.Err() == nil {
+				slog.Warn("consumer visibility extension failed", logging.KeyMessageID, rec.MessageId, logging.Err(err))
+			}
+		}
+	}
+}
+
+// toEventMessage converts a received SQS message to the shape Lambda delivers, so processMessage
+// sees the same input in both runtimes.
+func toEventMessage(m sqstypes.Message) events.SQSMessage {
+	rec := events.SQSMessage{
+		MessageId:         deref(m.MessageId),
+		ReceiptHandle:     deref(m.ReceiptHandle),
+		Body:              deref(m.Body),
+		Attributes:        m.Attributes,
+		MessageAttributes: make(map[string]events.SQSMessageAttribute, len(m.MessageAttributes)),
+		EventSource:       "aws:sqs",
+	}
+	for k, v := range m.MessageAttributes {
+		rec.MessageAttributes[k] = events.SQSMessageAttribute{
+			DataType:    deref(v.DataType),
+			StringValue: v.StringValue,
+			BinaryValue: v.BinaryValue,
+		}
+	}
+	return rec
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/idempotency"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/testing/sqsfake"
+)
+
+// observedSQS counts heartbeats and cancels the consumer as soon as a batch is received.
+type observedSQS struct {
+	*sqsfake.Fake
+	heartbeats   atomic.Int32
+	onFirstBatch func()
+	fired        atomic.Bool
+}
+
+func (o *observedSQS) ReceiveMessage(ctx context.Context, in *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	out, err := o.Fake.ReceiveMessage(ctx, in, optFns...)
+	if err == nil && len(out.Messages) > 0 && o.onFirstBatch != nil && o.fired.CompareAndSwap(false, true) {
+		o.onFirstBatch()
+	}
+	return out, err
+}
+
+func (o *observedSQS) ChangeMessageVisibility(ctx context.Context, in *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	o.heartbeats.Add(1)
+	return o.Fake.ChangeMessageVisibility(ctx, in, optFns...)
+}
+
+func TestConsumer_DrainsInFlightOnShutdownAndDeletesOnlySuccesses(t *testing.T) {
+	db := newFakeDynamo()
+	for _, id := range []string{"o1", "o2"} {
+		item, _ := attributevalue.MarshalMap(orders.Order{OrderID: id, Status: orders.StatusPending, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+		db.Put("orders", item)
+		idmap, _ := attributevalue.MarshalMap(idempotency.IdempotencyRecord{IdempotencyKey: "k-" + id, Status: idempotency.StatusInProgress, OrderID: id})
+		db.Put("idempotency", idmap)
+	}
+
+	fake := sqsfake.New()
+	url := fake.CreateQueue("orders", time.Second)
+	for _, body := range []string{
+		`{"order_id":"o1","idempotency_key":"k-o1"}`,
+		`{not json`,
+		`{"order_id":"o2","idempotency_key":"k-o2"}`,
+	} {
+		if _, err := fake.SendMessage(context.Background(), &sqs.SendMessageInput{QueueUrl: &url, MessageBody: &body}); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &observedSQS{Fake: fake, onFirstBatch: cancel}
+	p := NewProcessor(&aws.AWSClients{DynamoDB: db, SQS: q}, "idempotency", "orders", 48*time.Hour).
+		WithSteps(StepFunc("work", func(context.Context, StepInput) error {
+			time.Sleep(200 * time.Millisecond)
+			return nil
+		}))
+	c := NewConsumer(q, p, ConsumerConfig{
+		QueueURL:          url,
+		Concurrency:       3,
+		VisibilityTimeout: time.Second,
+		HeartbeatInterval: 50 * time.Millisecond,
+	})
+
+	// shutdown is requested as soon as the first batch arrives; Run must still finish it
+	if err := c.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	for _, id := range []string{"o1", "o2"} {
+		if st := db.Item("orders", id)["status"].(*types.AttributeValueMemberS).Value; st != orders.StatusCompleted {
+			t.Fatalf("expected %s COMPLETED after drain, got %s", id, st)
+		}
+	}
+	// only the poison message is left for redelivery
+	if n := fake.Len(url); n != 1 {
+		t.Fatalf("expected only the failed message to remain, got %d", n)
+	}
+	// the step takes ~200ms, so the 50ms heartbeat extended visibility several times
+	if q.heartbeats.Load() == 0 {
+		t.Fatalf("expected visibility to be extended while processing")
+	}
+}
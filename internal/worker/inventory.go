@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/inventory"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+)
+
+// ReserveInventoryStep reserves the order's line items. Missing stock fails the order; the reservation
+// is keyed by order_id, so a rerun after a crash does not reserve twice. Its compensation is
+// ReleaseInventory.
+func ReserveInventoryStep(inv *inventory.Store) Step {
+	return WithCompensation(StepFunc("reserve_inventory", func(ctx context.Context, in StepInput) error {
+		items, err := in.Order.LineItems()
+		if err != nil {
+			return Permanent(err)
+		}
+		lines := make([]inventory.Line, 0, len(items))
+		for _, it := range items {
+			lines = append(lines, inventory.Line{SKU: it.SKU, Quantity: int64(it.Quantity)})
+		}
+		_, err = inv.Reserve(ctx, in.Order.OrderID, lines)
+		return classifyInventory(err)
+	}), ReleaseInventory(inv))
+}
+
+// CommitInventoryStep marks the order's reservation as consumed so it no longer expires. It belongs
+// after the steps that can still fail the order, e.g. charging the payment.
+func CommitInventoryStep(inv *inventory.Store) Step {
+	return StepFunc("commit_inventory", func(ctx context.Context, in StepInput) error {
+		_, err := inv.Commit(ctx, in.Order.OrderID)
+		return classifyInventory(err)
+	})
+}
+
+// ReleaseInventory is a Compensator returning the stock reserved for a cancelled or failed order.
+func ReleaseInventory(inv *inventory.Store) Compensator {
+	return func(ctx context.Context, order *orders.Order) error {
+		_, err := inv.Release(ctx, order.OrderID, fmt.Sprintf("order %s", order.Status))
+		return err
+	}
+}
+
+// classifyInventory marks the inventory errors that retrying cannot fix as permanent.
+func classifyInventory(err error) error {
+	switch {
+	case errors.Is(err, inventory.ErrInsufficientStock),
+		errors.Is(err, inventory.ErrUnknownSKU),
+		errors.Is(err, inventory.ErrInvalidLines),
+		errors.Is(err, inventory.ErrReservationNotFound),
+		errors.Is(err, inventory.ErrReservationReleased):
+		return Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/inventory"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/testing/dynamofake"
+)
+
+func TestInventorySteps_ReserveCommitAndReleaseOnFailure(t *testing.T) {
+	db := newFakeDynamo()
+	db.CreateTable(dynamofake.TableSchema{Name: "stock", HashKey: "sku"})
+	db.CreateTable(dynamofake.TableSchema{
+		Name:    "reservations",
+		HashKey: "order_id",
+		Indexes: []dynamofake.IndexSchema{{Name: inventory.StatusIndex, HashKey: "status", RangeKey: "expires_at"}},
+	})
+	inv := inventory.NewStore(db, "stock", "reservations", inventory.DefaultHold)
+	ctx := context.Background()
+	if _, err := inv.AddStock(ctx, "A", 3); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"paid", "declined"} {
+		putPendingOrder(db, id, 500) // one unit of A
+	}
+
+	p := NewProcessor(&aws.AWSClients{DynamoDB: db}, "idempotency", "orders", 48*time.Hour).
+		WithSteps(
+			ValidateStep(),
+			ReserveInventoryStep(inv),
+			StepFunc("charge", func(_ context.Context, in StepInput) error {
+				if in.Order.OrderID == "declined" {
+					return Permanent(errors.New("card declined"))
+				}
+				return nil
+			}),
+			CommitInventoryStep(inv),
+		)
+
+	for _, id := range []string{"paid", "declined"} {
+		if resp, _ := p.Handle(ctx, orderMessage(id)); len(resp.BatchItemFailures) != 0 {
+			t.Fatalf("%s: unexpected failures %+v", id, resp.BatchItemFailures)
+		}
+	}
+	if res, _ := inv.GetReservation(ctx, "paid"); res == nil || res.Status != inventory.StatusCommitted {
+		t.Fatalf("expected the paid order's stock committed, got %+v", res)
+	}
+	if res, _ := inv.GetReservation(ctx, "declined"); res == nil || res.Status != inventory.StatusReleased || res.Reason != "order FAILED" {
+		t.Fatalf("expected the declined order's stock released, got %+v", res)
+	}
+	if st, _ := inv.Get(ctx, "A"); st.Available != 2 || st.Reserved != 0 {
+		t.Fatalf("expected 2 available and none reserved, got %+v", st)
+	}
+
+	// not enough stock fails the order without reserving anything
+	putPendingOrder(db, "big", 500)
+	item, _ := attributevalue.MarshalMap(orders.Order{
+		OrderID:   "big",
+		Status:    orders.StatusPending,
+		Amount:    money.Money{Amount: 500, Currency: "USD"},
+		Items:     []map[string]interface{}{{"sku": "A", "quantity": 5, "price": money.Money{Amount: 100, Currency: "USD"}}},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	db.Put("orders", item)
+	if resp, _ := p.Handle(ctx, orderMessage("big")); len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("unexpected failures %+v", resp.BatchItemFailures)
+	}
+	o, _ := orders.NewStore(db, "orders").Get(ctx, "big")
+	if o.Status != orders.StatusFailed || !strings.Contains(o.StatusHistory[len(o.StatusHistory)-1].Reason, "insufficient stock") {
+		t.Fatalf("expected FAILED for insufficient stock, got %s %+v", o.Status, o.StatusHistory)
+	}
+	if res, _ := inv.GetReservation(ctx, "big"); res != nil {
+		t.Fatalf("nothing should be reserved, got %+v", res)
+	}
+}
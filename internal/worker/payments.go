@@ -0,0 +1,166 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/payments"
+)
+
+const (
+	stepAuthorizePayment = "authorize_payment"
+	stepCapturePayment   = "capture_payment"
+)
+
+var errNotAuthorized = errors.New("no successful payment authorization")
+
+// AuthorizePaymentStep places a hold for the order total. A declined payment fails the order. A timeout
+// is retried with the same idempotency key, so the provider authorizes at most once per order. Its
+// compensation is ReleasePayment, which also covers CapturePaymentStep.
+func AuthorizePaymentStep(gw payments.PaymentGateway, store *orders.Store) Step {
+	return WithCompensation(StepFunc(stepAuthorizePayment, func(ctx context.Context, in StepInput) error {
+		_, err := authorizePayment(ctx, gw, store, in.Order)
+		return err
+	}), ReleasePayment(gw, store))
+}
+
+// CapturePaymentStep collects the authorized order total. It belongs after AuthorizePaymentStep.
+func CapturePaymentStep(gw payments.PaymentGateway, store *orders.Store) Step {
+	return StepFunc(stepCapturePayment, func(ctx context.Context, in StepInput) error {
+		_, err := capturePayment(ctx, gw, store, in.Order)
+		return err
+	})
+}
+
+// ReleasePayment is a Compensator giving the money of a cancelled or failed order back: it voids the
+// authorization, or refunds the capture if the order was already charged. A call whose outcome was
+// never recorded is settled first by replaying it under its idempotency key.
+func ReleasePayment(gw payments.PaymentGateway, store *orders.Store) Compensator {
+	return func(ctx context.Context, order *orders.Order) error {
+		auth := order.LastPayment(string(payments.OpAuthorize))
+		if auth == nil {
+			return nil
+		}
+		if auth.Status == orders.PaymentPending {
+			var err error
+			if auth, err = authorizePayment(ctx, gw, store, order); err != nil {
+				if IsPermanent(err) {
+					return nil // declined, nothing to give back
+				}
+				return err
+			}
+		}
+		if auth.Status != orders.PaymentSucceeded {
+			return nil
+		}
+
+		capture := order.LastPayment(string(payments.OpCapture))
+		if capture == nil || capture.Status != orders.PaymentSucceeded {
+			// a pending capture may or may not have happened; voiding answers that without charging
+			_, err := recordedCall(ctx, store, order, orders.PaymentAttempt{
+				Operation:       string(payments.OpVoid),
+				IdempotencyKey:  StepKey(order.OrderID, "void_payment"),
+				Amount:          auth.Amount,
+				AuthorizationID: auth.AuthorizationID,
+			}, func() (*payments.Result, error) {
+				return gw.Void(ctx, payments.VoidRequest{IdempotencyKey: StepKey(order.OrderID, "void_payment"), AuthorizationID: auth.AuthorizationID})
+			})
+			if err == nil || capture == nil || !errors.Is(err, payments.ErrInvalidState) {
+				return err
+			}
+			if capture, err = capturePayment(ctx, gw, store, order); err != nil {
+				return err
+			}
+		}
+		_, err := recordedCall(ctx, store, order, orders.PaymentAttempt{
+			Operation:       string(payments.OpRefund),
+			IdempotencyKey:  StepKey(order.OrderID, "refund_payment"),
+			Amount:          capture.Amount,
+			AuthorizationID: auth.AuthorizationID,
+		}, func() (*payments.Result, error) {
+			return gw.Refund(ctx, payments.RefundRequest{IdempotencyKey: StepKey(order.OrderID, "refund_payment"), AuthorizationID: auth.AuthorizationID, Amount: capture.Amount})
+		})
+		return err
+	}
+}
+
+func authorizePayment(ctx context.Context, gw payments.PaymentGateway, store *orders.Store, order *orders.Order) (*orders.PaymentAttempt, error) {
+	req := payments.AuthorizeRequest{
+		IdempotencyKey: StepKey(order.OrderID, stepAuthorizePayment),
+		OrderID:        order.OrderID,
+		CustomerID:     order.CustomerID,
+		Amount:         order.Amount,
+	}
+	return recordedCall(ctx, store, order, orders.PaymentAttempt{
+		Operation:      string(payments.OpAuthorize),
+		IdempotencyKey: req.IdempotencyKey,
+		Amount:         req.Amount,
+	}, func() (*payments.Result, error) { return gw.Authorize(ctx, req) })
+}
+
+func capturePayment(ctx context.Context, gw payments.PaymentGateway, store *orders.Store, order *orders.Order) (*orders.PaymentAttempt, error) {
+	auth := order.LastPayment(string(payments.OpAuthorize))
+	if auth == nil || auth.Status != orders.PaymentSucceeded {
+		return nil, Permanent(fmt.Errorf("capture: %w", errNotAuthorized))
+	}
+	req := payments.CaptureRequest{
+		IdempotencyKey:  StepKey(order.OrderID, stepCapturePayment),
+		AuthorizationID: auth.AuthorizationID,
+		Amount:          auth.Amount,
+	}
+	return recordedCall(ctx, store, order, orders.PaymentAttempt{
+		Operation:       string(payments.OpCapture),
+		IdempotencyKey:  req.IdempotencyKey,
+		Amount:          req.Amount,
+		AuthorizationID: req.AuthorizationID,
+	}, func() (*payments.Result, error) { return gw.Capture(ctx, req) })
+}
+
+// recordedCall makes one gateway call with the order's payment attempts as a write-ahead log: a PENDING
+// attempt is persisted before the call and the outcome after it. If the last attempt under the same key
+// already succeeded the call is skipped; if it is still PENDING (a crash or a timeout) the call is
+// repeated under the same key and the provider returns the original outcome. Errors the provider
+// would repeat are recorded and returned as Permanent; anything else leaves the attempt PENDING.
+func recordedCall(ctx context.Context, store *orders.Store, order *orders.Order, attempt orders.PaymentAttempt, call func() (*payments.Result, error)) (*orders.PaymentAttempt, error) {
+	last := order.LastPayment(attempt.Operation)
+	sameKey := last != nil && last.IdempotencyKey == attempt.IdempotencyKey
+	if sameKey && last.Status == orders.PaymentSucceeded {
+		return last, nil
+	}
+	if !sameKey || last.Status != orders.PaymentPending {
+		attempt.Status = orders.PaymentPending
+		if err := recordPayment(ctx, store, order, attempt); err != nil {
+			return nil, err
+		}
+	}
+
+	res, callErr := call()
+	switch {
+	case callErr == nil:
+		attempt.Status, attempt.ProviderID, attempt.AuthorizationID, attempt.Amount = orders.PaymentSucceeded, res.ID, res.AuthorizationID, res.Amount
+	case payments.IsPermanent(callErr):
+		attempt.Status, attempt.Error = orders.PaymentFailed, callErr.Error()
+	default:
+		return nil, fmt.Errorf("%s payment: %w", attempt.Operation, callErr)
+	}
+	if err := recordPayment(ctx, store, order, attempt); err != nil {
+		return nil, err
+	}
+	if callErr != nil {
+		return nil, Permanent(fmt.Errorf("%s payment: %w", attempt.Operation, callErr))
+	}
+	return &order.Payments[len(order.Payments)-1], nil
+}
+
+// recordPayment persists attempt and appends it to the in-memory order as well.
+func recordPayment(ctx context.Context, store *orders.Store, order *orders.Order, attempt orders.PaymentAttempt) error {
+	attempt.At = time.Now().UTC()
+	if err := store.RecordPayment(ctx, order.OrderID, attempt); err != nil {
+		return fmt.Errorf("record %s payment attempt: %w", attempt.Operation, err)
+	}
+	order.Payments = append(order.Payments, attempt)
+	return nil
+}
@@ -0,0 +1,135 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/payments"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/testing/dynamofake"
+)
+
+// putCustomerOrder stores a pending order like putPendingOrder, placed by customerID.
+func putCustomerOrder(db *dynamofake.Fake, orderID, customerID string, amount int64) {
+	putPendingOrder(db, orderID, amount)
+	item, _ := attributevalue.MarshalMap(orders.Order{
+		OrderID:    orderID,
+		CustomerID: customerID,
+		Status:     orders.StatusPending,
+		Amount:     money.Money{Amount: amount, Currency: "USD"},
+		Items:      []map[string]interface{}{{"sku": "A", "quantity": 1, "price": money.Money{Amount: amount, Currency: "USD"}}},
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	})
+	db.Put("orders", item)
+}
+
+func TestPaymentSteps_ResumeAfterLostResponseWithoutChargingTwice(t *testing.T) {
+	db := newFakeDynamo()
+	putCustomerOrder(db, "o1", "c1", 1000)
+	ctx := context.Background()
+	gw := payments.NewFake().
+		FailNext(payments.OpAuthorize, payments.FaultLostResponse).
+		FailNext(payments.OpCapture, payments.FaultTimeout)
+	store := orders.NewStore(db, "orders")
+	p := NewProcessor(&aws.AWSClients{DynamoDB: db}, "idempotency", "orders", 48*time.Hour).
+		WithSteps(ValidateStep(), AuthorizePaymentStep(gw, store), CapturePaymentStep(gw, store))
+
+	// the authorization happens but its response is lost, then the capture times out
+	for i := 0; i < 2; i++ {
+		if resp, _ := p.Handle(ctx, orderMessage("o1")); len(resp.BatchItemFailures) != 1 {
+			t.Fatalf("attempt %d: expected a retryable failure, got %+v", i, resp)
+		}
+	}
+	o, _ := store.Get(ctx, "o1")
+	if last := o.LastPayment("capture"); last == nil || last.Status != orders.PaymentPending {
+		t.Fatalf("expected a pending capture, got %+v", o.Payments)
+	}
+
+	if resp, _ := p.Handle(ctx, orderMessage("o1")); len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("unexpected failures %+v", resp.BatchItemFailures)
+	}
+	if gw.Applied(payments.OpAuthorize) != 1 || gw.Applied(payments.OpCapture) != 1 {
+		t.Fatalf("expected one authorization and one capture, got %d and %d", gw.Applied(payments.OpAuthorize), gw.Applied(payments.OpCapture))
+	}
+	o, _ = store.Get(ctx, "o1")
+	if o.Status != orders.StatusCompleted {
+		t.Fatalf("expected COMPLETED, got %s", o.Status)
+	}
+	want := []string{"authorize PENDING", "authorize SUCCEEDED", "capture PENDING", "capture SUCCEEDED"}
+	if len(o.Payments) != len(want) {
+		t.Fatalf("expected attempts %v, got %+v", want, o.Payments)
+	}
+	for i, a := range o.Payments {
+		if a.Operation+" "+a.Status != want[i] || a.IdempotencyKey != StepKey("o1", a.Operation+"_payment") {
+			t.Fatalf("attempt %d: expected %s, got %+v", i, want[i], a)
+		}
+	}
+	if fp, _ := gw.Payment(o.LastPayment("authorize").AuthorizationID); fp.Status != payments.StatusCaptured || fp.Captured.Amount != 1000 {
+		t.Fatalf("unexpected provider state %+v", fp)
+	}
+}
+
+func TestPaymentSteps_DeclineFailsOrderAndReleasePaymentUndoesCharges(t *testing.T) {
+	db := newFakeDynamo()
+	putCustomerOrder(db, "declined", "broke", 1000)
+	putCustomerOrder(db, "unshippable", "c1", 1000)
+	putCustomerOrder(db, "unfulfillable", "c1", 1000)
+	ctx := context.Background()
+	gw := payments.NewFake().Decline("broke", "insufficient_funds")
+	store := orders.NewStore(db, "orders")
+	failFor := func(name, orderID string) Step {
+		return StepFunc(name, func(_ context.Context, in StepInput) error {
+			if in.Order.OrderID == orderID {
+				return Permanent(errors.New(name + " failed"))
+			}
+			return nil
+		})
+	}
+	p := NewProcessor(&aws.AWSClients{DynamoDB: db}, "idempotency", "orders", 48*time.Hour).
+		WithSteps(
+			AuthorizePaymentStep(gw, store),
+			failFor("fulfil", "unfulfillable"),
+			CapturePaymentStep(gw, store),
+			failFor("ship", "unshippable"),
+		)
+
+	for _, id := range []string{"declined", "unshippable", "unfulfillable"} {
+		// the second delivery finds the saga compensated and changes nothing
+		for i := 0; i < 2; i++ {
+			if resp, _ := p.Handle(ctx, orderMessage(id)); len(resp.BatchItemFailures) != 0 {
+				t.Fatalf("%s: unexpected failures %+v", id, resp.BatchItemFailures)
+			}
+		}
+		if o, _ := store.Get(ctx, id); o.Status != orders.StatusFailed {
+			t.Fatalf("%s: expected FAILED, got %s", id, o.Status)
+		}
+	}
+
+	o, _ := store.Get(ctx, "declined")
+	if len(o.Payments) != 2 || o.Payments[1].Status != orders.PaymentFailed || o.Payments[1].Error != "payment declined: insufficient_funds" {
+		t.Fatalf("expected a failed authorization, got %+v", o.Payments)
+	}
+
+	o, _ = store.Get(ctx, "unfulfillable")
+	if fp, _ := gw.Payment(o.LastPayment("authorize").AuthorizationID); fp.Status != payments.StatusVoided {
+		t.Fatalf("expected the authorization voided, got %+v", fp)
+	}
+	if v := o.LastPayment("void"); v == nil || v.Status != orders.PaymentSucceeded {
+		t.Fatalf("expected a recorded void, got %+v", o.Payments)
+	}
+
+	o, _ = store.Get(ctx, "unshippable")
+	if fp, _ := gw.Payment(o.LastPayment("authorize").AuthorizationID); fp.Status != payments.StatusRefunded || fp.Refunded.Amount != 1000 {
+		t.Fatalf("expected the capture refunded, got %+v", fp)
+	}
+	if gw.Applied(payments.OpRefund) != 1 || gw.Applied(payments.OpVoid) != 1 {
+		t.Fatalf("expected one refund and one void, got %d and %d", gw.Applied(payments.OpRefund), gw.Applied(payments.OpVoid))
+	}
+}
@@ -0,0 +1,155 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/metrics"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
+)
+
+// Step is one stage of the order processing pipeline, e.g. validate, reserve inventory, charge
+// payment, fulfil. The Processor runs its steps in order for a PROCESSING order and checkpoints each
+// one on the order record once it succeeds, so a retried message resumes after the last completed step.
+//
+// A step may still run more than once for the same order (it crashed before the checkpoint, or a
+// duplicate message is processed concurrently), so Run must be idempotent: pass in.IdempotencyKey to
+// the systems it calls, or check for its own earlier effect.
+type Step interface {
+	Name() string
+	Run(ctx context.Context, in StepInput) error
+}
+
+// StepInput is what a Step runs on.
+type StepInput struct {
+	Order *orders.Order
+	// IdempotencyKey identifies this step for this order (see StepKey); it is the same on every
+	// attempt, so downstream services can deduplicate on it.
+	IdempotencyKey string
+}
+
+// StepKey returns the idempotency key of step name for orderID.
+func StepKey(orderID, name string) string {
+	return orderID + ":" + name
+}
+
+// StepFunc adapts a function to a Step named name.
+func StepFunc(name string, run func(ctx context.Context, in StepInput) error) Step {
+	return funcStep{name: name, run: run}
+}
+
+type funcStep struct {
+	name string
+	run  func(ctx context.Context, in StepInput) error
+}
+
+func (s funcStep) Name() string                                { return s.name }
+func (s funcStep) Run(ctx context.Context, in StepInput) error { return s.run(ctx, in) }
+
+// permanentError marks a failure that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as a permanent step failure: the Processor fails the order instead of retrying
+// the message. Errors not marked are retryable.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err, or an error it wraps, was marked with Permanent.
+func IsPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
+
+// WithSteps sets the pipeline p runs, in order, between moving an order to PROCESSING and completing it.
+// Step names are the checkpoints stored on orders: renaming or reordering steps affects orders
+// already in flight. Without steps an order is completed as soon as it is PROCESSING.
+func (p *Processor) WithSteps(steps ...Step) *Processor {
+	p.steps = steps
+	return p
+}
+
+// runSteps runs the pipeline for a PROCESSING order, skipping the steps checkpointed by an earlier
+// attempt. A step error is returned as is, so IsPermanent still classifies it. Returns an error
+// wrapping orders.ErrStatusMismatch if the order leaves PROCESSING between steps.
+func (p *Processor) runSteps(ctx context.Context, order *orders.Order) error {
+	logger := logging.FromContext(ctx)
+	for _, step := range p.steps {
+		name := step.Name()
+		if order.StepCompleted(name) {
+			logger.Debug("step already completed", logging.KeyStep, name)
+			continue
+		}
+		if err := p.startAction(ctx, step, order); err != nil {
+			return fmt.Errorf("start step %s: %w", name, err)
+		}
+		if err := p.runStep(ctx, step, order); err != nil {
+			return fmt.Errorf("step %s: %w", name, err)
+		}
+		if err := p.orderStore.CompleteStep(ctx, order.OrderID, name); err != nil {
+			return fmt.Errorf("checkpoint step %s: %w", name, err)
+		}
+		order.CompletedSteps = append(order.CompletedSteps, name)
+	}
+	return nil
+}
+
+// runStep runs one step in its own span and records how long it took.
+func (p *Processor) runStep(ctx context.Context, step Step, order *orders.Order) (err error) {
+	name := step.Name()
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "worker.step "+name, trace.WithAttributes(attribute.String(logging.KeyStep, name)))
+	defer tracing.End(span, &err)
+	ctx, logger := logging.With(ctx, logging.KeyStep, name)
+
+	err = step.Run(ctx, StepInput{Order: order, IdempotencyKey: StepKey(order.OrderID, name)})
+	outcome := metrics.OutcomeSuccess
+	if err != nil {
+		outcome = metrics.OutcomeFailure
+		logger.Warn("step failed", logging.Err(err), "permanent", IsPermanent(err))
+	} else {
+		logger.Debug("step completed")
+	}
+	p.metrics.Observe(metrics.StepDuration, time.Since(start), metrics.Dim(metrics.DimStep, name), metrics.Dim(metrics.DimOutcome, outcome))
+	return err
+}
+
+// ValidateStep checks that the order can be processed at all: it has well-formed line items and a
+// positive total in a known currency. Invalid orders fail permanently.
+func ValidateStep() Step {
+	return StepFunc("validate", func(_ context.Context, in StepInput) error {
+		items, err := in.Order.LineItems()
+		if err != nil {
+			return Permanent(err)
+		}
+		if len(items) == 0 {
+			return Permanent(errors.New("order has no items"))
+		}
+		if err := in.Order.Amount.Validate(); err != nil {
+			return Permanent(fmt.Errorf("order amount: %w", err))
+		}
+		if in.Order.Amount.Amount <= 0 {
+			return Permanent(fmt.Errorf("order amount must be positive, got %s", in.Order.Amount))
+		}
+		for _, it := range items {
+			if !it.Price.IsZero() && it.Price.Currency != in.Order.Amount.Currency {
+				return Permanent(fmt.Errorf("item %s: %w", it.SKU, money.ErrCurrencyMismatch))
+			}
+		}
+		return nil
+	})
+}
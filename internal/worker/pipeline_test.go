@@ -0,0 +1,148 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/idempotency"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/metrics"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/testing/dynamofake"
+)
+
+// putPendingOrder stores a PENDING order with one line item and its in-progress idempotency record.
+func putPendingOrder(db *dynamofake.Fake, orderID string, amount int64) {
+	item, _ := attributevalue.MarshalMap(orders.Order{
+		OrderID:   orderID,
+		Status:    orders.StatusPending,
+		Amount:    money.Money{Amount: amount, Currency: "USD"},
+		Items:     []map[string]interface{}{{"sku": "A", "quantity": 1, "price": money.Money{Amount: amount, Currency: "USD"}}},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	db.Put("orders", item)
+	rec, _ := attributevalue.MarshalMap(idempotency.IdempotencyRecord{
+		IdempotencyKey: "k-" + orderID,
+		Status:         idempotency.StatusInProgress,
+		OrderID:        orderID,
+	})
+	db.Put("idempotency", rec)
+}
+
+func orderMessage(orderID string) events.SQSEvent {
+	b, _ := json.Marshal(WorkerMessage{OrderID: orderID, IdempotencyKey: "k-" + orderID})
+	return events.SQSEvent{Records: []events.SQSMessage{{MessageId: "m-" + orderID, Body: string(b)}}}
+}
+
+func TestPipeline_RetryResumesAfterLastCompletedStep(t *testing.T) {
+	db := newFakeDynamo()
+	putPendingOrder(db, "o1", 500)
+	ctx := context.Background()
+
+	runs := map[string][]string{} // step -> idempotency keys it ran with
+	step := func(name string, fail *bool) Step {
+		return StepFunc(name, func(_ context.Context, in StepInput) error {
+			runs[name] = append(runs[name], in.IdempotencyKey)
+			if fail != nil && *fail {
+				return errors.New("payment provider timeout")
+			}
+			return nil
+		})
+	}
+	chargeDown := true
+	recorder := metrics.NewMemory()
+	p := NewProcessor(&aws.AWSClients{DynamoDB: db}, "idempotency", "orders", 48*time.Hour).
+		WithMetrics(recorder).
+		WithSteps(ValidateStep(), step("reserve", nil), step("charge", &chargeDown), step("fulfil", nil))
+
+	// a retryable failure fails the message and leaves the order PROCESSING with its checkpoints
+	if resp, _ := p.Handle(ctx, orderMessage("o1")); len(resp.BatchItemFailures) != 1 {
+		t.Fatalf("expected the message to fail, got %+v", resp.BatchItemFailures)
+	}
+	o, _ := orders.NewStore(db, "orders").Get(ctx, "o1")
+	if o.Status != orders.StatusProcessing || !o.StepCompleted("validate") || !o.StepCompleted("reserve") || o.StepCompleted("charge") {
+		t.Fatalf("unexpected order after the failed attempt: status=%s steps=%v", o.Status, o.CompletedSteps)
+	}
+
+	// the redelivery skips the completed steps and retries charge with the same key
+	chargeDown = false
+	if resp, _ := p.Handle(ctx, orderMessage("o1")); len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("unexpected failures on retry: %+v", resp.BatchItemFailures)
+	}
+	if len(runs["reserve"]) != 1 || len(runs["charge"]) != 2 || len(runs["fulfil"]) != 1 {
+		t.Fatalf("unexpected step runs: %v", runs)
+	}
+	if runs["charge"][0] != "o1:charge" || runs["charge"][1] != "o1:charge" {
+		t.Fatalf("expected charge keyed by order and step, got %v", runs["charge"])
+	}
+	if st := db.Item("orders", "o1")["status"].(*types.AttributeValueMemberS).Value; st != orders.StatusCompleted {
+		t.Fatalf("expected COMPLETED, got %s", st)
+	}
+	if n := recorder.Observations(metrics.StepDuration, metrics.Dim(metrics.DimStep, "charge"), metrics.Dim(metrics.DimOutcome, metrics.OutcomeFailure)); n != 1 {
+		t.Fatalf("expected 1 failed charge sample, got %d", n)
+	}
+}
+
+func TestPipeline_PermanentFailureFailsOrder(t *testing.T) {
+	db := newFakeDynamo()
+	putPendingOrder(db, "declined", 500)
+	putPendingOrder(db, "invalid", 0)
+	ctx := context.Background()
+
+	fulfilled := 0
+	p := NewProcessor(&aws.AWSClients{DynamoDB: db}, "idempotency", "orders", 48*time.Hour).
+		WithSteps(
+			ValidateStep(),
+			StepFunc("charge", func(context.Context, StepInput) error {
+				return Permanent(errors.New("card declined"))
+			}),
+			StepFunc("fulfil", func(context.Context, StepInput) error { fulfilled++; return nil }),
+		)
+	store := orders.NewStore(db, "orders")
+	idem := idempotency.NewStore(db, "idempotency", 48*time.Hour)
+
+	for id, reason := range map[string]string{"declined": "step charge: card declined", "invalid": "step validate: order amount must be positive"} {
+		// acknowledged: retrying cannot help
+		if resp, _ := p.Handle(ctx, orderMessage(id)); len(resp.BatchItemFailures) != 0 {
+			t.Fatalf("%s: expected the message acknowledged, got %+v", id, resp.BatchItemFailures)
+		}
+		o, _ := store.Get(ctx, id)
+		last := o.StatusHistory[len(o.StatusHistory)-1]
+		if o.Status != orders.StatusFailed || last.Actor != workerActor || !strings.HasPrefix(last.Reason, reason) {
+			t.Fatalf("%s: unexpected order: status=%s last=%+v", id, o.Status, last)
+		}
+		rec, _ := idem.Get(ctx, "k-"+id)
+		if rec.Status != idempotency.StatusFailed || !strings.HasPrefix(rec.Note, reason) {
+			t.Fatalf("%s: unexpected idempotency record: %+v", id, rec)
+		}
+		// a redelivery of a failed order is acknowledged too
+		if resp, _ := p.Handle(ctx, orderMessage(id)); len(resp.BatchItemFailures) != 0 {
+			t.Fatalf("%s: expected the redelivery acknowledged, got %+v", id, resp.BatchItemFailures)
+		}
+	}
+	if fulfilled != 0 {
+		t.Fatalf("no order should have been fulfilled, got %d", fulfilled)
+	}
+}
+
+func TestPermanent_SurvivesWrapping(t *testing.T) {
+	err := errors.New("card declined")
+	if IsPermanent(err) || Permanent(nil) != nil {
+		t.Fatal("unmarked errors are retryable")
+	}
+	wrapped := fmt.Errorf("step charge: %w", Permanent(err))
+	if !IsPermanent(wrapped) || !errors.Is(wrapped, err) {
+		t.Fatalf("expected %v to stay permanent and unwrap to the cause", wrapped)
+	}
+}
@@ -0,0 +1,339 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/blob"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/idempotency"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/metrics"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/payments"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
+)
+
+// workerActor is recorded in an order's status history for transitions made by the worker.
+const workerActor = "worker"
+
+// Processor handles SQS messages and performs order lifecycle transitions.
+type Processor struct {
+	dynamo         aws.DynamoDBAPI
+	idempotencyTbl string
+	ordersTbl      string
+	idempStore     *idempotency.Store
+	orderStore     *orders.Store
+	metrics        metrics.Recorder
+	compensate     Compensator
+	gateway        payments.PaymentGateway
+	steps          []Step
+}
+
+// Compensator undoes the work done for an order that stopped while the worker processed it: it was
+// cancelled (the cancellation won the race against completion) or a step failed permanently. It may
+// run more than once for the same order (a crash before the saga checkpoint, a retry after it failed)
+// and must be idempotent.
+type Compensator func(ctx context.Context, order *orders.Order) error
+
+// NewProcessor creates a new worker processor with AWS clients injected.
+// ttl is the idempotency record retention and must match the API's.
+func NewProcessor(clients *aws.AWSClients, idempTable, ordersTable string, ttl time.Duration) *Processor {
+	return &Processor{
+		dynamo:         clients.DynamoDB,
+		idempotencyTbl: idempTable,
+		ordersTbl:      ordersTable,
+		idempStore:     idempotency.NewStore(clients.DynamoDB, idempTable, ttl),
+		orderStore:     orders.NewStore(clients.DynamoDB, ordersTable),
+		metrics:        metrics.Nop{},
+	}
+}
+
+// WithMetrics makes p record transitions, processing durations and retries to r.
+// The caller owns flushing r.
+func (p *Processor) WithMetrics(r metrics.Recorder) *Processor {
+	p.metrics = metrics.OrNop(r)
+	return p
+}
+
+// WithCompensator makes p run c for orders cancelled or failed while processing, after the
+// compensations of the pipeline's Compensable steps. It is for work that no single step owns.
+func (p *Processor) WithCompensator(c Compensator) *Processor {
+	p.compensate = c
+	return p
+}
+
+// WithPaymentGateway makes p pay out refund jobs through gw, the gateway the payment steps charge.
+func (p *Processor) WithPaymentGateway(gw payments.PaymentGateway) *Processor {
+	p.gateway = gw
+	return p
+}
+
+// WithBlobStore lets p read and write idempotent responses spilled to bs; it must be the API's blob store.
+func (p *Processor) WithBlobStore(bs blob.Store) *Processor {
+	p.idempStore.SetBlobStore(bs)
+	return p
+}
+
+// WithTransitionRecorder makes every status change p writes also write r's item in the same transaction;
+// it must be the API's recorder so webhooks see every transition.
+func (p *Processor) WithTransitionRecorder(r orders.TransitionRecorder) *Processor {
+	p.orderStore.SetTransitionRecorder(r)
+	return p
+}
+
+// Handle receives an SQS batch event and processes every message.
+// Failed messages are reported individually via BatchItemFailures so only they are redelivered
+// (the event source mapping must enable ReportBatchItemFailures). After maxReceiveCount they go to the DLQ.
+func (p *Processor) Handle(ctx context.Context, ev events.SQSEvent) (events.SQSEventResponse, error) {
+	resp := events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{}}
+	for _, rec := range ev.Records {
+		if err := p.processMessage(ctx, rec); err != nil {
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: rec.MessageId})
+		}
+	}
+	return resp, nil
+}
+
+// processMessage runs one message through the order lifecycle and records and logs its outcome.
+// It is shared by the Lambda handler and the long-polling Consumer.
+func (p *Processor) processMessage(ctx context.Context, rec events.SQSMessage) (err error) {
+	start := time.Now()
+	attempt, _ := strconv.Atoi(rec.Attributes["ApproximateReceiveCount"])
+	if attempt > 1 {
+		p.metrics.Count(metrics.WorkerRetry, 1)
+	}
+	ctx, span := tracing.StartSQSProcess(tracing.Extract(ctx, stringAttributes(rec)), rec.MessageId,
+		attribute.Int("messaging.aws.sqs.receive_count", attempt))
+	defer tracing.End(span, &err)
+	ctx, logger := logging.With(logging.WithTraceID(ctx), logging.KeyMessageID, rec.MessageId, logging.KeyAttempt, attempt)
+
+	var msg WorkerMessage
+	err = json.Unmarshal([]byte(rec.Body), &msg)
+	if err != nil {
+		err = fmt.Errorf("invalid message body: %w", err)
+	} else {
+		ctx = logging.WithCorrelationID(ctx, correlationID(rec, msg))
+		ctx, logger = logging.With(ctx, logging.KeyOrderID, msg.OrderID, logging.KeyIdempotencyKey, msg.IdempotencyKey)
+		span.SetAttributes(attribute.String(logging.KeyOrderID, msg.OrderID))
+		switch msg.Type {
+		case "":
+			err = p.processOrder(ctx, msg)
+		case MessageTypeRefund:
+			err = p.processRefund(ctx, msg)
+		default:
+			err = fmt.Errorf("unknown message type %q", msg.Type)
+		}
+	}
+
+	outcome := metrics.OutcomeSuccess
+	if err != nil {
+		outcome = metrics.OutcomeFailure
+		logger.Error("message processing failed", logging.Err(err))
+	}
+	p.metrics.Observe(metrics.ProcessingDuration, time.Since(start), metrics.Dim(metrics.DimOutcome, outcome))
+	return err
+}
+
+// stringAttributes returns the message's string attributes, which carry the trace context.
+func stringAttributes(rec events.SQSMessage) map[string]string {
+	attrs := make(map[string]string, len(rec.MessageAttributes))
+	for k, v := range rec.MessageAttributes {
+		if v.StringValue != nil {
+			attrs[k] = *v.StringValue
+		}
+	}
+	return attrs
+}
+
+// correlationID returns the ID the API assigned to the request behind msg: the body field, else
+// the message attribute (messages enqueued before the body carried it), else a fresh one so the
+// worker's lines for this message still group together.
+func correlationID(rec events.SQSMessage, msg WorkerMessage) string {
+	if msg.CorrelationID != "" {
+		return msg.CorrelationID
+	}
+	if a, ok := rec.MessageAttributes[logging.KeyCorrelationID]; ok && a.StringValue != nil && *a.StringValue != "" {
+		return *a.StringValue
+	}
+	return logging.NewCorrelationID()
+}
+
+func (p *Processor) processOrder(ctx context.Context, msg WorkerMessage) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("order message received")
+
+	// Step 1: Read the current order
+	order, err := p.orderStore.Get(ctx, msg.OrderID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch order: %w", err)
+	}
+	if order == nil {
+		// Should never happen — DLQ if it does
+		return fmt.Errorf("order not found: %s", msg.OrderID)
+	}
+
+	// Step 2: Move PENDING -> PROCESSING (idempotent)
+	err = p.orderStore.UpdateStatus(ctx, msg.OrderID, orders.StatusChange{
+		From: orders.StatusPending, To: orders.StatusProcessing, Actor: workerActor,
+	})
+	if err == orders.ErrStatusMismatch {
+		// Already processed or competing worker:
+		// If already COMPLETED (or since refunded) -> treat as success.
+		// If already FAILED -> a step failed permanently; finish the compensation and the idempotency
+		// record in case an earlier attempt stopped half way.
+		// If already PROCESSING -> an earlier attempt stopped mid-pipeline (or another worker is running
+		// it): resume after the last checkpointed step; steps are idempotent.
+		// If CANCELLED -> nothing left to do, unless it was cancelled mid-processing: then compensate.
+		// If ON_HOLD -> fail so the message is retried once the hold is released.
+		o2, gerr := p.orderStore.Get(ctx, msg.OrderID)
+		if gerr != nil {
+			return fmt.Errorf("failed to fetch order: %w", gerr)
+		}
+		if o2 == nil {
+			return fmt.Errorf("order not found: %s", msg.OrderID)
+		}
+		switch o2.Status {
+		case orders.StatusCompleted, orders.StatusPartiallyRefunded, orders.StatusRefunded:
+			logger.Info("order already completed")
+			return nil
+		case orders.StatusFailed:
+			if stoppedWhileProcessing(o2) {
+				return p.finishFailed(ctx, msg, o2)
+			}
+			logger.Info("order already failed")
+			return nil
+		case orders.StatusProcessing:
+			logger.Info("resuming processing", "completed_steps", o2.CompletedSteps)
+			order = o2
+		case orders.StatusCancelled:
+			if stoppedWhileProcessing(o2) {
+				// an earlier attempt lost the race to the cancellation; make sure its work is undone
+				return p.compensateOrder(ctx, o2)
+			}
+			logger.Info("skipping cancelled order")
+			return nil
+		case orders.StatusOnHold:
+			return fmt.Errorf("order=%s is ON_HOLD", msg.OrderID)
+		default:
+			return fmt.Errorf("unexpected status for order=%s: %s", msg.OrderID, o2.Status)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to update status to PROCESSING: %w", err)
+	} else {
+		p.recordTransition(orders.StatusPending, orders.StatusProcessing)
+	}
+
+	// Step 3: Run the pipeline, resuming after the steps checkpointed by earlier attempts
+	err = p.runSteps(ctx, order)
+	switch {
+	case errors.Is(err, orders.ErrStatusMismatch):
+		return p.leftProcessing(ctx, msg.OrderID)
+	case IsPermanent(err):
+		return p.failOrder(ctx, msg, order, err)
+	case err != nil:
+		return err // retryable: the redelivered message resumes at the failed step
+	}
+
+	// Step 4: Complete order: PROCESSING -> COMPLETED
+	err = p.orderStore.UpdateStatus(ctx, msg.OrderID, orders.StatusChange{
+		From: orders.StatusProcessing, To: orders.StatusCompleted, Actor: workerActor,
+	})
+	if err == orders.ErrStatusMismatch {
+		return p.leftProcessing(ctx, msg.OrderID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update status to COMPLETED: %w", err)
+	}
+	p.recordTransition(orders.StatusProcessing, orders.StatusCompleted)
+
+	// Step 5: Mark idempotency DONE (API created the record)
+	response := fmt.Sprintf(`{"order_id":"%s","status":"COMPLETED"}`, msg.OrderID)
+	if err := p.idempStore.MarkDone(ctx, msg.IdempotencyKey, response, 200); err != nil {
+		return fmt.Errorf("failed to update idempotency: %w", err)
+	}
+
+	logger.Info("order completed")
+	return nil
+}
+
+// failOrder moves an order whose step failed permanently to FAILED, undoes the work of its earlier
+// steps and acknowledges the message; retrying it could not succeed.
+func (p *Processor) failOrder(ctx context.Context, msg WorkerMessage, order *orders.Order, stepErr error) error {
+	err := p.orderStore.UpdateStatus(ctx, msg.OrderID, orders.StatusChange{
+		From: orders.StatusProcessing, To: orders.StatusFailed, Actor: workerActor, Reason: stepErr.Error(),
+	})
+	if err == orders.ErrStatusMismatch {
+		return p.leftProcessing(ctx, msg.OrderID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update status to FAILED: %w", err)
+	}
+	p.recordTransition(orders.StatusProcessing, orders.StatusFailed)
+	logging.FromContext(ctx).Warn("order failed", logging.Err(stepErr))
+
+	order.Status = orders.StatusFailed
+	order.StatusHistory = append(order.StatusHistory, orders.StatusChange{From: orders.StatusProcessing, To: orders.StatusFailed, Reason: stepErr.Error()})
+	return p.finishFailed(ctx, msg, order)
+}
+
+// finishFailed compensates an order that failed while processing and then marks its idempotency
+// record FAILED. Both are idempotent, so a redelivered message repeats them until they succeed.
+func (p *Processor) finishFailed(ctx context.Context, msg WorkerMessage, order *orders.Order) error {
+	if err := p.compensateOrder(ctx, order); err != nil {
+		return err
+	}
+	reason := order.StatusHistory[len(order.StatusHistory)-1].Reason
+	if err := p.idempStore.MarkFailed(ctx, msg.IdempotencyKey, reason); err != nil {
+		return fmt.Errorf("failed to update idempotency: %w", err)
+	}
+	return nil
+}
+
+// leftProcessing handles an order that another writer moved out of PROCESSING while this attempt
+// worked on it: a cancellation is compensated, a concurrent attempt's completion acknowledged, and
+// anything else (e.g. ON_HOLD) fails the message so it is retried.
+func (p *Processor) leftProcessing(ctx context.Context, orderID string) error {
+	o2, err := p.orderStore.Get(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch order: %w", err)
+	}
+	if o2 == nil {
+		return fmt.Errorf("order not found: %s", orderID)
+	}
+	switch o2.Status {
+	case orders.StatusCancelled:
+		return p.compensateOrder(ctx, o2)
+	case orders.StatusCompleted, orders.StatusFailed:
+		logging.FromContext(ctx).Info("order finished by a concurrent attempt", "status", o2.Status)
+		return nil
+	default:
+		return fmt.Errorf("order=%s left PROCESSING before completion (now %s): %w", orderID, o2.Status, orders.ErrStatusMismatch)
+	}
+}
+
+// stoppedWhileProcessing reports whether order was cancelled or failed after it had entered PROCESSING,
+// so work may have been done for it. The stop need not come straight from PROCESSING: an order put
+// ON_HOLD mid-pipeline and cancelled from there still has steps to undo.
+func stoppedWhileProcessing(order *orders.Order) bool {
+	if order.Status != orders.StatusCancelled && order.Status != orders.StatusFailed {
+		return false
+	}
+	for _, c := range order.StatusHistory {
+		if c.To == orders.StatusProcessing {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Processor) recordTransition(from, to string) {
+	p.metrics.Count(metrics.WorkerTransition, 1, metrics.Dim(metrics.DimFrom, from), metrics.Dim(metrics.DimTo, to))
+}
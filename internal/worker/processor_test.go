@@ -0,0 +1,231 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/idempotency"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/metrics"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/testing/dynamofake"
+)
+
+func newFakeDynamo() *dynamofake.Fake {
+	return dynamofake.New(
+		dynamofake.TableSchema{Name: "orders", HashKey: "order_id"},
+		dynamofake.TableSchema{Name: "idempotency", HashKey: "idempotency_key"},
+	)
+}
+
+// --- test cases ---
+
+func TestWorkerProcess_Success(t *testing.T) {
+	db := newFakeDynamo()
+
+	// Insert order PENDING
+	order := orders.Order{
+		OrderID:    "o1",
+		CustomerID: "c1",
+		Status:     orders.StatusPending,
+		Amount:     money.Money{Amount: 1000, Currency: "USD"},
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	item, _ := attributevalue.MarshalMap(order)
+	db.Put("orders", item)
+
+	// Insert idempotency record
+	idemp := idempotency.IdempotencyRecord{
+		IdempotencyKey: "k1",
+		Status:         idempotency.StatusInProgress,
+		OrderID:        "o1",
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	idmap, _ := attributevalue.MarshalMap(idemp)
+	db.Put("idempotency", idmap)
+
+	clients := &aws.AWSClients{DynamoDB: db}
+	p := NewProcessor(clients, "idempotency", "orders", 48*time.Hour)
+
+	msg := WorkerMessage{
+		OrderID:        "o1",
+		IdempotencyKey: "k1",
+	}
+	body, _ := json.Marshal(msg)
+	ev := events.SQSEvent{
+		Records: []events.SQSMessage{
+			{Body: string(body)},
+		},
+	}
+
+	resp, err := p.Handle(context.Background(), ev)
+	if err != nil {
+		t.Fatalf("unexpected worker error: %v", err)
+	}
+	if len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("unexpected batch item failures: %+v", resp.BatchItemFailures)
+	}
+}
+
+func TestWorkerProcess_PartialBatchFailure(t *testing.T) {
+	db := newFakeDynamo()
+
+	for _, id := range []string{"o1", "o2"} {
+		item, _ := attributevalue.MarshalMap(orders.Order{
+			OrderID:   id,
+			Status:    orders.StatusPending,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+		db.Put("orders", item)
+		idmap, _ := attributevalue.MarshalMap(idempotency.IdempotencyRecord{
+			IdempotencyKey: "k-" + id,
+			Status:         idempotency.StatusInProgress,
+			OrderID:        id,
+		})
+		db.Put("idempotency", idmap)
+	}
+
+	recorder := metrics.NewMemory()
+	p := NewProcessor(&aws.AWSClients{DynamoDB: db}, "idempotency", "orders", 48*time.Hour).WithMetrics(recorder)
+
+	body := func(orderID string) string {
+		b, _ := json.Marshal(WorkerMessage{OrderID: orderID, IdempotencyKey: "k-" + orderID})
+		return string(b)
+	}
+	ev := events.SQSEvent{
+		Records: []events.SQSMessage{
+			{MessageId: "m1", Body: body("o1")},
+			{MessageId: "m-poison", Body: "{not json"},
+			{MessageId: "m-missing", Body: body("does-not-exist")},
+			{MessageId: "m2", Body: body("o2"), Attributes: map[string]string{"ApproximateReceiveCount": "3"}},
+		},
+	}
+
+	resp, err := p.Handle(context.Background(), ev)
+	if err != nil {
+		t.Fatalf("unexpected worker error: %v", err)
+	}
+	failed := map[string]bool{}
+	for _, f := range resp.BatchItemFailures {
+		failed[f.ItemIdentifier] = true
+	}
+	if len(failed) != 2 || !failed["m-poison"] || !failed["m-missing"] {
+		t.Fatalf("expected only m-poison and m-missing to fail, got %+v", resp.BatchItemFailures)
+	}
+	// the good messages around the poison one were still processed
+	for _, id := range []string{"o1", "o2"} {
+		if st := db.Item("orders", id)["status"].(*types.AttributeValueMemberS).Value; st != orders.StatusCompleted {
+			t.Fatalf("expected %s COMPLETED, got %s", id, st)
+		}
+	}
+
+	if n := recorder.Total(metrics.WorkerTransition, metrics.Dim(metrics.DimTo, orders.StatusCompleted)); n != 2 {
+		t.Fatalf("expected 2 COMPLETED transitions, got %v", n)
+	}
+	if n := recorder.Observations(metrics.ProcessingDuration, metrics.Dim(metrics.DimOutcome, metrics.OutcomeFailure)); n != 2 {
+		t.Fatalf("expected 2 failed processing samples, got %d", n)
+	}
+	if n := recorder.Total(metrics.WorkerRetry); n != 1 {
+		t.Fatalf("expected 1 retry, got %v", n)
+	}
+}
+
+func TestWorkerProcess_CancellationRacesCompletion(t *testing.T) {
+	db := newFakeDynamo()
+	for _, id := range []string{"racing", "cancelled-early"} {
+		item, _ := attributevalue.MarshalMap(orders.Order{OrderID: id, Status: orders.StatusPending, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+		db.Put("orders", item)
+	}
+	store := orders.NewStore(db, "orders")
+	ctx := context.Background()
+
+	var compensated []string
+	recorder := metrics.NewMemory()
+	p := NewProcessor(&aws.AWSClients{DynamoDB: db}, "idempotency", "orders", 48*time.Hour).
+		WithMetrics(recorder).
+		WithCompensator(func(_ context.Context, o *orders.Order) error {
+			compensated = append(compensated, o.OrderID)
+			return nil
+		}).
+		// the client cancels while the worker is in the middle of the pipeline
+		WithSteps(StepFunc("fulfil", func(ctx context.Context, in StepInput) error {
+			_, err := store.Cancel(ctx, in.Order.OrderID, orders.StatusChange{Actor: "api"})
+			return err
+		}))
+	message := func(orderID string) events.SQSEvent {
+		b, _ := json.Marshal(WorkerMessage{OrderID: orderID, IdempotencyKey: "k-" + orderID})
+		return events.SQSEvent{Records: []events.SQSMessage{{MessageId: "m-" + orderID, Body: string(b)}}}
+	}
+	status := func(id string) string {
+		return db.Item("orders", id)["status"].(*types.AttributeValueMemberS).Value
+	}
+
+	resp, err := p.Handle(ctx, message("racing"))
+	if err != nil || len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("the lost race must be acknowledged: %+v, %v", resp, err)
+	}
+	if status("racing") != orders.StatusCancelled || len(compensated) != 1 {
+		t.Fatalf("expected the cancelled order compensated once, status=%s compensated=%v", status("racing"), compensated)
+	}
+
+	// the saga is marked compensated, so a redelivery does not compensate again
+	if resp, _ := p.Handle(ctx, message("racing")); len(resp.BatchItemFailures) != 0 || len(compensated) != 1 {
+		t.Fatalf("redelivery: failures=%+v compensated=%v", resp.BatchItemFailures, compensated)
+	}
+	if o, _ := store.Get(ctx, "racing"); o.SagaStatus != orders.SagaCompensated {
+		t.Fatalf("expected the saga compensated, got %q", o.SagaStatus)
+	}
+
+	// cancelled before the worker started: acknowledged, nothing to undo
+	if _, err := store.Cancel(ctx, "cancelled-early", orders.StatusChange{Actor: "api"}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := p.Handle(ctx, message("cancelled-early")); len(resp.BatchItemFailures) != 0 || len(compensated) != 1 {
+		t.Fatalf("cancelled order: failures=%+v compensated=%v", resp.BatchItemFailures, compensated)
+	}
+	if n := recorder.Total(metrics.Compensation, metrics.Dim(metrics.DimOutcome, metrics.OutcomeSuccess)); n != 1 {
+		t.Fatalf("expected 1 compensation recorded, got %v", n)
+	}
+
+	// a failing compensation fails the message so it is retried
+	item, _ := attributevalue.MarshalMap(orders.Order{OrderID: "racing-again", Status: orders.StatusPending, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+	db.Put("orders", item)
+	p.WithCompensator(func(context.Context, *orders.Order) error { return errors.New("refund service down") })
+	for i := 0; i < 2; i++ {
+		if resp, _ := p.Handle(ctx, message("racing-again")); len(resp.BatchItemFailures) != 1 {
+			t.Fatalf("attempt %d: expected the message to fail, got %+v", i, resp.BatchItemFailures)
+		}
+	}
+	if o, _ := store.Get(ctx, "racing-again"); o.Status != orders.StatusCancelled || o.SagaStatus != "" {
+		t.Fatalf("expected a cancelled order still to compensate, got %s %q", o.Status, o.SagaStatus)
+	}
+}
+
+func TestCorrelationID_BodyThenAttributeThenGenerated(t *testing.T) {
+	attr := "from-attr"
+	rec := events.SQSMessage{MessageAttributes: map[string]events.SQSMessageAttribute{
+		logging.KeyCorrelationID: {StringValue: &attr, DataType: "String"},
+	}}
+	if got := correlationID(rec, WorkerMessage{CorrelationID: "from-body"}); got != "from-body" {
+		t.Fatalf("expected body id, got %q", got)
+	}
+	if got := correlationID(rec, WorkerMessage{}); got != "from-attr" {
+		t.Fatalf("expected attribute id, got %q", got)
+	}
+	if got := correlationID(events.SQSMessage{}, WorkerMessage{}); got == "" {
+		t.Fatal("expected a generated id")
+	}
+}
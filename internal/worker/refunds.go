@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/metrics"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/payments"
+)
+
+var (
+	errNoGateway   = errors.New("no payment gateway configured")
+	errNotCaptured = errors.New("no successful payment capture")
+)
+
+// processRefund pays out a refund the API recorded on an order. The provider call goes through the
+// order's payment attempts under a key derived from the refund ID, so a redelivered job never refunds
+// twice. A refund the provider rejects is settled FAILED, which gives its amount back to the order so it
+// can be refunded again; a timeout leaves it PENDING and the job is retried.
+func (p *Processor) processRefund(ctx context.Context, msg WorkerMessage) error {
+	ctx, logger := logging.With(ctx, logging.KeyRefundID, msg.RefundID)
+	logger.Info("refund message received")
+
+	order, err := p.orderStore.Get(ctx, msg.OrderID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch order: %w", err)
+	}
+	if order == nil {
+		return fmt.Errorf("order not found: %s", msg.OrderID)
+	}
+	i := order.FindRefund(msg.RefundID)
+	if i < 0 {
+		return fmt.Errorf("refund %s not found on order=%s", msg.RefundID, msg.OrderID)
+	}
+	refund := order.Refunds[i]
+	if refund.Status != orders.RefundPending {
+		logger.Info("refund already settled", "status", refund.Status)
+		return nil
+	}
+
+	attempt, err := p.payRefund(ctx, order, refund)
+	outcome := metrics.OutcomeSuccess
+	switch {
+	case err == nil:
+		refund.Status = orders.RefundSucceeded
+		if attempt != nil {
+			refund.ProviderID = attempt.ProviderID
+		}
+	case IsPermanent(err):
+		refund.Status, refund.Error = orders.RefundFailed, err.Error()
+		outcome = metrics.OutcomeFailure
+	default:
+		return err
+	}
+	if err := p.orderStore.SettleRefund(ctx, msg.OrderID, i, refund); err != nil {
+		return fmt.Errorf("failed to settle refund: %w", err)
+	}
+	p.metrics.Count(metrics.RefundSettled, 1, metrics.Dim(metrics.DimOutcome, outcome))
+	logger.Info("refund settled", "status", refund.Status, "amount", refund.Amount.String())
+	return nil
+}
+
+// payRefund refunds refund.Amount of the order's capture. An order never charged through a gateway has
+// nothing to pay back at the provider, and gets a nil attempt.
+func (p *Processor) payRefund(ctx context.Context, order *orders.Order, refund orders.Refund) (*orders.PaymentAttempt, error) {
+	if len(order.Payments) == 0 {
+		return nil, nil
+	}
+	if p.gateway == nil {
+		return nil, Permanent(fmt.Errorf("refund %s: %w", refund.RefundID, errNoGateway))
+	}
+	capture := order.LastPayment(string(payments.OpCapture))
+	if capture == nil || capture.Status != orders.PaymentSucceeded {
+		return nil, Permanent(fmt.Errorf("refund %s: %w", refund.RefundID, errNotCaptured))
+	}
+	req := payments.RefundRequest{
+		IdempotencyKey:  StepKey(order.OrderID, "refund:"+refund.RefundID),
+		AuthorizationID: capture.AuthorizationID,
+		Amount:          refund.Amount,
+	}
+	return recordedCall(ctx, p.orderStore, order, orders.PaymentAttempt{
+		Operation:       string(payments.OpRefund),
+		IdempotencyKey:  req.IdempotencyKey,
+		Amount:          req.Amount,
+		AuthorizationID: req.AuthorizationID,
+	}, func() (*payments.Result, error) { return p.gateway.Refund(ctx, req) })
+}
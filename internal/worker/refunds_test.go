@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/money"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/payments"
+)
+
+func refundMessage(orderID, refundID string) events.SQSEvent {
+	b, _ := json.Marshal(WorkerMessage{Type: MessageTypeRefund, OrderID: orderID, RefundID: refundID, IdempotencyKey: "rk-" + refundID})
+	return events.SQSEvent{Records: []events.SQSMessage{{MessageId: "m-" + refundID, Body: string(b)}}}
+}
+
+func TestRefundJobs_PayOutOnceAcrossRedeliveries(t *testing.T) {
+	db := newFakeDynamo()
+	putPendingOrder(db, "o1", 1000)
+	usd := money.Money{Amount: 500, Currency: "USD"}
+	item, _ := attributevalue.MarshalMap(orders.Order{
+		OrderID: "o1", CustomerID: "c1", Status: orders.StatusPending, Amount: money.Money{Amount: 1000, Currency: "USD"},
+		Items:     []map[string]interface{}{{"sku": "A", "quantity": 2, "price": usd}},
+		CreatedAt: time.Now(), UpdatedAt: time.Now(),
+	})
+	db.Put("orders", item)
+	putPendingOrder(db, "unpaid", 1000)
+	ctx := context.Background()
+
+	gw := payments.NewFake().FailNext(payments.OpRefund, payments.FaultLostResponse)
+	store := orders.NewStore(db, "orders")
+	p := NewProcessor(&aws.AWSClients{DynamoDB: db}, "idempotency", "orders", 48*time.Hour).
+		WithSteps(AuthorizePaymentStep(gw, store), CapturePaymentStep(gw, store)).
+		WithPaymentGateway(gw)
+	if resp, _ := p.Handle(ctx, orderMessage("o1")); len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("unexpected failures %+v", resp.BatchItemFailures)
+	}
+
+	if _, _, err := store.Refund(ctx, "o1", orders.RefundRequest{RefundID: "r1", IdempotencyKey: "rk-r1", Items: []orders.RefundItem{{SKU: "A", Quantity: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	// the provider refunds but the response is lost; the redelivery replays the call
+	if resp, _ := p.Handle(ctx, refundMessage("o1", "r1")); len(resp.BatchItemFailures) != 1 {
+		t.Fatalf("expected a retryable failure, got %+v", resp)
+	}
+	for i := 0; i < 2; i++ {
+		if resp, _ := p.Handle(ctx, refundMessage("o1", "r1")); len(resp.BatchItemFailures) != 0 {
+			t.Fatalf("unexpected failures %+v", resp.BatchItemFailures)
+		}
+	}
+	o, _ := store.Get(ctx, "o1")
+	if r := o.Refunds[0]; r.Status != orders.RefundSucceeded || r.ProviderID == "" || r.SettledAt == nil {
+		t.Fatalf("expected a settled refund, got %+v", r)
+	}
+	if gw.Applied(payments.OpRefund) != 1 {
+		t.Fatalf("expected one provider refund, got %d", gw.Applied(payments.OpRefund))
+	}
+
+	if _, _, err := store.Refund(ctx, "o1", orders.RefundRequest{RefundID: "r2", IdempotencyKey: "rk-r2"}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := p.Handle(ctx, refundMessage("o1", "r2")); len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("unexpected failures %+v", resp.BatchItemFailures)
+	}
+	o, _ = store.Get(ctx, "o1")
+	if fp, _ := gw.Payment(o.LastPayment("authorize").AuthorizationID); fp.Status != payments.StatusRefunded || fp.Refunded.Amount != 1000 {
+		t.Fatalf("expected the capture refunded in full, got %+v", fp)
+	}
+	if o.Status != orders.StatusRefunded || o.Refunds[1].Status != orders.RefundSucceeded {
+		t.Fatalf("unexpected order %s %+v", o.Status, o.Refunds)
+	}
+
+	// an order the gateway never charged has nothing to pay back at the provider
+	if resp, _ := NewProcessor(&aws.AWSClients{DynamoDB: db}, "idempotency", "orders", 48*time.Hour).Handle(ctx, orderMessage("unpaid")); len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("unexpected failures %+v", resp.BatchItemFailures)
+	}
+	if _, _, err := store.Refund(ctx, "unpaid", orders.RefundRequest{RefundID: "r3", IdempotencyKey: "rk-r3"}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := p.Handle(ctx, refundMessage("unpaid", "r3")); len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("unexpected failures %+v", resp.BatchItemFailures)
+	}
+	if o, _ = store.Get(ctx, "unpaid"); o.Refunds[0].Status != orders.RefundSucceeded || gw.Applied(payments.OpRefund) != 2 {
+		t.Fatalf("unexpected refund %+v", o.Refunds[0])
+	}
+
+	if resp, _ := p.Handle(ctx, refundMessage("o1", "unknown")); len(resp.BatchItemFailures) != 1 {
+		t.Fatalf("expected an unknown refund to fail, got %+v", resp)
+	}
+}
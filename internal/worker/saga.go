@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/logging"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/metrics"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/tracing"
+)
+
+// The Processor coordinates the pipeline as a saga. Before a Compensable step runs, the step is
+// recorded in the order's saga_actions. When the order fails permanently or is cancelled while
+// processing, the compensations of the recorded steps run in reverse order, each one checkpointed in
+// saga_compensated, and saga_status becomes COMPENSATED at the end. A worker that crashes half way
+// leaves the message to be redelivered, and the next attempt resumes with the compensations that did
+// not run.
+
+// Compensable is a Step whose effect can be undone. Compensate runs for an order cancelled or failed
+// after the step started, possibly before the step finished or more than once, so it must be
+// idempotent and tolerate finding nothing to undo.
+type Compensable interface {
+	Step
+	Compensate(ctx context.Context, order *orders.Order) error
+}
+
+// WithCompensation returns step as a Compensable step undone by c.
+func WithCompensation(step Step, c Compensator) Step {
+	return compensableStep{Step: step, compensate: c}
+}
+
+type compensableStep struct {
+	Step
+	compensate Compensator
+}
+
+func (s compensableStep) Compensate(ctx context.Context, order *orders.Order) error {
+	return s.compensate(ctx, order)
+}
+
+// startAction records a Compensable step in the order's saga before it runs. Other steps need no record.
+func (p *Processor) startAction(ctx context.Context, step Step, order *orders.Order) error {
+	if _, ok := step.(Compensable); !ok || order.ActionStarted(step.Name()) {
+		return nil
+	}
+	if err := p.orderStore.StartAction(ctx, order.OrderID, step.Name()); err != nil {
+		return err
+	}
+	order.SagaActions = append(order.SagaActions, step.Name())
+	return nil
+}
+
+// compensateOrder undoes the work done for an order cancelled or failed while processing: the
+// compensations of its started steps in reverse order, then the Processor's Compensator. A failed
+// compensation fails the message so it is retried; the compensations that already ran are skipped then.
+func (p *Processor) compensateOrder(ctx context.Context, order *orders.Order) error {
+	logger := logging.FromContext(ctx).With("status", order.Status)
+	if order.SagaStatus == orders.SagaCompensated {
+		logger.Info("order stopped while processing; already compensated")
+		return nil
+	}
+	if err := p.runCompensations(ctx, order); err != nil {
+		p.metrics.Count(metrics.Compensation, 1, metrics.Dim(metrics.DimOutcome, metrics.OutcomeFailure))
+		return fmt.Errorf("compensate %s order=%s: %w", order.Status, order.OrderID, err)
+	}
+	p.metrics.Count(metrics.Compensation, 1, metrics.Dim(metrics.DimOutcome, metrics.OutcomeSuccess))
+	logger.Info("order stopped while processing; compensated", "compensated", order.SagaCompensated)
+	return nil
+}
+
+func (p *Processor) runCompensations(ctx context.Context, order *orders.Order) error {
+	for i := len(p.steps) - 1; i >= 0; i-- {
+		step, ok := p.steps[i].(Compensable)
+		name := p.steps[i].Name()
+		if !ok || !order.ActionStarted(name) || order.ActionCompensated(name) {
+			continue
+		}
+		if err := p.compensateStep(ctx, step, order); err != nil {
+			return fmt.Errorf("step %s: %w", name, err)
+		}
+		if err := p.orderStore.CompleteCompensation(ctx, order.OrderID, name); err != nil {
+			return fmt.Errorf("checkpoint compensation of %s: %w", name, err)
+		}
+		order.SagaCompensated = append(order.SagaCompensated, name)
+	}
+	if p.compensate != nil {
+		if err := p.compensate(ctx, order); err != nil {
+			return err
+		}
+	}
+	if err := p.orderStore.FinishCompensation(ctx, order.OrderID); err != nil {
+		return err
+	}
+	order.SagaStatus = orders.SagaCompensated
+	return nil
+}
+
+// compensateStep runs one compensation in its own span.
+func (p *Processor) compensateStep(ctx context.Context, step Compensable, order *orders.Order) (err error) {
+	name := step.Name()
+	ctx, span := tracing.Start(ctx, "worker.compensate "+name, trace.WithAttributes(attribute.String(logging.KeyStep, name)))
+	defer tracing.End(span, &err)
+	ctx, logger := logging.With(ctx, logging.KeyStep, name)
+
+	if err = step.Compensate(ctx, order); err != nil {
+		logger.Warn("compensation failed", logging.Err(err))
+		return err
+	}
+	logger.Info("step compensated")
+	return nil
+}
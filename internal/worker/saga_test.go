@@ -0,0 +1,175 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/aws"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/idempotency"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/inventory"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/payments"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/shipping"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/testing/dynamofake"
+)
+
+func TestSaga_CompensatesInReverseAndResumesAfterACrash(t *testing.T) {
+	db := newFakeDynamo()
+	putPendingOrder(db, "o1", 500)
+	ctx := context.Background()
+
+	var undone []string
+	crash := true
+	action := func(name string) Step {
+		return WithCompensation(StepFunc(name, func(context.Context, StepInput) error { return nil }),
+			func(context.Context, *orders.Order) error {
+				if name == "b" && crash {
+					crash = false
+					return errors.New("worker crashed")
+				}
+				undone = append(undone, name)
+				return nil
+			})
+	}
+	p := NewProcessor(&aws.AWSClients{DynamoDB: db}, "idempotency", "orders", 48*time.Hour).
+		WithSteps(
+			action("a"),
+			StepFunc("check", func(context.Context, StepInput) error { return nil }),
+			action("b"),
+			WithCompensation(StepFunc("c", func(context.Context, StepInput) error {
+				return Permanent(errors.New("carrier rejected the parcel"))
+			}), func(context.Context, *orders.Order) error { undone = append(undone, "c"); return nil }),
+			action("never"),
+		)
+	store := orders.NewStore(db, "orders")
+	idem := idempotency.NewStore(db, "idempotency", 48*time.Hour)
+
+	// c started, so it is undone too; b's compensation fails and the message is retried
+	if resp, _ := p.Handle(ctx, orderMessage("o1")); len(resp.BatchItemFailures) != 1 {
+		t.Fatalf("expected the message to fail, got %+v", resp.BatchItemFailures)
+	}
+	o, _ := store.Get(ctx, "o1")
+	if o.Status != orders.StatusFailed || len(o.SagaActions) != 3 || len(o.SagaCompensated) != 1 || !o.ActionCompensated("c") || o.SagaStatus != "" {
+		t.Fatalf("unexpected saga state: status=%s actions=%v compensated=%v saga=%q", o.Status, o.SagaActions, o.SagaCompensated, o.SagaStatus)
+	}
+	if rec, _ := idem.Get(ctx, "k-o1"); rec.Status != idempotency.StatusInProgress {
+		t.Fatalf("the idempotency record must wait for the compensation, got %s", rec.Status)
+	}
+
+	// the redelivery resumes with b and a
+	if resp, _ := p.Handle(ctx, orderMessage("o1")); len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("unexpected failures %+v", resp.BatchItemFailures)
+	}
+	if strings.Join(undone, ",") != "c,b,a" {
+		t.Fatalf("expected compensations c,b,a, got %v", undone)
+	}
+	o, _ = store.Get(ctx, "o1")
+	if o.SagaStatus != orders.SagaCompensated || o.Status != orders.StatusFailed {
+		t.Fatalf("expected a compensated FAILED order, got %s %q", o.Status, o.SagaStatus)
+	}
+	rec, _ := idem.Get(ctx, "k-o1")
+	if rec.Status != idempotency.StatusFailed || rec.Note != "step c: carrier rejected the parcel" {
+		t.Fatalf("expected the idempotency record FAILED, got %s %q", rec.Status, rec.Note)
+	}
+
+	// nothing runs twice
+	if resp, _ := p.Handle(ctx, orderMessage("o1")); len(resp.BatchItemFailures) != 0 || len(undone) != 3 {
+		t.Fatalf("redelivery: failures=%+v undone=%v", resp.BatchItemFailures, undone)
+	}
+}
+
+func TestSaga_CancelledFromHoldMidPipelineIsCompensated(t *testing.T) {
+	db := newFakeDynamo()
+	putPendingOrder(db, "o1", 500)
+	ctx := context.Background()
+	store := orders.NewStore(db, "orders")
+
+	var undone []string
+	p := NewProcessor(&aws.AWSClients{DynamoDB: db}, "idempotency", "orders", 48*time.Hour).
+		WithSteps(
+			WithCompensation(StepFunc("reserve", func(context.Context, StepInput) error { return nil }),
+				func(context.Context, *orders.Order) error { undone = append(undone, "reserve"); return nil }),
+			// an operator puts the order on hold while the worker runs it
+			StepFunc("hold", func(ctx context.Context, in StepInput) error {
+				return store.UpdateStatus(ctx, in.Order.OrderID, orders.StatusChange{
+					From: orders.StatusProcessing, To: orders.StatusOnHold, Actor: "ops"})
+			}),
+		)
+
+	if resp, _ := p.Handle(ctx, orderMessage("o1")); len(resp.BatchItemFailures) != 1 {
+		t.Fatalf("expected the message to fail while ON_HOLD, got %+v", resp.BatchItemFailures)
+	}
+	if _, err := store.Cancel(ctx, "o1", orders.StatusChange{Actor: "customer", Reason: "changed my mind"}); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+
+	// PROCESSING -> ON_HOLD -> CANCELLED: the redelivery still undoes the reservation
+	if resp, _ := p.Handle(ctx, orderMessage("o1")); len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("unexpected failures %+v", resp.BatchItemFailures)
+	}
+	o, _ := store.Get(ctx, "o1")
+	if strings.Join(undone, ",") != "reserve" || o.Status != orders.StatusCancelled || o.SagaStatus != orders.SagaCompensated {
+		t.Fatalf("expected a compensated CANCELLED order, got %s %q undone=%v", o.Status, o.SagaStatus, undone)
+	}
+}
+
+func TestSaga_RejectedShipmentUndoesPaymentAndInventory(t *testing.T) {
+	db := newFakeDynamo()
+	db.CreateTable(dynamofake.TableSchema{Name: "stock", HashKey: "sku"})
+	db.CreateTable(dynamofake.TableSchema{
+		Name:    "reservations",
+		HashKey: "order_id",
+		Indexes: []dynamofake.IndexSchema{{Name: inventory.StatusIndex, HashKey: "status", RangeKey: "expires_at"}},
+	})
+	inv := inventory.NewStore(db, "stock", "reservations", inventory.DefaultHold)
+	ctx := context.Background()
+	if _, err := inv.AddStock(ctx, "A", 2); err != nil {
+		t.Fatal(err)
+	}
+	putCustomerOrder(db, "shipped", "c1", 1000)
+	putCustomerOrder(db, "remote", "far-away", 1000)
+
+	gw := payments.NewFake()
+	carrier := shipping.NewFake().Reject("far-away", "no delivery to this address")
+	store := orders.NewStore(db, "orders")
+	p := NewProcessor(&aws.AWSClients{DynamoDB: db}, "idempotency", "orders", 48*time.Hour).
+		WithSteps(
+			ValidateStep(),
+			ReserveInventoryStep(inv),
+			AuthorizePaymentStep(gw, store),
+			CapturePaymentStep(gw, store),
+			CommitInventoryStep(inv),
+			CreateShipmentStep(carrier),
+		)
+
+	for _, id := range []string{"shipped", "remote"} {
+		if resp, _ := p.Handle(ctx, orderMessage(id)); len(resp.BatchItemFailures) != 0 {
+			t.Fatalf("%s: unexpected failures %+v", id, resp.BatchItemFailures)
+		}
+	}
+	if s, ok := carrier.Shipment("shipped"); !ok || s.Status != shipping.StatusCreated {
+		t.Fatalf("expected a shipment, got %+v", s)
+	}
+
+	o, _ := store.Get(ctx, "remote")
+	if o.Status != orders.StatusFailed || o.SagaStatus != orders.SagaCompensated {
+		t.Fatalf("expected a compensated FAILED order, got %s %q", o.Status, o.SagaStatus)
+	}
+	sort.Strings(o.SagaCompensated)
+	if want := "authorize_payment,create_shipment,reserve_inventory"; strings.Join(o.SagaCompensated, ",") != want {
+		t.Fatalf("expected %v compensated, got %v", want, o.SagaCompensated)
+	}
+	if fp, _ := gw.Payment(o.LastPayment("authorize").AuthorizationID); fp.Status != payments.StatusRefunded {
+		t.Fatalf("expected the charge refunded, got %+v", fp)
+	}
+	if res, _ := inv.GetReservation(ctx, "remote"); res.Status != inventory.StatusReleased {
+		t.Fatalf("expected the stock released, got %+v", res)
+	}
+	if st, _ := inv.Get(ctx, "A"); st.Available != 1 || st.Reserved != 0 {
+		t.Fatalf("expected only the shipped unit gone, got %+v", st)
+	}
+}
@@ -0,0 +1,29 @@
+package worker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/orders"
+	"github.com/imrishuroy/go-idempotent-orderflow/internal/shipping"
+)
+
+// CreateShipmentStep hands the order to the carrier. A rejected shipment fails the order. Its
+// compensation is CancelShipment.
+func CreateShipmentStep(svc shipping.Service) Step {
+	return WithCompensation(StepFunc("create_shipment", func(ctx context.Context, in StepInput) error {
+		_, err := svc.CreateShipment(ctx, shipping.CreateRequest{OrderID: in.Order.OrderID, CustomerID: in.Order.CustomerID})
+		if errors.Is(err, shipping.ErrRejected) {
+			return Permanent(err)
+		}
+		return err
+	}), CancelShipment(svc))
+}
+
+// CancelShipment is a Compensator cancelling the shipment of a cancelled or failed order.
+func CancelShipment(svc shipping.Service) Compensator {
+	return func(ctx context.Context, order *orders.Order) error {
+		_, err := svc.CancelShipment(ctx, order.OrderID)
+		return err
+	}
+}
@@ -0,0 +1,13 @@
+package worker
+
+// MessageTypeRefund marks a WorkerMessage as a refund job: pay out the refund RefundID recorded on the order.
+const MessageTypeRefund = "refund"
+
+// WorkerMessage is the payload sent from API -> SQS -> Worker.
+type WorkerMessage struct {
+	Type           string `json:"type,omitempty"` // empty for an order to process
+	OrderID        string `json:"order_id"`
+	IdempotencyKey string `json:"idempotency_key"`
+	CorrelationID  string `json:"correlation_id,omitempty"`
+	RefundID       string `json:"refund_id,omitempty"` // set for MessageTypeRefund
+}
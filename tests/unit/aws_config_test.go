@@ -35,4 +35,7 @@ func TestLoadAWSConfig_WithEndpointOverride(t *testing.T) {
 	if cfg.Region != "ap-south-1" {
 		t.Fatalf("region mismatch, got %s", cfg.Region)
 	}
+	if cfg.BaseEndpoint == nil || *cfg.BaseEndpoint != "http://localhost:4566" {
+		t.Fatalf("expected the endpoint override to be applied, got %v", cfg.BaseEndpoint)
+	}
 }